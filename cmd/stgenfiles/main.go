@@ -15,6 +15,8 @@ import (
 	"os"
 	"path/filepath"
 	"time"
+
+	"github.com/syncthing/syncthing/lib/fixtures"
 )
 
 func main() {
@@ -22,7 +24,28 @@ func main() {
 	files := flag.Int("files", 1000, "Number of files to create")
 	maxExp := flag.Int("maxexp", 20, "Max size exponent")
 	src := flag.String("src", "/dev/urandom", "Source of file data")
+	seed := flag.Int64("seed", 0, "Generate a reproducible tree from this seed instead of random data")
+	script := flag.String("script", "", "Reproducible change script to apply to an existing tree, e.g. \"create:10,modify:5,delete:2\" (implies -seed)")
 	flag.Parse()
+
+	if *seed != 0 || *script != "" {
+		spec := fixtures.Spec{Seed: *seed, Files: *files, MaxSizeExp: uint(*maxExp)}
+		if *script != "" {
+			sc, err := fixtures.ParseScript(*script)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := fixtures.Apply(*dir, spec, sc); err != nil {
+				log.Println(err)
+			}
+			return
+		}
+		if err := fixtures.Generate(*dir, spec); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
 	if err := generateFiles(*dir, *files, *maxExp, *src); err != nil {
 		log.Println(err)
 	}
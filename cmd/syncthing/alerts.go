@@ -0,0 +1,360 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/syncthing/syncthing/lib/config"
+	"github.com/syncthing/syncthing/lib/events"
+	"github.com/syncthing/syncthing/lib/osutil"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+const (
+	// How often offline devices and disk space are checked.
+	alertCheckInterval = time.Hour
+	// Minimum time between two alerts with the same key, so a folder
+	// erroring on every pull retry doesn't flood the transports.
+	alertSuppression = time.Hour
+)
+
+// An alert is the data made available to notification templates.
+type alert struct {
+	Type    string // "folder-errors", "device-offline" or "low-disk"
+	Time    time.Time
+	Device  string
+	Folder  string
+	Message string
+}
+
+var defaultAlertTemplate = template.Must(template.New("alert").Parse("Syncthing alert: {{.Message}}"))
+
+// The alertService watches the event stream and periodic checks for
+// conditions worth an alert, and delivers them via the notification
+// transports from the configuration.
+type alertService struct {
+	cfg  *config.Wrapper
+	stop chan struct{}
+
+	offline   map[protocol.DeviceID]time.Time // disconnect time of currently offline devices
+	lastAlert map[string]time.Time
+}
+
+func newAlertService(cfg *config.Wrapper) *alertService {
+	return &alertService{
+		cfg:       cfg,
+		stop:      make(chan struct{}),
+		offline:   make(map[protocol.DeviceID]time.Time),
+		lastAlert: make(map[string]time.Time),
+	}
+}
+
+func (s *alertService) Serve() {
+	sub := events.Default.Subscribe(events.FolderErrors | events.DeviceConnected | events.DeviceDisconnected)
+	defer events.Default.Unsubscribe(sub)
+
+	ticker := time.NewTicker(alertCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case ev := <-sub.C():
+			s.handleEvent(ev)
+
+		case <-ticker.C:
+			s.checkOffline()
+			s.checkDiskSpace()
+
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *alertService) Stop() {
+	close(s.stop)
+}
+
+func (s *alertService) String() string {
+	return fmt.Sprintf("alertService@%p", s)
+}
+
+func (s *alertService) handleEvent(ev events.Event) {
+	switch ev.Type {
+	case events.FolderErrors:
+		data, ok := ev.Data.(map[string]interface{})
+		if !ok {
+			return
+		}
+		folder, _ := data["folder"].(string)
+		s.raise(alert{
+			Type:    "folder-errors",
+			Folder:  folder,
+			Message: fmt.Sprintf("Folder %q has items that failed to sync", folder),
+		})
+
+	case events.DeviceConnected, events.DeviceDisconnected:
+		data, ok := ev.Data.(map[string]string)
+		if !ok {
+			return
+		}
+		id, err := protocol.DeviceIDFromString(data["id"])
+		if err != nil {
+			return
+		}
+		if ev.Type == events.DeviceConnected {
+			delete(s.offline, id)
+		} else {
+			s.offline[id] = time.Now()
+		}
+	}
+}
+
+func (s *alertService) checkOffline() {
+	hours := s.cfg.Options().AlertOfflineHours
+	if hours <= 0 {
+		return
+	}
+	devices := s.cfg.Devices()
+	for id, since := range s.offline {
+		if _, ok := devices[id]; !ok {
+			delete(s.offline, id)
+			continue
+		}
+		if time.Since(since) > time.Duration(hours)*time.Hour {
+			s.raise(alert{
+				Type:    "device-offline",
+				Device:  id.String(),
+				Message: fmt.Sprintf("Device %v has been offline since %v", id, since.Format(time.RFC1123)),
+			})
+		}
+	}
+}
+
+func (s *alertService) checkDiskSpace() {
+	for folder, fcfg := range s.cfg.Folders() {
+		if fcfg.MinDiskFreePct <= 0 {
+			continue
+		}
+		free, err := osutil.DiskFreePercentage(fcfg.Path())
+		if err == nil && free < fcfg.MinDiskFreePct {
+			s.raise(alert{
+				Type:    "low-disk",
+				Folder:  folder,
+				Message: fmt.Sprintf("Folder %q has only %.1f%% disk space free", folder, free),
+			})
+		}
+	}
+}
+
+// raise delivers the alert via all configured transports, unless the same
+// alert was sent recently.
+func (s *alertService) raise(a alert) {
+	a.Time = time.Now()
+	key := a.Type + "/" + a.Folder + "/" + a.Device
+	if last, ok := s.lastAlert[key]; ok && time.Since(last) < alertSuppression {
+		return
+	}
+	s.lastAlert[key] = a.Time
+
+	for _, ncfg := range s.cfg.Raw().Notifications {
+		body, err := renderAlert(ncfg, a)
+		if err != nil {
+			l.Warnf("Notification %q template: %v", ncfg.Name, err)
+			continue
+		}
+		if err := deliverAlert(ncfg, a, body); err != nil {
+			l.Infof("Notification %q: %v", ncfg.Name, err)
+		}
+	}
+}
+
+func renderAlert(ncfg config.NotificationConfiguration, a alert) (string, error) {
+	tmpl := defaultAlertTemplate
+	if ncfg.Template != "" {
+		var err error
+		tmpl, err = template.New(ncfg.Name).Parse(ncfg.Template)
+		if err != nil {
+			return "", err
+		}
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, a); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func deliverAlert(ncfg config.NotificationConfiguration, a alert, body string) error {
+	switch ncfg.Type {
+	case "smtp":
+		return deliverAlertSMTP(ncfg, a, body)
+	case "webhook":
+		return deliverAlertWebhook(ncfg, a, body)
+	case "matrix":
+		return deliverAlertMatrix(ncfg, body)
+	case "xmpp":
+		return deliverAlertXMPP(ncfg, body)
+	default:
+		return fmt.Errorf("unknown transport type %q", ncfg.Type)
+	}
+}
+
+func deliverAlertSMTP(ncfg config.NotificationConfiguration, a alert, body string) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", ncfg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(ncfg.To, ", "))
+	fmt.Fprintf(&b, "Subject: Syncthing alert (%s)\r\n", a.Type)
+	fmt.Fprintf(&b, "\r\n%s\r\n", body)
+
+	var auth smtp.Auth
+	if ncfg.Token != "" {
+		host := ncfg.Address
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", ncfg.From, ncfg.Token, host)
+	}
+	return smtp.SendMail(ncfg.Address, auth, ncfg.From, ncfg.To, b.Bytes())
+}
+
+func deliverAlertWebhook(ncfg config.NotificationConfiguration, a alert, body string) error {
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(map[string]interface{}{
+		"type":    a.Type,
+		"time":    a.Time,
+		"device":  a.Device,
+		"folder":  a.Folder,
+		"message": body,
+	}); err != nil {
+		return err
+	}
+	resp, err := http.Post(ncfg.Address, "application/json", &b)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// deliverAlertMatrix posts the message to each room in To via the client
+// API of the homeserver in Address, using the access token in Token.
+func deliverAlertMatrix(ncfg config.NotificationConfiguration, body string) error {
+	msg, err := json.Marshal(map[string]string{
+		"msgtype": "m.text",
+		"body":    body,
+	})
+	if err != nil {
+		return err
+	}
+	for _, room := range ncfg.To {
+		postURL := fmt.Sprintf("%s/_matrix/client/r0/rooms/%s/send/m.room.message?access_token=%s",
+			strings.TrimRight(ncfg.Address, "/"), url.PathEscape(room), url.QueryEscape(ncfg.Token))
+		resp, err := http.Post(postURL, "application/json", bytes.NewReader(msg))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("homeserver returned %s", resp.Status)
+		}
+	}
+	return nil
+}
+
+// deliverAlertXMPP sends the message in a minimal send-only XMPP session:
+// TLS on connect (usually port 5223), SASL PLAIN with From/Token, one
+// message stanza per recipient.
+func deliverAlertXMPP(ncfg config.NotificationConfiguration, body string) error {
+	conn, err := tls.Dial("tcp", ncfg.Address, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	user, domain := ncfg.From, ncfg.From
+	if i := strings.Index(ncfg.From, "@"); i >= 0 {
+		user, domain = ncfg.From[:i], ncfg.From[i+1:]
+	}
+
+	br := bufio.NewReader(conn)
+	openStream := func() error {
+		fmt.Fprintf(conn, "<stream:stream to='%s' xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>", xmlEscape(domain))
+		return expectXMPP(br, "<stream:features", "</stream:features>")
+	}
+
+	if err := openStream(); err != nil {
+		return err
+	}
+	sasl := base64.StdEncoding.EncodeToString([]byte("\x00" + user + "\x00" + ncfg.Token))
+	fmt.Fprintf(conn, "<auth xmlns='urn:ietf:params:xml:ns:xmpp-sasl' mechanism='PLAIN'>%s</auth>", sasl)
+	if err := expectXMPP(br, "<success", "/>"); err != nil {
+		return fmt.Errorf("authentication failed: %v", err)
+	}
+	if err := openStream(); err != nil {
+		return err
+	}
+	fmt.Fprint(conn, "<iq type='set' id='bind'><bind xmlns='urn:ietf:params:xml:ns:xmpp-bind'/></iq>")
+	if err := expectXMPP(br, "<jid>", "</iq>"); err != nil {
+		return fmt.Errorf("resource binding failed: %v", err)
+	}
+
+	for _, to := range ncfg.To {
+		fmt.Fprintf(conn, "<message to='%s' type='chat'><body>%s</body></message>", xmlEscape(to), xmlEscape(body))
+	}
+	fmt.Fprint(conn, "</stream:stream>")
+	return nil
+}
+
+// expectXMPP reads from the stream until both markers have been seen, or
+// errors out on stream errors and timeouts.
+func expectXMPP(br *bufio.Reader, markers ...string) error {
+	var seen string
+	for {
+		bs := make([]byte, 4096)
+		n, err := br.Read(bs)
+		if err != nil {
+			return err
+		}
+		seen += string(bs[:n])
+		if strings.Contains(seen, "<stream:error") || strings.Contains(seen, "<failure") {
+			return fmt.Errorf("server said: %s", seen)
+		}
+		found := true
+		for _, marker := range markers {
+			if !strings.Contains(seen, marker) {
+				found = false
+			}
+		}
+		if found {
+			return nil
+		}
+	}
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,199 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"time"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syncthing/syncthing/lib/sha256"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+type benchResults struct {
+	HashMBPerSec     float64 `json:"hashMBPerSec"`
+	DBWriteOpsPerSec float64 `json:"dbWriteOpsPerSec"`
+	DBReadOpsPerSec  float64 `json:"dbReadOpsPerSec"`
+	NetworkMBPerSec  float64 `json:"networkMBPerSec"`
+}
+
+// runBenchmarks measures local hashing throughput, database write and read
+// performance, and loopback protocol throughput, printing the results as
+// JSON on stdout. This quantifies the hardware we're running on, so that
+// performance issues can be compared rather than guessed at.
+func runBenchmarks() {
+	var res benchResults
+	var err error
+
+	l.Infoln("Benchmarking hashing...")
+	res.HashMBPerSec = benchmarkHashing()
+
+	l.Infoln("Benchmarking database...")
+	res.DBWriteOpsPerSec, res.DBReadOpsPerSec, err = benchmarkDB()
+	if err != nil {
+		l.Fatalln("Database benchmark:", err)
+	}
+
+	l.Infoln("Benchmarking loopback protocol...")
+	res.NetworkMBPerSec, err = benchmarkNetwork()
+	if err != nil {
+		l.Fatalln("Network benchmark:", err)
+	}
+
+	bs, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		l.Fatalln("Marshalling results:", err)
+	}
+	fmt.Println(string(bs))
+}
+
+// benchmarkHashing hashes 128 MiB of data in block sized chunks and
+// returns the throughput in MiB/s.
+func benchmarkHashing() float64 {
+	const total = 128 << 20
+
+	buf := make([]byte, protocol.BlockSize)
+	for i := range buf {
+		buf[i] = byte(i)
+	}
+
+	h := sha256.New()
+	t0 := time.Now()
+	for hashed := 0; hashed < total; hashed += len(buf) {
+		h.Reset()
+		h.Write(buf)
+		h.Sum(nil)
+	}
+	return total / (1 << 20) / time.Since(t0).Seconds()
+}
+
+// benchmarkDB writes and then reads back a bunch of small keys in a
+// throwaway database, returning the write and read rates in operations
+// per second.
+func benchmarkDB() (writeOps, readOps float64, err error) {
+	const ops = 20000
+
+	dir, err := ioutil.TempDir("", "syncthing-bench")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer os.RemoveAll(dir)
+
+	ldb, err := leveldb.OpenFile(dir, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer ldb.Close()
+
+	key := make([]byte, 16)
+	val := make([]byte, 128)
+
+	t0 := time.Now()
+	for i := 0; i < ops; i++ {
+		binary.BigEndian.PutUint64(key, uint64(i))
+		if err := ldb.Put(key, val, nil); err != nil {
+			return 0, 0, err
+		}
+	}
+	writeOps = ops / time.Since(t0).Seconds()
+
+	t0 = time.Now()
+	for i := 0; i < ops; i++ {
+		binary.BigEndian.PutUint64(key, uint64(i))
+		if _, err := ldb.Get(key, nil); err != nil {
+			return 0, 0, err
+		}
+	}
+	readOps = ops / time.Since(t0).Seconds()
+
+	return writeOps, readOps, nil
+}
+
+// benchmarkNetwork sets up a protocol connection pair over a TCP loopback
+// socket, pulls a bunch of blocks through it and returns the throughput in
+// MiB/s. This exercises the full message marshalling and socket path, but
+// no disk I/O.
+func benchmarkNetwork() (float64, error) {
+	const requests = 256
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+	server := <-accepted
+	defer server.Close()
+
+	serverConn := protocol.NewConnection(protocol.LocalDeviceID, server, server, &benchModel{}, "bench-server", protocol.CompressNever, 0, 0)
+	clientConn := protocol.NewConnection(protocol.LocalDeviceID, client, client, &benchModel{}, "bench-client", protocol.CompressNever, 0, 0)
+	serverConn.Start()
+	clientConn.Start()
+
+	// The connections must exchange cluster configs before they accept
+	// requests.
+	serverConn.ClusterConfig(protocol.ClusterConfig{})
+	clientConn.ClusterConfig(protocol.ClusterConfig{})
+
+	t0 := time.Now()
+	for i := 0; i < requests; i++ {
+		if _, err := clientConn.Request("bench", "file", 0, protocol.BlockSize, nil, false); err != nil {
+			return 0, err
+		}
+	}
+	elapsed := time.Since(t0)
+
+	return float64(requests) * protocol.BlockSize / (1 << 20) / elapsed.Seconds(), nil
+}
+
+// benchModel is a protocol.Model that serves requests with dummy data and
+// ignores everything else.
+type benchModel struct{}
+
+func (m *benchModel) Index(deviceID protocol.DeviceID, folder string, files []protocol.FileInfo) {
+}
+
+func (m *benchModel) IndexUpdate(deviceID protocol.DeviceID, folder string, files []protocol.FileInfo) {
+}
+
+func (m *benchModel) Request(deviceID protocol.DeviceID, folder, name string, offset int64, hash []byte, fromTemporary bool, buf []byte) error {
+	for i := range buf {
+		buf[i] = byte(i)
+	}
+	return nil
+}
+
+func (m *benchModel) ClusterConfig(deviceID protocol.DeviceID, config protocol.ClusterConfig) {
+}
+
+func (m *benchModel) Closed(conn protocol.Connection, err error) {
+}
+
+func (m *benchModel) DownloadProgress(deviceID protocol.DeviceID, folder string, updates []protocol.FileDownloadProgressUpdate) {
+}
+
+func (m *benchModel) ConfigUpdate(deviceID protocol.DeviceID, update protocol.ConfigUpdate) {
+}
@@ -8,12 +8,16 @@ package main
 
 import (
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"reflect"
 	"runtime"
@@ -27,7 +31,9 @@ import (
 	"github.com/syncthing/syncthing/lib/config"
 	"github.com/syncthing/syncthing/lib/db"
 	"github.com/syncthing/syncthing/lib/discover"
+	"github.com/syncthing/syncthing/lib/errcat"
 	"github.com/syncthing/syncthing/lib/events"
+	"github.com/syncthing/syncthing/lib/ignore"
 	"github.com/syncthing/syncthing/lib/logger"
 	"github.com/syncthing/syncthing/lib/model"
 	"github.com/syncthing/syncthing/lib/osutil"
@@ -37,6 +43,7 @@ import (
 	"github.com/syncthing/syncthing/lib/sync"
 	"github.com/syncthing/syncthing/lib/tlsutil"
 	"github.com/syncthing/syncthing/lib/upgrade"
+	"github.com/syncthing/syncthing/lib/versioner"
 	"github.com/vitrun/qart/qr"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -57,7 +64,9 @@ type apiService struct {
 	discoverer         discover.CachingMux
 	connectionsService connectionsIntf
 	fss                *folderSummaryService
-	systemConfigMut    sync.Mutex    // serializes posts to /rest/system/config
+	systemConfigMut    sync.Mutex // serializes posts to /rest/system/config
+	patternSubsMut     sync.Mutex // protects patternSubs
+	patternSubs        map[string][]string
 	stop               chan struct{} // signals intentional stop
 	configChanged      chan struct{} // signals intentional listener close due to config change
 	started            chan string   // signals startup complete by sending the listener address, for testing only
@@ -65,21 +74,31 @@ type apiService struct {
 
 	guiErrors logger.Recorder
 	systemLog logger.Recorder
+
+	// guiCfgOverride is set for services serving an extra GUI listener, in
+	// which case it is used instead of the main GUI configuration.
+	guiCfgOverride *config.GUIConfiguration
 }
 
 type modelIntf interface {
 	GlobalDirectoryTree(folder, prefix string, levels int, dirsonly bool) map[string]interface{}
 	Completion(device protocol.DeviceID, folder string) model.FolderCompletion
 	Override(folder string)
-	NeedFolderFiles(folder string, page, perpage int) ([]db.FileInfoTruncated, []db.FileInfoTruncated, []db.FileInfoTruncated, int)
+	DropDeviceVersions(folder string) (int, error)
+	NeedFolderFiles(folder, prefix string, page, perpage int) ([]db.FileInfoTruncated, []db.FileInfoTruncated, []db.FileInfoTruncated, int)
 	NeedSize(folder string) (nfiles, ndeletes int, bytes int64)
 	ConnectionStats() map[string]interface{}
 	DeviceStatistics() map[string]stats.DeviceStatistics
 	FolderStatistics() map[string]stats.FolderStatistics
+	FolderHistory(folder string, since time.Time) []stats.HistorySample
 	CurrentFolderFile(folder string, file string) (protocol.FileInfo, bool)
 	CurrentGlobalFile(folder string, file string) (protocol.FileInfo, bool)
 	ResetFolder(folder string)
 	Availability(folder, file string, version protocol.Vector, block protocol.BlockInfo) []model.Availability
+	FileHolders(folder, file string) []model.FileHolder
+	BlockHolders(folder string, hash []byte) []model.BlockHolder
+	OpenPreview(folder, file string) (*os.File, error)
+	AcceptChanges(folder string, device protocol.DeviceID)
 	GetIgnores(folder string) ([]string, []string, error)
 	SetIgnores(folder string, content []string) error
 	PauseDevice(device protocol.DeviceID)
@@ -89,6 +108,31 @@ type modelIntf interface {
 	ScanFolders() map[string]error
 	ScanFolderSubdirs(folder string, subs []string) error
 	BringToFront(folder, file string)
+	SendToBack(folder, file string)
+	SkipJob(folder, file string)
+	RetryJob(folder, file string)
+	PullQueue(folder string) []model.PullQueueItem
+	DiskUsage() map[string]model.FolderDiskUsage
+	BlockStats() db.BlockStats
+	FolderAnalysis(folder string) (model.FolderAnalysis, error)
+	PendingChanges(folder string) (model.PendingChanges, error)
+	PushConfigUpdate(device protocol.DeviceID, update protocol.ConfigUpdate) error
+	CheckDBIntegrity(level db.CheckLevel) []db.CheckResult
+	ExportIndex(folder string, w io.Writer) error
+	ExportArchive(folder, prefix, format string, w io.Writer) error
+	DBCompactionStats() db.CompactionStats
+	FileProvenance(folder, file string) (stats.FileProvenance, bool)
+	FolderProvenanceSummary(folder string) stats.ProvenanceSummary
+	DBTransactionStats() db.TransactionStats
+	SystemSummary() model.SystemSummary
+	CompactDB() error
+	ImportIndex(folder string, r io.Reader) (int, error)
+	Holds(folder string) (map[string]model.FileHold, error)
+	PlaceHold(folder, file string) error
+	ReleaseHold(folder, file string) error
+	FolderVersions(folder string) (map[string][]versioner.ArchivedVersion, error)
+	VersionsAsOf(folder string, asOf time.Time) (map[string]versioner.ArchivedVersion, error)
+	RestoreVersionsAsOf(folder string, asOf time.Time, paths []string) (map[string]string, error)
 	ConnectedTo(deviceID protocol.DeviceID) bool
 	GlobalSize(folder string) (nfiles, deleted int, bytes int64)
 	LocalSize(folder string) (nfiles, deleted int, bytes int64)
@@ -108,6 +152,9 @@ type configIntf interface {
 	Save() error
 	ListenAddresses() []string
 	RequiresRestart() bool
+	ListBackups() ([]config.BackupInfo, error)
+	BackupConfig(name string, myID protocol.DeviceID) (config.Configuration, error)
+	RestoreBackup(name string, myID protocol.DeviceID) error
 }
 
 type connectionsIntf interface {
@@ -127,6 +174,8 @@ func newAPIService(id protocol.DeviceID, cfg configIntf, httpsCertFile, httpsKey
 		discoverer:         discoverer,
 		connectionsService: connectionsService,
 		systemConfigMut:    sync.NewMutex(),
+		patternSubsMut:     sync.NewMutex(),
+		patternSubs:        make(map[string][]string),
 		stop:               make(chan struct{}),
 		configChanged:      make(chan struct{}),
 		startedOnce:        make(chan struct{}),
@@ -137,8 +186,28 @@ func newAPIService(id protocol.DeviceID, cfg configIntf, httpsCertFile, httpsKey
 	return service
 }
 
+// guiConfig returns the GUI configuration for this specific listener, which
+// is the main GUI configuration unless an override was set for an extra
+// listener.
+func (s *apiService) guiConfig() config.GUIConfiguration {
+	if s.guiCfgOverride != nil {
+		return *s.guiCfgOverride
+	}
+	return s.cfg.GUI()
+}
+
 func (s *apiService) getListener(guiCfg config.GUIConfiguration) (net.Listener, error) {
-	cert, err := tls.LoadX509KeyPair(s.httpsCertFile, s.httpsKeyFile)
+	httpsCertFile, httpsKeyFile := s.httpsCertFile, s.httpsKeyFile
+	if guiCfg.CertFile != "" && guiCfg.KeyFile != "" {
+		httpsCertFile, httpsKeyFile = guiCfg.CertFile, guiCfg.KeyFile
+	}
+
+	cert, err := tls.LoadX509KeyPair(httpsCertFile, httpsKeyFile)
+	if err != nil && httpsCertFile != s.httpsCertFile {
+		// The user has configured their own certificate (e.g. one managed
+		// by an ACME client); we should not try to replace it.
+		return nil, err
+	}
 	if err != nil {
 		l.Infoln("Loading HTTPS certificate:", err)
 		l.Infoln("Creating new HTTPS certificate")
@@ -151,14 +220,19 @@ func (s *apiService) getListener(guiCfg config.GUIConfiguration) (net.Listener,
 			name = tlsDefaultCommonName
 		}
 
-		cert, err = tlsutil.NewCertificate(s.httpsCertFile, s.httpsKeyFile, name, httpsRSABits)
+		cert, err = tlsutil.NewCertificate(httpsCertFile, httpsKeyFile, name, httpsRSABits)
 	}
 	if err != nil {
 		return nil, err
 	}
+
+	// Serve the certificate via a reloader, so that renewed certificates
+	// (e.g. from Let's Encrypt) are picked up without a restart.
+	reloader := newCertificateReloader(httpsCertFile, httpsKeyFile, cert)
+
 	tlsCfg := &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		MinVersion:   tls.VersionTLS10, // No SSLv3
+		GetCertificate: reloader.GetCertificate,
+		MinVersion:     tls.VersionTLS10, // No SSLv3
 		CipherSuites: []uint16{
 			// No RC4
 			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
@@ -186,6 +260,54 @@ func (s *apiService) getListener(guiCfg config.GUIConfiguration) (net.Listener,
 	return listener, nil
 }
 
+// How often, at most, we check whether the certificate on disk has changed.
+const certCheckInterval = time.Minute
+
+// A certificateReloader hands out the HTTPS certificate, reloading it from
+// disk when the file changes so that externally renewed certificates (e.g.
+// by an ACME client such as certbot) are picked up without a restart.
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+	cert     tls.Certificate
+	modTime  time.Time
+	checked  time.Time
+	mut      sync.Mutex
+}
+
+func newCertificateReloader(certFile, keyFile string, cert tls.Certificate) *certificateReloader {
+	r := &certificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		cert:     cert,
+		checked:  time.Now(),
+		mut:      sync.NewMutex(),
+	}
+	if info, err := os.Stat(certFile); err == nil {
+		r.modTime = info.ModTime()
+	}
+	return r
+}
+
+func (r *certificateReloader) GetCertificate(h *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	if time.Since(r.checked) > certCheckInterval {
+		r.checked = time.Now()
+		if info, err := os.Stat(r.certFile); err == nil && !info.ModTime().Equal(r.modTime) {
+			if cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile); err == nil {
+				l.Infoln("Reloaded HTTPS certificate from", r.certFile)
+				r.cert = cert
+				r.modTime = info.ModTime()
+			} else {
+				l.Warnln("Reloading HTTPS certificate:", err)
+			}
+		}
+	}
+	return &r.cert, nil
+}
+
 func sendJSON(w http.ResponseWriter, jsonObject interface{}) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	// Marshalling might fail, in which case we should return a 500 with the
@@ -201,7 +323,7 @@ func sendJSON(w http.ResponseWriter, jsonObject interface{}) {
 }
 
 func (s *apiService) Serve() {
-	listener, err := s.getListener(s.cfg.GUI())
+	listener, err := s.getListener(s.guiConfig())
 	if err != nil {
 		select {
 		case <-s.startedOnce:
@@ -211,6 +333,12 @@ func (s *apiService) Serve() {
 			return
 
 		default:
+			if s.guiCfgOverride != nil {
+				// An extra listener failing is not fatal; the main GUI is
+				// still available.
+				l.Warnln("Starting extra API/GUI listener:", err)
+				return
+			}
 			// This is during initialization. A failure here should be fatal
 			// as there will be no way for the user to communicate with us
 			// otherwise anyway.
@@ -228,51 +356,97 @@ func (s *apiService) Serve() {
 
 	// The GET handlers
 	getRestMux := http.NewServeMux()
-	getRestMux.HandleFunc("/rest/db/completion", s.getDBCompletion)              // device folder
-	getRestMux.HandleFunc("/rest/db/file", s.getDBFile)                          // folder file
-	getRestMux.HandleFunc("/rest/db/ignores", s.getDBIgnores)                    // folder
-	getRestMux.HandleFunc("/rest/db/need", s.getDBNeed)                          // folder [perpage] [page]
-	getRestMux.HandleFunc("/rest/db/status", s.getDBStatus)                      // folder
-	getRestMux.HandleFunc("/rest/db/browse", s.getDBBrowse)                      // folder [prefix] [dirsonly] [levels]
-	getRestMux.HandleFunc("/rest/events", s.getIndexEvents)                      // since [limit]
-	getRestMux.HandleFunc("/rest/events/disk", s.getDiskEvents)                  // since [limit]
-	getRestMux.HandleFunc("/rest/stats/device", s.getDeviceStats)                // -
-	getRestMux.HandleFunc("/rest/stats/folder", s.getFolderStats)                // -
-	getRestMux.HandleFunc("/rest/svc/deviceid", s.getDeviceID)                   // id
-	getRestMux.HandleFunc("/rest/svc/lang", s.getLang)                           // -
-	getRestMux.HandleFunc("/rest/svc/report", s.getReport)                       // -
-	getRestMux.HandleFunc("/rest/svc/random/string", s.getRandomString)          // [length]
-	getRestMux.HandleFunc("/rest/system/browse", s.getSystemBrowse)              // current
-	getRestMux.HandleFunc("/rest/system/config", s.getSystemConfig)              // -
-	getRestMux.HandleFunc("/rest/system/config/insync", s.getSystemConfigInsync) // -
-	getRestMux.HandleFunc("/rest/system/connections", s.getSystemConnections)    // -
-	getRestMux.HandleFunc("/rest/system/discovery", s.getSystemDiscovery)        // -
-	getRestMux.HandleFunc("/rest/system/error", s.getSystemError)                // -
-	getRestMux.HandleFunc("/rest/system/ping", s.restPing)                       // -
-	getRestMux.HandleFunc("/rest/system/status", s.getSystemStatus)              // -
-	getRestMux.HandleFunc("/rest/system/upgrade", s.getSystemUpgrade)            // -
-	getRestMux.HandleFunc("/rest/system/version", s.getSystemVersion)            // -
-	getRestMux.HandleFunc("/rest/system/debug", s.getSystemDebug)                // -
-	getRestMux.HandleFunc("/rest/system/log", s.getSystemLog)                    // [since]
-	getRestMux.HandleFunc("/rest/system/log.txt", s.getSystemLogTxt)             // [since]
+	getRestMux.HandleFunc("/rest/db/completion", s.getDBCompletion)                        // device folder
+	getRestMux.HandleFunc("/rest/db/file", s.getDBFile)                                    // folder file
+	getRestMux.HandleFunc("/rest/db/availability", s.getDBAvailability)                    // folder file|blockhash
+	getRestMux.HandleFunc("/rest/db/preview", s.getDBPreview)                              // folder file
+	getRestMux.HandleFunc("/rest/db/ignores", s.getDBIgnores)                              // folder
+	getRestMux.HandleFunc("/rest/db/need", s.getDBNeed)                                    // folder [prefix] [perpage] [page]
+	getRestMux.HandleFunc("/rest/db/status", s.getDBStatus)                                // folder
+	getRestMux.HandleFunc("/rest/db/analysis", s.getDBAnalysis)                            // folder
+	getRestMux.HandleFunc("/rest/db/pendingchanges", s.getDBPendingChanges)                // folder
+	getRestMux.HandleFunc("/rest/db/provenance", s.getDBProvenance)                        // folder [file]
+	getRestMux.HandleFunc("/rest/db/browse", s.getDBBrowse)                                // folder [prefix] [dirsonly] [levels]
+	getRestMux.HandleFunc("/rest/db/queue", s.getDBQueue)                                  // folder
+	getRestMux.HandleFunc("/rest/db/export", s.getDBExport)                                // folder
+	getRestMux.HandleFunc("/rest/db/holds", s.getDBHolds)                                  // folder
+	getRestMux.HandleFunc("/rest/db/usage", s.getDBUsage)                                  // -
+	getRestMux.HandleFunc("/rest/db/blockstats", s.getDBBlockStats)                        // -
+	getRestMux.HandleFunc("/rest/events", s.getIndexEvents)                                // [since] [limit] [events]
+	getRestMux.HandleFunc("/rest/events/disk", s.getDiskEvents)                            // [since] [limit] [events]
+	getRestMux.HandleFunc("/rest/events/subscriptions", s.getPatternEvents)                // id [since] [limit]
+	getRestMux.HandleFunc("/rest/folder/archive", s.getFolderArchive)                      // folder [prefix] [format]
+	getRestMux.HandleFunc("/rest/folder/versions", s.getFolderVersions)                    // folder [asof]
+	getRestMux.HandleFunc("/rest/stats/device", s.getDeviceStats)                          // -
+	getRestMux.HandleFunc("/rest/stats/folder", s.getFolderStats)                          // -
+	getRestMux.HandleFunc("/rest/stats/folder/history", s.getFolderStatsHistory)           // folder [since]
+	getRestMux.HandleFunc("/rest/svc/deviceid", s.getDeviceID)                             // id
+	getRestMux.HandleFunc("/rest/svc/errorcatalog", s.getErrorCatalog)                     // -
+	getRestMux.HandleFunc("/rest/svc/lang", s.getLang)                                     // -
+	getRestMux.HandleFunc("/rest/svc/report", s.getReport)                                 // -
+	getRestMux.HandleFunc("/rest/svc/random/string", s.getRandomString)                    // [length]
+	getRestMux.HandleFunc("/rest/system/browse", s.getSystemBrowse)                        // current
+	getRestMux.HandleFunc("/rest/system/config", s.getSystemConfig)                        // -
+	getRestMux.HandleFunc("/rest/system/config/devices", s.getSystemConfigDevices)         // [tags]
+	getRestMux.HandleFunc("/rest/system/config/folders", s.getSystemConfigFolders)         // [tags]
+	getRestMux.HandleFunc("/rest/system/config/insync", s.getSystemConfigInsync)           // -
+	getRestMux.HandleFunc("/rest/system/config/downgrade", s.getSystemConfigDowngrade)     // version
+	getRestMux.HandleFunc("/rest/system/config/backups", s.getSystemConfigBackups)         // -
+	getRestMux.HandleFunc("/rest/system/config/diff", s.getSystemConfigDiff)               // name
+	getRestMux.HandleFunc("/rest/system/compaction", s.getSystemCompaction)                // -
+	getRestMux.HandleFunc("/rest/system/transactions", s.getSystemTransactions)            // -
+	getRestMux.HandleFunc("/rest/system/connections", s.getSystemConnections)              // -
+	getRestMux.HandleFunc("/rest/system/discovery", s.getSystemDiscovery)                  // -
+	getRestMux.HandleFunc("/rest/system/infrastructure", s.getSystemInfrastructure)        // -
+	getRestMux.HandleFunc("/rest/system/error", s.getSystemError)                          // -
+	getRestMux.HandleFunc("/rest/system/ping", s.restPing)                                 // -
+	getRestMux.HandleFunc("/rest/system/setup", s.getSystemSetup)                          // -
+	getRestMux.HandleFunc("/rest/system/setup/introduction", s.getSystemSetupIntroduction) // -
+	getRestMux.HandleFunc("/rest/system/status", s.getSystemStatus)                        // -
+	getRestMux.HandleFunc("/rest/system/summary", s.getSystemSummary)                      // -
+	getRestMux.HandleFunc("/rest/system/topology", s.getSystemTopology)                    // -
+	getRestMux.HandleFunc("/rest/system/upgrade", s.getSystemUpgrade)                      // -
+	getRestMux.HandleFunc("/rest/system/version", s.getSystemVersion)                      // -
+	getRestMux.HandleFunc("/rest/system/debug", s.getSystemDebug)                          // -
+	getRestMux.HandleFunc("/rest/system/log", s.getSystemLog)                              // [since]
+	getRestMux.HandleFunc("/rest/system/log.txt", s.getSystemLogTxt)                       // [since]
 
 	// The POST handlers
 	postRestMux := http.NewServeMux()
-	postRestMux.HandleFunc("/rest/db/prio", s.postDBPrio)                      // folder file [perpage] [page]
-	postRestMux.HandleFunc("/rest/db/ignores", s.postDBIgnores)                // folder
-	postRestMux.HandleFunc("/rest/db/override", s.postDBOverride)              // folder
-	postRestMux.HandleFunc("/rest/db/scan", s.postDBScan)                      // folder [sub...] [delay]
-	postRestMux.HandleFunc("/rest/system/config", s.postSystemConfig)          // <body>
-	postRestMux.HandleFunc("/rest/system/error", s.postSystemError)            // <body>
-	postRestMux.HandleFunc("/rest/system/error/clear", s.postSystemErrorClear) // -
-	postRestMux.HandleFunc("/rest/system/ping", s.restPing)                    // -
-	postRestMux.HandleFunc("/rest/system/reset", s.postSystemReset)            // [folder]
-	postRestMux.HandleFunc("/rest/system/restart", s.postSystemRestart)        // -
-	postRestMux.HandleFunc("/rest/system/shutdown", s.postSystemShutdown)      // -
-	postRestMux.HandleFunc("/rest/system/upgrade", s.postSystemUpgrade)        // -
-	postRestMux.HandleFunc("/rest/system/pause", s.postSystemPause)            // device
-	postRestMux.HandleFunc("/rest/system/resume", s.postSystemResume)          // device
-	postRestMux.HandleFunc("/rest/system/debug", s.postSystemDebug)            // [enable] [disable]
+	postRestMux.HandleFunc("/rest/db/acceptchanges", s.postDBAcceptChanges)          // folder device
+	postRestMux.HandleFunc("/rest/db/check", s.postDBCheck)                          // [repair]
+	postRestMux.HandleFunc("/rest/db/compact", s.postDBCompact)                      // -
+	postRestMux.HandleFunc("/rest/db/holds", s.postDBHolds)                          // folder file [release]
+	postRestMux.HandleFunc("/rest/db/import", s.postDBImport)                        // folder <body>
+	postRestMux.HandleFunc("/rest/db/prio", s.postDBPrio)                            // folder file [perpage] [page]
+	postRestMux.HandleFunc("/rest/db/queue/back", s.postDBQueueBack)                 // folder file
+	postRestMux.HandleFunc("/rest/db/queue/skip", s.postDBQueueSkip)                 // folder file
+	postRestMux.HandleFunc("/rest/db/queue/retry", s.postDBQueueRetry)               // folder file
+	postRestMux.HandleFunc("/rest/db/ignores", s.postDBIgnores)                      // folder
+	postRestMux.HandleFunc("/rest/db/override", s.postDBOverride)                    // folder
+	postRestMux.HandleFunc("/rest/db/prune", s.postDBPrune)                          // folder
+	postRestMux.HandleFunc("/rest/db/scan", s.postDBScan)                            // folder [sub...] [delay]
+	postRestMux.HandleFunc("/rest/events/subscriptions", s.postPatternEvents)        // [remove] <body>
+	postRestMux.HandleFunc("/rest/folder/restore", s.postFolderRestore)              // folder asof <body>
+	postRestMux.HandleFunc("/rest/system/config", s.postSystemConfig)                // <body>
+	postRestMux.HandleFunc("/rest/system/config/devices", s.postSystemConfigDevices) // <body>
+	postRestMux.HandleFunc("/rest/system/config/folders", s.postSystemConfigFolders) // <body>
+	postRestMux.HandleFunc("/rest/system/config/restore", s.postSystemConfigRestore) // name
+	postRestMux.HandleFunc("/rest/system/error", s.postSystemError)                  // <body>
+	postRestMux.HandleFunc("/rest/system/error/clear", s.postSystemErrorClear)       // -
+	postRestMux.HandleFunc("/rest/system/ping", s.restPing)                          // -
+	postRestMux.HandleFunc("/rest/system/reset", s.postSystemReset)                  // [folder]
+	postRestMux.HandleFunc("/rest/system/restart", s.postSystemRestart)              // -
+	postRestMux.HandleFunc("/rest/system/setup/defaults", s.postSystemSetupDefaults) // <body>
+	postRestMux.HandleFunc("/rest/system/setup/device", s.postSystemSetupDevice)     // <body>
+	postRestMux.HandleFunc("/rest/system/setup/folder", s.postSystemSetupFolder)     // <body>
+	postRestMux.HandleFunc("/rest/system/shutdown", s.postSystemShutdown)            // -
+	postRestMux.HandleFunc("/rest/system/upgrade", s.postSystemUpgrade)              // -
+	postRestMux.HandleFunc("/rest/system/logout", s.postSystemLogout)                // -
+	postRestMux.HandleFunc("/rest/system/configpush", s.postSystemConfigPush)        // device <body>
+	postRestMux.HandleFunc("/rest/system/pause", s.postSystemPause)                  // device
+	postRestMux.HandleFunc("/rest/system/resume", s.postSystemResume)                // device
+	postRestMux.HandleFunc("/rest/system/debug", s.postSystemDebug)                  // [enable] [disable]
 
 	// Debug endpoints, not for general use
 	debugMux := http.NewServeMux()
@@ -282,14 +456,17 @@ func (s *apiService) Serve() {
 	debugMux.HandleFunc("/rest/debug/heapprof", s.getHeapProf)
 	getRestMux.Handle("/rest/debug/", s.whenDebugging(debugMux))
 
-	// A handler that splits requests between the two above and disables
-	// caching
-	restMux := noCacheMiddleware(metricsMiddleware(getPostHandler(getRestMux, postRestMux)))
+	guiCfg := s.guiConfig()
+
+	// A handler that splits requests between the two above, rate limits
+	// clients, records metrics and disables caching
+	restMux := noCacheMiddleware(rateLimitMiddleware(newAPIRateLimiter(guiCfg.APIRateLimit), metricsMiddleware(getPostHandler(getRestMux, postRestMux))))
 
 	// The main routing handler
 	mux := http.NewServeMux()
 	mux.Handle("/rest/", restMux)
 	mux.HandleFunc("/qr/", s.getQR)
+	mux.HandleFunc("/export/", s.getFolderExport)
 
 	// Serve compiled in assets unless an asset directory was set (for development)
 	mux.Handle("/", s.statics)
@@ -297,8 +474,6 @@ func (s *apiService) Serve() {
 	// Handle the special meta.js path
 	mux.HandleFunc("/meta.js", s.getJSMetadata)
 
-	guiCfg := s.cfg.GUI()
-
 	// Wrap everything in CSRF protection. The /rest prefix should be
 	// protected, other requests will grant cookies.
 	handler := csrfMiddleware(s.id.String()[:5], "/rest", guiCfg, mux)
@@ -306,16 +481,31 @@ func (s *apiService) Serve() {
 	// Add our version and ID as a header to responses
 	handler = withDetailsMiddleware(s.id, handler)
 
-	// Wrap everything in basic auth, if user/password is set.
-	if len(guiCfg.User) > 0 && len(guiCfg.Password) > 0 {
+	// Wrap everything in basic auth, if enabled.
+	if guiCfg.IsAuthEnabled() {
 		handler = basicAuthAndSessionMiddleware("sessionid-"+s.id.String()[:5], guiCfg, handler)
 	}
 
+	// Requests with a folder scoped token get access to a small set of
+	// folder endpoints directly, bypassing the other authentication.
+	handler = folderTokenMiddleware(s.cfg, restMux, handler)
+
+	// Likewise for namespace scoped API keys, which reach only the
+	// namespace aware config endpoints.
+	handler = namespaceKeyMiddleware(s.cfg, restMux, handler)
+
 	// Redirect to HTTPS if we are supposed to
 	if guiCfg.UseTLS() {
 		handler = redirectToHTTPSMiddleware(handler)
 	}
 
+	// Serve ACME HTTP-01 challenge responses without auth or redirection,
+	// so an external client (e.g. certbot in webroot mode) can answer
+	// challenges through us.
+	if guiCfg.ACMEChallengeDir != "" {
+		handler = acmeChallengeMiddleware(guiCfg.ACMEChallengeDir, handler)
+	}
+
 	// Add the CORS handling
 	handler = corsMiddleware(handler)
 
@@ -324,6 +514,18 @@ func (s *apiService) Serve() {
 		handler = localhostMiddleware(handler)
 	}
 
+	// Serve everything under the configured URL base, for deployments
+	// behind a path routing reverse proxy.
+	if base := guiCfg.BasePath(); base != "" {
+		handler = basePathMiddleware(base, handler)
+	}
+
+	// Rewrite the remote address and scheme from forwarded headers, if we
+	// are told to trust them.
+	if guiCfg.UseProxyHeaders {
+		handler = proxyHeadersMiddleware(handler)
+	}
+
 	handler = debugMiddleware(handler)
 
 	srv := http.Server{
@@ -384,10 +586,22 @@ func (s *apiService) VerifyConfiguration(from, to config.Configuration) error {
 	if _, err := net.ResolveTCPAddr("tcp", to.GUI.Address()); err != nil {
 		return err
 	}
+	for _, guiCfg := range to.ExtraGUIs {
+		if _, err := net.ResolveTCPAddr("tcp", guiCfg.Address()); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 func (s *apiService) CommitConfiguration(from, to config.Configuration) bool {
+	if s.guiCfgOverride != nil {
+		// We serve an extra listener with a configuration snapshot; any
+		// change to the extra listener list requires a restart to take
+		// effect.
+		return reflect.DeepEqual(from.ExtraGUIs, to.ExtraGUIs)
+	}
+
 	// No action required when this changes, so mask the fact that it changed at all.
 	from.GUI.Debugging = to.GUI.Debugging
 
@@ -444,6 +658,77 @@ func debugMiddleware(h http.Handler) http.Handler {
 	})
 }
 
+// The endpoints that a folder scoped token may call. They all take a
+// folder parameter, which is checked against the token's folder list.
+var folderTokenEndpoints = map[string]struct{}{
+	"/rest/db/browse": {},
+	"/rest/db/file":   {},
+	"/rest/db/need":   {},
+	"/rest/db/scan":   {},
+	"/rest/db/status": {},
+}
+
+// folderTokenMiddleware serves requests carrying a folder scoped token,
+// after checking the endpoint and folder against the token's grants. Other
+// requests pass through to the next handler.
+func folderTokenMiddleware(cfg configIntf, rest, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		folders, ok := config.FoldersForToken(cfg.Raw().FolderTokens, r.Header.Get("X-API-Key"))
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if _, ok := folderTokenEndpoints[r.URL.Path]; !ok {
+			http.Error(w, "Not Authorized", http.StatusForbidden)
+			return
+		}
+		folder := r.URL.Query().Get("folder")
+		granted := false
+		for _, f := range folders {
+			if f == folder {
+				granted = true
+				break
+			}
+		}
+		if !granted {
+			http.Error(w, "Not Authorized", http.StatusForbidden)
+			return
+		}
+
+		rest.ServeHTTP(w, r)
+	})
+}
+
+// The endpoints that a namespace scoped API key may call. Their handlers
+// filter or verify the affected resources against the key's namespace;
+// everything else is off limits for such keys.
+var namespaceKeyEndpoints = map[string]struct{}{
+	"/rest/system/config":         {},
+	"/rest/system/config/devices": {},
+	"/rest/system/config/folders": {},
+}
+
+// namespaceKeyMiddleware serves requests carrying a namespace scoped API
+// key, after checking the endpoint against the allowlist above. A
+// namespace key is not a full credential; requests with one never fall
+// through to the regular authentication.
+func namespaceKeyMiddleware(cfg configIntf, rest, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := config.NamespaceForAPIKey(cfg.Raw().Namespaces, r.Header.Get("X-API-Key")); !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if _, ok := namespaceKeyEndpoints[r.URL.Path]; !ok {
+			http.Error(w, "Not Authorized", http.StatusForbidden)
+			return
+		}
+
+		rest.ServeHTTP(w, r)
+	})
+}
+
 func corsMiddleware(next http.Handler) http.Handler {
 	// Handle CORS headers and CORS OPTIONS request.
 	// CORS OPTIONS request are typically sent by browser during AJAX preflight
@@ -480,9 +765,95 @@ func corsMiddleware(next http.Handler) http.Handler {
 func metricsMiddleware(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		t := metrics.GetOrRegisterTimer(r.URL.Path, nil)
+		cw := &countingResponseWriter{ResponseWriter: w}
 		t0 := time.Now()
-		h.ServeHTTP(w, r)
+		h.ServeHTTP(cw, r)
 		t.UpdateSince(t0)
+
+		// Response sizes per endpoint, and request rates per client, so
+		// that a hammering integration can be identified.
+		metrics.GetOrRegisterHistogram(r.URL.Path+":size", nil, metrics.NewExpDecaySample(1028, 0.015)).Update(cw.bytes)
+		metrics.GetOrRegisterMeter("client:"+apiClientID(r)+":"+r.URL.Path, nil).Mark(1)
+	})
+}
+
+// countingResponseWriter tallies the number of body bytes written.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	bytes int64
+}
+
+func (w *countingResponseWriter) Write(bs []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(bs)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush is forwarded so that the streaming endpoints keep working behind
+// the counting wrapper.
+func (w *countingResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+const acmeChallengePrefix = "/.well-known/acme-challenge/"
+
+func acmeChallengeMiddleware(dir string, h http.Handler) http.Handler {
+	fs := http.StripPrefix(acmeChallengePrefix, http.FileServer(http.Dir(dir)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, acmeChallengePrefix) {
+			fs.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// basePathMiddleware serves the wrapped handler under the given path
+// prefix. A request for the bare prefix is redirected to the slash
+// terminated form so that relative URLs in the GUI resolve correctly;
+// requests outside the prefix get a 404.
+func basePathMiddleware(base string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == base {
+			http.Redirect(w, r, base+"/", http.StatusMovedPermanently)
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, base+"/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		r2 := *r
+		u := *r.URL
+		u.Path = strings.TrimPrefix(r.URL.Path, base)
+		r2.URL = &u
+		h.ServeHTTP(w, &r2)
+	})
+}
+
+// proxyHeadersMiddleware rewrites the remote address and TLS indication
+// from the X-Forwarded-For and X-Forwarded-Proto headers set by a
+// trusted reverse proxy, so that login tracking and HTTPS redirection see
+// the real client.
+func proxyHeadersMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			// The header is a comma separated list of proxies; the first
+			// entry is the original client.
+			if i := strings.IndexByte(fwd, ','); i >= 0 {
+				fwd = fwd[:i]
+			}
+			r.RemoteAddr = strings.TrimSpace(fwd)
+		}
+		if r.TLS == nil && r.Header.Get("X-Forwarded-Proto") == "https" {
+			// The connection from the client to the proxy is encrypted, so
+			// mark the request as such to prevent a redirect loop through
+			// redirectToHTTPSMiddleware.
+			r.TLS = &tls.ConnectionState{}
+		}
+		h.ServeHTTP(w, r)
 	})
 }
 
@@ -529,7 +900,7 @@ func localhostMiddleware(h http.Handler) http.Handler {
 
 func (s *apiService) whenDebugging(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if s.cfg.GUI().Debugging {
+		if s.guiConfig().Debugging {
 			h.ServeHTTP(w, r)
 			return
 		}
@@ -560,6 +931,18 @@ func (s *apiService) getSystemVersion(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+func (s *apiService) getSystemCompaction(w http.ResponseWriter, r *http.Request) {
+	sendJSON(w, s.model.DBCompactionStats())
+}
+
+func (s *apiService) getSystemTransactions(w http.ResponseWriter, r *http.Request) {
+	sendJSON(w, s.model.DBTransactionStats())
+}
+
+func (s *apiService) getSystemSummary(w http.ResponseWriter, r *http.Request) {
+	sendJSON(w, s.model.SystemSummary())
+}
+
 func (s *apiService) getSystemDebug(w http.ResponseWriter, r *http.Request) {
 	names := l.Facilities()
 	enabled := l.FacilityDebugging()
@@ -623,6 +1006,30 @@ func (s *apiService) getDBCompletion(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// getDBAnalysis returns a content breakdown of the folder — extensions,
+// size histogram, largest files, deepest paths — computed from the index.
+func (s *apiService) getDBAnalysis(w http.ResponseWriter, r *http.Request) {
+	folder := r.URL.Query().Get("folder")
+	analysis, err := s.model.FolderAnalysis(folder)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	sendJSON(w, analysis)
+}
+
+// getDBPendingChanges returns the operations each device needs to reach
+// global state for the folder, as a preview of what syncing would do.
+func (s *apiService) getDBPendingChanges(w http.ResponseWriter, r *http.Request) {
+	folder := r.URL.Query().Get("folder")
+	changes, err := s.model.PendingChanges(folder)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	sendJSON(w, changes)
+}
+
 func (s *apiService) getDBStatus(w http.ResponseWriter, r *http.Request) {
 	qs := r.URL.Query()
 	folder := qs.Get("folder")
@@ -649,6 +1056,9 @@ func folderSummary(cfg configIntf, m modelIntf, folder string) map[string]interf
 	res["state"], res["stateChanged"], err = m.State(folder)
 	if err != nil {
 		res["error"] = err.Error()
+		if code := errcat.Code(err); code != "" {
+			res["errorCode"] = code
+		}
 	}
 
 	ourSeq, _ := m.CurrentSequence(folder)
@@ -669,16 +1079,57 @@ func folderSummary(cfg configIntf, m modelIntf, folder string) map[string]interf
 	return res
 }
 
+func (s *apiService) getDBProvenance(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	folder := qs.Get("folder")
+	if file := qs.Get("file"); file != "" {
+		prov, ok := s.model.FileProvenance(folder, file)
+		if !ok {
+			http.Error(w, "No provenance recorded", 404)
+			return
+		}
+		sendJSON(w, prov)
+		return
+	}
+	sendJSON(w, s.model.FolderProvenanceSummary(folder))
+}
+
 func (s *apiService) postDBOverride(w http.ResponseWriter, r *http.Request) {
 	var qs = r.URL.Query()
 	var folder = qs.Get("folder")
 	go s.model.Override(folder)
 }
 
+// postDBPrune drops lingering index data for devices that no longer share
+// the folder, and reports how many version list entries were removed.
+func (s *apiService) postDBPrune(w http.ResponseWriter, r *http.Request) {
+	folder := r.URL.Query().Get("folder")
+	dropped, err := s.model.DropDeviceVersions(folder)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	sendJSON(w, map[string]interface{}{
+		"folder":  folder,
+		"dropped": dropped,
+	})
+}
+
+func (s *apiService) postDBAcceptChanges(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	device, err := protocol.DeviceIDFromString(qs.Get("device"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.model.AcceptChanges(qs.Get("folder"), device)
+}
+
 func (s *apiService) getDBNeed(w http.ResponseWriter, r *http.Request) {
 	qs := r.URL.Query()
 
 	folder := qs.Get("folder")
+	prefix := qs.Get("prefix")
 
 	page, err := strconv.Atoi(qs.Get("page"))
 	if err != nil || page < 1 {
@@ -689,7 +1140,7 @@ func (s *apiService) getDBNeed(w http.ResponseWriter, r *http.Request) {
 		perpage = 1 << 16
 	}
 
-	progress, queued, rest, total := s.model.NeedFolderFiles(folder, page, perpage)
+	progress, queued, rest, total := s.model.NeedFolderFiles(folder, prefix, page, perpage)
 
 	// Convert the struct to a more loose structure, and inject the size.
 	sendJSON(w, map[string]interface{}{
@@ -714,6 +1165,21 @@ func (s *apiService) getFolderStats(w http.ResponseWriter, r *http.Request) {
 	sendJSON(w, s.model.FolderStatistics())
 }
 
+func (s *apiService) getFolderStatsHistory(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	folder := qs.Get("folder")
+	var since time.Time
+	if sinceStr := qs.Get("since"); sinceStr != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339, sinceStr)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	sendJSON(w, s.model.FolderHistory(folder, since))
+}
+
 func (s *apiService) getDBFile(w http.ResponseWriter, r *http.Request) {
 	qs := r.URL.Query()
 	folder := qs.Get("folder")
@@ -735,14 +1201,128 @@ func (s *apiService) getDBFile(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+func (s *apiService) getDBAvailability(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	folder := qs.Get("folder")
+
+	if blockhash := qs.Get("blockhash"); blockhash != "" {
+		hash, err := hex.DecodeString(blockhash)
+		if err != nil {
+			http.Error(w, "Invalid block hash", http.StatusBadRequest)
+			return
+		}
+		sendJSON(w, map[string]interface{}{
+			"blocks": s.model.BlockHolders(folder, hash),
+		})
+		return
+	}
+
+	holders := s.model.FileHolders(folder, qs.Get("file"))
+	if holders == nil {
+		http.Error(w, "No such object in the index", http.StatusNotFound)
+		return
+	}
+	sendJSON(w, map[string]interface{}{
+		"holders": holders,
+	})
+}
+
+func (s *apiService) getDBPreview(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	fd, err := s.model.OpenPreview(qs.Get("folder"), qs.Get("file"))
+	if err != nil {
+		http.Error(w, "No such preview", http.StatusNotFound)
+		return
+	}
+	defer fd.Close()
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	io.Copy(w, fd)
+}
+
+// requestNamespace returns the namespace a request is scoped to, based on
+// its API key. Requests authenticated in any other way are unscoped.
+func (s *apiService) requestNamespace(r *http.Request) (string, bool) {
+	return config.NamespaceForAPIKey(s.cfg.Raw().Namespaces, r.Header.Get("X-API-Key"))
+}
+
 func (s *apiService) getSystemConfig(w http.ResponseWriter, r *http.Request) {
-	sendJSON(w, s.cfg.Raw())
+	cfg := s.cfg.Raw()
+
+	if ns, ok := s.requestNamespace(r); ok {
+		// A namespace scoped key sees only the resources in its namespace,
+		// and none of the instance wide secrets.
+		cfg = cfg.Copy()
+		folders := cfg.Folders[:0]
+		for _, fcfg := range cfg.Folders {
+			if fcfg.Namespace == ns {
+				folders = append(folders, fcfg)
+			}
+		}
+		cfg.Folders = folders
+		devices := cfg.Devices[:0]
+		for _, dcfg := range cfg.Devices {
+			if dcfg.Namespace == ns {
+				devices = append(devices, dcfg)
+			}
+		}
+		cfg.Devices = devices
+		cfg.GUI.APIKey = ""
+		cfg.GUI.Password = ""
+		cfg.Namespaces = nil
+	}
+
+	sendJSON(w, cfg)
+}
+
+// getSystemConfigFolders returns the list of folder configurations,
+// optionally filtered by the comma separated tag requirements in the tags
+// parameter ("site=oslo,tenant=acme").
+func (s *apiService) getSystemConfigFolders(w http.ResponseWriter, r *http.Request) {
+	filter := r.URL.Query().Get("tags")
+	ns, scoped := s.requestNamespace(r)
+	folders := s.cfg.Raw().Folders
+	matched := make([]config.FolderConfiguration, 0, len(folders))
+	for _, fcfg := range folders {
+		if scoped && fcfg.Namespace != ns {
+			continue
+		}
+		if config.MatchTags(fcfg.Tags, filter) {
+			matched = append(matched, fcfg)
+		}
+	}
+	sendJSON(w, matched)
+}
+
+// getSystemConfigDevices returns the list of device configurations,
+// optionally filtered by tags like getSystemConfigFolders.
+func (s *apiService) getSystemConfigDevices(w http.ResponseWriter, r *http.Request) {
+	filter := r.URL.Query().Get("tags")
+	ns, scoped := s.requestNamespace(r)
+	devices := s.cfg.Raw().Devices
+	matched := make([]config.DeviceConfiguration, 0, len(devices))
+	for _, dcfg := range devices {
+		if scoped && dcfg.Namespace != ns {
+			continue
+		}
+		if config.MatchTags(dcfg.Tags, filter) {
+			matched = append(matched, dcfg)
+		}
+	}
+	sendJSON(w, matched)
 }
 
 func (s *apiService) postSystemConfig(w http.ResponseWriter, r *http.Request) {
 	s.systemConfigMut.Lock()
 	defer s.systemConfigMut.Unlock()
 
+	if _, ok := s.requestNamespace(r); ok {
+		// Namespace scoped keys may only use the batch endpoints for
+		// resources in their own namespace.
+		http.Error(w, "namespace scoped API keys cannot replace the configuration", http.StatusForbidden)
+		return
+	}
+
 	to, err := config.ReadJSON(r.Body, myID)
 	r.Body.Close()
 	if err != nil {
@@ -778,56 +1358,317 @@ func (s *apiService) postSystemConfig(w http.ResponseWriter, r *http.Request) {
 
 	// Activate and save
 
-	if err := s.cfg.Replace(to); err != nil {
-		l.Warnln("Replacing config:", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	if err := s.cfg.Save(); err != nil {
-		l.Warnln("Saving config:", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-}
-
-func (s *apiService) getSystemConfigInsync(w http.ResponseWriter, r *http.Request) {
-	sendJSON(w, map[string]bool{"configInSync": !s.cfg.RequiresRestart()})
+	s.replaceConfig(w, to)
 }
 
-func (s *apiService) postSystemRestart(w http.ResponseWriter, r *http.Request) {
-	s.flushResponse(`{"ok": "restarting"}`, w)
-	go restart()
-}
+// postSystemConfigFolders adds, updates or removes multiple folders in a
+// single configuration commit. The posted object contains a list of folder
+// configurations to add or update and a list of folder IDs to remove. The
+// change is all or nothing; if any part of it fails the configuration is
+// left untouched.
+func (s *apiService) postSystemConfigFolders(w http.ResponseWriter, r *http.Request) {
+	s.systemConfigMut.Lock()
+	defer s.systemConfigMut.Unlock()
 
-func (s *apiService) postSystemReset(w http.ResponseWriter, r *http.Request) {
-	var qs = r.URL.Query()
-	folder := qs.Get("folder")
+	var batch struct {
+		Folders []config.FolderConfiguration `json:"folders"`
+		Remove  []string                     `json:"remove"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&batch)
+	r.Body.Close()
+	if err != nil {
+		l.Warnln("Decoding posted folder batch:", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	if len(folder) > 0 {
-		if _, ok := s.cfg.Folders()[folder]; !ok {
-			http.Error(w, "Invalid folder ID", 500)
-			return
+	if ns, ok := s.requestNamespace(r); ok {
+		current := s.cfg.Folders()
+		for _, fcfg := range batch.Folders {
+			if fcfg.Namespace != ns {
+				http.Error(w, "folder outside API key namespace", http.StatusForbidden)
+				return
+			}
+			if cur, ok := current[fcfg.ID]; ok && cur.Namespace != ns {
+				http.Error(w, "folder outside API key namespace", http.StatusForbidden)
+				return
+			}
+		}
+		for _, id := range batch.Remove {
+			if cur, ok := current[id]; ok && cur.Namespace != ns {
+				http.Error(w, "folder outside API key namespace", http.StatusForbidden)
+				return
+			}
 		}
 	}
 
-	if len(folder) == 0 {
-		// Reset all folders.
-		for folder := range s.cfg.Folders() {
-			s.model.ResetFolder(folder)
+	to := s.cfg.Raw().Copy()
+	for _, fcfg := range batch.Folders {
+		found := false
+		for i := range to.Folders {
+			if to.Folders[i].ID == fcfg.ID {
+				to.Folders[i] = fcfg
+				found = true
+				break
+			}
+		}
+		if !found {
+			to.Folders = append(to.Folders, fcfg)
+		}
+	}
+	for _, id := range batch.Remove {
+		for i := range to.Folders {
+			if to.Folders[i].ID == id {
+				to.Folders = append(to.Folders[:i], to.Folders[i+1:]...)
+				break
+			}
 		}
-		s.flushResponse(`{"ok": "resetting database"}`, w)
-	} else {
-		// Reset a specific folder, assuming it's supposed to exist.
-		s.model.ResetFolder(folder)
-		s.flushResponse(`{"ok": "resetting folder `+folder+`"}`, w)
 	}
 
-	go restart()
+	s.replaceConfig(w, to)
 }
 
-func (s *apiService) postSystemShutdown(w http.ResponseWriter, r *http.Request) {
-	s.flushResponse(`{"ok": "shutting down"}`, w)
+// postSystemConfigDevices adds, updates or removes multiple devices in a
+// single configuration commit, with the same semantics as
+// postSystemConfigFolders.
+func (s *apiService) postSystemConfigDevices(w http.ResponseWriter, r *http.Request) {
+	s.systemConfigMut.Lock()
+	defer s.systemConfigMut.Unlock()
+
+	var batch struct {
+		Devices []config.DeviceConfiguration `json:"devices"`
+		Remove  []protocol.DeviceID          `json:"remove"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&batch)
+	r.Body.Close()
+	if err != nil {
+		l.Warnln("Decoding posted device batch:", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if ns, ok := s.requestNamespace(r); ok {
+		current := s.cfg.Devices()
+		for _, dcfg := range batch.Devices {
+			if dcfg.Namespace != ns {
+				http.Error(w, "device outside API key namespace", http.StatusForbidden)
+				return
+			}
+			if cur, ok := current[dcfg.DeviceID]; ok && cur.Namespace != ns {
+				http.Error(w, "device outside API key namespace", http.StatusForbidden)
+				return
+			}
+		}
+		for _, id := range batch.Remove {
+			if cur, ok := current[id]; ok && cur.Namespace != ns {
+				http.Error(w, "device outside API key namespace", http.StatusForbidden)
+				return
+			}
+		}
+	}
+
+	to := s.cfg.Raw().Copy()
+	for _, dcfg := range batch.Devices {
+		found := false
+		for i := range to.Devices {
+			if to.Devices[i].DeviceID == dcfg.DeviceID {
+				to.Devices[i] = dcfg
+				found = true
+				break
+			}
+		}
+		if !found {
+			to.Devices = append(to.Devices, dcfg)
+		}
+	}
+	for _, id := range batch.Remove {
+		for i := range to.Devices {
+			if to.Devices[i].DeviceID == id {
+				to.Devices = append(to.Devices[:i], to.Devices[i+1:]...)
+				break
+			}
+		}
+	}
+
+	s.replaceConfig(w, to)
+}
+
+// replaceConfig activates and saves the given configuration, writing an
+// HTTP level error to w if either step fails.
+func (s *apiService) replaceConfig(w http.ResponseWriter, to config.Configuration) {
+	if err := s.cfg.Replace(to); err != nil {
+		l.Warnln("Replacing config:", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.cfg.Save(); err != nil {
+		l.Warnln("Saving config:", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// getSystemConfigDowngrade returns the current configuration converted to
+// an older config version, for use with a previous release after a failed
+// upgrade. The on disk configuration is left untouched.
+func (s *apiService) getSystemConfigDowngrade(w http.ResponseWriter, r *http.Request) {
+	version, err := strconv.Atoi(r.URL.Query().Get("version"))
+	if err != nil {
+		http.Error(w, "query parameter version must be a number", http.StatusBadRequest)
+		return
+	}
+
+	cfg, err := config.Downgraded(s.cfg.Raw(), version)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	cfg.WriteXML(w)
+}
+
+func (s *apiService) getSystemConfigInsync(w http.ResponseWriter, r *http.Request) {
+	sendJSON(w, map[string]bool{"configInSync": !s.cfg.RequiresRestart()})
+}
+
+func (s *apiService) getSystemConfigBackups(w http.ResponseWriter, r *http.Request) {
+	backups, err := s.cfg.ListBackups()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendJSON(w, map[string]interface{}{"backups": backups})
+}
+
+// getSystemConfigDiff returns the difference between the named config
+// backup and the current configuration, as the lines of the JSON rendering
+// that appear only on either side.
+func (s *apiService) getSystemConfigDiff(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	backup, err := s.cfg.BackupConfig(name, myID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	added, removed := diffConfigs(backup, s.cfg.Raw())
+	sendJSON(w, map[string]interface{}{
+		"name":    name,
+		"added":   added,
+		"removed": removed,
+	})
+}
+
+func (s *apiService) postSystemConfigRestore(w http.ResponseWriter, r *http.Request) {
+	s.systemConfigMut.Lock()
+	defer s.systemConfigMut.Unlock()
+
+	if _, ok := s.requestNamespace(r); ok {
+		http.Error(w, "namespace scoped API keys cannot restore the configuration", http.StatusForbidden)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if err := s.cfg.RestoreBackup(name, myID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// diffConfigs returns the lines of the JSON rendering of the current config
+// that are not present in the backup ("added") and the other way around
+// ("removed").
+func diffConfigs(backup, current config.Configuration) (added, removed []string) {
+	backupLines := configLines(backup)
+	currentLines := configLines(current)
+
+	added = []string{}
+	counts := make(map[string]int)
+	for _, line := range backupLines {
+		counts[line]++
+	}
+	for _, line := range currentLines {
+		if counts[line] > 0 {
+			counts[line]--
+		} else {
+			added = append(added, line)
+		}
+	}
+
+	removed = []string{}
+	counts = make(map[string]int)
+	for _, line := range currentLines {
+		counts[line]++
+	}
+	for _, line := range backupLines {
+		if counts[line] > 0 {
+			counts[line]--
+		} else {
+			removed = append(removed, line)
+		}
+	}
+
+	return added, removed
+}
+
+func configLines(cfg config.Configuration) []string {
+	bs, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return nil
+	}
+	return strings.Split(string(bs), "\n")
+}
+
+func (s *apiService) postSystemRestart(w http.ResponseWriter, r *http.Request) {
+	s.flushResponse(`{"ok": "restarting"}`, w)
+	go restart()
+}
+
+func (s *apiService) postSystemReset(w http.ResponseWriter, r *http.Request) {
+	var qs = r.URL.Query()
+	folder := qs.Get("folder")
+
+	if len(folder) > 0 {
+		if _, ok := s.cfg.Folders()[folder]; !ok {
+			http.Error(w, "Invalid folder ID", 500)
+			return
+		}
+	}
+
+	if len(folder) == 0 {
+		// Reset all folders.
+		for folder := range s.cfg.Folders() {
+			s.model.ResetFolder(folder)
+		}
+		s.flushResponse(`{"ok": "resetting database"}`, w)
+	} else {
+		// Reset a specific folder, assuming it's supposed to exist.
+		s.model.ResetFolder(folder)
+		s.flushResponse(`{"ok": "resetting folder `+folder+`"}`, w)
+	}
+
+	go restart()
+}
+
+func (s *apiService) postSystemLogout(w http.ResponseWriter, r *http.Request) {
+	cookieName := "sessionid-" + s.id.String()[:5]
+	if cookie, err := r.Cookie(cookieName); err == nil && cookie != nil {
+		sessionsMut.Lock()
+		delete(sessions, cookie.Value)
+		sessionsMut.Unlock()
+		http.SetCookie(w, &http.Cookie{
+			Name:   cookieName,
+			Value:  "",
+			MaxAge: -1,
+		})
+	}
+	s.flushResponse(`{"ok": "logged out"}`, w)
+}
+
+func (s *apiService) postSystemShutdown(w http.ResponseWriter, r *http.Request) {
+	s.flushResponse(`{"ok": "shutting down"}`, w)
 	go shutdown()
 }
 
@@ -881,6 +1722,51 @@ func (s *apiService) getSystemStatus(w http.ResponseWriter, r *http.Request) {
 	sendJSON(w, res)
 }
 
+// getSystemTopology returns the known cluster graph as structured data:
+// which devices we know of, which folders they share with us, and the state
+// and type of each connection. Intended for dashboards rendering topology
+// maps of larger deployments.
+func (s *apiService) getSystemTopology(w http.ResponseWriter, r *http.Request) {
+	conns, _ := s.model.ConnectionStats()["connections"].(map[string]model.ConnectionInfo)
+
+	deviceFolders := make(map[protocol.DeviceID][]string)
+	folders := make([]map[string]interface{}, 0, len(s.cfg.Folders()))
+	for _, folderCfg := range s.cfg.Folders() {
+		deviceIDs := folderCfg.DeviceIDs()
+		deviceStrs := make([]string, len(deviceIDs))
+		for i, device := range deviceIDs {
+			deviceStrs[i] = device.String()
+			deviceFolders[device] = append(deviceFolders[device], folderCfg.ID)
+		}
+		sort.Strings(deviceStrs)
+		folders = append(folders, map[string]interface{}{
+			"id":      folderCfg.ID,
+			"label":   folderCfg.Label,
+			"type":    folderCfg.Type,
+			"devices": deviceStrs,
+		})
+	}
+
+	devices := make([]map[string]interface{}, 0, len(s.cfg.Devices()))
+	for device, deviceCfg := range s.cfg.Devices() {
+		shared := deviceFolders[device]
+		sort.Strings(shared)
+		devices = append(devices, map[string]interface{}{
+			"deviceID":   device.String(),
+			"name":       deviceCfg.Name,
+			"introducer": deviceCfg.Introducer,
+			"folders":    shared,
+			"connection": conns[device.String()],
+		})
+	}
+
+	sendJSON(w, map[string]interface{}{
+		"myID":    s.id.String(),
+		"devices": devices,
+		"folders": folders,
+	})
+}
+
 func (s *apiService) getSystemError(w http.ResponseWriter, r *http.Request) {
 	sendJSON(w, map[string][]logger.Line{
 		"errors": s.guiErrors.Since(time.Time{}),
@@ -920,7 +1806,8 @@ func (s *apiService) getSystemLogTxt(w http.ResponseWriter, r *http.Request) {
 func (s *apiService) getSystemHTTPMetrics(w http.ResponseWriter, r *http.Request) {
 	stats := make(map[string]interface{})
 	metrics.Each(func(name string, intf interface{}) {
-		if m, ok := intf.(*metrics.StandardTimer); ok {
+		switch m := intf.(type) {
+		case *metrics.StandardTimer:
 			pct := m.Percentiles([]float64{0.50, 0.95, 0.99})
 			for i := range pct {
 				pct[i] /= 1e6 // ns to ms
@@ -931,6 +1818,17 @@ func (s *apiService) getSystemHTTPMetrics(w http.ResponseWriter, r *http.Request
 				"ratesPerS":     []float64{m.Rate1(), m.Rate5(), m.Rate15()},
 				"percentilesMs": pct,
 			}
+		case metrics.Histogram:
+			stats[name] = map[string]interface{}{
+				"count":            m.Count(),
+				"sumBytes":         m.Sum(),
+				"percentilesBytes": m.Percentiles([]float64{0.50, 0.95, 0.99}),
+			}
+		case metrics.Meter:
+			stats[name] = map[string]interface{}{
+				"count":     m.Count(),
+				"ratesPerS": []float64{m.Rate1(), m.Rate5(), m.Rate15()},
+			}
 		}
 	})
 	bs, _ := json.MarshalIndent(stats, "", "  ")
@@ -952,6 +1850,30 @@ func (s *apiService) getSystemDiscovery(w http.ResponseWriter, r *http.Request)
 	sendJSON(w, devices)
 }
 
+func (s *apiService) getSystemInfrastructure(w http.ResponseWriter, r *http.Request) {
+	discovery := make(map[string]discover.ServiceStatus)
+	if s.discoverer != nil {
+		discovery = s.discoverer.ChildStatus()
+	}
+
+	// Pick the relay listeners out of the general listener status; the
+	// other listeners are local and say nothing about the infrastructure.
+	relays := make(map[string]interface{})
+	for addr, status := range s.connectionsService.Status() {
+		if uri, err := url.Parse(addr); err == nil {
+			switch uri.Scheme {
+			case "relay", "dynamic+http", "dynamic+https":
+				relays[addr] = status
+			}
+		}
+	}
+
+	sendJSON(w, map[string]interface{}{
+		"discovery": discovery,
+		"relays":    relays,
+	})
+}
+
 func (s *apiService) getReport(w http.ResponseWriter, r *http.Request) {
 	sendJSON(w, reportData(s.cfg, s.model))
 }
@@ -978,6 +1900,7 @@ func (s *apiService) getDBIgnores(w http.ResponseWriter, r *http.Request) {
 	sendJSON(w, map[string][]string{
 		"ignore":   ignores,
 		"expanded": patterns,
+		"presets":  ignore.Presets(),
 	})
 }
 
@@ -1023,6 +1946,21 @@ func (s *apiService) getEvents(w http.ResponseWriter, r *http.Request, eventSub
 	since, _ := strconv.Atoi(sinceStr)
 	limit, _ := strconv.Atoi(limitStr)
 
+	// An optional comma separated list of event type names to filter on,
+	// applied server side before the limit.
+	mask := events.EventType(events.AllEvents)
+	if eventsStr := qs.Get("events"); eventsStr != "" {
+		mask = 0
+		for _, name := range strings.Split(eventsStr, ",") {
+			evType := events.ParseEventType(strings.TrimSpace(name))
+			if evType == 0 {
+				http.Error(w, fmt.Sprintf("unknown event type %q", name), http.StatusBadRequest)
+				return
+			}
+			mask |= evType
+		}
+	}
+
 	// Flush before blocking, to indicate that we've received the request and
 	// that it should not be retried. Must set Content-Type header before
 	// flushing.
@@ -1031,6 +1969,15 @@ func (s *apiService) getEvents(w http.ResponseWriter, r *http.Request, eventSub
 	f.Flush()
 
 	evs := eventSub.Since(since, nil)
+	if mask != events.AllEvents {
+		filtered := evs[:0]
+		for _, ev := range evs {
+			if ev.Type&mask != 0 {
+				filtered = append(filtered, ev)
+			}
+		}
+		evs = filtered
+	}
 	if 0 < limit && limit < len(evs) {
 		evs = evs[len(evs)-limit:]
 	}
@@ -1038,6 +1985,120 @@ func (s *apiService) getEvents(w http.ResponseWriter, r *http.Request, eventSub
 	sendJSON(w, evs)
 }
 
+// postPatternEvents registers a new pattern subscription from the posted
+// set of path globs, or removes one given a remove parameter.
+func (s *apiService) postPatternEvents(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	if id := qs.Get("remove"); id != "" {
+		s.patternSubsMut.Lock()
+		delete(s.patternSubs, id)
+		s.patternSubsMut.Unlock()
+		return
+	}
+
+	var req struct {
+		Patterns []string `json:"patterns"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body.Close()
+
+	if len(req.Patterns) == 0 {
+		http.Error(w, "no patterns given", http.StatusBadRequest)
+		return
+	}
+	for _, pat := range req.Patterns {
+		if _, err := path.Match(pat, "x"); err != nil {
+			http.Error(w, fmt.Sprintf("invalid pattern %q", pat), http.StatusBadRequest)
+			return
+		}
+	}
+
+	id := rand.String(32)
+	s.patternSubsMut.Lock()
+	s.patternSubs[id] = req.Patterns
+	s.patternSubsMut.Unlock()
+
+	sendJSON(w, map[string]string{"id": id})
+}
+
+// getPatternEvents returns the ItemStarted and ItemFinished events whose
+// paths match the globs of the given subscription, filtered server side so
+// the consumer need not process the whole event stream.
+func (s *apiService) getPatternEvents(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	s.patternSubsMut.Lock()
+	patterns, ok := s.patternSubs[qs.Get("id")]
+	s.patternSubsMut.Unlock()
+	if !ok {
+		http.Error(w, "no such subscription", http.StatusNotFound)
+		return
+	}
+
+	since, _ := strconv.Atoi(qs.Get("since"))
+	limit, _ := strconv.Atoi(qs.Get("limit"))
+
+	// Flush before blocking, to indicate that we've received the request and
+	// that it should not be retried. Must set Content-Type header before
+	// flushing.
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	f := w.(http.Flusher)
+	f.Flush()
+
+	evs := s.eventSub.Since(since, nil)
+	filtered := evs[:0]
+	for _, ev := range evs {
+		if ev.Type != events.ItemStarted && ev.Type != events.ItemFinished {
+			continue
+		}
+		if matchesAnyPattern(patterns, eventItemPath(ev)) {
+			filtered = append(filtered, ev)
+		}
+	}
+	evs = filtered
+	if 0 < limit && limit < len(evs) {
+		evs = evs[len(evs)-limit:]
+	}
+
+	sendJSON(w, evs)
+}
+
+// eventItemPath returns the item path of an ItemStarted or ItemFinished
+// event.
+func eventItemPath(ev events.Event) string {
+	switch data := ev.Data.(type) {
+	case map[string]string:
+		return data["item"]
+	case map[string]interface{}:
+		if item, ok := data["item"].(string); ok {
+			return item
+		}
+	}
+	return ""
+}
+
+// matchesAnyPattern returns true when the file matches any of the globs,
+// tried against both the full relative path and the base name so that a
+// plain *.pdf matches at any depth.
+func matchesAnyPattern(patterns []string, file string) bool {
+	if file == "" {
+		return false
+	}
+	file = filepath.ToSlash(file)
+	for _, pat := range patterns {
+		if ok, _ := path.Match(pat, file); ok {
+			return true
+		}
+		if ok, _ := path.Match(pat, path.Base(file)); ok {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *apiService) getSystemUpgrade(w http.ResponseWriter, r *http.Request) {
 	if noUpgrade {
 		http.Error(w, upgrade.ErrUpgradeUnsupported.Error(), 500)
@@ -1073,6 +2134,10 @@ func (s *apiService) getDeviceID(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (s *apiService) getErrorCatalog(w http.ResponseWriter, r *http.Request) {
+	sendJSON(w, errcat.Catalog())
+}
+
 func (s *apiService) getLang(w http.ResponseWriter, r *http.Request) {
 	lang := r.Header.Get("Accept-Language")
 	var langs []string
@@ -1118,6 +2183,63 @@ func (s *apiService) postSystemPause(w http.ResponseWriter, r *http.Request) {
 	s.model.PauseDevice(device)
 }
 
+// postSystemConfigPush sends a config fragment to a connected device. The
+// device applies it only if it has designated us as a remote admin.
+func (s *apiService) postSystemConfigPush(w http.ResponseWriter, r *http.Request) {
+	device, err := protocol.DeviceIDFromString(r.URL.Query().Get("device"))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	var req struct {
+		Folders []struct {
+			ID      string   `json:"id"`
+			Devices []string `json:"devices"`
+		} `json:"folders"`
+		Devices []struct {
+			DeviceID  string   `json:"deviceID"`
+			Name      string   `json:"name"`
+			Addresses []string `json:"addresses"`
+		} `json:"devices"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	var update protocol.ConfigUpdate
+	for _, folder := range req.Folders {
+		pf := protocol.Folder{ID: folder.ID}
+		for _, devStr := range folder.Devices {
+			id, err := protocol.DeviceIDFromString(devStr)
+			if err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			pf.Devices = append(pf.Devices, protocol.Device{ID: id[:]})
+		}
+		update.Folders = append(update.Folders, pf)
+	}
+	for _, dev := range req.Devices {
+		id, err := protocol.DeviceIDFromString(dev.DeviceID)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		update.Devices = append(update.Devices, protocol.Device{
+			ID:        id[:],
+			Name:      dev.Name,
+			Addresses: dev.Addresses,
+		})
+	}
+
+	if err := s.model.PushConfigUpdate(device, update); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+}
+
 func (s *apiService) postSystemResume(w http.ResponseWriter, r *http.Request) {
 	var qs = r.URL.Query()
 	var deviceStr = qs.Get("device")
@@ -1165,6 +2287,247 @@ func (s *apiService) postDBPrio(w http.ResponseWriter, r *http.Request) {
 	s.getDBNeed(w, r)
 }
 
+// getFolderArchive streams the current global view of a folder, or a
+// subtree of it, as a tar or zip archive. Blocks we don't have locally
+// are fetched from connected peers.
+func (s *apiService) getFolderArchive(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	folder := qs.Get("folder")
+	format := qs.Get("format")
+	if format == "" {
+		format = "tar"
+	}
+	if format != "tar" && format != "zip" {
+		http.Error(w, "unknown archive format", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", folder+"."+format))
+	if err := s.model.ExportArchive(folder, qs.Get("prefix"), format, w); err != nil {
+		// The headers and part of the archive have likely been sent
+		// already, so all we can do is log the failure.
+		l.Warnf("Exporting archive for folder %q: %v", folder, err)
+	}
+}
+
+func (s *apiService) getFolderVersions(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	folder := qs.Get("folder")
+
+	if asOfStr := qs.Get("asof"); asOfStr != "" {
+		asOf, err := time.Parse(time.RFC3339, asOfStr)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		versions, err := s.model.VersionsAsOf(folder, asOf)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		sendJSON(w, versions)
+		return
+	}
+
+	versions, err := s.model.FolderVersions(folder)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	sendJSON(w, versions)
+}
+
+func (s *apiService) postFolderRestore(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	folder := qs.Get("folder")
+
+	asOf, err := time.Parse(time.RFC3339, qs.Get("asof"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	bs, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	var paths []string
+	if err := json.Unmarshal(bs, &paths); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	restoreErrors, err := s.model.RestoreVersionsAsOf(folder, asOf, paths)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	sendJSON(w, restoreErrors)
+}
+
+func (s *apiService) getDBQueue(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	folder := qs.Get("folder")
+	sendJSON(w, map[string]interface{}{
+		"queue": s.model.PullQueue(folder),
+	})
+}
+
+func (s *apiService) getDBUsage(w http.ResponseWriter, r *http.Request) {
+	sendJSON(w, s.model.DiskUsage())
+}
+
+func (s *apiService) getDBBlockStats(w http.ResponseWriter, r *http.Request) {
+	sendJSON(w, s.model.BlockStats())
+}
+
+// getDBExport streams a snapshot of the folder's local index, suitable
+// for import on a device that was pre-seeded with the folder data by
+// external means.
+func (s *apiService) getDBExport(w http.ResponseWriter, r *http.Request) {
+	folder := r.URL.Query().Get("folder")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", folder+".index.json"))
+	if err := s.model.ExportIndex(folder, w); err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
+
+// postDBImport applies an index snapshot previously produced by
+// getDBExport as the folder's local index.
+func (s *apiService) postDBImport(w http.ResponseWriter, r *http.Request) {
+	folder := r.URL.Query().Get("folder")
+	imported, err := s.model.ImportIndex(folder, r.Body)
+	r.Body.Close()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	sendJSON(w, map[string]interface{}{
+		"imported": imported,
+	})
+}
+
+func (s *apiService) getDBHolds(w http.ResponseWriter, r *http.Request) {
+	holds, err := s.model.Holds(r.URL.Query().Get("folder"))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	sendJSON(w, map[string]interface{}{
+		"holds": holds,
+	})
+}
+
+func (s *apiService) postDBHolds(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	var err error
+	if qs.Get("release") != "" {
+		err = s.model.ReleaseHold(qs.Get("folder"), qs.Get("file"))
+	} else {
+		err = s.model.PlaceHold(qs.Get("folder"), qs.Get("file"))
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
+
+// postDBCheck runs an integrity check of the index database. The repair
+// parameter selects the repair level: "report" (the default) only counts
+// issues, "fix" removes broken entries, and "rebuild" drops the index of
+// any folder with issues.
+func (s *apiService) postDBCheck(w http.ResponseWriter, r *http.Request) {
+	var level db.CheckLevel
+	switch repair := r.URL.Query().Get("repair"); repair {
+	case "", "report":
+		level = db.CheckReportOnly
+	case "fix":
+		level = db.CheckFix
+	case "rebuild":
+		level = db.CheckRebuild
+	default:
+		http.Error(w, fmt.Sprintf("unknown repair level %q", repair), http.StatusBadRequest)
+		return
+	}
+	sendJSON(w, s.model.CheckDBIntegrity(level))
+}
+
+// postDBCompact runs an immediate compaction of the index database.
+func (s *apiService) postDBCompact(w http.ResponseWriter, r *http.Request) {
+	if err := s.model.CompactDB(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// getFolderExport serves folder contents read-only over HTTP, under
+// /export/<folder ID>/<path>, for folders that have the HTTP export
+// enabled. Requests pass the usual GUI authentication, range requests are
+// honored, and directory listings are served only when allowed by the
+// folder configuration.
+func (s *apiService) getFolderExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, "/export/")
+	parts := strings.SplitN(rest, "/", 2)
+
+	fcfg, ok := s.cfg.Folders()[parts[0]]
+	if !ok || !fcfg.HTTPExportEnabled {
+		http.Error(w, "No such export", http.StatusNotFound)
+		return
+	}
+
+	file := ""
+	if len(parts) == 2 {
+		file = parts[1]
+	}
+	if strings.Contains(file, "..") {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	name := filepath.Join(fcfg.Path(), filepath.FromSlash(file))
+	info, err := os.Lstat(name)
+	if err != nil {
+		http.Error(w, "No such file", http.StatusNotFound)
+		return
+	}
+	if info.IsDir() && !fcfg.HTTPExportListings {
+		http.Error(w, "Directory listing disabled", http.StatusForbidden)
+		return
+	}
+	if !info.IsDir() && !info.Mode().IsRegular() {
+		http.Error(w, "Not a regular file", http.StatusForbidden)
+		return
+	}
+
+	http.ServeFile(w, r, name)
+}
+
+func (s *apiService) postDBQueueBack(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	s.model.SendToBack(qs.Get("folder"), qs.Get("file"))
+	s.getDBQueue(w, r)
+}
+
+func (s *apiService) postDBQueueSkip(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	s.model.SkipJob(qs.Get("folder"), qs.Get("file"))
+	s.getDBQueue(w, r)
+}
+
+func (s *apiService) postDBQueueRetry(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	s.model.RetryJob(qs.Get("folder"), qs.Get("file"))
+	s.getDBQueue(w, r)
+}
+
 func (s *apiService) getQR(w http.ResponseWriter, r *http.Request) {
 	var qs = r.URL.Query()
 	var text = qs.Get("text")
@@ -9,6 +9,7 @@ package main
 import (
 	"bytes"
 	"encoding/base64"
+	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -17,14 +18,78 @@ import (
 	"github.com/syncthing/syncthing/lib/events"
 	"github.com/syncthing/syncthing/lib/rand"
 	"github.com/syncthing/syncthing/lib/sync"
-	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// Sessions not used for this long are discarded and the user must log
+	// in again.
+	sessionLifetime = 24 * time.Hour
+	// After this many failed login attempts from the same host, further
+	// attempts from it are rejected outright for the lockout duration.
+	maxFailedLogins = 5
+	loginLockout    = 5 * time.Minute
 )
 
 var (
-	sessions    = make(map[string]bool)
+	sessions    = make(map[string]time.Time) // session ID -> last used
 	sessionsMut = sync.NewMutex()
+
+	failedLogins    = make(map[string]failedLogin) // remote host -> failures
+	failedLoginsMut = sync.NewMutex()
 )
 
+type failedLogin struct {
+	failures int
+	last     time.Time
+}
+
+func hostOnly(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// loginIsLocked returns whether login attempts from the given host are
+// currently rejected due to too many failures.
+func loginIsLocked(host string) bool {
+	failedLoginsMut.Lock()
+	defer failedLoginsMut.Unlock()
+
+	att, ok := failedLogins[host]
+	if !ok {
+		return false
+	}
+	if time.Since(att.last) > loginLockout {
+		delete(failedLogins, host)
+		return false
+	}
+	return att.failures >= maxFailedLogins
+}
+
+func registerFailedLogin(host string) {
+	failedLoginsMut.Lock()
+	att := failedLogins[host]
+	if time.Since(att.last) > loginLockout {
+		att = failedLogin{}
+	}
+	att.failures++
+	att.last = time.Now()
+	failedLogins[host] = att
+	failedLoginsMut.Unlock()
+
+	if att.failures == maxFailedLogins {
+		l.Warnf("Locking out login attempts from %s for %v after repeated failures", host, loginLockout)
+	}
+}
+
+func registerSuccessfulLogin(host string) {
+	failedLoginsMut.Lock()
+	delete(failedLogins, host)
+	failedLoginsMut.Unlock()
+}
+
 func emitLoginAttempt(success bool, username string) {
 	events.Default.Log(events.LoginAttempt, map[string]interface{}{
 		"success":  success,
@@ -33,6 +98,7 @@ func emitLoginAttempt(success bool, username string) {
 }
 
 func basicAuthAndSessionMiddleware(cookieName string, cfg config.GUIConfiguration, next http.Handler) http.Handler {
+	auth := authProviderFor(cfg)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if cfg.IsValidAPIKey(r.Header.Get("X-API-Key")) {
 			next.ServeHTTP(w, r)
@@ -42,7 +108,14 @@ func basicAuthAndSessionMiddleware(cookieName string, cfg config.GUIConfiguratio
 		cookie, err := r.Cookie(cookieName)
 		if err == nil && cookie != nil {
 			sessionsMut.Lock()
-			_, ok := sessions[cookie.Value]
+			last, ok := sessions[cookie.Value]
+			if ok && time.Since(last) > sessionLifetime {
+				delete(sessions, cookie.Value)
+				ok = false
+			}
+			if ok {
+				sessions[cookie.Value] = time.Now()
+			}
 			sessionsMut.Unlock()
 			if ok {
 				next.ServeHTTP(w, r)
@@ -50,6 +123,12 @@ func basicAuthAndSessionMiddleware(cookieName string, cfg config.GUIConfiguratio
 			}
 		}
 
+		remoteHost := hostOnly(r.RemoteAddr)
+		if loginIsLocked(remoteHost) {
+			http.Error(w, "Too many failed login attempts - try again later", http.StatusForbidden)
+			return
+		}
+
 		httpl.Debugln("Sessionless HTTP request with authentication; this is expensive.")
 
 		error := func() {
@@ -77,45 +156,25 @@ func basicAuthAndSessionMiddleware(cookieName string, cfg config.GUIConfiguratio
 			return
 		}
 
-		// Check if the username is correct, assuming it was sent as UTF-8
+		// Check the credentials as sent, assuming UTF-8, and then again
+		// converting them from assumed ISO-8859-1, as there is no standard
+		// for the encoding of basic auth credentials.
 		username := string(fields[0])
-		if username == cfg.User {
-			goto usernameOK
-		}
-
-		// ... check it again, converting it from assumed ISO-8859-1 to UTF-8
-		username = string(iso88591ToUTF8(fields[0]))
-		if username == cfg.User {
-			goto usernameOK
-		}
-
-		// Neither of the possible interpretations match the configured username
-		emitLoginAttempt(false, username)
-		error()
-		return
-
-	usernameOK:
-		// Check password as given (assumes UTF-8 encoding)
-		password := fields[1]
-		if err := bcrypt.CompareHashAndPassword([]byte(cfg.Password), password); err == nil {
-			goto passwordOK
-		}
-
-		// ... check it again, converting it from assumed ISO-8859-1 to UTF-8
-		password = iso88591ToUTF8(password)
-		if err := bcrypt.CompareHashAndPassword([]byte(cfg.Password), password); err == nil {
-			goto passwordOK
+		if !auth.Authenticate(username, string(fields[1])) {
+			username = string(iso88591ToUTF8(fields[0]))
+			if !auth.Authenticate(username, string(iso88591ToUTF8(fields[1]))) {
+				emitLoginAttempt(false, username)
+				registerFailedLogin(remoteHost)
+				error()
+				return
+			}
 		}
 
-		// Neither of the attempts to verify the password checked out
-		emitLoginAttempt(false, username)
-		error()
-		return
+		registerSuccessfulLogin(remoteHost)
 
-	passwordOK:
 		sessionid := rand.String(32)
 		sessionsMut.Lock()
-		sessions[sessionid] = true
+		sessions[sessionid] = time.Now()
 		sessionsMut.Unlock()
 		http.SetCookie(w, &http.Cookie{
 			Name:   cookieName,
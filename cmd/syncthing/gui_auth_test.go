@@ -0,0 +1,50 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginLockout(t *testing.T) {
+	const host = "192.0.2.1"
+
+	if loginIsLocked(host) {
+		t.Error("Unknown host should not be locked")
+	}
+
+	for i := 0; i < maxFailedLogins-1; i++ {
+		registerFailedLogin(host)
+	}
+	if loginIsLocked(host) {
+		t.Error("Host should not be locked before reaching the limit")
+	}
+
+	registerFailedLogin(host)
+	if !loginIsLocked(host) {
+		t.Error("Host should be locked after reaching the limit")
+	}
+
+	// A lockout that happened longer than the lockout duration ago has
+	// expired.
+	failedLoginsMut.Lock()
+	failedLogins[host] = failedLogin{
+		failures: maxFailedLogins,
+		last:     time.Now().Add(-loginLockout - time.Second),
+	}
+	failedLoginsMut.Unlock()
+	if loginIsLocked(host) {
+		t.Error("Lockout should have expired")
+	}
+
+	registerFailedLogin(host)
+	registerSuccessfulLogin(host)
+	if loginIsLocked(host) {
+		t.Error("Successful login should clear the failure count")
+	}
+}
@@ -0,0 +1,267 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/syncthing/syncthing/lib/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const authProviderTimeout = 10 * time.Second
+
+// An authProvider checks a username and password against some database of
+// users. Implementations must be safe for concurrent use.
+type authProvider interface {
+	Authenticate(username, password string) bool
+}
+
+// authProviderFor returns the authentication provider selected by the GUI
+// configuration. The default is the static user and password from the
+// configuration itself.
+func authProviderFor(cfg config.GUIConfiguration) authProvider {
+	switch cfg.AuthMode {
+	case config.AuthModeLDAP:
+		return &ldapAuth{
+			address:   cfg.LDAPAddress,
+			transport: cfg.LDAPTransport,
+			bindDN:    cfg.LDAPBindDN,
+		}
+	case config.AuthModeOIDC:
+		return &oidcAuth{
+			tokenEndpoint: cfg.OIDCTokenEndpoint,
+			clientID:      cfg.OIDCClientID,
+			clientSecret:  cfg.OIDCClientSecret,
+		}
+	default:
+		return &staticAuth{
+			user:         cfg.User,
+			passwordHash: cfg.Password,
+		}
+	}
+}
+
+// staticAuth verifies the password against the bcrypt hash in the
+// configuration.
+type staticAuth struct {
+	user         string
+	passwordHash string
+}
+
+func (a *staticAuth) Authenticate(username, password string) bool {
+	if username != a.user {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(a.passwordHash), []byte(password)) == nil
+}
+
+// ldapAuth verifies the credentials by performing an LDAPv3 simple bind
+// against the configured server, with the username substituted into the
+// bind DN template.
+type ldapAuth struct {
+	address   string
+	transport string
+	bindDN    string
+}
+
+func (a *ldapAuth) Authenticate(username, password string) bool {
+	// An empty password must never reach the server: RFC 4513 treats a
+	// simple bind without a password as an anonymous ("unauthenticated")
+	// bind, which succeeds on most servers regardless of the DN.
+	if password == "" {
+		return false
+	}
+
+	var conn net.Conn
+	var err error
+	if a.transport == "tls" {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: authProviderTimeout}, "tcp", a.address, nil)
+	} else {
+		conn, err = net.DialTimeout("tcp", a.address, authProviderTimeout)
+	}
+	if err != nil {
+		l.Warnln("LDAP auth:", err)
+		return false
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(authProviderTimeout))
+
+	dn := strings.Replace(a.bindDN, "%s", ldapEscapeDN(username), -1)
+	if _, err := conn.Write(ldapBindRequest(dn, password)); err != nil {
+		l.Warnln("LDAP auth:", err)
+		return false
+	}
+
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		l.Warnln("LDAP auth:", err)
+		return false
+	}
+	code, err := ldapBindResult(buf[:n])
+	if err != nil {
+		l.Warnln("LDAP auth:", err)
+		return false
+	}
+	return code == 0
+}
+
+// ldapEscapeDN escapes an attribute value for inclusion in a bind DN, per
+// RFC 4514, so that a crafted username cannot change the structure of the
+// DN it is substituted into.
+func ldapEscapeDN(value string) string {
+	const hex = "0123456789abcdef"
+	escaped := make([]byte, 0, len(value))
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == ',' || c == '+' || c == '"' || c == '\\' || c == '<' || c == '>' || c == ';' || c == '=':
+			escaped = append(escaped, '\\', c)
+		case c == '#' && i == 0:
+			escaped = append(escaped, '\\', c)
+		case c == ' ' && (i == 0 || i == len(value)-1):
+			escaped = append(escaped, '\\', c)
+		case c < 0x20:
+			escaped = append(escaped, '\\', hex[c>>4], hex[c&0xf])
+		default:
+			escaped = append(escaped, c)
+		}
+	}
+	return string(escaped)
+}
+
+// ldapBindRequest returns a BER encoded LDAPv3 simple bind request for the
+// given DN and password, with message ID 1.
+func ldapBindRequest(dn, password string) []byte {
+	var body []byte
+	body = append(body, 0x02, 0x01, 0x03) // version 3
+	body = append(body, berElement(0x04, []byte(dn))...)
+	body = append(body, berElement(0x80, []byte(password))...) // simple authentication
+
+	var msg []byte
+	msg = append(msg, 0x02, 0x01, 0x01)          // message ID 1
+	msg = append(msg, berElement(0x60, body)...) // [APPLICATION 0] BindRequest
+
+	return berElement(0x30, msg)
+}
+
+// ldapBindResult returns the result code from a BER encoded bind response.
+func ldapBindResult(bs []byte) (int, error) {
+	msg, _, err := berParse(bs, 0x30) // LDAPMessage
+	if err != nil {
+		return 0, err
+	}
+	_, rest, err := berParse(msg, 0x02) // message ID
+	if err != nil {
+		return 0, err
+	}
+	resp, _, err := berParse(rest, 0x61) // [APPLICATION 1] BindResponse
+	if err != nil {
+		return 0, err
+	}
+	code, _, err := berParse(resp, 0x0a) // resultCode ENUMERATED
+	if err != nil {
+		return 0, err
+	}
+
+	res := 0
+	for _, b := range code {
+		res = res<<8 | int(b)
+	}
+	return res, nil
+}
+
+// berElement returns the BER encoding of an element with the given tag and
+// contents.
+func berElement(tag byte, content []byte) []byte {
+	out := []byte{tag}
+	if n := len(content); n < 128 {
+		out = append(out, byte(n))
+	} else {
+		var lenBytes []byte
+		for ; n > 0; n >>= 8 {
+			lenBytes = append([]byte{byte(n)}, lenBytes...)
+		}
+		out = append(out, 0x80|byte(len(lenBytes)))
+		out = append(out, lenBytes...)
+	}
+	return append(out, content...)
+}
+
+// berParse expects a BER element with the given tag at the start of bs and
+// returns its contents and whatever follows it.
+func berParse(bs []byte, tag byte) (content, rest []byte, err error) {
+	if len(bs) < 2 {
+		return nil, nil, errors.New("ldap: short response")
+	}
+	if bs[0] != tag {
+		return nil, nil, fmt.Errorf("ldap: unexpected tag 0x%02x", bs[0])
+	}
+
+	length := int(bs[1])
+	offset := 2
+	if length >= 128 {
+		n := length & 0x7f
+		if n == 0 || n > 4 || len(bs) < 2+n {
+			return nil, nil, errors.New("ldap: bad length")
+		}
+		length = 0
+		for _, b := range bs[2 : 2+n] {
+			length = length<<8 | int(b)
+		}
+		offset = 2 + n
+	}
+	if len(bs) < offset+length {
+		return nil, nil, errors.New("ldap: short response")
+	}
+	return bs[offset : offset+length], bs[offset+length:], nil
+}
+
+// oidcAuth verifies the credentials by performing an OpenID Connect
+// resource owner password credentials grant against the token endpoint,
+// accepting the user when the provider issues a token.
+type oidcAuth struct {
+	tokenEndpoint string
+	clientID      string
+	clientSecret  string
+}
+
+func (a *oidcAuth) Authenticate(username, password string) bool {
+	data := url.Values{
+		"grant_type": {"password"},
+		"username":   {username},
+		"password":   {password},
+		"scope":      {"openid"},
+	}
+
+	req, err := http.NewRequest("POST", a.tokenEndpoint, strings.NewReader(data.Encode()))
+	if err != nil {
+		l.Warnln("OIDC auth:", err)
+		return false
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if a.clientID != "" {
+		req.SetBasicAuth(a.clientID, a.clientSecret)
+	}
+
+	client := &http.Client{Timeout: authProviderTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		l.Warnln("OIDC auth:", err)
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
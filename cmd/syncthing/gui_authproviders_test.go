@@ -0,0 +1,119 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/syncthing/syncthing/lib/config"
+)
+
+func TestAuthProviderSelection(t *testing.T) {
+	cases := []struct {
+		mode string
+		typ  interface{}
+	}{
+		{"", &staticAuth{}},
+		{config.AuthModeStatic, &staticAuth{}},
+		{config.AuthModeLDAP, &ldapAuth{}},
+		{config.AuthModeOIDC, &oidcAuth{}},
+	}
+
+	for _, tc := range cases {
+		p := authProviderFor(config.GUIConfiguration{AuthMode: tc.mode})
+		if got, want := fmt.Sprintf("%T", p), fmt.Sprintf("%T", tc.typ); got != want {
+			t.Errorf("mode %q: got provider %s, expected %s", tc.mode, got, want)
+		}
+	}
+}
+
+func TestLDAPBindRequest(t *testing.T) {
+	exp := []byte{
+		0x30, 0x20, // LDAPMessage
+		0x02, 0x01, 0x01, // message ID 1
+		0x60, 0x1b, // BindRequest
+		0x02, 0x01, 0x03, // version 3
+		0x04, 0x10, 'c', 'n', '=', 'u', 's', 'e', 'r', ',', 'd', 'c', '=', 't', 'e', 's', 't', 0x00,
+		0x80, 0x04, 'p', 'a', 's', 's',
+	}
+	// The DN above ends with a zero byte just to keep the layout obvious;
+	// build the same one here.
+	dn := "cn=user,dc=test\x00"
+
+	if got := ldapBindRequest(dn, "pass"); !bytes.Equal(got, exp) {
+		t.Errorf("unexpected bind request\n got %x\nwant %x", got, exp)
+	}
+}
+
+func TestLDAPBindResult(t *testing.T) {
+	resp := []byte{
+		0x30, 0x0a, // LDAPMessage
+		0x02, 0x01, 0x01, // message ID 1
+		0x61, 0x05, // BindResponse
+		0x0a, 0x01, 0x31, // resultCode invalidCredentials (49)
+		0x04, 0x00, // matchedDN
+		// truncated; the result code is all we parse
+	}
+
+	code, err := ldapBindResult(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 49 {
+		t.Errorf("got result code %d, expected 49", code)
+	}
+
+	if _, err := ldapBindResult([]byte{0x30}); err == nil {
+		t.Error("expected an error on a short response")
+	}
+	if _, err := ldapBindResult(ldapBindRequest("cn=x", "y")); err == nil {
+		t.Error("expected an error on a response with the wrong tags")
+	}
+}
+
+func TestLDAPEscapeDN(t *testing.T) {
+	cases := []struct {
+		in, out string
+	}{
+		{"user", "user"},
+		{"us,er", "us\\,er"},
+		{"a=b+c", "a\\=b\\+c"},
+		{`back\slash`, `back\\slash`},
+		{"<angle>", "\\<angle\\>"},
+		{"#leading", "\\#leading"},
+		{"mid#dle", "mid#dle"},
+		{" padded ", "\\ padded\\ "},
+		{"in ner", "in ner"},
+		{"nul\x00byte", `nul\00byte`},
+	}
+
+	for _, tc := range cases {
+		if got := ldapEscapeDN(tc.in); got != tc.out {
+			t.Errorf("ldapEscapeDN(%q) == %q, expected %q", tc.in, got, tc.out)
+		}
+	}
+}
+
+func TestBEREncodingLongForm(t *testing.T) {
+	content := make([]byte, 300)
+	enc := berElement(0x04, content)
+
+	exp := []byte{0x04, 0x82, 0x01, 0x2c}
+	if !bytes.Equal(enc[:4], exp) {
+		t.Fatalf("unexpected long form header %x, expected %x", enc[:4], exp)
+	}
+
+	dec, rest, err := berParse(enc, 0x04)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dec, content) || len(rest) != 0 {
+		t.Error("long form did not round trip")
+	}
+}
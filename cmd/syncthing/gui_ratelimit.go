@@ -0,0 +1,81 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/juju/ratelimit"
+	"github.com/syncthing/syncthing/lib/sync"
+)
+
+// An apiRateLimiter hands out request permits from one token bucket per
+// API client, so that a single misbehaving integration can not starve the
+// REST interface for everyone else.
+type apiRateLimiter struct {
+	rate    int // requests per second and client
+	buckets map[string]*ratelimit.Bucket
+	mut     sync.Mutex
+}
+
+func newAPIRateLimiter(requestsPerSecond int) *apiRateLimiter {
+	if requestsPerSecond <= 0 {
+		return nil
+	}
+	return &apiRateLimiter{
+		rate:    requestsPerSecond,
+		buckets: make(map[string]*ratelimit.Bucket),
+		mut:     sync.NewMutex(),
+	}
+}
+
+// take returns true if the client is within its request budget.
+func (l *apiRateLimiter) take(client string) bool {
+	l.mut.Lock()
+	bucket, ok := l.buckets[client]
+	if !ok {
+		// Allow short bursts of up to ten seconds worth of requests.
+		bucket = ratelimit.NewBucketWithRate(float64(l.rate), int64(10*l.rate))
+		l.buckets[client] = bucket
+	}
+	l.mut.Unlock()
+
+	return bucket.TakeAvailable(1) == 1
+}
+
+// apiClientID returns the identity that rate limits and request metrics
+// are accounted against: the API key when one is presented, otherwise the
+// remote address.
+func apiClientID(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// rateLimitMiddleware returns 429 when the client has exceeded its
+// request budget, with headers describing the limit, and passes the
+// request on otherwise. A nil limiter passes everything.
+func rateLimitMiddleware(limiter *apiRateLimiter, h http.Handler) http.Handler {
+	if limiter == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !limiter.take(apiClientID(r)) {
+			w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%d", limiter.rate))
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -921,3 +921,241 @@ func TestOptionsRequest(t *testing.T) {
 		t.Fatal("OPTIONS on /rest/system/status should return a 'Access-Control-Allow-Headers: Content-Type, X-API-KEY' header")
 	}
 }
+
+func TestFolderTokenMiddleware(t *testing.T) {
+	cfg := config.Wrap("/dev/null", config.Configuration{
+		FolderTokens: []config.FolderTokenConfiguration{
+			{Name: "photos-app", Token: "s3cr3t", Folders: []string{"photos"}},
+		},
+	})
+
+	rest := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler := folderTokenMiddleware(cfg, rest, next)
+
+	cases := []struct {
+		url    string
+		apiKey string
+		status int
+	}{
+		// A folder token grants access to listed endpoints for its folders.
+		{"/rest/db/browse?folder=photos", "s3cr3t", http.StatusOK},
+		{"/rest/db/status?folder=photos", "s3cr3t", http.StatusOK},
+		// Other folders and other endpoints are off limits.
+		{"/rest/db/browse?folder=docs", "s3cr3t", http.StatusForbidden},
+		{"/rest/system/config", "s3cr3t", http.StatusForbidden},
+		{"/rest/db/override?folder=photos", "s3cr3t", http.StatusForbidden},
+		// Unknown or missing tokens fall through to the next handler.
+		{"/rest/db/browse?folder=photos", "other", http.StatusTeapot},
+		{"/rest/db/browse?folder=photos", "", http.StatusTeapot},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest("GET", tc.url, nil)
+		if tc.apiKey != "" {
+			req.Header.Set("X-API-Key", tc.apiKey)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s with key %q: got status %d, expected %d", tc.url, tc.apiKey, w.Code, tc.status)
+		}
+	}
+}
+
+func TestNamespaceKeyMiddleware(t *testing.T) {
+	cfg := config.Wrap("/dev/null", config.Configuration{
+		Namespaces: []config.NamespaceConfiguration{
+			{Name: "team-a", APIKey: "s3cr3t"},
+		},
+	})
+
+	rest := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler := namespaceKeyMiddleware(cfg, rest, next)
+
+	cases := []struct {
+		url    string
+		apiKey string
+		status int
+	}{
+		// A namespace key reaches the namespace aware config endpoints.
+		{"/rest/system/config", "s3cr3t", http.StatusOK},
+		{"/rest/system/config/folders", "s3cr3t", http.StatusOK},
+		{"/rest/system/config/devices", "s3cr3t", http.StatusOK},
+		// Everything else is off limits, it is not a full credential.
+		{"/rest/system/config/restore", "s3cr3t", http.StatusForbidden},
+		{"/rest/system/shutdown", "s3cr3t", http.StatusForbidden},
+		{"/rest/db/browse?folder=photos", "s3cr3t", http.StatusForbidden},
+		{"/rest/events", "s3cr3t", http.StatusForbidden},
+		// Unknown or missing keys fall through to the next handler.
+		{"/rest/system/config", "other", http.StatusTeapot},
+		{"/rest/system/config", "", http.StatusTeapot},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest("GET", tc.url, nil)
+		if tc.apiKey != "" {
+			req.Header.Set("X-API-Key", tc.apiKey)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s with key %q: got status %d, expected %d", tc.url, tc.apiKey, w.Code, tc.status)
+		}
+	}
+}
+
+func TestMatchesAnyPattern(t *testing.T) {
+	cases := []struct {
+		patterns []string
+		file     string
+		matches  bool
+	}{
+		{[]string{"*/*.pdf"}, "docs/report.pdf", true},
+		{[]string{"*.pdf"}, "docs/report.pdf", true}, // base name match
+		{[]string{"*/*.pdf"}, "report.pdf", false},
+		{[]string{"*.jpg", "*.png"}, "photos/cat.png", true},
+		{[]string{"*.jpg"}, "docs/report.pdf", false},
+		{[]string{"*.pdf"}, "", false},
+	}
+
+	for _, tc := range cases {
+		if res := matchesAnyPattern(tc.patterns, tc.file); res != tc.matches {
+			t.Errorf("matchesAnyPattern(%v, %q) => %v, expected %v", tc.patterns, tc.file, res, tc.matches)
+		}
+	}
+}
+
+func TestHTTPBasePath(t *testing.T) {
+	const testAPIKey = "foobarbaz"
+	cfg := new(mockedConfig)
+	cfg.gui.APIKey = testAPIKey
+	cfg.gui.URLBase = "/st"
+	baseURL, err := startHTTP(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cli := &http.Client{
+		Timeout: time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	// The bare prefix redirects to the slash terminated form.
+	resp, err := cli.Get(baseURL + "/st")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMovedPermanently {
+		t.Errorf("expected 301 for bare prefix, got %d", resp.StatusCode)
+	}
+	if loc := resp.Header.Get("Location"); loc != "/st/" {
+		t.Errorf("unexpected redirect location %q", loc)
+	}
+
+	// Requests outside the prefix are not served.
+	req, _ := http.NewRequest("GET", baseURL+"/rest/system/ping", nil)
+	req.Header.Set("X-API-Key", testAPIKey)
+	resp, err = cli.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 outside the prefix, got %d", resp.StatusCode)
+	}
+
+	// Requests under the prefix are served as usual.
+	req, _ = http.NewRequest("GET", baseURL+"/st/rest/system/ping", nil)
+	req.Header.Set("X-API-Key", testAPIKey)
+	resp, err = cli.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 under the prefix, got %d", resp.StatusCode)
+	}
+}
+
+func TestProxyHeadersMiddleware(t *testing.T) {
+	var gotRemote string
+	var gotTLS bool
+	handler := proxyHeadersMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotRemote = r.RemoteAddr
+		gotTLS = r.TLS != nil
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "192.0.2.1, 10.0.0.1")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if gotRemote != "192.0.2.1" {
+		t.Errorf("unexpected remote address %q", gotRemote)
+	}
+	if !gotTLS {
+		t.Error("expected the request to be marked as TLS")
+	}
+
+	// Without the headers, nothing changes.
+	req = httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if gotRemote != "127.0.0.1:1234" {
+		t.Errorf("unexpected remote address %q", gotRemote)
+	}
+	if gotTLS {
+		t.Error("expected the request to not be marked as TLS")
+	}
+}
+
+func TestAPIRateLimiter(t *testing.T) {
+	if limiter := newAPIRateLimiter(0); limiter != nil {
+		t.Error("zero rate should disable the limiter")
+	}
+
+	limiter := newAPIRateLimiter(10)
+
+	// The initial burst allowance is ten seconds worth of requests.
+	for i := 0; i < 100; i++ {
+		if !limiter.take("client-a") {
+			t.Fatalf("request %d should be within the burst allowance", i)
+		}
+	}
+	if limiter.take("client-a") {
+		t.Error("expected client-a to be over its budget")
+	}
+
+	// Other clients have their own budget.
+	if !limiter.take("client-b") {
+		t.Error("expected client-b to have its own budget")
+	}
+}
+
+func TestAPIClientID(t *testing.T) {
+	r := httptest.NewRequest("GET", "/rest/db/need", nil)
+	r.RemoteAddr = "127.0.0.1:12345"
+	if id := apiClientID(r); id != "127.0.0.1" {
+		t.Errorf("unexpected client ID %q", id)
+	}
+
+	r.Header.Set("X-API-Key", "abc123")
+	if id := apiClientID(r); id != "abc123" {
+		t.Errorf("unexpected client ID %q", id)
+	}
+}
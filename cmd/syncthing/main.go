@@ -9,6 +9,7 @@ package main
 import (
 	"bytes"
 	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -203,7 +204,12 @@ type RuntimeOptions struct {
 	showPaths      bool
 	doUpgrade      bool
 	doUpgradeCheck bool
+	doRollback     bool
+	doBench        bool
+	dbCheck        string
+	auditIndex     bool
 	upgradeTo      string
+	downgradeTo    string
 	noBrowser      bool
 	browserOnly    bool
 	hideConsole    bool
@@ -261,9 +267,14 @@ func parseCommandLineOptions() RuntimeOptions {
 	flag.BoolVar(&options.reset, "reset", false, "Reset the database")
 	flag.BoolVar(&options.doUpgrade, "upgrade", false, "Perform upgrade")
 	flag.BoolVar(&options.doUpgradeCheck, "upgrade-check", false, "Check for available upgrade")
+	flag.BoolVar(&options.doRollback, "rollback", false, "Restore the binary saved by the previous upgrade")
+	flag.BoolVar(&options.doBench, "bench", false, "Run hashing, database and network benchmarks, then exit")
+	flag.StringVar(&options.dbCheck, "db-check", "", "Check index database integrity at given repair level (\"report\", \"fix\" or \"rebuild\"), then exit")
+	flag.BoolVar(&options.auditIndex, "audit-index", false, "Print the contents of the index database as JSON, then exit")
 	flag.BoolVar(&options.showVersion, "version", false, "Show version")
 	flag.BoolVar(&options.showPaths, "paths", false, "Show configuration paths")
 	flag.StringVar(&options.upgradeTo, "upgrade-to", options.upgradeTo, "Force upgrade directly from specified URL")
+	flag.StringVar(&options.downgradeTo, "downgrade-to", "", "Downgrade to the given release version, after checking that it can read the current data")
 	flag.BoolVar(&options.auditEnabled, "audit", false, "Write events to audit file")
 	flag.BoolVar(&options.verbose, "verbose", false, "Print verbose log output")
 	flag.BoolVar(&options.paused, "paused", false, "Start with all devices paused")
@@ -358,12 +369,41 @@ func main() {
 		return
 	}
 
+	if options.doBench {
+		runBenchmarks()
+		return
+	}
+
+	if options.dbCheck != "" {
+		checkDB(options.dbCheck)
+		return
+	}
+
+	if options.auditIndex {
+		auditIndex()
+		return
+	}
+
 	if options.doUpgrade {
 		release := checkUpgrade()
 		performUpgrade(release)
 		return
 	}
 
+	if options.downgradeTo != "" {
+		release := checkDowngrade(options.downgradeTo)
+		performUpgrade(release)
+		return
+	}
+
+	if options.doRollback {
+		if err := upgrade.Rollback("manual rollback requested"); err != nil {
+			l.Fatalln("Rollback:", err) // exits 1
+		}
+		l.Infoln("Rolled back to the previous version")
+		return
+	}
+
 	if options.reset {
 		resetDB()
 		return
@@ -465,6 +505,36 @@ func checkUpgrade() upgrade.Release {
 	return release
 }
 
+// checkDowngrade resolves the given release version and verifies that it
+// can read the configuration and index database written by this version,
+// protecting against a downgrade that would brick the install.
+func checkDowngrade(tag string) upgrade.Release {
+	if upgrade.CompareVersions(tag, Version) >= upgrade.Equal {
+		l.Fatalf("Downgrade: %q is not older than the running version %q", tag, Version)
+	}
+
+	// The on disk data is at the schema versions of this build, as any
+	// older data would have been migrated on startup.
+	var dbSchema int64
+	if ldb, err := db.OpenReadOnly(locations[locDatabase]); err == nil {
+		dbSchema = ldb.SchemaVersion()
+		ldb.Close()
+	}
+	if err := upgrade.CheckDowngrade(tag, config.CurrentVersion, dbSchema); err != nil {
+		l.Fatalln("Downgrade:", err) // exits 1
+	}
+
+	cfg, _ := loadConfig()
+	for _, rel := range upgrade.FetchLatestReleases(cfg.Options().ReleasesURL, Version) {
+		if upgrade.CompareVersions(rel.Tag, tag) == upgrade.Equal {
+			return rel
+		}
+	}
+
+	l.Fatalf("Downgrade: no release %q found", tag) // exits 1
+	return upgrade.Release{}                        // not reached
+}
+
 func performUpgrade(release upgrade.Release) {
 	// Use leveldb database locks to protect against concurrent upgrades
 	_, err := db.Open(locations[locDatabase])
@@ -660,6 +730,13 @@ func syncthingMain(runtimeOptions RuntimeOptions) {
 		l.Fatalln("Cannot open database:", err, "- Is another copy of Syncthing already running?")
 	}
 
+	ldb.SetBatchSizes(opts.DBBatchFlushRecords, opts.DBMaxBatchSizeKiB<<10)
+	ldb.SetSlowTransactionThreshold(time.Duration(opts.DBSlowTransactionMs) * time.Millisecond)
+	ldb.SetFileCacheSize(opts.DBFileCacheEntries)
+
+	mainService.Add(db.NewCompactionScheduler(ldb))
+	mainService.Add(db.NewBlockMapGC(ldb))
+
 	protectedFiles := []string{
 		locations[locDatabase],
 		locations[locConfigFile],
@@ -700,12 +777,6 @@ func syncthingMain(runtimeOptions RuntimeOptions) {
 		}
 	}
 
-	// Add and start folders
-	for _, folderCfg := range cfg.Folders() {
-		m.AddFolder(folderCfg)
-		m.StartFolder(folderCfg.ID)
-	}
-
 	mainService.Add(m)
 
 	// Start discovery
@@ -755,6 +826,16 @@ func syncthingMain(runtimeOptions RuntimeOptions) {
 
 	setupGUI(mainService, cfg, m, apiSub, diskSub, cachedDiscovery, connectionsService, errors, systemLog, runtimeOptions)
 
+	// Add and start folders in the background, so that the GUI, API and
+	// device connections come up without waiting for the initial scans to
+	// complete. Folders report a "preparing" state until started.
+	go func() {
+		for _, folderCfg := range cfg.Folders() {
+			m.AddFolder(folderCfg)
+			m.StartFolder(folderCfg.ID)
+		}
+	}()
+
 	if runtimeOptions.cpuProfile {
 		f, err := os.Create(fmt.Sprintf("cpu-%d.pprof", os.Getpid()))
 		if err != nil {
@@ -790,6 +871,14 @@ func syncthingMain(runtimeOptions RuntimeOptions) {
 	// Hence we don't keep the returned pointer.
 	newUsageReportingManager(cfg, m)
 
+	// The status report service is always running; whether it actually
+	// sends anything is controlled by the configuration at each tick.
+	mainService.Add(newStatusReportService(cfg, m))
+
+	// Likewise for the alert service; it does nothing unless notification
+	// transports are configured.
+	mainService.Add(newAlertService(cfg))
+
 	if opts.RestartOnWakeup {
 		go standbyMonitor()
 	}
@@ -863,6 +952,10 @@ func loadConfig() (*config.Wrapper, error) {
 		cfg = config.Wrap(cfgFile, newCfg)
 	}
 
+	if caErr := upgrade.ConfigureClient(cfg.Options().UpgradeCABundle); caErr != nil {
+		l.Warnln("Upgrade CA bundle:", caErr)
+	}
+
 	return cfg, err
 }
 
@@ -944,6 +1037,22 @@ func setupGUI(mainService *suture.Supervisor, cfg *config.Wrapper, m *model.Mode
 	cfg.Subscribe(api)
 	mainService.Add(api)
 
+	// Start one additional service per configured extra GUI listener, each
+	// with its own address, TLS and authentication settings.
+	for _, extraCfg := range cfg.ExtraGUIs() {
+		if !extraCfg.Enabled {
+			continue
+		}
+		if extraCfg.InsecureAdminAccess {
+			l.Warnln("Insecure admin access is enabled on", extraCfg.Address())
+		}
+		extraAPI := newAPIService(myID, cfg, locations[locHTTPSCertFile], locations[locHTTPSKeyFile], runtimeOptions.assetDir, m, apiSub, diskSub, discoverer, connectionsService, errors, systemLog)
+		extraCfg := extraCfg
+		extraAPI.guiCfgOverride = &extraCfg
+		cfg.Subscribe(extraAPI)
+		mainService.Add(extraAPI)
+	}
+
 	if cfg.Options().StartBrowser && !runtimeOptions.noBrowser && !runtimeOptions.stRestarting {
 		// Can potentially block if the utility we are invoking doesn't
 		// fork, and just execs, hence keep it in it's own routine.
@@ -1011,6 +1120,57 @@ func resetDB() error {
 	return os.RemoveAll(locations[locDatabase])
 }
 
+// checkDB runs an offline integrity check of the index database at the
+// given repair level, printing the results as JSON on stdout.
+func checkDB(repair string) {
+	var level db.CheckLevel
+	switch repair {
+	case "report":
+		level = db.CheckReportOnly
+	case "fix":
+		level = db.CheckFix
+	case "rebuild":
+		level = db.CheckRebuild
+	default:
+		l.Fatalf("Unknown repair level %q; expected \"report\", \"fix\" or \"rebuild\"", repair)
+	}
+
+	ldb, err := db.Open(locations[locDatabase])
+	if err != nil {
+		l.Fatalln("Cannot open database:", err)
+	}
+	res := ldb.CheckIntegrity(level)
+	ldb.Close()
+
+	bs, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		l.Fatalln("Marshalling results:", err)
+	}
+	fmt.Println(string(bs))
+}
+
+// auditIndex dumps the global file records of the index database as a
+// stream of JSON objects on stdout. The database is opened read-only, so
+// this is safe to run against the database of a stopped instance.
+func auditIndex() {
+	ldb, err := db.OpenReadOnly(locations[locDatabase])
+	if err != nil {
+		l.Fatalln("Cannot open database:", err)
+	}
+	defer ldb.Close()
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, folder := range ldb.ListFolders() {
+		ldb.WithGlobalTruncated(folder, func(f db.FileIntf) bool {
+			enc.Encode(map[string]interface{}{
+				"folder": folder,
+				"file":   f,
+			})
+			return true
+		})
+	}
+}
+
 func restart() {
 	l.Infoln("Restarting")
 	stop <- exitRestarting
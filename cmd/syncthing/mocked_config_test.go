@@ -52,3 +52,15 @@ func (c *mockedConfig) Save() error {
 func (c *mockedConfig) RequiresRestart() bool {
 	return false
 }
+
+func (c *mockedConfig) ListBackups() ([]config.BackupInfo, error) {
+	return nil, nil
+}
+
+func (c *mockedConfig) BackupConfig(name string, myID protocol.DeviceID) (config.Configuration, error) {
+	return config.Configuration{}, nil
+}
+
+func (c *mockedConfig) RestoreBackup(name string, myID protocol.DeviceID) error {
+	return nil
+}
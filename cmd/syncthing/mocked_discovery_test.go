@@ -50,3 +50,7 @@ func (m *mockedCachingMux) Add(finder discover.Finder, cacheTime, negCacheTime t
 func (m *mockedCachingMux) ChildErrors() map[string]error {
 	return nil
 }
+
+func (m *mockedCachingMux) ChildStatus() map[string]discover.ServiceStatus {
+	return nil
+}
@@ -7,12 +7,15 @@
 package main
 
 import (
+	"io"
+	"os"
 	"time"
 
 	"github.com/syncthing/syncthing/lib/db"
 	"github.com/syncthing/syncthing/lib/model"
 	"github.com/syncthing/syncthing/lib/protocol"
 	"github.com/syncthing/syncthing/lib/stats"
+	"github.com/syncthing/syncthing/lib/versioner"
 )
 
 type mockedModel struct{}
@@ -27,7 +30,11 @@ func (m *mockedModel) Completion(device protocol.DeviceID, folder string) model.
 
 func (m *mockedModel) Override(folder string) {}
 
-func (m *mockedModel) NeedFolderFiles(folder string, page, perpage int) ([]db.FileInfoTruncated, []db.FileInfoTruncated, []db.FileInfoTruncated, int) {
+func (m *mockedModel) DropDeviceVersions(folder string) (int, error) {
+	return 0, nil
+}
+
+func (m *mockedModel) NeedFolderFiles(folder, prefix string, page, perpage int) ([]db.FileInfoTruncated, []db.FileInfoTruncated, []db.FileInfoTruncated, int) {
 	return nil, nil, nil, 0
 }
 
@@ -47,6 +54,10 @@ func (m *mockedModel) FolderStatistics() map[string]stats.FolderStatistics {
 	return nil
 }
 
+func (m *mockedModel) FolderHistory(folder string, since time.Time) []stats.HistorySample {
+	return nil
+}
+
 func (m *mockedModel) CurrentFolderFile(folder string, file string) (protocol.FileInfo, bool) {
 	return protocol.FileInfo{}, false
 }
@@ -62,6 +73,21 @@ func (m *mockedModel) Availability(folder, file string, version protocol.Vector,
 	return nil
 }
 
+func (m *mockedModel) FileHolders(folder, file string) []model.FileHolder {
+	return nil
+}
+
+func (m *mockedModel) BlockHolders(folder string, hash []byte) []model.BlockHolder {
+	return nil
+}
+
+func (m *mockedModel) OpenPreview(folder, file string) (*os.File, error) {
+	return nil, os.ErrNotExist
+}
+
+func (m *mockedModel) AcceptChanges(folder string, device protocol.DeviceID) {
+}
+
 func (m *mockedModel) GetIgnores(folder string) ([]string, []string, error) {
 	return nil, nil, nil
 }
@@ -91,6 +117,100 @@ func (m *mockedModel) ScanFolderSubdirs(folder string, subs []string) error {
 
 func (m *mockedModel) BringToFront(folder, file string) {}
 
+func (m *mockedModel) SendToBack(folder, file string) {}
+
+func (m *mockedModel) SkipJob(folder, file string) {}
+
+func (m *mockedModel) RetryJob(folder, file string) {}
+
+func (m *mockedModel) PullQueue(folder string) []model.PullQueueItem {
+	return nil
+}
+
+func (m *mockedModel) BlockStats() db.BlockStats {
+	return db.BlockStats{}
+}
+
+func (m *mockedModel) FolderAnalysis(folder string) (model.FolderAnalysis, error) {
+	return model.FolderAnalysis{}, nil
+}
+
+func (m *mockedModel) PendingChanges(folder string) (model.PendingChanges, error) {
+	return model.PendingChanges{}, nil
+}
+
+func (m *mockedModel) PushConfigUpdate(device protocol.DeviceID, update protocol.ConfigUpdate) error {
+	return nil
+}
+
+func (m *mockedModel) DiskUsage() map[string]model.FolderDiskUsage {
+	return nil
+}
+
+func (m *mockedModel) CheckDBIntegrity(level db.CheckLevel) []db.CheckResult {
+	return nil
+}
+
+func (m *mockedModel) ExportIndex(folder string, w io.Writer) error {
+	return nil
+}
+
+func (m *mockedModel) ExportArchive(folder, prefix, format string, w io.Writer) error {
+	return nil
+}
+
+func (m *mockedModel) DBCompactionStats() db.CompactionStats {
+	return db.CompactionStats{}
+}
+
+func (m *mockedModel) FileProvenance(folder, file string) (stats.FileProvenance, bool) {
+	return stats.FileProvenance{}, false
+}
+
+func (m *mockedModel) FolderProvenanceSummary(folder string) stats.ProvenanceSummary {
+	return stats.ProvenanceSummary{}
+}
+
+func (m *mockedModel) DBTransactionStats() db.TransactionStats {
+	return db.TransactionStats{}
+}
+
+func (m *mockedModel) SystemSummary() model.SystemSummary {
+	return model.SystemSummary{}
+}
+
+func (m *mockedModel) CompactDB() error {
+	return nil
+}
+
+func (m *mockedModel) ImportIndex(folder string, r io.Reader) (int, error) {
+	return 0, nil
+}
+
+func (m *mockedModel) Holds(folder string) (map[string]model.FileHold, error) {
+	return nil, nil
+}
+
+func (m *mockedModel) PlaceHold(folder, file string) error {
+	return nil
+}
+
+func (m *mockedModel) ReleaseHold(folder, file string) error {
+	return nil
+}
+
+func (m *mockedModel) FolderVersions(folder string) (map[string][]versioner.ArchivedVersion, error) {
+	return nil, nil
+}
+
+func (m *mockedModel) VersionsAsOf(folder string, asOf time.Time) (map[string]versioner.ArchivedVersion, error) {
+	return nil, nil
+}
+
+func (m *mockedModel) RestoreVersionsAsOf(folder string, asOf time.Time, paths []string) (map[string]string, error) {
+	return nil, nil
+}
+
 func (m *mockedModel) ConnectedTo(deviceID protocol.DeviceID) bool {
 	return false
 }
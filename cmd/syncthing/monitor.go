@@ -8,6 +8,7 @@ package main
 
 import (
 	"bufio"
+	"fmt"
 	"io"
 	"os"
 	"os/exec"
@@ -19,6 +20,7 @@ import (
 
 	"github.com/syncthing/syncthing/lib/osutil"
 	"github.com/syncthing/syncthing/lib/sync"
+	"github.com/syncthing/syncthing/lib/upgrade"
 )
 
 var (
@@ -32,6 +34,11 @@ const (
 	loopThreshold         = 60 * time.Second
 	logFileAutoCloseDelay = 5 * time.Second
 	logFileMaxOpenTime    = time.Minute
+
+	// A crash this soon after an upgrade triggers an automatic rollback
+	// to the previous binary. Override with the STUPGRADEGRACE
+	// environment variable (a duration; 0 disables).
+	upgradeGraceDefault = 5 * time.Minute
 )
 
 func monitorMain(runtimeOptions RuntimeOptions) {
@@ -161,10 +168,52 @@ func monitorMain(runtimeOptions RuntimeOptions) {
 		}
 
 		l.Infoln("Syncthing exited:", err)
+
+		if err != nil && maybeAutoRollback(args[0], time.Since(restarts[len(restarts)-1])) {
+			// The new binary crashed shortly after an upgrade; the
+			// previous one has been swapped back in and the next lap of
+			// the loop starts it.
+			continue
+		}
+
 		time.Sleep(1 * time.Second)
 	}
 }
 
+// maybeAutoRollback rolls back to the previous binary when the current one
+// crashes within the grace period after an upgrade. It returns true when a
+// rollback was performed.
+func maybeAutoRollback(binary string, uptime time.Duration) bool {
+	grace := upgradeGraceDefault
+	if v := os.Getenv("STUPGRADEGRACE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			l.Warnln("Invalid STUPGRADEGRACE duration:", err)
+		} else {
+			grace = d
+		}
+	}
+	if grace <= 0 || uptime >= grace {
+		return false
+	}
+
+	// The upgrade stamps the saved binary with the time of the upgrade;
+	// an old stamp means the crash is unrelated to an upgrade.
+	info, err := os.Stat(binary + ".old")
+	if err != nil || time.Since(info.ModTime()) >= grace {
+		return false
+	}
+
+	reason := fmt.Sprintf("crashed %v after upgrade", uptime)
+	if err := upgrade.Rollback(reason); err != nil {
+		l.Warnln("Automatic rollback:", err)
+		return false
+	}
+
+	l.Warnln("Rolled back to the previous version; the upgraded binary crashed within the grace period")
+	return true
+}
+
 func copyStderr(stderr io.Reader, dst io.Writer) {
 	br := bufio.NewReader(stderr)
 
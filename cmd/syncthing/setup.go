@@ -0,0 +1,228 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/syncthing/syncthing/lib/config"
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syncthing/syncthing/lib/rand"
+)
+
+// The guided setup API walks a fresh installation through the steps
+// needed to get syncing: the device keys (generated at first start), the
+// defaults decision (usage reporting), the first remote device, and a
+// folder shared with it. The GUI or a packager's onboarding tool reads
+// the state machine from GET /rest/system/setup and advances it with the
+// POST endpoints, instead of editing the raw configuration.
+
+const (
+	setupStepKeys     = "keys"
+	setupStepDefaults = "defaults"
+	setupStepDevice   = "device"
+	setupStepFolder   = "folder"
+)
+
+type setupStep struct {
+	Name      string `json:"name"`
+	Completed bool   `json:"completed"`
+}
+
+type setupStatus struct {
+	Completed   bool        `json:"completed"`
+	CurrentStep string      `json:"currentStep,omitempty"`
+	Steps       []setupStep `json:"steps"`
+}
+
+// A setupIntroduction carries what is needed to add the device that
+// generated it, as a single blob that can be pasted or scanned on the
+// other side.
+type setupIntroduction struct {
+	DeviceID  string   `json:"deviceID"`
+	Name      string   `json:"name,omitempty"`
+	Addresses []string `json:"addresses,omitempty"`
+}
+
+// setupState derives the current state of the guided setup from the
+// configuration.
+func (s *apiService) setupState() setupStatus {
+	steps := []setupStep{
+		// We would not be serving this request without keys.
+		{Name: setupStepKeys, Completed: true},
+		// The usage reporting decision is the marker for having been
+		// through the defaults step; zero means undecided.
+		{Name: setupStepDefaults, Completed: s.cfg.Options().URAccepted != 0},
+		{Name: setupStepDevice, Completed: len(s.cfg.Devices()) > 1},
+		{Name: setupStepFolder, Completed: s.haveSharedFolder()},
+	}
+
+	status := setupStatus{Completed: true, Steps: steps}
+	for _, step := range steps {
+		if !step.Completed {
+			status.Completed = false
+			status.CurrentStep = step.Name
+			break
+		}
+	}
+	return status
+}
+
+// haveSharedFolder returns true if any folder is shared with a remote
+// device. The default folder created at first start is shared only with
+// ourselves and doesn't count.
+func (s *apiService) haveSharedFolder() bool {
+	for _, folder := range s.cfg.Folders() {
+		for _, dev := range folder.Devices {
+			if dev.DeviceID != s.id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *apiService) getSystemSetup(w http.ResponseWriter, r *http.Request) {
+	sendJSON(w, s.setupState())
+}
+
+func (s *apiService) getSystemSetupIntroduction(w http.ResponseWriter, r *http.Request) {
+	intro := setupIntroduction{
+		DeviceID:  s.id.String(),
+		Name:      s.cfg.Devices()[s.id].Name,
+		Addresses: s.cfg.ListenAddresses(),
+	}
+	bs, _ := json.Marshal(intro) // marshalling can't fail
+	sendJSON(w, map[string]string{
+		"introduction": base64.StdEncoding.EncodeToString(bs),
+	})
+}
+
+func (s *apiService) postSystemSetupDefaults(w http.ResponseWriter, r *http.Request) {
+	s.systemConfigMut.Lock()
+	defer s.systemConfigMut.Unlock()
+
+	var req struct {
+		URAccepted bool `json:"urAccepted"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	r.Body.Close()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	to := s.cfg.Raw().Copy()
+	if req.URAccepted {
+		to.Options.URAccepted = usageReportVersion
+		to.Options.URUniqueID = rand.String(8)
+	} else {
+		to.Options.URAccepted = -1
+	}
+
+	s.replaceConfig(w, to)
+}
+
+func (s *apiService) postSystemSetupDevice(w http.ResponseWriter, r *http.Request) {
+	s.systemConfigMut.Lock()
+	defer s.systemConfigMut.Unlock()
+
+	var req struct {
+		Introduction string `json:"introduction"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	r.Body.Close()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	bs, err := base64.StdEncoding.DecodeString(req.Introduction)
+	if err != nil {
+		http.Error(w, "malformed introduction: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var intro setupIntroduction
+	if err := json.Unmarshal(bs, &intro); err != nil {
+		http.Error(w, "malformed introduction: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	deviceID, err := protocol.DeviceIDFromString(intro.DeviceID)
+	if err != nil {
+		http.Error(w, "malformed device ID: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if deviceID == s.id {
+		http.Error(w, "cannot add ourselves", http.StatusBadRequest)
+		return
+	}
+	if _, ok := s.cfg.Devices()[deviceID]; ok {
+		http.Error(w, "device already configured", http.StatusBadRequest)
+		return
+	}
+
+	dcfg := config.NewDeviceConfiguration(deviceID, intro.Name)
+	dcfg.Addresses = intro.Addresses
+	if len(dcfg.Addresses) == 0 {
+		dcfg.Addresses = []string{"dynamic"}
+	}
+
+	to := s.cfg.Raw().Copy()
+	to.Devices = append(to.Devices, dcfg)
+
+	s.replaceConfig(w, to)
+}
+
+func (s *apiService) postSystemSetupFolder(w http.ResponseWriter, r *http.Request) {
+	s.systemConfigMut.Lock()
+	defer s.systemConfigMut.Unlock()
+
+	var req struct {
+		ID    string `json:"id"`
+		Label string `json:"label"`
+		Path  string `json:"path"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	r.Body.Close()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.ID == "" {
+		req.ID = strings.ToLower(rand.String(5) + "-" + rand.String(5))
+	}
+	if req.Path == "" {
+		req.Path = locations[locDefFolder]
+	}
+	if _, ok := s.cfg.Folders()[req.ID]; ok {
+		http.Error(w, "folder already configured", http.StatusBadRequest)
+		return
+	}
+
+	fcfg := config.NewFolderConfiguration(req.ID, req.Path)
+	fcfg.Label = req.Label
+	fcfg.RescanIntervalS = 60
+	fcfg.MinDiskFreePct = 1
+	fcfg.AutoNormalize = true
+	fcfg.MaxConflicts = -1
+
+	// Share the folder with every configured device; during guided setup
+	// that is ourselves and the device just paired.
+	for deviceID := range s.cfg.Devices() {
+		fcfg.Devices = append(fcfg.Devices, config.FolderDeviceConfiguration{DeviceID: deviceID})
+	}
+
+	to := s.cfg.Raw().Copy()
+	to.Folders = append(to.Folders, fcfg)
+
+	s.replaceConfig(w, to)
+}
@@ -0,0 +1,79 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/syncthing/syncthing/lib/config"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+type setupTestConfig struct {
+	mockedConfig
+	opts    config.OptionsConfiguration
+	devices map[protocol.DeviceID]config.DeviceConfiguration
+	folders map[string]config.FolderConfiguration
+}
+
+func (c *setupTestConfig) Options() config.OptionsConfiguration {
+	return c.opts
+}
+
+func (c *setupTestConfig) Devices() map[protocol.DeviceID]config.DeviceConfiguration {
+	return c.devices
+}
+
+func (c *setupTestConfig) Folders() map[string]config.FolderConfiguration {
+	return c.folders
+}
+
+func TestSetupState(t *testing.T) {
+	myID := protocol.LocalDeviceID
+	remoteID, _ := protocol.DeviceIDFromString("I6KAH76-66SLLLB-5PFXSOA-UFJCDZC-YAOMLEK-CP2GB32-BV5RQST-3PSROAU")
+
+	cfg := &setupTestConfig{
+		devices: map[protocol.DeviceID]config.DeviceConfiguration{
+			myID: {DeviceID: myID},
+		},
+	}
+	s := &apiService{id: myID, cfg: cfg}
+
+	// A fresh installation has keys but nothing else.
+	if state := s.setupState(); state.Completed || state.CurrentStep != setupStepDefaults {
+		t.Errorf("unexpected state %+v", state)
+	}
+
+	// The usage reporting decision completes the defaults step.
+	cfg.opts.URAccepted = -1
+	if state := s.setupState(); state.Completed || state.CurrentStep != setupStepDevice {
+		t.Errorf("unexpected state %+v", state)
+	}
+
+	// Adding a remote device completes the device step.
+	cfg.devices[remoteID] = config.DeviceConfiguration{DeviceID: remoteID}
+	if state := s.setupState(); state.Completed || state.CurrentStep != setupStepFolder {
+		t.Errorf("unexpected state %+v", state)
+	}
+
+	// A folder shared only with ourselves does not count.
+	cfg.folders = map[string]config.FolderConfiguration{
+		"default": {ID: "default", Devices: []config.FolderDeviceConfiguration{{DeviceID: myID}}},
+	}
+	if state := s.setupState(); state.Completed {
+		t.Errorf("unexpected state %+v", state)
+	}
+
+	// Sharing a folder with the remote device completes the setup.
+	cfg.folders["default"] = config.FolderConfiguration{
+		ID:      "default",
+		Devices: []config.FolderDeviceConfiguration{{DeviceID: myID}, {DeviceID: remoteID}},
+	}
+	if state := s.setupState(); !state.Completed || state.CurrentStep != "" {
+		t.Errorf("unexpected state %+v", state)
+	}
+}
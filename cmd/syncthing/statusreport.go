@@ -0,0 +1,227 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/syncthing/syncthing/lib/config"
+	"github.com/syncthing/syncthing/lib/events"
+	"github.com/syncthing/syncthing/lib/model"
+)
+
+// The statusReportService compiles per-folder activity digests on a
+// schedule and delivers them via webhook or SMTP, for admins who want a
+// periodic summary instead of watching the GUI.
+type statusReportService struct {
+	cfg   *config.Wrapper
+	model *model.Model
+	stop  chan struct{}
+
+	counts map[string]*folderCounts
+}
+
+// folderCounts accumulates activity for one folder between two reports.
+type folderCounts struct {
+	changed   int
+	conflicts int
+	errors    int
+}
+
+type folderDigest struct {
+	Folder    string   `json:"folder"`
+	State     string   `json:"state"`
+	Error     string   `json:"error,omitempty"`
+	Changed   int      `json:"changedItems"`
+	Conflicts int      `json:"conflicts"`
+	Errors    int      `json:"errors"`
+	OutOfSync []string `json:"outOfSyncDevices,omitempty"`
+}
+
+type statusReport struct {
+	Time    time.Time      `json:"time"`
+	Device  string         `json:"device"`
+	Folders []folderDigest `json:"folders"`
+}
+
+func newStatusReportService(cfg *config.Wrapper, m *model.Model) *statusReportService {
+	return &statusReportService{
+		cfg:    cfg,
+		model:  m,
+		stop:   make(chan struct{}),
+		counts: make(map[string]*folderCounts),
+	}
+}
+
+func (s *statusReportService) Serve() {
+	sub := events.Default.Subscribe(events.ItemFinished)
+	defer events.Default.Unsubscribe(sub)
+
+	timer := time.NewTimer(s.interval())
+	defer timer.Stop()
+
+	for {
+		select {
+		case ev := <-sub.C():
+			s.accumulate(ev)
+
+		case <-timer.C:
+			if s.cfg.Options().StatusReportEnabled {
+				if err := s.sendReport(); err != nil {
+					l.Infoln("Status report:", err)
+				}
+			}
+			timer.Reset(s.interval())
+
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *statusReportService) Stop() {
+	close(s.stop)
+}
+
+func (s *statusReportService) String() string {
+	return fmt.Sprintf("statusReportService@%p", s)
+}
+
+func (s *statusReportService) interval() time.Duration {
+	hours := s.cfg.Options().StatusReportIntervalH
+	if hours <= 0 {
+		hours = 24
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+func (s *statusReportService) folderCounts(folder string) *folderCounts {
+	c, ok := s.counts[folder]
+	if !ok {
+		c = &folderCounts{}
+		s.counts[folder] = c
+	}
+	return c
+}
+
+func (s *statusReportService) accumulate(ev events.Event) {
+	data, ok := ev.Data.(map[string]interface{})
+	if !ok {
+		return
+	}
+	folder, _ := data["folder"].(string)
+	c := s.folderCounts(folder)
+	c.changed++
+	if err, ok := data["error"].(*string); ok && err != nil {
+		c.errors++
+	}
+	if item, ok := data["item"].(string); ok && strings.Contains(item, ".sync-conflict-") {
+		c.conflicts++
+	}
+}
+
+func (s *statusReportService) compileReport() statusReport {
+	report := statusReport{
+		Time:   time.Now().Truncate(time.Second),
+		Device: myID.String(),
+	}
+
+	folders := s.cfg.Folders()
+	ids := make([]string, 0, len(folders))
+	for id := range folders {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		counts := s.folderCounts(id)
+		state, _, stateErr := s.model.State(id)
+		digest := folderDigest{
+			Folder:    id,
+			State:     state,
+			Changed:   counts.changed,
+			Conflicts: counts.conflicts,
+			Errors:    counts.errors,
+		}
+		if stateErr != nil {
+			digest.Error = stateErr.Error()
+		}
+		for _, dev := range folders[id].Devices {
+			if dev.DeviceID == myID || !s.model.ConnectedTo(dev.DeviceID) {
+				continue
+			}
+			if comp := s.model.Completion(dev.DeviceID, id); comp.CompletionPct < 100 {
+				digest.OutOfSync = append(digest.OutOfSync, dev.DeviceID.String())
+			}
+		}
+		report.Folders = append(report.Folders, digest)
+		delete(s.counts, id)
+	}
+
+	return report
+}
+
+func (s *statusReportService) sendReport() error {
+	report := s.compileReport()
+	opts := s.cfg.Options()
+
+	var firstErr error
+	if opts.StatusReportWebhookURL != "" {
+		if err := sendReportWebhook(opts.StatusReportWebhookURL, report); err != nil {
+			firstErr = err
+		}
+	}
+	if opts.StatusReportSMTPServer != "" && len(opts.StatusReportSMTPTo) > 0 {
+		if err := sendReportMail(opts, report); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func sendReportWebhook(url string, report statusReport) error {
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(report); err != nil {
+		return err
+	}
+	resp, err := http.Post(url, "application/json", &b)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+func sendReportMail(opts config.OptionsConfiguration, report statusReport) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", opts.StatusReportSMTPFrom)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(opts.StatusReportSMTPTo, ", "))
+	fmt.Fprintf(&b, "Subject: Syncthing status report from %s\r\n", report.Device)
+	fmt.Fprintf(&b, "\r\n")
+	fmt.Fprintf(&b, "Status report generated %v.\r\n\r\n", report.Time)
+	for _, digest := range report.Folders {
+		fmt.Fprintf(&b, "Folder %q (%s): %d changed items, %d conflicts, %d errors\r\n",
+			digest.Folder, digest.State, digest.Changed, digest.Conflicts, digest.Errors)
+		if digest.Error != "" {
+			fmt.Fprintf(&b, "  Folder error: %s\r\n", digest.Error)
+		}
+		if len(digest.OutOfSync) > 0 {
+			fmt.Fprintf(&b, "  Out of sync devices: %s\r\n", strings.Join(digest.OutOfSync, ", "))
+		}
+	}
+	return smtp.SendMail(opts.StatusReportSMTPServer, nil, opts.StatusReportSMTPFrom, opts.StatusReportSMTPTo, b.Bytes())
+}
@@ -0,0 +1,58 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package blake2b
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/rand"
+	"testing"
+)
+
+var vectors = []struct {
+	data string
+	hash string
+}{
+	{"", "0e5751c026e543b2e8ab2eb06099daa1d1e5df47778f7787faab45cdf12fe3a8"},
+	{"abc", "bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319"},
+}
+
+func TestVectors(t *testing.T) {
+	for _, tc := range vectors {
+		h := New()
+		h.Write([]byte(tc.data))
+		if sum := hex.EncodeToString(h.Sum(nil)); sum != tc.hash {
+			t.Errorf("BLAKE2b-256(%q) => %s, expected %s", tc.data, sum, tc.hash)
+		}
+	}
+}
+
+func TestChunkedWrites(t *testing.T) {
+	// Writing the data in arbitrary chunks must give the same digest as
+	// writing it in one go, regardless of how the chunks line up with the
+	// internal block size.
+	data := make([]byte, 3*BlockSize+17)
+	rand.Read(data)
+
+	h := New()
+	h.Write(data)
+	expected := h.Sum(nil)
+
+	for _, chunk := range []int{1, 31, BlockSize - 1, BlockSize, BlockSize + 1, 2 * BlockSize} {
+		h := New()
+		for i := 0; i < len(data); i += chunk {
+			end := i + chunk
+			if end > len(data) {
+				end = len(data)
+			}
+			h.Write(data[i:end])
+		}
+		if sum := h.Sum(nil); !bytes.Equal(sum, expected) {
+			t.Errorf("chunk size %d gave digest %x, expected %x", chunk, sum, expected)
+		}
+	}
+}
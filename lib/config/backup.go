@@ -0,0 +1,136 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+const (
+	// The number of timestamped config backups kept next to the config
+	// file.
+	configBackupCount = 10
+	backupTimeFormat  = "20060102-150405"
+)
+
+// BackupInfo describes one timestamped config backup.
+type BackupInfo struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+}
+
+// archiveBackup copies the current config file to a timestamped backup and
+// removes the oldest backups beyond configBackupCount. It is called before
+// every save.
+func (w *Wrapper) archiveBackup() error {
+	bs, err := ioutil.ReadFile(w.path)
+	if os.IsNotExist(err) {
+		// Nothing to back up.
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s.%s.bak", w.path, time.Now().Format(backupTimeFormat))
+	if err := ioutil.WriteFile(name, bs, 0600); err != nil {
+		return err
+	}
+
+	names, err := filepath.Glob(w.path + ".*.bak")
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for len(names) > configBackupCount {
+		if err := os.Remove(names[0]); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// ListBackups returns the available config backups, newest first.
+func (w *Wrapper) ListBackups() ([]BackupInfo, error) {
+	names, err := filepath.Glob(w.path + ".*.bak")
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+
+	backups := make([]BackupInfo, 0, len(names))
+	for _, name := range names {
+		info, err := os.Stat(name)
+		if err != nil {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, w.path+"."), ".bak")
+		when, err := time.ParseInLocation(backupTimeFormat, stamp, time.Local)
+		if err != nil {
+			// Not one of ours.
+			continue
+		}
+		backups = append(backups, BackupInfo{
+			Name: filepath.Base(name),
+			Time: when,
+			Size: info.Size(),
+		})
+	}
+	return backups, nil
+}
+
+// BackupConfig loads the named backup, without touching the current
+// configuration.
+func (w *Wrapper) BackupConfig(name string, myID protocol.DeviceID) (Configuration, error) {
+	path, err := w.backupPath(name)
+	if err != nil {
+		return Configuration{}, err
+	}
+	fd, err := os.Open(path)
+	if err != nil {
+		return Configuration{}, err
+	}
+	defer fd.Close()
+	return ReadXML(fd, myID)
+}
+
+// RestoreBackup replaces the current configuration with the named backup and
+// saves it. The save archives the replaced config, so a restore can itself
+// be undone.
+func (w *Wrapper) RestoreBackup(name string, myID protocol.DeviceID) error {
+	cfg, err := w.BackupConfig(name, myID)
+	if err != nil {
+		return err
+	}
+	if err := w.Replace(cfg); err != nil {
+		return err
+	}
+	return w.Save()
+}
+
+// backupPath validates a user supplied backup name and returns the full
+// path it refers to.
+func (w *Wrapper) backupPath(name string) (string, error) {
+	base := filepath.Base(w.path)
+	if filepath.Base(name) != name || !strings.HasPrefix(name, base+".") || !strings.HasSuffix(name, ".bak") {
+		return "", fmt.Errorf("no such backup: %s", name)
+	}
+	path := filepath.Join(filepath.Dir(w.path), name)
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
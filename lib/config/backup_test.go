@@ -0,0 +1,76 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackupRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syncthing-backup-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.xml")
+	w := Wrap(path, New(device1))
+	if err := w.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Saving without an existing config creates no backup.
+	backups, err := w.ListBackups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 0 {
+		t.Errorf("Expected no backups, got %d", len(backups))
+	}
+
+	// Fill up with fake old backups, then save again. The oldest should be
+	// pruned down to the retention count, including the new one.
+	base := time.Now().Add(-24 * time.Hour)
+	for i := 0; i < configBackupCount+5; i++ {
+		stamp := base.Add(time.Duration(i) * time.Minute).Format(backupTimeFormat)
+		name := fmt.Sprintf("%s.%s.bak", path, stamp)
+		if err := ioutil.WriteFile(name, []byte("old"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err = w.ListBackups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != configBackupCount {
+		t.Errorf("Expected %d backups, got %d", configBackupCount, len(backups))
+	}
+	for i := 1; i < len(backups); i++ {
+		if backups[i].Time.After(backups[i-1].Time) {
+			t.Errorf("Backups not sorted newest first")
+		}
+	}
+
+	// The newest backup restores cleanly.
+	if err := w.RestoreBackup(backups[0].Name, device1); err != nil {
+		t.Error("Restore:", err)
+	}
+
+	// A name trying to escape the config directory is rejected.
+	if err := w.RestoreBackup("../evil.bak", device1); err == nil {
+		t.Error("Expected restore of bad name to fail")
+	}
+}
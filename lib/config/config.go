@@ -85,7 +85,17 @@ func ReadXML(r io.Reader, myID protocol.DeviceID) (Configuration, error) {
 	util.SetDefaults(&cfg.Options)
 	util.SetDefaults(&cfg.GUI)
 
-	if err := xml.NewDecoder(r).Decode(&cfg); err != nil {
+	bs, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Configuration{}, err
+	}
+
+	if err := xml.Unmarshal(bs, &cfg); err != nil {
+		// Run the validation pass for a friendlier error, pinpointing the
+		// offending element and line where possible.
+		if errs := ValidateXML(bs); len(errs) > 0 {
+			return Configuration{}, errs[0]
+		}
 		return Configuration{}, err
 	}
 	cfg.OriginalVersion = cfg.Version
@@ -120,13 +130,17 @@ func ReadJSON(r io.Reader, myID protocol.DeviceID) (Configuration, error) {
 }
 
 type Configuration struct {
-	Version        int                   `xml:"version,attr" json:"version"`
-	Folders        []FolderConfiguration `xml:"folder" json:"folders"`
-	Devices        []DeviceConfiguration `xml:"device" json:"devices"`
-	GUI            GUIConfiguration      `xml:"gui" json:"gui"`
-	Options        OptionsConfiguration  `xml:"options" json:"options"`
-	IgnoredDevices []protocol.DeviceID   `xml:"ignoredDevice" json:"ignoredDevices"`
-	XMLName        xml.Name              `xml:"configuration" json:"-"`
+	Version        int                         `xml:"version,attr" json:"version"`
+	Folders        []FolderConfiguration       `xml:"folder" json:"folders"`
+	Devices        []DeviceConfiguration       `xml:"device" json:"devices"`
+	GUI            GUIConfiguration            `xml:"gui" json:"gui"`
+	ExtraGUIs      []GUIConfiguration          `xml:"extraGui,omitempty" json:"extraGuis"`
+	Namespaces     []NamespaceConfiguration    `xml:"namespace,omitempty" json:"namespaces"`
+	FolderTokens   []FolderTokenConfiguration  `xml:"folderToken,omitempty" json:"folderTokens"`
+	Notifications  []NotificationConfiguration `xml:"notification,omitempty" json:"notifications"`
+	Options        OptionsConfiguration        `xml:"options" json:"options"`
+	IgnoredDevices []protocol.DeviceID         `xml:"ignoredDevice" json:"ignoredDevices"`
+	XMLName        xml.Name                    `xml:"configuration" json:"-"`
 
 	OriginalVersion int `xml:"-" json:"-"` // The version we read from disk, before any conversion
 }
@@ -148,6 +162,26 @@ func (cfg Configuration) Copy() Configuration {
 
 	newCfg.Options = cfg.Options.Copy()
 
+	// GUIConfigurations are values
+	newCfg.ExtraGUIs = make([]GUIConfiguration, len(cfg.ExtraGUIs))
+	copy(newCfg.ExtraGUIs, cfg.ExtraGUIs)
+
+	// NamespaceConfigurations are values
+	newCfg.Namespaces = make([]NamespaceConfiguration, len(cfg.Namespaces))
+	copy(newCfg.Namespaces, cfg.Namespaces)
+
+	newCfg.FolderTokens = make([]FolderTokenConfiguration, len(cfg.FolderTokens))
+	for i := range cfg.FolderTokens {
+		newCfg.FolderTokens[i] = cfg.FolderTokens[i]
+		newCfg.FolderTokens[i].Folders = make([]string, len(cfg.FolderTokens[i].Folders))
+		copy(newCfg.FolderTokens[i].Folders, cfg.FolderTokens[i].Folders)
+	}
+
+	newCfg.Notifications = make([]NotificationConfiguration, len(cfg.Notifications))
+	for i := range cfg.Notifications {
+		newCfg.Notifications[i] = cfg.Notifications[i].Copy()
+	}
+
 	// DeviceIDs are values
 	newCfg.IgnoredDevices = make([]protocol.DeviceID, len(cfg.IgnoredDevices))
 	copy(newCfg.IgnoredDevices, cfg.IgnoredDevices)
@@ -206,12 +240,27 @@ func (cfg *Configuration) clean() error {
 	if cfg.IgnoredDevices == nil {
 		cfg.IgnoredDevices = []protocol.DeviceID{}
 	}
+	if cfg.ExtraGUIs == nil {
+		cfg.ExtraGUIs = []GUIConfiguration{}
+	}
+	if cfg.Namespaces == nil {
+		cfg.Namespaces = []NamespaceConfiguration{}
+	}
+	if cfg.FolderTokens == nil {
+		cfg.FolderTokens = []FolderTokenConfiguration{}
+	}
+	if cfg.Notifications == nil {
+		cfg.Notifications = []NotificationConfiguration{}
+	}
 	if cfg.Options.AlwaysLocalNets == nil {
 		cfg.Options.AlwaysLocalNets = []string{}
 	}
 	if cfg.Options.UnackedNotificationIDs == nil {
 		cfg.Options.UnackedNotificationIDs = []string{}
 	}
+	if cfg.Options.StatusReportSMTPTo == nil {
+		cfg.Options.StatusReportSMTPTo = []string{}
+	}
 
 	// Prepare folders and check for duplicates. Duplicates are bad and
 	// dangerous, can't currently be resolved in the GUI, and shouldn't
@@ -228,6 +277,17 @@ func (cfg *Configuration) clean() error {
 		seenFolders[folder.ID] = struct{}{}
 	}
 
+	// Warn about folders whose paths nest or overlap. Such folders feed
+	// each other's changes back and forth in a loop; the model keeps them
+	// in an error state at runtime.
+	for i := range cfg.Folders {
+		for j := i + 1; j < len(cfg.Folders); j++ {
+			if FolderPathsOverlap(cfg.Folders[i].Path(), cfg.Folders[j].Path()) {
+				l.Warnf("Folder %q and folder %q have overlapping paths; this is not a supported configuration.", cfg.Folders[i].ID, cfg.Folders[j].ID)
+			}
+		}
+	}
+
 	cfg.Options.ListenAddresses = util.UniqueStrings(cfg.Options.ListenAddresses)
 	cfg.Options.GlobalAnnServers = util.UniqueStrings(cfg.Options.GlobalAnnServers)
 
@@ -65,6 +65,10 @@ func TestDefaultValues(t *testing.T) {
 		OverwriteRemoteDevNames: false,
 		TempIndexMinBlocks:      10,
 		UnackedNotificationIDs:  []string{},
+		StatusReportIntervalH:   24,
+		StatusReportSMTPTo:      []string{},
+		AlertOfflineHours:       24,
+		CongestionAvoidance:     true,
 	}
 
 	cfg := New(device1)
@@ -199,6 +203,10 @@ func TestOverriddenValues(t *testing.T) {
 		OverwriteRemoteDevNames: true,
 		TempIndexMinBlocks:      100,
 		UnackedNotificationIDs:  []string{},
+		StatusReportIntervalH:   24,
+		StatusReportSMTPTo:      []string{},
+		AlertOfflineHours:       24,
+		CongestionAvoidance:     true,
 	}
 
 	cfg, err := Load("testdata/overridenvalues.xml", device1)
@@ -660,7 +668,7 @@ func TestV14ListenAddressesMigration(t *testing.T) {
 		// config to start with...
 		{
 			{"tcp://0.0.0.0:22000"}, // old listen addrs
-			{""}, // old relay addrs
+			{""},                    // old relay addrs
 			{"tcp://0.0.0.0:22000"}, // new listen addrs
 		},
 		// Default listen plus non-default relays gets copied verbatim
@@ -772,3 +780,171 @@ func TestSharesRemovedOnDeviceRemoval(t *testing.T) {
 		t.Error("Unexpected extra device")
 	}
 }
+
+func TestDowngraded(t *testing.T) {
+	cfg := New(device1)
+	cfg.Options.ListenAddresses = []string{"default", "tcp://10.0.0.1:22000"}
+	cfg.Options.NATEnabled = true
+	cfg.Folders = []FolderConfiguration{
+		{ID: "ro", RawPath: "testdata", Type: FolderTypeReadOnly},
+		{ID: "rw", RawPath: "testdata", Type: FolderTypeReadWrite},
+	}
+
+	if _, err := Downgraded(cfg, CurrentVersion); err == nil {
+		t.Error("Downgrading to the current version should fail")
+	}
+	if _, err := Downgraded(cfg, OldestDowngradeVersion-1); err == nil {
+		t.Error("Downgrading below the oldest supported version should fail")
+	}
+
+	down, err := Downgraded(cfg, 13)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if down.Version != 13 {
+		t.Errorf("Wrong version %d", down.Version)
+	}
+	if cfg.Version != CurrentVersion {
+		t.Error("Downgraded should not modify the original configuration")
+	}
+	if !down.Options.DeprecatedUPnPEnabled {
+		t.Error("NAT option should be migrated back to UPnP")
+	}
+	if !down.Folders[0].DeprecatedReadOnly || down.Folders[1].DeprecatedReadOnly {
+		t.Error("Folder types should be migrated back to the ro attribute")
+	}
+	expectedListen := []string{"tcp://0.0.0.0:22000", "tcp://10.0.0.1:22000"}
+	if diff, equal := messagediff.PrettyDiff(expectedListen, down.Options.ListenAddresses); !equal {
+		t.Errorf("Listen addresses differ. Diff:\n%s", diff)
+	}
+	expectedRelays := []string{"dynamic+https://relays.syncthing.net/endpoint"}
+	if diff, equal := messagediff.PrettyDiff(expectedRelays, down.Options.DeprecatedRelayServers); !equal {
+		t.Errorf("Relay servers differ. Diff:\n%s", diff)
+	}
+}
+
+func TestValidateXML(t *testing.T) {
+	valid := []byte(`<configuration version="16">
+    <folder id="default" path="testdata"></folder>
+    <device id="` + device1.String() + `" name="node"></device>
+</configuration>
+`)
+	if errs := ValidateXML(valid); len(errs) != 0 {
+		t.Errorf("Unexpected errors for valid config: %v", errs)
+	}
+
+	invalid := []byte(`<configuration version="16">
+    <folder id="default" path="testdata" rescanIntervalS="often"></folder>
+    <folder id="default" path="testdata"></folder>
+    <device id="NOT-A-DEVICE-ID" name="node"></device>
+</configuration>
+`)
+	errs := ValidateXML(invalid)
+	if len(errs) != 3 {
+		t.Fatalf("Expected 3 errors, got %d: %v", len(errs), errs)
+	}
+
+	if errs[0].Element != "folder" || errs[0].Attribute != "rescanIntervalS" || errs[0].Line != 2 {
+		t.Errorf("Unexpected first error: %v", errs[0])
+	}
+	if errs[1].Element != "folder" || errs[1].Attribute != "id" || errs[1].Line != 2 {
+		t.Errorf("Unexpected second error: %v", errs[1])
+	}
+	if errs[2].Element != "device" || errs[2].Attribute != "id" || errs[2].Line != 4 {
+		t.Errorf("Unexpected third error: %v", errs[2])
+	}
+}
+
+func TestMatchTags(t *testing.T) {
+	tags := []TagConfiguration{
+		{Key: "site", Value: "oslo"},
+		{Key: "tenant", Value: "acme"},
+	}
+
+	cases := []struct {
+		filter string
+		match  bool
+	}{
+		{"", true},
+		{"site", true},
+		{"site=oslo", true},
+		{"site=oslo,tenant=acme", true},
+		{" site=oslo , tenant=acme ", true},
+		{"site=stockholm", false},
+		{"region", false},
+		{"site=oslo,region=eu", false},
+	}
+
+	for _, tc := range cases {
+		if res := MatchTags(tags, tc.filter); res != tc.match {
+			t.Errorf("MatchTags(%q) => %v, expected %v", tc.filter, res, tc.match)
+		}
+	}
+
+	if !MatchTags(nil, "") {
+		t.Error("Empty filter should match untagged resources")
+	}
+	if MatchTags(nil, "site") {
+		t.Error("Untagged resources should not match a key requirement")
+	}
+}
+
+func TestExtensionRuleMatches(t *testing.T) {
+	rule := ExtensionRuleConfiguration{Extensions: "zip, .gz,JPG"}
+
+	cases := []struct {
+		name  string
+		match bool
+	}{
+		{"archive.zip", true},
+		{"archive.ZIP", true},
+		{"dump.tar.gz", true},
+		{"photo.jpg", true},
+		{"document.txt", false},
+		{"zip", false}, // no extension
+		{"noext", false},
+		{"dir.zip/file.txt", false},
+	}
+
+	for _, tc := range cases {
+		if res := rule.Matches(tc.name); res != tc.match {
+			t.Errorf("Matches(%q) => %v, expected %v", tc.name, res, tc.match)
+		}
+	}
+
+	fcfg := FolderConfiguration{
+		ExtensionRules: []ExtensionRuleConfiguration{
+			{Extensions: "zip", NoDelta: true},
+			{Extensions: "db", WholeFile: true},
+		},
+	}
+	if rule, ok := fcfg.ExtensionRule("data.db"); !ok || !rule.WholeFile {
+		t.Error("Expected the whole file rule for data.db")
+	}
+	if _, ok := fcfg.ExtensionRule("notes.txt"); ok {
+		t.Error("Expected no rule for notes.txt")
+	}
+}
+
+func TestFolderPathsOverlap(t *testing.T) {
+	cases := []struct {
+		a, b    string
+		overlap bool
+	}{
+		{"/home/user/a", "/home/user/a", true},
+		{"/home/user/a", "/home/user/a/", true},
+		{"/home/user/a", "/home/user/a/b", true},
+		{"/home/user/a/b", "/home/user/a", true},
+		{"/home/user/a", "/home/user/ab", false},
+		{"/home/user/a", "/home/user/b", false},
+		{"", "/home/user/a", false},
+		{"/home/user/a", "", false},
+	}
+
+	for _, tc := range cases {
+		if res := FolderPathsOverlap(tc.a, tc.b); res != tc.overlap {
+			t.Errorf("FolderPathsOverlap(%q, %q) => %v, expected %v", tc.a, tc.b, res, tc.overlap)
+		}
+	}
+}
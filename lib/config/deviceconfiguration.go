@@ -15,6 +15,32 @@ type DeviceConfiguration struct {
 	Compression protocol.Compression `xml:"compression,attr" json:"compression"`
 	CertName    string               `xml:"certName,attr,omitempty" json:"certName"`
 	Introducer  bool                 `xml:"introducer,attr" json:"introducer"`
+	// Serve blocks to this device only when it connects from the local
+	// network; index exchange is unaffected.
+	ServeBlocksOnlyInLan bool `xml:"serveBlocksOnlyInLan,attr" json:"serveBlocksOnlyInLan"`
+	// Bytes of relayed traffic allowed per calendar month, after which
+	// only direct connections are attempted. Zero means no budget.
+	MonthlyRelayBudget int64 `xml:"monthlyRelayBudget,attr,omitempty" json:"monthlyRelayBudget"`
+	// Bytes of data sent to respectively received from the device allowed
+	// per calendar month, after which the device is paused. Zero means no
+	// cap.
+	MonthlySendCap    int64 `xml:"monthlySendCap,attr,omitempty" json:"monthlySendCap"`
+	MonthlyReceiveCap int64 `xml:"monthlyReceiveCap,attr,omitempty" json:"monthlyReceiveCap"`
+	// Keepalive tuning; zero selects the protocol defaults. Raise both on
+	// high latency links that would otherwise time out. The ping interval
+	// adapts downwards on its own when the link is unstable.
+	PingIntervalS   int `xml:"pingIntervalS,attr,omitempty" json:"pingIntervalS"`
+	ReceiveTimeoutS int `xml:"receiveTimeoutS,attr,omitempty" json:"receiveTimeoutS"`
+	// Shift modification times announced by this device into our clock
+	// frame when its measured clock skew is above the threshold.
+	CompensateClockSkew bool `xml:"compensateClockSkew,attr,omitempty" json:"compensateClockSkew"`
+	// Accept config updates pushed by this device over the protocol:
+	// adding devices to shared folders, updating device addresses. A
+	// stronger form of the introducer mechanism, for clusters managed
+	// from one admin machine.
+	RemoteAdmin bool               `xml:"remoteAdmin,attr,omitempty" json:"remoteAdmin"`
+	Tags        []TagConfiguration `xml:"tag,omitempty" json:"tags"`
+	Namespace   string             `xml:"namespace,attr,omitempty" json:"namespace"`
 }
 
 func NewDeviceConfiguration(id protocol.DeviceID, name string) DeviceConfiguration {
@@ -28,6 +54,10 @@ func (orig DeviceConfiguration) Copy() DeviceConfiguration {
 	c := orig
 	c.Addresses = make([]string, len(orig.Addresses))
 	copy(c.Addresses, orig.Addresses)
+	if orig.Tags != nil {
+		c.Tags = make([]TagConfiguration, len(orig.Tags))
+		copy(c.Tags, orig.Tags)
+	}
 	return c
 }
 
@@ -0,0 +1,94 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/syncthing/syncthing/lib/util"
+)
+
+// OldestDowngradeVersion is the oldest config version we can write a best
+// effort downgraded configuration for.
+const OldestDowngradeVersion = 13
+
+// Downgraded returns a copy of the configuration converted to the given
+// older config version, on a best effort basis, for use with a previous
+// syncthing release after a failed upgrade. Settings that have no
+// representation in the older version are lost.
+func Downgraded(cfg Configuration, version int) (Configuration, error) {
+	if version >= cfg.Version {
+		return Configuration{}, fmt.Errorf("configuration is already version %d", cfg.Version)
+	}
+	if version < OldestDowngradeVersion {
+		return Configuration{}, fmt.Errorf("downgrading below config version %d is not supported", OldestDowngradeVersion)
+	}
+
+	cfg = cfg.Copy()
+
+	// Downgrade configuration versions as appropriate, mirroring the
+	// upgrade conversions in config.go.
+	if cfg.Version == 16 && version < 16 {
+		downconvertV16V15(&cfg)
+	}
+	if cfg.Version == 15 && version < 15 {
+		downconvertV15V14(&cfg)
+	}
+	if cfg.Version == 14 && version < 14 {
+		downconvertV14V13(&cfg)
+	}
+
+	return cfg, nil
+}
+
+func downconvertV16V15(cfg *Configuration) {
+	// The v15 to v16 conversion only triggered a database tweak.
+	cfg.Version = 15
+}
+
+func downconvertV15V14(cfg *Configuration) {
+	// The v14 to v15 conversion undid a broken discovery server migration;
+	// nothing sensible to recreate.
+	cfg.Version = 14
+}
+
+func downconvertV14V13(cfg *Configuration) {
+	// Migrate NAT -> UPnP options
+	cfg.Options.DeprecatedUPnPEnabled = cfg.Options.NATEnabled
+	cfg.Options.NATEnabled = false
+	cfg.Options.DeprecatedUPnPLeaseM = cfg.Options.NATLeaseM
+	cfg.Options.NATLeaseM = 0
+	cfg.Options.DeprecatedUPnPRenewalM = cfg.Options.NATRenewalM
+	cfg.Options.NATRenewalM = 0
+	cfg.Options.DeprecatedUPnPTimeoutS = cfg.Options.NATTimeoutS
+	cfg.Options.NATTimeoutS = 0
+
+	// Move relay addresses back into the relay server list, and expand the
+	// "default" listen address to its v13 equivalents.
+	var listenAddrs, relayAddrs []string
+	for _, addr := range cfg.Options.ListenAddresses {
+		switch {
+		case addr == "default":
+			listenAddrs = append(listenAddrs, "tcp://0.0.0.0:22000")
+			relayAddrs = append(relayAddrs, "dynamic+https://relays.syncthing.net/endpoint")
+		case strings.HasPrefix(addr, "relay://"), strings.HasPrefix(addr, "dynamic+http"):
+			relayAddrs = append(relayAddrs, addr)
+		default:
+			listenAddrs = append(listenAddrs, addr)
+		}
+	}
+	cfg.Options.ListenAddresses = util.UniqueStrings(listenAddrs)
+	cfg.Options.DeprecatedRelayServers = util.UniqueStrings(relayAddrs)
+
+	for i, fcfg := range cfg.Folders {
+		cfg.Folders[i].DeprecatedReadOnly = fcfg.Type == FolderTypeReadOnly
+		cfg.Folders[i].Type = FolderTypeReadWrite
+	}
+
+	cfg.Version = 13
+}
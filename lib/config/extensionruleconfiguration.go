@@ -0,0 +1,39 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package config
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// An ExtensionRuleConfiguration adjusts transfer behavior for files whose
+// extension matches the rule. Already-compressed formats gain nothing from
+// delta transfers or wire compression, and formats with internal structure
+// (database files, say) should be replaced wholesale rather than patched
+// block by block.
+type ExtensionRuleConfiguration struct {
+	Extensions string `xml:"extensions,attr" json:"extensions"`           // Comma separated list of extensions, with or without leading dots.
+	NoDelta    bool   `xml:"noDelta,attr,omitempty" json:"noDelta"`       // Skip block reuse from the database; pull all missing blocks from the network.
+	WholeFile  bool   `xml:"wholeFile,attr,omitempty" json:"wholeFile"`   // Transfer the entire file on any change, ignoring unchanged blocks. Implies noDelta.
+	NoCompress bool   `xml:"noCompress,attr,omitempty" json:"noCompress"` // Never compress response data for these files, regardless of the device compression setting.
+}
+
+// Matches returns true when the rule applies to the given file name.
+func (r ExtensionRuleConfiguration) Matches(name string) bool {
+	ext := strings.TrimPrefix(filepath.Ext(name), ".")
+	if ext == "" {
+		return false
+	}
+	for _, cand := range strings.Split(r.Extensions, ",") {
+		cand = strings.TrimPrefix(strings.TrimSpace(cand), ".")
+		if cand != "" && strings.EqualFold(cand, ext) {
+			return true
+		}
+	}
+	return false
+}
@@ -17,27 +17,48 @@ import (
 )
 
 type FolderConfiguration struct {
-	ID                    string                      `xml:"id,attr" json:"id"`
-	Label                 string                      `xml:"label,attr" json:"label"`
-	RawPath               string                      `xml:"path,attr" json:"path"`
-	Type                  FolderType                  `xml:"type,attr" json:"type"`
-	Devices               []FolderDeviceConfiguration `xml:"device" json:"devices"`
-	RescanIntervalS       int                         `xml:"rescanIntervalS,attr" json:"rescanIntervalS"`
-	IgnorePerms           bool                        `xml:"ignorePerms,attr" json:"ignorePerms"`
-	AutoNormalize         bool                        `xml:"autoNormalize,attr" json:"autoNormalize"`
-	MinDiskFreePct        float64                     `xml:"minDiskFreePct" json:"minDiskFreePct"`
-	Versioning            VersioningConfiguration     `xml:"versioning" json:"versioning"`
-	Copiers               int                         `xml:"copiers" json:"copiers"` // This defines how many files are handled concurrently.
-	Pullers               int                         `xml:"pullers" json:"pullers"` // Defines how many blocks are fetched at the same time, possibly between separate copier routines.
-	Hashers               int                         `xml:"hashers" json:"hashers"` // Less than one sets the value to the number of cores. These are CPU bound due to hashing.
-	Order                 PullOrder                   `xml:"order" json:"order"`
-	IgnoreDelete          bool                        `xml:"ignoreDelete" json:"ignoreDelete"`
-	ScanProgressIntervalS int                         `xml:"scanProgressIntervalS" json:"scanProgressIntervalS"` // Set to a negative value to disable. Value of 0 will get replaced with value of 2 (default value)
-	PullerSleepS          int                         `xml:"pullerSleepS" json:"pullerSleepS"`
-	PullerPauseS          int                         `xml:"pullerPauseS" json:"pullerPauseS"`
-	MaxConflicts          int                         `xml:"maxConflicts" json:"maxConflicts"`
-	DisableSparseFiles    bool                        `xml:"disableSparseFiles" json:"disableSparseFiles"`
-	DisableTempIndexes    bool                        `xml:"disableTempIndexes" json:"disableTempIndexes"`
+	ID                    string                       `xml:"id,attr" json:"id"`
+	Label                 string                       `xml:"label,attr" json:"label"`
+	RawPath               string                       `xml:"path,attr" json:"path"`
+	Type                  FolderType                   `xml:"type,attr" json:"type"`
+	Devices               []FolderDeviceConfiguration  `xml:"device" json:"devices"`
+	RescanIntervalS       int                          `xml:"rescanIntervalS,attr" json:"rescanIntervalS"`
+	IgnorePerms           bool                         `xml:"ignorePerms,attr" json:"ignorePerms"`
+	AutoNormalize         bool                         `xml:"autoNormalize,attr" json:"autoNormalize"`
+	MinDiskFreePct        float64                      `xml:"minDiskFreePct" json:"minDiskFreePct"`
+	Versioning            VersioningConfiguration      `xml:"versioning" json:"versioning"`
+	Copiers               int                          `xml:"copiers" json:"copiers"`             // This defines how many files are handled concurrently.
+	Pullers               int                          `xml:"pullers" json:"pullers"`             // Defines how many blocks are fetched at the same time, possibly between separate copier routines.
+	Hashers               int                          `xml:"hashers" json:"hashers"`             // Less than one sets the value to the number of cores. These are CPU bound due to hashing.
+	HashAlgorithm         string                       `xml:"hashAlgorithm" json:"hashAlgorithm"` // Block hash algorithm; empty or "sha256" (default), or "blake2b". Must be the same on all devices sharing the folder.
+	Order                 PullOrder                    `xml:"order" json:"order"`
+	IgnoreDelete          bool                         `xml:"ignoreDelete" json:"ignoreDelete"`
+	ScanProgressIntervalS int                          `xml:"scanProgressIntervalS" json:"scanProgressIntervalS"` // Set to a negative value to disable. Value of 0 will get replaced with value of 2 (default value)
+	PullerSleepS          int                          `xml:"pullerSleepS" json:"pullerSleepS"`
+	PullerPauseS          int                          `xml:"pullerPauseS" json:"pullerPauseS"`
+	MaxConflicts          int                          `xml:"maxConflicts" json:"maxConflicts"`
+	DisableSparseFiles    bool                         `xml:"disableSparseFiles" json:"disableSparseFiles"`
+	DisableTempIndexes    bool                         `xml:"disableTempIndexes" json:"disableTempIndexes"`
+	EncryptTempFiles      bool                         `xml:"encryptTempFiles" json:"encryptTempFiles"`           // Keep pull temp files encrypted until the final rename. Implies no temp index exchange for the folder.
+	HonorHolds            bool                         `xml:"honorHolds" json:"honorHolds"`                       // Defer pulling changes to paths that are under an advisory hold by another device.
+	WatchJournal          bool                         `xml:"watchJournal" json:"watchJournal"`                   // Use a change journal backend for scan hints, where available.
+	WatchBudget           int                          `xml:"watchBudget" json:"watchBudget"`                     // Maximum number of watch descriptors to consume for the folder; zero means the default. Subtrees over budget fall back to periodic scanning.
+	SeparateDatabase      bool                         `xml:"separateDatabase" json:"separateDatabase"`           // Keep the folder's index in its own database, so removing the folder is a directory delete and corruption stays contained. Blocks in the folder are not found by cross-folder block reuse.
+	PauseOnBattery        bool                         `xml:"pauseOnBattery" json:"pauseOnBattery"`               // Suspend pulls and scheduled scans while on battery power.
+	PauseOnMetered        bool                         `xml:"pauseOnMetered" json:"pauseOnMetered"`               // Suspend pulls and scheduled scans while on a metered connection.
+	DetectContentTypes    bool                         `xml:"detectContentTypes" json:"detectContentTypes"`       // Sniff the content type of scanned files and store it in the file metadata.
+	GeneratePreviews      bool                         `xml:"generatePreviews" json:"generatePreviews"`           // Generate small previews of image files into the .stpreviews sidecar store inside the folder.
+	RemoteChangeLimitPct  int                          `xml:"remoteChangeLimitPct" json:"remoteChangeLimitPct"`   // Ignore changes from a device that modifies or deletes more than this percentage of the folder within the change window, until explicitly confirmed. Zero disables the guard.
+	RemoteChangeWindowS   int                          `xml:"remoteChangeWindowS" json:"remoteChangeWindowS"`     // Measurement window for remoteChangeLimitPct, in seconds. Zero means ten minutes.
+	DeletionQuorum        int                          `xml:"deletionQuorum" json:"deletionQuorum"`               // Apply remote deletions only after this many devices announce them. Zero or one applies deletions immediately.
+	DeletionQuorumMaxAgeS int                          `xml:"deletionQuorumMaxAgeS" json:"deletionQuorumMaxAgeS"` // Apply a deletion anyway after waiting this long for quorum, in seconds. Zero waits indefinitely.
+	PullDependsOn         string                       `xml:"pullDependsOn,omitempty" json:"pullDependsOn"`       // Wait with pulling until this folder has reached its completion level.
+	PullDependsOnPct      float64                      `xml:"pullDependsOnPct,omitempty" json:"pullDependsOnPct"` // Completion percentage the depended-on folder must reach before we pull. Zero means fully in sync.
+	ExtensionRules        []ExtensionRuleConfiguration `xml:"extensionRule,omitempty" json:"extensionRules"`
+	Tags                  []TagConfiguration           `xml:"tag,omitempty" json:"tags"`
+	Namespace             string                       `xml:"namespace,attr,omitempty" json:"namespace"`
+	HTTPExportEnabled     bool                         `xml:"httpExportEnabled" json:"httpExportEnabled"`   // Serve folder contents read-only over the GUI listener
+	HTTPExportListings    bool                         `xml:"httpExportListings" json:"httpExportListings"` // Allow directory listings in the HTTP export
 
 	cachedPath string
 
@@ -62,6 +83,14 @@ func (f FolderConfiguration) Copy() FolderConfiguration {
 	c.Devices = make([]FolderDeviceConfiguration, len(f.Devices))
 	copy(c.Devices, f.Devices)
 	c.Versioning = f.Versioning.Copy()
+	if f.ExtensionRules != nil {
+		c.ExtensionRules = make([]ExtensionRuleConfiguration, len(f.ExtensionRules))
+		copy(c.ExtensionRules, f.ExtensionRules)
+	}
+	if f.Tags != nil {
+		c.Tags = make([]TagConfiguration, len(f.Tags))
+		copy(c.Tags, f.Tags)
+	}
 	return c
 }
 
@@ -98,6 +127,17 @@ func (f *FolderConfiguration) HasMarker() bool {
 	return true
 }
 
+// ExtensionRule returns the first extension rule matching the given file
+// name, if any.
+func (f *FolderConfiguration) ExtensionRule(name string) (ExtensionRuleConfiguration, bool) {
+	for _, rule := range f.ExtensionRules {
+		if rule.Matches(name) {
+			return rule, true
+		}
+	}
+	return ExtensionRuleConfiguration{}, false
+}
+
 func (f *FolderConfiguration) DeviceIDs() []protocol.DeviceID {
 	deviceIDs := make([]protocol.DeviceID, len(f.Devices))
 	for i, n := range f.Devices {
@@ -173,6 +213,27 @@ func (f *FolderConfiguration) cleanedPath() string {
 	return cleaned
 }
 
+// FolderPathsOverlap returns true when the two paths are the same or one
+// nests within the other, comparing the paths lexically. Overlap by way
+// of symlinks is not detected here; the model resolves symlinks before
+// calling us when it checks folder health.
+func FolderPathsOverlap(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+
+	sep := string(filepath.Separator)
+	a = filepath.Clean(a)
+	b = filepath.Clean(b)
+	if !strings.HasSuffix(a, sep) {
+		a += sep
+	}
+	if !strings.HasSuffix(b, sep) {
+		b += sep
+	}
+	return strings.HasPrefix(a, b) || strings.HasPrefix(b, a)
+}
+
 type FolderDeviceConfigurationList []FolderDeviceConfiguration
 
 func (l FolderDeviceConfigurationList) Less(a, b int) bool {
@@ -0,0 +1,31 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package config
+
+// FolderTokenConfiguration describes an API token restricted to a set of
+// folders. Such a token grants access to a small set of folder scoped
+// endpoints only, so a third party app can browse, scan and retrieve data
+// from its own folders without the run of the whole instance.
+type FolderTokenConfiguration struct {
+	Name    string   `xml:"name,attr" json:"name"`
+	Token   string   `xml:"token,omitempty" json:"token"`
+	Folders []string `xml:"folder,omitempty" json:"folders"`
+}
+
+// FoldersForToken returns the IDs of the folders the given token grants
+// access to, if the token is known.
+func FoldersForToken(tokens []FolderTokenConfiguration, token string) ([]string, bool) {
+	if token == "" {
+		return nil, false
+	}
+	for _, tc := range tokens {
+		if tc.Token != "" && tc.Token == token {
+			return tc.Folders, true
+		}
+	}
+	return nil, false
+}
@@ -22,9 +22,29 @@ type GUIConfiguration struct {
 	InsecureAdminAccess   bool   `xml:"insecureAdminAccess,omitempty" json:"insecureAdminAccess"`
 	Theme                 string `xml:"theme" json:"theme" default:"default"`
 	Debugging             bool   `xml:"debugging,attr" json:"debugging"`
+	APIRateLimit          int    `xml:"apiRateLimit,omitempty" json:"apiRateLimit"` // max REST requests per second and client; 0 means no limit
 	InsecureSkipHostCheck bool   `xml:"insecureSkipHostcheck,omitempty" json:"insecureSkipHostcheck"`
+	CertFile              string `xml:"certFile,omitempty" json:"certFile"`
+	KeyFile               string `xml:"keyFile,omitempty" json:"keyFile"`
+	ACMEChallengeDir      string `xml:"acmeChallengeDir,omitempty" json:"acmeChallengeDir"`
+	URLBase               string `xml:"urlBase,omitempty" json:"urlBase"`                     // serve the GUI and API under this path prefix, e.g. "/syncthing"
+	UseProxyHeaders       bool   `xml:"useProxyHeaders,omitempty" json:"useProxyHeaders"`     // trust X-Forwarded-For and X-Forwarded-Proto from a reverse proxy in front of us
+	AuthMode              string `xml:"authMode,omitempty" json:"authMode"`                   // "static" (default), "ldap" or "oidc"
+	LDAPAddress           string `xml:"ldapAddress,omitempty" json:"ldapAddress"`             // host:port of the LDAP server
+	LDAPTransport         string `xml:"ldapTransport,omitempty" json:"ldapTransport"`         // "plain" (default) or "tls"
+	LDAPBindDN            string `xml:"ldapBindDN,omitempty" json:"ldapBindDN"`               // bind DN template, "%s" is replaced by the username
+	OIDCTokenEndpoint     string `xml:"oidcTokenEndpoint,omitempty" json:"oidcTokenEndpoint"` // token endpoint URL of the OpenID Connect provider
+	OIDCClientID          string `xml:"oidcClientID,omitempty" json:"oidcClientID"`
+	OIDCClientSecret      string `xml:"oidcClientSecret,omitempty" json:"oidcClientSecret"`
 }
 
+// The allowed values for the GUI AuthMode setting.
+const (
+	AuthModeStatic = "static"
+	AuthModeLDAP   = "ldap"
+	AuthModeOIDC   = "oidc"
+)
+
 func (c GUIConfiguration) Address() string {
 	if override := os.Getenv("STGUIADDRESS"); override != "" {
 		// This value may be of the form "scheme://address:port" or just
@@ -53,11 +73,22 @@ func (c GUIConfiguration) UseTLS() bool {
 	return c.RawUseTLS
 }
 
+// BasePath returns the configured URL base as a clean path prefix: a
+// leading slash is added and a trailing slash removed as needed. An empty
+// string means the GUI is served from the root.
+func (c GUIConfiguration) BasePath() string {
+	base := strings.Trim(c.URLBase, "/")
+	if base == "" {
+		return ""
+	}
+	return "/" + base
+}
+
 func (c GUIConfiguration) URL() string {
 	u := url.URL{
 		Scheme: "http",
 		Host:   c.Address(),
-		Path:   "/",
+		Path:   c.BasePath() + "/",
 	}
 
 	if c.UseTLS() {
@@ -78,6 +109,18 @@ func (c GUIConfiguration) URL() string {
 	return u.String()
 }
 
+// IsAuthEnabled returns true when the GUI requires authentication, either
+// against the static user and password in the configuration or against an
+// external provider.
+func (c GUIConfiguration) IsAuthEnabled() bool {
+	switch c.AuthMode {
+	case AuthModeLDAP, AuthModeOIDC:
+		return true
+	default:
+		return c.User != "" && c.Password != ""
+	}
+}
+
 // IsValidAPIKey returns true when the given API key is valid, including both
 // the value in config and any overrides
 func (c GUIConfiguration) IsValidAPIKey(apiKey string) bool {
@@ -0,0 +1,30 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package config
+
+// NamespaceConfiguration describes a named partition of the configuration.
+// Folders and devices can be assigned to a namespace, and the namespace API
+// key then grants access to those resources only, so separate teams can
+// administer their shares on a common instance without seeing each other's.
+type NamespaceConfiguration struct {
+	Name   string `xml:"name,attr" json:"name"`
+	APIKey string `xml:"apikey,omitempty" json:"apiKey"`
+}
+
+// NamespaceForAPIKey returns the name of the namespace the given API key
+// belongs to, if any.
+func NamespaceForAPIKey(namespaces []NamespaceConfiguration, apiKey string) (string, bool) {
+	if apiKey == "" {
+		return "", false
+	}
+	for _, ns := range namespaces {
+		if ns.APIKey != "" && ns.APIKey == apiKey {
+			return ns.Name, true
+		}
+	}
+	return "", false
+}
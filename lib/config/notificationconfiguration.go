@@ -0,0 +1,26 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package config
+
+// A NotificationConfiguration routes alerts (folder errors, devices
+// offline too long, low disk space) to an external transport.
+type NotificationConfiguration struct {
+	Name     string   `xml:"name,attr" json:"name"`
+	Type     string   `xml:"type,attr" json:"type"`            // "smtp", "xmpp", "matrix" or "webhook"
+	Address  string   `xml:"address,omitempty" json:"address"` // server host:port, or URL for webhook/matrix
+	From     string   `xml:"from,omitempty" json:"from"`
+	To       []string `xml:"to,omitempty" json:"to"`
+	Token    string   `xml:"token,omitempty" json:"token"`       // access token or password, where the transport uses one
+	Template string   `xml:"template,omitempty" json:"template"` // text/template for the message body; empty for the default
+}
+
+func (c NotificationConfiguration) Copy() NotificationConfiguration {
+	n := c
+	n.To = make([]string, len(c.To))
+	copy(n.To, c.To)
+	return n
+}
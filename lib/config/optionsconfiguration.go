@@ -37,10 +37,25 @@ type OptionsConfiguration struct {
 	LimitBandwidthInLan     bool     `xml:"limitBandwidthInLan" json:"limitBandwidthInLan" default:"false"`
 	MinHomeDiskFreePct      float64  `xml:"minHomeDiskFreePct" json:"minHomeDiskFreePct" default:"1"`
 	ReleasesURL             string   `xml:"releasesURL" json:"releasesURL" default:"https://upgrades.syncthing.net/meta.json"`
+	UpgradeCABundle         string   `xml:"upgradeCABundle,omitempty" json:"upgradeCABundle"` // verify the release server against these CAs, for internal mirrors behind TLS intercepting proxies
 	AlwaysLocalNets         []string `xml:"alwaysLocalNet" json:"alwaysLocalNets"`
 	OverwriteRemoteDevNames bool     `xml:"overwriteRemoteDeviceNamesOnConnect" json:"overwriteRemoteDeviceNamesOnConnect" default:"false"`
 	TempIndexMinBlocks      int      `xml:"tempIndexMinBlocks" json:"tempIndexMinBlocks" default:"10"`
 	UnackedNotificationIDs  []string `xml:"unackedNotificationID" json:"unackedNotificationIDs"`
+	BlockStashDir           string   `xml:"blockStashDir,omitempty" json:"blockStashDir"` // Content addressable store of pulled blocks; empty to disable
+	StatusReportEnabled     bool     `xml:"statusReportEnabled" json:"statusReportEnabled" default:"false"`
+	StatusReportIntervalH   int      `xml:"statusReportIntervalH" json:"statusReportIntervalH" default:"24"`
+	StatusReportWebhookURL  string   `xml:"statusReportWebhookUrl,omitempty" json:"statusReportWebhookUrl"`
+	StatusReportSMTPServer  string   `xml:"statusReportSmtpServer,omitempty" json:"statusReportSmtpServer"` // host:port
+	StatusReportSMTPFrom    string   `xml:"statusReportSmtpFrom,omitempty" json:"statusReportSmtpFrom"`
+	StatusReportSMTPTo      []string `xml:"statusReportSmtpTo,omitempty" json:"statusReportSmtpTo"`
+	AlertOfflineHours       int      `xml:"alertOfflineHours" json:"alertOfflineHours" default:"24"`       // Notify when a device has been offline this long; 0 for off
+	MeteredConnection       bool     `xml:"meteredConnection" json:"meteredConnection"`                    // Treat the connection as metered, where OS detection is unavailable or wrong
+	CongestionAvoidance     bool     `xml:"congestionAvoidance" json:"congestionAvoidance" default:"true"` // Back off serving blocks when the uplink looks saturated
+	DBBatchFlushRecords     int      `xml:"dbBatchFlushRecords" json:"dbBatchFlushRecords"`                // Flush database batches after this many records; 0 for the built-in default
+	DBMaxBatchSizeKiB       int      `xml:"dbMaxBatchSizeKiB" json:"dbMaxBatchSizeKiB"`                    // Flush large database batches when they exceed this size; 0 for the built-in default
+	DBSlowTransactionMs     int      `xml:"dbSlowTransactionMs" json:"dbSlowTransactionMs"`                // Log details of database transactions taking longer than this; 0 disables
+	DBFileCacheEntries      int      `xml:"dbFileCacheEntries" json:"dbFileCacheEntries"`                  // Cache this many recently used file records in memory; 0 disables the cache
 
 	DeprecatedUPnPEnabled  bool     `xml:"upnpEnabled,omitempty" json:"-"`
 	DeprecatedUPnPLeaseM   int      `xml:"upnpLeaseMinutes,omitempty" json:"-"`
@@ -59,5 +74,7 @@ func (orig OptionsConfiguration) Copy() OptionsConfiguration {
 	copy(c.AlwaysLocalNets, orig.AlwaysLocalNets)
 	c.UnackedNotificationIDs = make([]string, len(orig.UnackedNotificationIDs))
 	copy(c.UnackedNotificationIDs, orig.UnackedNotificationIDs)
+	c.StatusReportSMTPTo = make([]string, len(orig.StatusReportSMTPTo))
+	copy(c.StatusReportSMTPTo, orig.StatusReportSMTPTo)
 	return c
 }
@@ -0,0 +1,43 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package config
+
+import "strings"
+
+// TagConfiguration is a free form key/value pair attached to a device or
+// folder. Tags carry no meaning for syncthing itself but let admins group
+// resources by site, tenant or purpose and filter on them through the API.
+type TagConfiguration struct {
+	Key   string `xml:"key,attr" json:"key"`
+	Value string `xml:"value,attr" json:"value"`
+}
+
+// MatchTags returns true when the given tags satisfy the filter. The filter
+// is a comma separated list of requirements, each either a bare key that
+// must be present or a key=value pair that must match exactly. All
+// requirements must be met. An empty filter matches anything.
+func MatchTags(tags []TagConfiguration, filter string) bool {
+	for _, req := range strings.Split(filter, ",") {
+		req = strings.TrimSpace(req)
+		if req == "" {
+			continue
+		}
+
+		kv := strings.SplitN(req, "=", 2)
+		found := false
+		for _, tag := range tags {
+			if tag.Key == kv[0] && (len(kv) == 1 || tag.Value == kv[1]) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,132 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package config
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// A ValidationError describes an invalid element or attribute in the
+// configuration XML, with the line on which it occurs where that can be
+// determined.
+type ValidationError struct {
+	Line      int // 1-based, zero when unknown
+	Element   string
+	Attribute string // empty when the element itself is at fault
+	Err       error
+}
+
+func (e *ValidationError) Error() string {
+	msg := fmt.Sprintf("element %q", e.Element)
+	if e.Attribute != "" {
+		msg += fmt.Sprintf(", attribute %q", e.Attribute)
+	}
+	if e.Line > 0 {
+		msg = fmt.Sprintf("line %d: %s", e.Line, msg)
+	}
+	return fmt.Sprintf("%s: %v", msg, e.Err)
+}
+
+// numericFolderAttrs are folder element attributes that must parse as
+// integers.
+var numericFolderAttrs = map[string]bool{
+	"rescanIntervalS": true,
+	"copiers":         true,
+	"pullers":         true,
+	"hashers":         true,
+}
+
+// ValidateXML inspects the raw configuration XML and returns the validation
+// errors found, pinpointing the offending element and attribute. It checks
+// for well-formedness and for common value errors -- unparseable device
+// IDs, duplicate or empty folder IDs, non-numeric numeric attributes --
+// giving friendlier errors than the XML decoder itself.
+func ValidateXML(bs []byte) []*ValidationError {
+	var errs []*ValidationError
+
+	seenFolders := make(map[string]bool)
+	d := xml.NewDecoder(bytes.NewReader(bs))
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if syntaxErr, ok := err.(*xml.SyntaxError); ok {
+				errs = append(errs, &ValidationError{
+					Line:    syntaxErr.Line,
+					Element: "configuration",
+					Err:     err,
+				})
+			}
+			break
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		for _, attr := range start.Attr {
+			switch {
+			case start.Name.Local == "device" && attr.Name.Local == "id":
+				if _, err := protocol.DeviceIDFromString(attr.Value); err != nil {
+					errs = append(errs, &ValidationError{
+						Line:      lineOf(bs, attr.Value),
+						Element:   start.Name.Local,
+						Attribute: attr.Name.Local,
+						Err:       err,
+					})
+				}
+
+			case start.Name.Local == "folder" && attr.Name.Local == "id":
+				if attr.Value == "" {
+					errs = append(errs, &ValidationError{
+						Element:   start.Name.Local,
+						Attribute: attr.Name.Local,
+						Err:       fmt.Errorf("empty folder ID"),
+					})
+				} else if seenFolders[attr.Value] {
+					errs = append(errs, &ValidationError{
+						Line:      lineOf(bs, attr.Value),
+						Element:   start.Name.Local,
+						Attribute: attr.Name.Local,
+						Err:       fmt.Errorf("duplicate folder ID %q", attr.Value),
+					})
+				}
+				seenFolders[attr.Value] = true
+
+			case start.Name.Local == "folder" && numericFolderAttrs[attr.Name.Local]:
+				if _, err := strconv.Atoi(attr.Value); err != nil {
+					errs = append(errs, &ValidationError{
+						Line:      lineOf(bs, attr.Value),
+						Element:   start.Name.Local,
+						Attribute: attr.Name.Local,
+						Err:       fmt.Errorf("not a number: %q", attr.Value),
+					})
+				}
+			}
+		}
+	}
+
+	return errs
+}
+
+// lineOf returns the 1-based line on which the given value first occurs, or
+// zero if it cannot be located.
+func lineOf(bs []byte, value string) int {
+	if value == "" {
+		return 0
+	}
+	idx := bytes.Index(bs, []byte(value))
+	if idx < 0 {
+		return 0
+	}
+	return bytes.Count(bs[:idx], []byte("\n")) + 1
+}
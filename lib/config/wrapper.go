@@ -266,6 +266,15 @@ func (w *Wrapper) GUI() GUIConfiguration {
 	return w.cfg.GUI
 }
 
+// ExtraGUIs returns the configuration for any additional GUI listeners.
+func (w *Wrapper) ExtraGUIs() []GUIConfiguration {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	extras := make([]GUIConfiguration, len(w.cfg.ExtraGUIs))
+	copy(extras, w.cfg.ExtraGUIs)
+	return extras
+}
+
 // SetGUI replaces the current GUI configuration object.
 func (w *Wrapper) SetGUI(gui GUIConfiguration) error {
 	w.mut.Lock()
@@ -302,6 +311,10 @@ func (w *Wrapper) Device(id protocol.DeviceID) (DeviceConfiguration, bool) {
 
 // Save writes the configuration to disk, and generates a ConfigSaved event.
 func (w *Wrapper) Save() error {
+	if err := w.archiveBackup(); err != nil {
+		l.Debugln("archiveBackup:", err)
+	}
+
 	fd, err := osutil.CreateAtomic(w.path, 0600)
 	if err != nil {
 		l.Debugln("CreateAtomic:", err)
@@ -156,6 +156,19 @@ func (t *relayListener) Error() error {
 	return cerr
 }
 
+// RelayStatus returns whether the connection to the relay is healthy,
+// and the measured latency to it.
+func (t *relayListener) RelayStatus() (ok bool, latency time.Duration) {
+	t.mut.RLock()
+	client := t.client
+	t.mut.RUnlock()
+
+	if client == nil {
+		return false, 0
+	}
+	return client.Error() == nil, client.Latency()
+}
+
 func (t *relayListener) Factory() listenerFactory {
 	return t.factory
 }
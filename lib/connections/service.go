@@ -13,6 +13,7 @@ import (
 	"io"
 	"net"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/juju/ratelimit"
@@ -191,6 +192,14 @@ next:
 			continue
 		}
 
+		// Relay connections cost someone money or bandwidth; refuse them
+		// when the device's monthly relay budget is spent.
+		if strings.HasPrefix(c.Type, "Relay") && s.model.DeviceRelayBudgetExceeded(remoteID) {
+			l.Infof("Connection from %s at %s (%s) rejected: monthly relay budget spent", remoteID, c.RemoteAddr(), c.Type)
+			c.Close()
+			continue
+		}
+
 		// If we have a relay connection, and the new incoming connection is
 		// not a relay connection, we should drop that, and prefer the this one.
 		connected := s.model.ConnectedTo(remoteID)
@@ -238,7 +247,8 @@ next:
 		// If rate limiting is set, and based on the address we should
 		// limit the connection, then we wrap it in a limiter.
 
-		limit := s.shouldLimit(c.RemoteAddr())
+		isLAN := s.isLAN(c.RemoteAddr())
+		limit := s.cfg.Options().LimitBandwidthInLan || !isLAN
 
 		wr := io.Writer(c)
 		if limit && s.writeRateLimit != nil {
@@ -251,11 +261,12 @@ next:
 		}
 
 		name := fmt.Sprintf("%s-%s (%s)", c.LocalAddr(), c.RemoteAddr(), c.Type)
-		protoConn := protocol.NewConnection(remoteID, rd, wr, s.model, name, deviceCfg.Compression)
-		modelConn := Connection{c, protoConn}
+		protoConn := protocol.NewConnection(remoteID, rd, wr, s.model, name, deviceCfg.Compression,
+			time.Duration(deviceCfg.PingIntervalS)*time.Second, time.Duration(deviceCfg.ReceiveTimeoutS)*time.Second)
+		modelConn := Connection{c, protoConn, isLAN}
 
 		l.Infof("Established secure connection to %s at %s", remoteID, name)
-		l.Debugf("cipher suite: %04X in lan: %t", c.ConnectionState().CipherSuite, !limit)
+		l.Debugf("cipher suite: %04X in lan: %t", c.ConnectionState().CipherSuite, isLAN)
 
 		s.model.AddConnection(modelConn, hello)
 		s.curConMut.Lock()
@@ -364,6 +375,11 @@ func (s *Service) connect() {
 					continue
 				}
 
+				if uri.Scheme == "relay" && s.model.DeviceRelayBudgetExceeded(deviceID) {
+					l.Debugln("Not dialing", deviceID, "via relay as its monthly relay budget is spent")
+					continue
+				}
+
 				dialer := dialerFactory.New(s.cfg, s.tlsCfg)
 				l.Debugln("dial", deviceCfg.DeviceID, uri)
 				nextDial[addr] = now.Add(dialer.RedialFrequency())
@@ -392,21 +408,17 @@ func (s *Service) connect() {
 	}
 }
 
-func (s *Service) shouldLimit(addr net.Addr) bool {
-	if s.cfg.Options().LimitBandwidthInLan {
-		return true
-	}
-
+func (s *Service) isLAN(addr net.Addr) bool {
 	tcpaddr, ok := addr.(*net.TCPAddr)
 	if !ok {
-		return true
+		return false
 	}
 	for _, lan := range s.lans {
 		if lan.Contains(tcpaddr.IP) {
-			return false
+			return true
 		}
 	}
-	return !tcpaddr.IP.IsLoopback()
+	return tcpaddr.IP.IsLoopback()
 }
 
 func (s *Service) createListener(factory listenerFactory, uri *url.URL) bool {
@@ -542,6 +554,16 @@ func (s *Service) Status() map[string]interface{} {
 		status["lanAddresses"] = urlsToStrings(listener.LANAddresses())
 		status["wanAddresses"] = urlsToStrings(listener.WANAddresses())
 
+		// Relay listeners additionally know the health of and latency to
+		// the relay they are connected to.
+		if rl, ok := listener.(interface {
+			RelayStatus() (bool, time.Duration)
+		}); ok {
+			up, latency := rl.RelayStatus()
+			status["ok"] = up
+			status["latencyMs"] = latency.Nanoseconds() / 1e6
+		}
+
 		result[addr] = status
 	}
 	s.listenersMut.RUnlock()
@@ -27,6 +27,9 @@ type IntermediateConnection struct {
 type Connection struct {
 	IntermediateConnection
 	protocol.Connection
+	// IsLAN is true when the remote address is on one of our local
+	// networks.
+	IsLAN bool
 }
 
 func (c Connection) String() string {
@@ -74,6 +77,7 @@ type Model interface {
 	AddConnection(conn Connection, hello protocol.HelloResult)
 	ConnectedTo(remoteID protocol.DeviceID) bool
 	IsPaused(remoteID protocol.DeviceID) bool
+	DeviceRelayBudgetExceeded(remoteID protocol.DeviceID) bool
 	OnHello(protocol.DeviceID, net.Addr, protocol.HelloResult) error
 	GetHello(protocol.DeviceID) protocol.HelloIntf
 }
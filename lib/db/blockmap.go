@@ -7,9 +7,14 @@
 package db
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
+	"sort"
+	"sync"
+	"time"
 
+	"github.com/syncthing/syncthing/lib/events"
 	"github.com/syncthing/syncthing/lib/osutil"
 	"github.com/syncthing/syncthing/lib/protocol"
 
@@ -19,7 +24,24 @@ import (
 
 var blockFinder *BlockFinder
 
-const maxBatchSize = 256 << 10
+// Flush block map batches when they exceed this many bytes, unless
+// overridden on the Instance.
+const defaultMaxBatchSize = 256 << 10
+
+// batchPool holds leveldb write batches for reuse, as the block map
+// rewrites its entries on every index update.
+var batchPool = sync.Pool{
+	New: func() interface{} { return new(leveldb.Batch) },
+}
+
+func getBatch() *leveldb.Batch {
+	return batchPool.Get().(*leveldb.Batch)
+}
+
+func putBatch(batch *leveldb.Batch) {
+	batch.Reset()
+	batchPool.Put(batch)
+}
 
 type BlockMap struct {
 	db     *Instance
@@ -35,11 +57,12 @@ func NewBlockMap(db *Instance, folder uint32) *BlockMap {
 
 // Add files to the block map, ignoring any deleted or invalid files.
 func (m *BlockMap) Add(files []protocol.FileInfo) error {
-	batch := new(leveldb.Batch)
+	batch := getBatch()
+	defer putBatch(batch)
 	buf := make([]byte, 4)
 	var key []byte
 	for _, file := range files {
-		if batch.Len() > maxBatchSize {
+		if batch.Len() > m.db.batchMaxSize() {
 			if err := m.db.Write(batch, nil); err != nil {
 				return err
 			}
@@ -61,11 +84,12 @@ func (m *BlockMap) Add(files []protocol.FileInfo) error {
 
 // Update block map state, removing any deleted or invalid files.
 func (m *BlockMap) Update(files []protocol.FileInfo) error {
-	batch := new(leveldb.Batch)
+	batch := getBatch()
+	defer putBatch(batch)
 	buf := make([]byte, 4)
 	var key []byte
 	for _, file := range files {
-		if batch.Len() > maxBatchSize {
+		if batch.Len() > m.db.batchMaxSize() {
 			if err := m.db.Write(batch, nil); err != nil {
 				return err
 			}
@@ -93,12 +117,59 @@ func (m *BlockMap) Update(files []protocol.FileInfo) error {
 	return m.db.Write(batch, nil)
 }
 
+// batchWriter is the subset of batch operations needed to queue block map
+// changes, implemented by both leveldb batches and write transactions.
+type batchWriter interface {
+	Put(key, value []byte)
+	Delete(key []byte)
+}
+
+// updateBatch queues the block map operations for Update on the given
+// batch, without size limits or intermediate writes, for a caller that
+// commits the batch itself.
+func (m *BlockMap) updateBatch(w batchWriter, files []protocol.FileInfo) {
+	buf := make([]byte, 4)
+	var key []byte
+	for _, file := range files {
+		if file.IsDirectory() {
+			continue
+		}
+
+		if file.IsDeleted() || file.IsInvalid() {
+			for _, block := range file.Blocks {
+				key = m.blockKeyInto(key, block.Hash, file.Name)
+				w.Delete(key)
+			}
+			continue
+		}
+
+		for i, block := range file.Blocks {
+			binary.BigEndian.PutUint32(buf, uint32(i))
+			key = m.blockKeyInto(key, block.Hash, file.Name)
+			w.Put(key, buf)
+		}
+	}
+}
+
+// discardBatch queues the block map operations for Discard on the given
+// batch, like updateBatch.
+func (m *BlockMap) discardBatch(w batchWriter, files []protocol.FileInfo) {
+	var key []byte
+	for _, file := range files {
+		for _, block := range file.Blocks {
+			key = m.blockKeyInto(key, block.Hash, file.Name)
+			w.Delete(key)
+		}
+	}
+}
+
 // Discard block map state, removing the given files
 func (m *BlockMap) Discard(files []protocol.FileInfo) error {
-	batch := new(leveldb.Batch)
+	batch := getBatch()
+	defer putBatch(batch)
 	var key []byte
 	for _, file := range files {
-		if batch.Len() > maxBatchSize {
+		if batch.Len() > m.db.batchMaxSize() {
 			if err := m.db.Write(batch, nil); err != nil {
 				return err
 			}
@@ -115,11 +186,12 @@ func (m *BlockMap) Discard(files []protocol.FileInfo) error {
 
 // Drop block map, removing all entries related to this block map from the db.
 func (m *BlockMap) Drop() error {
-	batch := new(leveldb.Batch)
+	batch := getBatch()
+	defer putBatch(batch)
 	iter := m.db.NewIterator(util.BytesPrefix(m.blockKeyInto(nil, nil, "")[:keyPrefixLen+keyFolderLen]), nil)
 	defer iter.Release()
 	for iter.Next() {
-		if batch.Len() > maxBatchSize {
+		if batch.Len() > m.db.batchMaxSize() {
 			if err := m.db.Write(batch, nil); err != nil {
 				return err
 			}
@@ -158,30 +230,156 @@ func (f *BlockFinder) String() string {
 	return fmt.Sprintf("BlockFinder@%p", f)
 }
 
+// A BlockScore ranks a candidate block location; candidates with higher
+// scores are offered to the iterator function first.
+type BlockScore func(folder, file string) int
+
 // Iterate takes an iterator function which iterates over all matching blocks
 // for the given hash. The iterator function has to return either true (if
 // they are happy with the block) or false to continue iterating for whatever
 // reason. The iterator finally returns the result, whether or not a
 // satisfying block was eventually found.
 func (f *BlockFinder) Iterate(folders []string, hash []byte, iterFn func(string, string, int32) bool) bool {
+	return f.IterateRanked(folders, hash, nil, iterFn)
+}
+
+// IterateRanked is like Iterate, but calls the iterator function in the
+// order given by the score function, highest score first, instead of
+// arbitrary iterator order. Candidates with equal scores keep their
+// relative order. A nil score function keeps the unranked order.
+func (f *BlockFinder) IterateRanked(folders []string, hash []byte, score BlockScore, iterFn func(string, string, int32) bool) bool {
+	var candidates rankedCandidateList
+
 	var key []byte
 	for _, folder := range folders {
 		folderID := f.db.folderIdx.ID([]byte(folder))
 		key = blockKeyInto(key, hash, folderID, "")
 		iter := f.db.NewIterator(util.BytesPrefix(key), nil)
-		defer iter.Release()
 
 		for iter.Next() && iter.Error() == nil {
-			file := blockKeyName(iter.Key())
-			index := int32(binary.BigEndian.Uint32(iter.Value()))
-			if iterFn(folder, osutil.NativeFilename(file), index) {
-				return true
+			c := rankedCandidate{
+				folder: folder,
+				file:   osutil.NativeFilename(blockKeyName(iter.Key())),
+				index:  int32(binary.BigEndian.Uint32(iter.Value())),
 			}
+			if score != nil {
+				c.score = score(c.folder, c.file)
+			}
+			candidates = append(candidates, c)
+		}
+		iter.Release()
+	}
+
+	if score != nil {
+		sort.Stable(candidates)
+	}
+
+	for _, c := range candidates {
+		if iterFn(c.folder, c.file, c.index) {
+			return true
 		}
 	}
 	return false
 }
 
+// IterateMany resolves many block hashes against the block map in a
+// single iterator pass per folder, instead of the iterator setup per hash
+// that repeated calls to Iterate would cost. The iterator function
+// receives the index of the hash in the input slice along with the
+// candidate location, ranked like in IterateRanked; returning true stops
+// further candidates for that hash. IterateMany returns the number of
+// hashes for which the iterator function returned true.
+func (f *BlockFinder) IterateMany(folders []string, hashes [][]byte, score BlockScore, iterFn func(i int, folder, file string, index int32) bool) int {
+	// Visit the hashes in sorted order, so that each folder's iterator
+	// only moves forward.
+	order := make([]int, len(hashes))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Sort(&hashOrder{order, hashes})
+
+	candidates := make([]rankedCandidateList, len(hashes))
+
+	var key []byte
+	for _, folder := range folders {
+		folderID := f.db.folderIdx.ID([]byte(folder))
+		key = blockKeyInto(key, nil, folderID, "")
+		iter := f.db.NewIterator(util.BytesPrefix(key[:keyPrefixLen+keyFolderLen]), nil)
+
+		for _, i := range order {
+			key = blockKeyInto(key, hashes[i], folderID, "")
+			for ok := iter.Seek(key); ok && iter.Error() == nil; ok = iter.Next() {
+				if !bytes.HasPrefix(iter.Key(), key) {
+					break
+				}
+				c := rankedCandidate{
+					folder: folder,
+					file:   osutil.NativeFilename(blockKeyName(iter.Key())),
+					index:  int32(binary.BigEndian.Uint32(iter.Value())),
+				}
+				if score != nil {
+					c.score = score(c.folder, c.file)
+				}
+				candidates[i] = append(candidates[i], c)
+			}
+		}
+		iter.Release()
+	}
+
+	found := 0
+	for i := range candidates {
+		if score != nil {
+			sort.Stable(candidates[i])
+		}
+		for _, c := range candidates[i] {
+			if iterFn(i, c.folder, c.file, c.index) {
+				found++
+				break
+			}
+		}
+	}
+	return found
+}
+
+// hashOrder sorts a list of indices into a list of hashes by hash.
+type hashOrder struct {
+	order  []int
+	hashes [][]byte
+}
+
+func (h *hashOrder) Len() int {
+	return len(h.order)
+}
+
+func (h *hashOrder) Swap(a, b int) {
+	h.order[a], h.order[b] = h.order[b], h.order[a]
+}
+
+func (h *hashOrder) Less(a, b int) bool {
+	return bytes.Compare(h.hashes[h.order[a]], h.hashes[h.order[b]]) < 0
+}
+
+type rankedCandidate struct {
+	folder string
+	file   string
+	index  int32
+	score  int
+}
+
+type rankedCandidateList []rankedCandidate
+
+func (l rankedCandidateList) Len() int {
+	return len(l)
+}
+
+func (l rankedCandidateList) Swap(a, b int) {
+	l[a], l[b] = l[b], l[a]
+}
+
+func (l rankedCandidateList) Less(a, b int) bool {
+	return l[a].score > l[b].score
+}
+
 // Fix repairs incorrect blockmap entries, removing the old entry and
 // replacing it with a new entry for the given block
 func (f *BlockFinder) Fix(folder, file string, index int32, oldHash, newHash []byte) error {
@@ -196,10 +394,11 @@ func (f *BlockFinder) Fix(folder, file string, index int32, oldHash, newHash []b
 }
 
 // m.blockKey returns a byte slice encoding the following information:
-//	   keyTypeBlock (1 byte)
-//	   folder (4 bytes)
-//	   block hash (32 bytes)
-//	   file name (variable size)
+//
+//	keyTypeBlock (1 byte)
+//	folder (4 bytes)
+//	block hash (32 bytes)
+//	file name (variable size)
 func blockKeyInto(o, hash []byte, folder uint32, file string) []byte {
 	reqLen := keyPrefixLen + keyFolderLen + keyHashLen + len(file)
 	if cap(o) < reqLen {
@@ -226,3 +425,137 @@ func blockKeyName(data []byte) string {
 	file := string(data[keyPrefixLen+keyFolderLen+keyHashLen:])
 	return file
 }
+
+// Parameters for the block map garbage collector: how many keys are
+// examined per batch, the pause between batches, and how often a full
+// sweep is started.
+const (
+	blockGCBatchSize  = 50000
+	blockGCBatchPause = time.Second
+	blockGCInterval   = 24 * time.Hour
+)
+
+// A BlockMapGC periodically sweeps the block map and removes entries
+// referring to nonexistent, deleted or invalid local files. Such entries
+// can leak when files are renamed or folders are removed abnormally. The
+// sweep works in bounded batches with pauses in between, so it never holds
+// an iterator over the whole key space, and reports progress through the
+// events system. It implements the suture.Service interface.
+type BlockMapGC struct {
+	db   *Instance
+	stop chan struct{}
+}
+
+func NewBlockMapGC(db *Instance) *BlockMapGC {
+	return &BlockMapGC{
+		db:   db,
+		stop: make(chan struct{}),
+	}
+}
+
+func (gc *BlockMapGC) Serve() {
+	timer := time.NewTimer(blockGCInterval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			gc.sweep()
+			timer.Reset(blockGCInterval)
+		case <-gc.stop:
+			return
+		}
+	}
+}
+
+func (gc *BlockMapGC) Stop() {
+	close(gc.stop)
+}
+
+// sweep walks the entire block map in batches and deletes stale entries.
+func (gc *BlockMapGC) sweep() {
+	l.Debugln("Starting block map garbage collection")
+	var scanned, deleted int
+
+	after := []byte{KeyTypeBlock}
+	for {
+		n, d, next := gc.batch(after)
+		scanned += n
+		deleted += d
+		events.Default.Log(events.BlockmapGCProgress, map[string]interface{}{
+			"scanned": scanned,
+			"deleted": deleted,
+			"done":    next == nil,
+		})
+		if next == nil {
+			break
+		}
+		// The immediate successor of the last examined key, so the next
+		// batch resumes where this one stopped.
+		after = append(next, 0)
+
+		select {
+		case <-time.After(blockGCBatchPause):
+		case <-gc.stop:
+			return
+		}
+	}
+
+	l.Debugf("Block map garbage collection examined %d entries, deleted %d", scanned, deleted)
+}
+
+// batch examines up to blockGCBatchSize block map entries starting at the
+// given key and deletes the stale ones. It returns the number of entries
+// examined and deleted, and the last examined key, or nil when the end of
+// the block map was reached.
+func (gc *BlockMapGC) batch(start []byte) (scanned, deleted int, last []byte) {
+	batch := getBatch()
+	defer putBatch(batch)
+
+	iter := gc.db.NewIterator(&util.Range{Start: start, Limit: []byte{KeyTypeBlock + 1}}, nil)
+	defer iter.Release()
+
+	var fk []byte
+	for iter.Next() {
+		key := iter.Key()
+		scanned++
+
+		stale := len(key) < keyPrefixLen+keyFolderLen+keyHashLen+1
+		if !stale {
+			folderID := binary.BigEndian.Uint32(key[keyPrefixLen:])
+			folder, ok := gc.db.folderIdx.Val(folderID)
+			stale = !ok
+			if !stale {
+				fk = gc.db.deviceKeyInto(fk[:cap(fk)], folder, protocol.LocalDeviceID[:], []byte(blockKeyName(key)))
+				bs, err := gc.db.Get(fk, nil)
+				switch {
+				case err == leveldb.ErrNotFound:
+					stale = true
+				case err != nil:
+					// Database trouble; give up on this sweep.
+					gc.db.fail(err)
+					return scanned, deleted, nil
+				default:
+					var f FileInfoTruncated
+					if err := f.Unmarshal(bs); err != nil || f.IsDeleted() || f.IsInvalid() {
+						stale = true
+					}
+				}
+			}
+		}
+
+		if stale {
+			deleted++
+			batch.Delete(key)
+		}
+
+		if scanned >= blockGCBatchSize {
+			last = append([]byte{}, key...)
+			break
+		}
+	}
+
+	if err := gc.db.Write(batch, nil); err != nil {
+		gc.db.fail(err)
+	}
+	return scanned, deleted, last
+}
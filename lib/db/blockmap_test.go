@@ -253,3 +253,121 @@ func TestBlockFinderFix(t *testing.T) {
 		t.Fatal("Block not found")
 	}
 }
+
+func TestBlockMapGC(t *testing.T) {
+	db, f := setup()
+	fs := NewFileSet("folder1", db)
+
+	myID := protocol.ShortID(1)
+	files := []protocol.FileInfo{
+		{Name: "f1", Version: protocol.Vector{Counters: []protocol.Counter{{ID: myID, Value: 1000}}}, Blocks: genBlocks(2)},
+		{Name: "f2", Version: protocol.Vector{Counters: []protocol.Counter{{ID: myID, Value: 1000}}}, Blocks: genBlocks(3)},
+	}
+	if err := fs.Update(protocol.LocalDeviceID, files); err != nil {
+		t.Fatal(err)
+	}
+
+	// Remove the device file entry for f2 behind the block map's back,
+	// leaving its block entries orphaned.
+	if err := db.Delete(db.deviceKey([]byte("folder1"), protocol.LocalDeviceID[:], []byte("f2")), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	gc := NewBlockMapGC(db)
+	gc.sweep()
+
+	if !f.Iterate([]string{"folder1"}, files[0].Blocks[0].Hash, func(folder, file string, index int32) bool {
+		return true
+	}) {
+		t.Error("block entry for existing file should survive GC")
+	}
+	if f.Iterate([]string{"folder1"}, files[1].Blocks[2].Hash, func(folder, file string, index int32) bool {
+		return true
+	}) {
+		t.Error("orphaned block entry should be removed by GC")
+	}
+}
+
+func TestBlockFinderRanked(t *testing.T) {
+	db, f := setup()
+
+	m1 := NewBlockMap(db, db.folderIdx.ID([]byte("folder1")))
+	m2 := NewBlockMap(db, db.folderIdx.ID([]byte("folder2")))
+
+	if err := m1.Add([]protocol.FileInfo{f1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m2.Add([]protocol.FileInfo{f1}); err != nil {
+		t.Fatal(err)
+	}
+
+	score := func(folder, file string) int {
+		if folder == "folder2" {
+			return 1
+		}
+		return 0
+	}
+
+	var got []string
+	f.IterateRanked(folders, f1.Blocks[0].Hash, score, func(folder, file string, index int32) bool {
+		got = append(got, folder)
+		return false
+	})
+	if len(got) != 2 || got[0] != "folder2" || got[1] != "folder1" {
+		t.Errorf("unexpected ranked order: %v", got)
+	}
+
+	// Without a score function the folder argument order is kept.
+	got = nil
+	f.IterateRanked(folders, f1.Blocks[0].Hash, nil, func(folder, file string, index int32) bool {
+		got = append(got, folder)
+		return false
+	})
+	if len(got) != 2 || got[0] != "folder1" || got[1] != "folder2" {
+		t.Errorf("unexpected unranked order: %v", got)
+	}
+}
+
+func TestBlockFinderMany(t *testing.T) {
+	db, f := setup()
+
+	m1 := NewBlockMap(db, db.folderIdx.ID([]byte("folder1")))
+	m2 := NewBlockMap(db, db.folderIdx.ID([]byte("folder2")))
+
+	if err := m1.Add([]protocol.FileInfo{f1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m2.Add([]protocol.FileInfo{f2}); err != nil {
+		t.Fatal(err)
+	}
+
+	unknown := make([]byte, 32)
+	for i := range unknown {
+		unknown[i] = 0xff
+	}
+	hashes := [][]byte{f2.Blocks[3].Hash, unknown, f1.Blocks[0].Hash}
+
+	type hit struct {
+		folder string
+		file   string
+		index  int32
+	}
+	hits := make(map[int]hit)
+	found := f.IterateMany(folders, hashes, nil, func(i int, folder, file string, index int32) bool {
+		hits[i] = hit{folder, file, index}
+		return true
+	})
+
+	if found != 2 {
+		t.Errorf("expected 2 resolved hashes, got %d", found)
+	}
+	if h := hits[0]; h != (hit{"folder2", "f2", 3}) {
+		t.Errorf("unexpected hit for f2 block: %+v", h)
+	}
+	if _, ok := hits[1]; ok {
+		t.Error("unknown hash should not resolve")
+	}
+	if h := hits[2]; h != (hit{"folder1", "f1", 0}) {
+		t.Errorf("unexpected hit for f1 block: %+v", h)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import (
+	"bytes"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// FolderBlockStats summarizes the block map for one folder. The
+// difference between Blocks and UniqueBlocks is the number of block
+// references that could be deduplicated within the folder.
+type FolderBlockStats struct {
+	Blocks       int64 `json:"blocks"`       // block references from files
+	UniqueBlocks int64 `json:"uniqueBlocks"` // distinct block hashes
+}
+
+// BlockStats summarizes the block map across all folders in the
+// database.
+type BlockStats struct {
+	Folders          map[string]FolderBlockStats `json:"folders"`
+	UniqueBlocks     int64                       `json:"uniqueBlocks"`     // distinct block hashes across all folders
+	SharedBlocks     int64                       `json:"sharedBlocks"`     // distinct block hashes present in more than one folder
+	PotentialSavings int64                       `json:"potentialSavings"` // bytes saved by full deduplication, assuming standard size blocks
+}
+
+// BlockStats scans the block map and reports the number of unique and
+// shared blocks per folder and across the database. Block keys sort on
+// hash within each folder, so duplicates within a folder are adjacent in
+// the scan, and cross folder sharing falls out of a merge of the per
+// folder hash sequences; nothing but iterator state is held in memory.
+// The potential savings figure assumes standard size blocks, as the block
+// map does not record block sizes.
+func (db *Instance) BlockStats() BlockStats {
+	res := BlockStats{Folders: make(map[string]FolderBlockStats)}
+
+	t := db.newReadOnlyTransaction()
+	defer t.close()
+
+	var totalRefs int64
+	var iters []iterator.Iterator
+	for _, folder := range db.ListFolders() {
+		prefix := blockKeyInto(nil, nil, db.folderIdx.ID([]byte(folder)), "")[:keyPrefixLen+keyFolderLen]
+
+		var stats FolderBlockStats
+		var prev []byte
+		dbi := t.NewIterator(util.BytesPrefix(prefix), nil)
+		for dbi.Next() {
+			hash := blockKeyHash(dbi.Key())
+			stats.Blocks++
+			if !bytes.Equal(hash, prev) {
+				stats.UniqueBlocks++
+				prev = append(prev[:0], hash...)
+			}
+		}
+		dbi.Release()
+
+		res.Folders[folder] = stats
+		totalRefs += stats.Blocks
+
+		// A second iterator over the same range takes part in the cross
+		// folder merge below.
+		dbi = t.NewIterator(util.BytesPrefix(prefix), nil)
+		if dbi.Next() {
+			iters = append(iters, dbi)
+		} else {
+			dbi.Release()
+		}
+	}
+
+	// Merge the per folder hash sequences. Each round handles the
+	// smallest hash any folder is positioned at, counting in how many
+	// folders it occurs and advancing those past it.
+	var min []byte
+	for len(iters) > 0 {
+		min = append(min[:0], blockKeyHash(iters[0].Key())...)
+		for _, dbi := range iters[1:] {
+			if hash := blockKeyHash(dbi.Key()); bytes.Compare(hash, min) < 0 {
+				min = append(min[:0], hash...)
+			}
+		}
+
+		res.UniqueBlocks++
+		inFolders := 0
+		live := iters[:0]
+		for _, dbi := range iters {
+			if !bytes.Equal(blockKeyHash(dbi.Key()), min) {
+				live = append(live, dbi)
+				continue
+			}
+			inFolders++
+			ok := true
+			for ok && bytes.Equal(blockKeyHash(dbi.Key()), min) {
+				ok = dbi.Next()
+			}
+			if ok {
+				live = append(live, dbi)
+			} else {
+				dbi.Release()
+			}
+		}
+		iters = live
+
+		if inFolders > 1 {
+			res.SharedBlocks++
+		}
+	}
+
+	res.PotentialSavings = (totalRefs - res.UniqueBlocks) * protocol.BlockSize
+	return res
+}
+
+// blockKeyHash returns the block hash from the block key
+func blockKeyHash(key []byte) []byte {
+	return key[keyPrefixLen+keyFolderLen : keyPrefixLen+keyFolderLen+keyHashLen]
+}
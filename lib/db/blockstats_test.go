@@ -0,0 +1,55 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import (
+	"testing"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+func TestBlockStats(t *testing.T) {
+	ldb := OpenMemory()
+	b := genBlocks(4)
+	version := protocol.Vector{Counters: []protocol.Counter{{ID: 1, Value: 1000}}}
+
+	fs1 := NewFileSet("folder1", ldb)
+	fs1.Replace(protocol.LocalDeviceID, []protocol.FileInfo{
+		{Name: "a", Version: version, Blocks: b[0:2]},
+		{Name: "b", Version: version, Blocks: b[1:3]},
+	})
+	fs2 := NewFileSet("folder2", ldb)
+	fs2.Replace(protocol.LocalDeviceID, []protocol.FileInfo{
+		{Name: "c", Version: version, Blocks: b[2:4]},
+	})
+
+	stats := ldb.BlockStats()
+
+	// folder1 has four block references of which one (b[1]) is a
+	// duplicate; folder2 has two unique references.
+	if s := stats.Folders["folder1"]; s.Blocks != 4 || s.UniqueBlocks != 3 {
+		t.Errorf("unexpected folder1 stats %+v", s)
+	}
+	if s := stats.Folders["folder2"]; s.Blocks != 2 || s.UniqueBlocks != 2 {
+		t.Errorf("unexpected folder2 stats %+v", s)
+	}
+
+	// Four distinct hashes overall, of which one (b[2]) occurs in both
+	// folders.
+	if stats.UniqueBlocks != 4 {
+		t.Errorf("unexpected unique blocks %d", stats.UniqueBlocks)
+	}
+	if stats.SharedBlocks != 1 {
+		t.Errorf("unexpected shared blocks %d", stats.SharedBlocks)
+	}
+
+	// Six references minus four distinct hashes leaves two blocks worth
+	// of potential savings.
+	if exp := int64(2 * protocol.BlockSize); stats.PotentialSavings != exp {
+		t.Errorf("unexpected potential savings %d, expected %d", stats.PotentialSavings, exp)
+	}
+}
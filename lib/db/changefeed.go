@@ -0,0 +1,88 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import "github.com/syncthing/syncthing/lib/protocol"
+
+// A ChangeFeed follows the local changes to a FileSet in sequence order.
+// Consumers call Next to receive the changes since the previous call (or
+// since the sequence the feed was created at), and wait on C for a signal
+// that further changes have happened. A feed can be resumed across
+// restarts by remembering the sequence returned by Next and creating a new
+// feed from it.
+type ChangeFeed struct {
+	set  *FileSet
+	next int64
+	c    chan struct{}
+}
+
+// NewChangeFeed returns a ChangeFeed on the set, starting at the first
+// local change with a sequence number higher than since. Use since zero for
+// all changes from the beginning. The feed must be closed with Close when
+// no longer needed.
+func (s *FileSet) NewChangeFeed(since int64) *ChangeFeed {
+	f := &ChangeFeed{
+		set:  s,
+		next: since + 1,
+		c:    make(chan struct{}, 1),
+	}
+
+	s.feedMutex.Lock()
+	s.feeds = append(s.feeds, f)
+	s.feedMutex.Unlock()
+
+	// There may already be changes to pick up, so start out signalled.
+	f.signal()
+	return f
+}
+
+// C returns a channel that receives a signal when changes have happened
+// since the last call to Next. The channel is never closed.
+func (f *ChangeFeed) C() <-chan struct{} {
+	return f.c
+}
+
+// Next calls fn for the changes that have happened since the feed was
+// created or Next was last called, in sequence order, and returns the
+// sequence number of the last change seen. The returned sequence can be
+// handed to NewChangeFeed to resume the feed later.
+func (f *ChangeFeed) Next(fn Iterator) int64 {
+	f.set.WithHaveSequence(f.next, func(fi FileIntf) bool {
+		f.next = fi.(protocol.FileInfo).Sequence + 1
+		return fn(fi)
+	})
+	return f.next - 1
+}
+
+// Close detaches the feed from the set.
+func (f *ChangeFeed) Close() {
+	f.set.feedMutex.Lock()
+	for i, feed := range f.set.feeds {
+		if feed == f {
+			f.set.feeds = append(f.set.feeds[:i], f.set.feeds[i+1:]...)
+			break
+		}
+	}
+	f.set.feedMutex.Unlock()
+}
+
+func (f *ChangeFeed) signal() {
+	select {
+	case f.c <- struct{}{}:
+	default:
+	}
+}
+
+// notifyFeeds signals the attached change feeds that local changes have
+// happened.
+func (s *FileSet) notifyFeeds() {
+	s.feedMutex.Lock()
+	for _, f := range s.feeds {
+		f.signal()
+	}
+	s.feedMutex.Unlock()
+}
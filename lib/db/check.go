@@ -0,0 +1,179 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import (
+	"github.com/syncthing/syncthing/lib/protocol"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// CheckLevel controls how much an integrity check is allowed to change.
+type CheckLevel int
+
+const (
+	// CheckReportOnly finds and counts issues without touching the
+	// database.
+	CheckReportOnly CheckLevel = iota
+	// CheckFix removes malformed keys and unparseable entries, trims
+	// version lists referring to nonexistent files, and prunes orphaned
+	// block map entries.
+	CheckFix
+	// CheckRebuild drops the index of any folder with issues entirely,
+	// causing a full rescan and reexchange of that folder.
+	CheckRebuild
+)
+
+// CheckResult summarizes the issues found in the index of one folder.
+type CheckResult struct {
+	Folder           string `json:"folder"`
+	MalformedKeys    int    `json:"malformedKeys"`
+	InvalidFiles     int    `json:"invalidFiles"`
+	DanglingVersions int    `json:"danglingVersions"`
+	OrphanedBlocks   int    `json:"orphanedBlocks"`
+	Repaired         bool   `json:"repaired"`
+	Dropped          bool   `json:"dropped"`
+}
+
+// Issues returns the total number of issues found.
+func (r CheckResult) Issues() int {
+	return r.MalformedKeys + r.InvalidFiles + r.DanglingVersions + r.OrphanedBlocks
+}
+
+// CheckIntegrity verifies the key format sanity of the file index, the
+// referential integrity of the global version lists and the block map, for
+// each folder known to the database. Depending on the level it repairs
+// what it finds, and returns a summary per folder.
+func (db *Instance) CheckIntegrity(level CheckLevel) []CheckResult {
+	var res []CheckResult
+	for _, folder := range db.ListFolders() {
+		r := db.checkFolder([]byte(folder), level)
+		r.Folder = folder
+		if level == CheckRebuild && r.Issues() > 0 {
+			db.dropFolder([]byte(folder))
+			db.dropMtimes([]byte(folder))
+			r.Dropped = true
+		}
+		res = append(res, r)
+	}
+	return res
+}
+
+func (db *Instance) checkFolder(folder []byte, level CheckLevel) CheckResult {
+	var res CheckResult
+	fix := level == CheckFix
+
+	t, err := db.newReadWriteTransaction()
+	if err != nil {
+		return res
+	}
+	defer t.close()
+
+	// Device file entries must have a name part in the key and a value
+	// that unmarshals as a FileInfo.
+
+	dbi := t.NewIterator(util.BytesPrefix(db.deviceKey(folder, nil, nil)[:keyPrefixLen+keyFolderLen]), nil)
+	for dbi.Next() {
+		if len(dbi.Key()) <= keyPrefixLen+keyFolderLen+keyDeviceLen {
+			res.MalformedKeys++
+			if fix {
+				t.Delete(dbi.Key())
+				t.checkFlush()
+			}
+			continue
+		}
+
+		var f FileInfoTruncated
+		if err := f.Unmarshal(dbi.Value()); err != nil {
+			res.InvalidFiles++
+			if fix {
+				t.Delete(dbi.Key())
+				t.checkFlush()
+			}
+		}
+	}
+	dbi.Release()
+
+	// Global version lists must unmarshal, and each version must refer to
+	// an existing device file entry.
+
+	dbi = t.NewIterator(util.BytesPrefix(db.globalKey(folder, nil)[:keyPrefixLen+keyFolderLen]), nil)
+	defer dbi.Release()
+
+	var fk []byte
+	for dbi.Next() {
+		var vl VersionList
+		if err := vl.Unmarshal(dbi.Value()); err != nil {
+			res.InvalidFiles++
+			if fix {
+				t.Delete(dbi.Key())
+				t.checkFlush()
+			}
+			continue
+		}
+
+		name := db.globalKeyName(dbi.Key())
+		var newVL VersionList
+		for _, version := range vl.Versions {
+			fk = db.deviceKeyInto(fk[:cap(fk)], folder, version.Device, name)
+			_, err := t.Get(fk, nil)
+			if err == leveldb.ErrNotFound {
+				res.DanglingVersions++
+				continue
+			}
+			if err != nil {
+				panic(err)
+			}
+			newVL.Versions = append(newVL.Versions, version)
+		}
+
+		if fix && len(newVL.Versions) != len(vl.Versions) {
+			if len(newVL.Versions) == 0 {
+				t.Delete(dbi.Key())
+			} else {
+				buf, _ := marshalInto(&newVL, getMarshalBuffer()) // marshalling can't fail
+				t.Put(dbi.Key(), buf)
+				putMarshalBuffer(buf)
+			}
+			t.checkFlush()
+		}
+	}
+
+	// Block map entries must refer to an existing, non-deleted local file
+	// entry.
+
+	folderID := db.folderIdx.ID(folder)
+	bi := t.NewIterator(util.BytesPrefix(blockKeyInto(nil, nil, folderID, "")[:keyPrefixLen+keyFolderLen]), nil)
+	defer bi.Release()
+
+	for bi.Next() {
+		name := blockKeyName(bi.Key())
+		fk = db.deviceKeyInto(fk[:cap(fk)], folder, protocol.LocalDeviceID[:], []byte(name))
+		bs, err := t.Get(fk, nil)
+		orphaned := err == leveldb.ErrNotFound
+		if err != nil && err != leveldb.ErrNotFound {
+			panic(err)
+		}
+		if err == nil {
+			var f FileInfoTruncated
+			if err := f.Unmarshal(bs); err != nil || f.IsDeleted() || f.IsInvalid() {
+				orphaned = true
+			}
+		}
+		if orphaned {
+			res.OrphanedBlocks++
+			if fix {
+				t.Delete(bi.Key())
+				t.checkFlush()
+			}
+		}
+	}
+
+	res.Repaired = fix && res.Issues() > 0
+	return res
+}
@@ -0,0 +1,68 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import (
+	"testing"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+func TestCheckIntegrity(t *testing.T) {
+	ldb := OpenMemory()
+	fs := NewFileSet("test", ldb)
+
+	myID := protocol.ShortID(1)
+	fs.Update(protocol.LocalDeviceID, []protocol.FileInfo{
+		{Name: "a", Sequence: 1, Version: protocol.Vector{Counters: []protocol.Counter{{ID: myID, Value: 1000}}}, Blocks: genBlocks(1)},
+		{Name: "b", Sequence: 2, Version: protocol.Vector{Counters: []protocol.Counter{{ID: myID, Value: 1000}}}, Blocks: genBlocks(2)},
+	})
+
+	res := ldb.CheckIntegrity(CheckReportOnly)
+	if len(res) != 1 {
+		t.Fatalf("Expected one folder result, got %d", len(res))
+	}
+	if res[0].Issues() != 0 {
+		t.Fatalf("Expected no issues in a fresh database, got %+v", res[0])
+	}
+
+	// Remove the device file entry behind the back of the version list,
+	// leaving a dangling global version and orphaned block map entries.
+	if err := ldb.Delete(ldb.deviceKey([]byte("test"), protocol.LocalDeviceID[:], []byte("b")), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	res = ldb.CheckIntegrity(CheckReportOnly)
+	if res[0].DanglingVersions != 1 {
+		t.Fatalf("Expected one dangling version, got %+v", res[0])
+	}
+	if res[0].OrphanedBlocks != 2 {
+		t.Fatalf("Expected two orphaned blocks, got %+v", res[0])
+	}
+	if res[0].Repaired || res[0].Dropped {
+		t.Fatalf("Report only check should not repair, got %+v", res[0])
+	}
+
+	res = ldb.CheckIntegrity(CheckFix)
+	if res[0].DanglingVersions != 1 || res[0].OrphanedBlocks != 2 || !res[0].Repaired {
+		t.Fatalf("Expected the dangling version and orphaned blocks to be repaired, got %+v", res[0])
+	}
+
+	res = ldb.CheckIntegrity(CheckReportOnly)
+	if res[0].Issues() != 0 {
+		t.Fatalf("Expected no issues after repair, got %+v", res[0])
+	}
+
+	// A folder with issues is dropped entirely at the rebuild level.
+	if err := ldb.Delete(ldb.deviceKey([]byte("test"), protocol.LocalDeviceID[:], []byte("a")), nil); err != nil {
+		t.Fatal(err)
+	}
+	res = ldb.CheckIntegrity(CheckRebuild)
+	if !res[0].Dropped {
+		t.Fatalf("Expected the folder to be dropped, got %+v", res[0])
+	}
+}
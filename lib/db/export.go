@@ -0,0 +1,248 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// exportMagic identifies a database export stream, including its format
+// version.
+var exportMagic = []byte("syncthing-db-export-1\n")
+
+// Record types in an export stream. Folder and device records set the
+// context for the records that follow them.
+const (
+	exportFolder      = 'F' // folder name
+	exportDevice      = 'D' // device ID
+	exportFile        = 'f' // marshalled FileInfo for the current folder and device
+	exportVersionList = 'V' // file name plus marshalled VersionList for the current folder
+	exportEnd         = 'E' // end of stream
+)
+
+// Individual records are never larger than a FileInfo with a full block
+// list; refuse anything bigger as stream corruption.
+const exportMaxRecordSize = 64 << 20
+
+// Export serializes the full index database to a portable stream: all
+// file records per folder and device, plus the global version lists. The
+// stream contains no instance specific key indexes, so it can be imported
+// into a fresh database on another machine without a rescan or index
+// exchange.
+func (db *Instance) Export(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(exportMagic); err != nil {
+		return err
+	}
+
+	t := db.newReadOnlyTransaction()
+	defer t.close()
+
+	// File records, grouped by folder and device.
+	var curFolder, curDevice []byte
+	dbi := t.NewIterator(util.BytesPrefix([]byte{KeyTypeDevice}), nil)
+	for dbi.Next() {
+		folder := db.deviceKeyFolder(dbi.Key())
+		device := db.deviceKeyDevice(dbi.Key())
+		if !bytes.Equal(folder, curFolder) {
+			if err := exportRecord(bw, exportFolder, folder); err != nil {
+				dbi.Release()
+				return err
+			}
+			curFolder = append(curFolder[:0], folder...)
+			curDevice = curDevice[:0]
+		}
+		if !bytes.Equal(device, curDevice) {
+			if err := exportRecord(bw, exportDevice, device); err != nil {
+				dbi.Release()
+				return err
+			}
+			curDevice = append(curDevice[:0], device...)
+		}
+		if err := exportRecord(bw, exportFile, dbi.Value()); err != nil {
+			dbi.Release()
+			return err
+		}
+	}
+	dbi.Release()
+	if err := dbi.Error(); err != nil {
+		return err
+	}
+
+	// Global version lists, grouped by folder.
+	curFolder = nil
+	dbi = t.NewIterator(util.BytesPrefix([]byte{KeyTypeGlobal}), nil)
+	for dbi.Next() {
+		folder := db.globalKeyFolder(dbi.Key())
+		if !bytes.Equal(folder, curFolder) {
+			if err := exportRecord(bw, exportFolder, folder); err != nil {
+				dbi.Release()
+				return err
+			}
+			curFolder = append(curFolder[:0], folder...)
+		}
+
+		name := db.globalKeyName(dbi.Key())
+		data := make([]byte, 0, binary.MaxVarintLen64+len(name)+len(dbi.Value()))
+		var lenBuf [binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(lenBuf[:], uint64(len(name)))
+		data = append(data, lenBuf[:n]...)
+		data = append(data, name...)
+		data = append(data, dbi.Value()...)
+		if err := exportRecord(bw, exportVersionList, data); err != nil {
+			dbi.Release()
+			return err
+		}
+	}
+	dbi.Release()
+	if err := dbi.Error(); err != nil {
+		return err
+	}
+
+	if err := exportRecord(bw, exportEnd, nil); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Import loads an export stream produced by Export into the database.
+// Records are added to whatever is already present; importing into a
+// fresh database is the expected usage. FileSets for the affected folders
+// must be created (or recreated) after the import to pick up the new
+// records.
+func (db *Instance) Import(r io.Reader) (err error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(exportMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return err
+	}
+	if !bytes.Equal(magic, exportMagic) {
+		return errors.New("not a database export stream")
+	}
+
+	t, err := db.newReadWriteTransaction()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := t.close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	var folder, device []byte
+	var bm *BlockMap
+	var localFiles []protocol.FileInfo
+
+	// The block map is not part of the stream; rebuild it from the local
+	// device's file records as they come past.
+	flushLocal := func() error {
+		if bm == nil || len(localFiles) == 0 {
+			return nil
+		}
+		if err := bm.Add(localFiles); err != nil {
+			return err
+		}
+		localFiles = localFiles[:0]
+		return nil
+	}
+
+	for {
+		typ, data, err := importRecord(br)
+		if err != nil {
+			return err
+		}
+
+		switch typ {
+		case exportFolder:
+			if err := flushLocal(); err != nil {
+				return err
+			}
+			folder = data
+			bm = NewBlockMap(db, db.folderIdx.ID(folder))
+
+		case exportDevice:
+			device = data
+
+		case exportFile:
+			if folder == nil || device == nil {
+				return errors.New("file record without folder and device context")
+			}
+			var f protocol.FileInfo
+			if err := f.Unmarshal(data); err != nil {
+				return err
+			}
+			t.Put(db.deviceKey(folder, device, []byte(f.Name)), data)
+			if bytes.Equal(device, protocol.LocalDeviceID[:]) && !f.IsDeleted() && !f.IsInvalid() && !f.IsDirectory() && !f.IsSymlink() {
+				localFiles = append(localFiles, f)
+			}
+			if err := t.checkFlush(); err != nil {
+				return err
+			}
+
+		case exportVersionList:
+			if folder == nil {
+				return errors.New("version list record without folder context")
+			}
+			nameLen, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < nameLen {
+				return errors.New("malformed version list record")
+			}
+			name := data[n : n+int(nameLen)]
+			t.Put(db.globalKey(folder, name), data[n+int(nameLen):])
+			if err := t.checkFlush(); err != nil {
+				return err
+			}
+
+		case exportEnd:
+			return flushLocal()
+
+		default:
+			return fmt.Errorf("unknown record type 0x%02x", typ)
+		}
+	}
+}
+
+func exportRecord(bw *bufio.Writer, typ byte, data []byte) error {
+	if err := bw.WriteByte(typ); err != nil {
+		return err
+	}
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(len(data)))
+	if _, err := bw.Write(lenBuf[:n]); err != nil {
+		return err
+	}
+	_, err := bw.Write(data)
+	return err
+}
+
+func importRecord(br *bufio.Reader) (byte, []byte, error) {
+	typ, err := br.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	size, err := binary.ReadUvarint(br)
+	if err != nil {
+		return 0, nil, err
+	}
+	if size > exportMaxRecordSize {
+		return 0, nil, fmt.Errorf("oversized record (%d bytes)", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(br, data); err != nil {
+		return 0, nil, err
+	}
+	return typ, data, nil
+}
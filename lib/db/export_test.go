@@ -0,0 +1,99 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/syncthing/syncthing/lib/db"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+func TestExportImport(t *testing.T) {
+	src := db.OpenMemory()
+
+	localFiles := []protocol.FileInfo{
+		{Name: "a", Version: protocol.Vector{Counters: []protocol.Counter{{ID: myID, Value: 1000}}}, Blocks: genBlocks(2)},
+		{Name: "b", Version: protocol.Vector{Counters: []protocol.Counter{{ID: myID, Value: 1000}}}, Blocks: genBlocks(3)},
+	}
+	remoteFiles := []protocol.FileInfo{
+		{Name: "b", Version: protocol.Vector{Counters: []protocol.Counter{{ID: myID, Value: 1001}}}, Blocks: genBlocks(4)},
+		{Name: "c", Version: protocol.Vector{Counters: []protocol.Counter{{ID: myID, Value: 1000}}}, Blocks: genBlocks(5)},
+	}
+
+	fs := db.NewFileSet("folder1", src)
+	fs.Replace(protocol.LocalDeviceID, localFiles)
+	fs.Replace(remoteDevice0, remoteFiles)
+
+	// A second folder, to exercise the folder grouping in the stream.
+	fs2 := db.NewFileSet("folder2", src)
+	fs2.Replace(protocol.LocalDeviceID, []protocol.FileInfo{
+		{Name: "d", Version: protocol.Vector{Counters: []protocol.Counter{{ID: myID, Value: 1000}}}, Blocks: genBlocks(1)},
+	})
+
+	var buf bytes.Buffer
+	if err := src.Export(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := db.OpenMemory()
+	if err := dst.Import(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	ifs := db.NewFileSet("folder1", dst)
+	if diff, equal := messagediff.PrettyDiff(haveList(fs, protocol.LocalDeviceID), haveList(ifs, protocol.LocalDeviceID)); !equal {
+		t.Errorf("local have list differs after import:\n%s", diff)
+	}
+	if diff, equal := messagediff.PrettyDiff(haveList(fs, remoteDevice0), haveList(ifs, remoteDevice0)); !equal {
+		t.Errorf("remote have list differs after import:\n%s", diff)
+	}
+	if diff, equal := messagediff.PrettyDiff(globalList(fs), globalList(ifs)); !equal {
+		t.Errorf("global list differs after import:\n%s", diff)
+	}
+	if got, want := ifs.Sequence(protocol.LocalDeviceID), fs.Sequence(protocol.LocalDeviceID); got != want {
+		t.Errorf("sequence %d after import, expected %d", got, want)
+	}
+
+	ifs2 := db.NewFileSet("folder2", dst)
+	if diff, equal := messagediff.PrettyDiff(haveList(fs2, protocol.LocalDeviceID), haveList(ifs2, protocol.LocalDeviceID)); !equal {
+		t.Errorf("second folder have list differs after import:\n%s", diff)
+	}
+
+	// The block map is rebuilt from the local file records.
+	found := db.NewBlockFinder(dst).Iterate([]string{"folder1"}, localFiles[0].Blocks[1].Hash, func(folder, file string, index int32) bool {
+		return folder == "folder1" && file == "a" && index == 1
+	})
+	if !found {
+		t.Error("block map should be rebuilt on import")
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	src := db.OpenMemory()
+	fs := db.NewFileSet("folder1", src)
+	fs.Replace(protocol.LocalDeviceID, []protocol.FileInfo{
+		{Name: "a", Version: protocol.Vector{Counters: []protocol.Counter{{ID: myID, Value: 1000}}}, Blocks: genBlocks(2)},
+	})
+
+	var buf bytes.Buffer
+	if err := src.Export(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// A truncated stream is rejected.
+	if err := db.OpenMemory().Import(bytes.NewReader(buf.Bytes()[:buf.Len()-10])); err == nil {
+		t.Error("expected an error importing a truncated stream")
+	}
+
+	// Something else entirely is rejected.
+	if err := db.OpenMemory().Import(bytes.NewReader([]byte("this is not an export"))); err == nil {
+		t.Error("expected an error importing garbage")
+	}
+}
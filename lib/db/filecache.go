@@ -0,0 +1,132 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import (
+	"container/list"
+	stdsync "sync"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// CacheStats describes the file cache usage since the database was opened.
+type CacheStats struct {
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+	Entries int   `json:"entries"`
+}
+
+// fileCache is an LRU cache of recently read file records, keyed by device
+// key. The write path removes affected keys both when the operation is
+// batched and again when the batch is committed, and the generation
+// counter keeps a concurrent reader from re-adding a value it read before
+// the commit.
+type fileCache struct {
+	mut      stdsync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	lru      *list.List // front is most recently used
+	gen      int64
+	hits     int64
+	misses   int64
+}
+
+type fileCacheEntry struct {
+	key  string
+	file protocol.FileInfo
+}
+
+func newFileCache(capacity int) *fileCache {
+	return &fileCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element, capacity),
+		lru:      list.New(),
+	}
+}
+
+// get returns the cached file record for the key, if there is one.
+func (c *fileCache) get(key []byte) (protocol.FileInfo, bool) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	e, ok := c.entries[string(key)]
+	if !ok {
+		c.misses++
+		return protocol.FileInfo{}, false
+	}
+	c.hits++
+	c.lru.MoveToFront(e)
+	return e.Value.(*fileCacheEntry).file, true
+}
+
+// generation returns the current cache generation, to be handed to
+// addUnlessChanged together with a value read at this point in time.
+func (c *fileCache) generation() int64 {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.gen
+}
+
+// addUnlessChanged caches the file record under the key, unless a write
+// has been committed since gen was sampled in which case the value may be
+// outdated and is discarded.
+func (c *fileCache) addUnlessChanged(key []byte, f protocol.FileInfo, gen int64) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if c.gen != gen {
+		return
+	}
+	if e, ok := c.entries[string(key)]; ok {
+		e.Value.(*fileCacheEntry).file = f
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[string(key)] = c.lru.PushFront(&fileCacheEntry{key: string(key), file: f})
+	if c.lru.Len() > c.capacity {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.entries, e.Value.(*fileCacheEntry).key)
+	}
+}
+
+// remove drops the entry for the key, if there is one.
+func (c *fileCache) remove(key []byte) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.removeLocked(string(key))
+}
+
+// commitRemove drops the entries for keys written by a now committed
+// batch, and bumps the generation so that in-flight reads of the old
+// values are not cached.
+func (c *fileCache) commitRemove(keys []string) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	c.gen++
+	for _, key := range keys {
+		c.removeLocked(key)
+	}
+}
+
+func (c *fileCache) removeLocked(key string) {
+	if e, ok := c.entries[key]; ok {
+		c.lru.Remove(e)
+		delete(c.entries, key)
+	}
+}
+
+func (c *fileCache) stats() CacheStats {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return CacheStats{
+		Hits:    c.hits,
+		Misses:  c.misses,
+		Entries: c.lru.Len(),
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db_test
+
+import (
+	"testing"
+
+	"github.com/syncthing/syncthing/lib/db"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+func TestFileCache(t *testing.T) {
+	ldb := db.OpenMemory()
+	ldb.SetFileCacheSize(2)
+
+	s := db.NewFileSet("test", ldb)
+	local := []protocol.FileInfo{
+		{Name: "a", Version: protocol.Vector{Counters: []protocol.Counter{{ID: myID, Value: 1000}}}, Blocks: genBlocks(1)},
+		{Name: "b", Version: protocol.Vector{Counters: []protocol.Counter{{ID: myID, Value: 1000}}}, Blocks: genBlocks(2)},
+		{Name: "c", Version: protocol.Vector{Counters: []protocol.Counter{{ID: myID, Value: 1000}}}, Blocks: genBlocks(3)},
+	}
+	s.Replace(protocol.LocalDeviceID, local)
+
+	// The first read populates the cache, the second is served from it.
+	s.Get(protocol.LocalDeviceID, "a")
+	before := ldb.FileCacheStats()
+	if f, ok := s.Get(protocol.LocalDeviceID, "a"); !ok || f.Name != "a" {
+		t.Fatal("expected to get file a")
+	}
+	after := ldb.FileCacheStats()
+	if after.Hits != before.Hits+1 {
+		t.Errorf("expected a cache hit; hits %d -> %d", before.Hits, after.Hits)
+	}
+
+	// A write invalidates the cached entry and the next read sees the new
+	// version.
+	a := local[0]
+	a.Version = a.Version.Update(myID)
+	s.Update(protocol.LocalDeviceID, []protocol.FileInfo{a})
+	if f, ok := s.Get(protocol.LocalDeviceID, "a"); !ok || !f.Version.Equal(a.Version) {
+		t.Errorf("got old version %v after update", f.Version)
+	}
+
+	// The cache does not grow beyond its capacity.
+	s.Get(protocol.LocalDeviceID, "a")
+	s.Get(protocol.LocalDeviceID, "b")
+	s.Get(protocol.LocalDeviceID, "c")
+	if stats := ldb.FileCacheStats(); stats.Entries > 2 {
+		t.Errorf("cache holds %d entries, expected at most 2", stats.Entries)
+	}
+
+	// A database without a cache reports zero stats and still works.
+	ldb2 := db.OpenMemory()
+	s2 := db.NewFileSet("test", ldb2)
+	s2.Replace(protocol.LocalDeviceID, local)
+	if _, ok := s2.Get(protocol.LocalDeviceID, "a"); !ok {
+		t.Fatal("expected to get file a")
+	}
+	if stats := ldb2.FileCacheStats(); stats != (db.CacheStats{}) {
+		t.Errorf("unexpected stats %+v without a cache", stats)
+	}
+}
@@ -15,6 +15,13 @@ import (
 	"github.com/syndtr/goleveldb/leveldb/opt"
 )
 
+// Each key type is a namespace of its own, identified by the first byte of
+// the key. Key types that hold per-folder data place the folder -- as a
+// four byte folder index, or for the namespaced key-value stores as the
+// folder label -- directly after the key type byte, so that a folder's
+// data within each namespace forms a contiguous key range that can be
+// dropped in a single ranged delete. (The index ID keys are the exception,
+// being keyed on device first.)
 const (
 	KeyTypeDevice = iota
 	KeyTypeGlobal
@@ -25,6 +32,11 @@ const (
 	KeyTypeFolderIdx
 	KeyTypeDeviceIdx
 	KeyTypeIndexID
+	KeyTypeFolderHistory
+	KeyTypeMiscData
+	KeyTypeSequence
+	KeyTypeProvenance
+	KeyTypeIndexWatermark
 )
 
 func (l VersionList) String() string {
@@ -60,8 +72,9 @@ type dbReader interface {
 	Get([]byte, *opt.ReadOptions) ([]byte, error)
 }
 
-// Flush batches to disk when they contain this many records.
-const batchFlushSize = 64
+// Flush batches to disk when they contain this many records, unless
+// overridden on the Instance.
+const defaultBatchFlushSize = 64
 
 func getFile(db dbReader, key []byte) (protocol.FileInfo, bool) {
 	bs, err := db.Get(key, nil)
@@ -13,6 +13,7 @@ import (
 	"sort"
 	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/syncthing/syncthing/lib/protocol"
 	"github.com/syncthing/syncthing/lib/sync"
@@ -24,21 +25,56 @@ import (
 	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
-type deletionHandler func(t readWriteTransaction, folder, device, name []byte, dbi iterator.Iterator)
+type deletionHandler func(t readWriteTransaction, folder, device, name []byte, dbi iterator.Iterator) error
 
 type Instance struct {
-	committed int64 // this must be the first attribute in the struct to ensure 64 bit alignment on 32 bit plaforms
+	committed      int64 // this must be the first attribute in the struct to ensure 64 bit alignment on 32 bit plaforms
+	batchFlushSize int64 // atomic; flush batches when they contain this many records
+	maxBatchSize   int64 // atomic; flush block map batches when they exceed this many bytes
+	bulkDepth      int64 // atomic; batch sizes are enlarged while nonzero
+
+	// Write transaction counters, all atomic.
+	txPuts            int64
+	txDeletes         int64
+	txFlushes         int64
+	txCommitNs        int64 // cumulative time spent committing batches
+	txSlow            int64 // transactions that exceeded the slow threshold
+	txSlowThresholdNs int64 // slow transaction log threshold; zero disables
 	*leveldb.DB
 	location  string
 	folderIdx *smallIndex
 	deviceIdx *smallIndex
+	fileCache *fileCache // nil when the cache is disabled
+
+	compactionMut sync.Mutex
+	compaction    CompactionStats
+
+	healthMut sync.Mutex
+	unhealthy error
+
+	danglingMut sync.Mutex
+	dangling    []DanglingVersion
+
+	validMut     sync.Mutex
+	validDevices map[string]map[string]struct{} // folder -> devices in its cluster
+
+}
+
+// A DanglingVersion identifies a version list entry that referenced a file
+// record that did not exist. The entry has been dropped from the version
+// list; the device's index should be requested anew to repair it.
+type DanglingVersion struct {
+	Folder string
+	File   string
+	Device protocol.DeviceID
 }
 
 const (
-	keyPrefixLen = 1
-	keyFolderLen = 4 // indexed
-	keyDeviceLen = 4 // indexed
-	keyHashLen   = 32
+	keyPrefixLen   = 1
+	keyFolderLen   = 4 // indexed
+	keyDeviceLen   = 4 // indexed
+	keyHashLen     = 32
+	keySequenceLen = 8
 )
 
 func Open(file string) (*Instance, error) {
@@ -65,18 +101,49 @@ func Open(file string) (*Instance, error) {
 		return nil, err
 	}
 
+	i := newDBInstance(db, file)
+	if err := i.migrateWithBackup(); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// OpenReadOnly opens the database in read-only mode, for inspecting the
+// index of a stopped instance without modifying it. No corruption
+// recovery or schema migration is attempted, and anything that writes to
+// the database will fail.
+func OpenReadOnly(file string) (*Instance, error) {
+	opts := &opt.Options{
+		OpenFilesCacheCapacity: 100,
+		ReadOnly:               true,
+	}
+
+	db, err := leveldb.OpenFile(file, opts)
+	if err != nil {
+		return nil, err
+	}
+
 	return newDBInstance(db, file), nil
 }
 
 func OpenMemory() *Instance {
 	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
-	return newDBInstance(db, "<memory>")
+	i := newDBInstance(db, "<memory>")
+	i.migrate() // a fresh database; this just records the schema version
+	return i
 }
 
 func newDBInstance(db *leveldb.DB, location string) *Instance {
 	i := &Instance{
-		DB:       db,
-		location: location,
+		DB:             db,
+		location:       location,
+		batchFlushSize: defaultBatchFlushSize,
+		maxBatchSize:   defaultMaxBatchSize,
+		compactionMut:  sync.NewMutex(),
+		healthMut:      sync.NewMutex(),
+		danglingMut:    sync.NewMutex(),
+		validMut:       sync.NewMutex(),
+		validDevices:   make(map[string]map[string]struct{}),
 	}
 	i.folderIdx = newSmallIndex(i, []byte{KeyTypeFolderIdx})
 	i.deviceIdx = newSmallIndex(i, []byte{KeyTypeDeviceIdx})
@@ -88,16 +155,124 @@ func (db *Instance) Committed() int64 {
 	return atomic.LoadInt64(&db.committed)
 }
 
+// Batch sizes are multiplied by this factor while in bulk mode.
+const bulkBatchFactor = 16
+
+// SetBatchSizes overrides the default write batch thresholds: batches are
+// flushed when they contain flushRecords records, or for the block map
+// when they exceed maxSizeBytes bytes. Zero values keep the defaults.
+func (db *Instance) SetBatchSizes(flushRecords, maxSizeBytes int) {
+	if flushRecords > 0 {
+		atomic.StoreInt64(&db.batchFlushSize, int64(flushRecords))
+	}
+	if maxSizeBytes > 0 {
+		atomic.StoreInt64(&db.maxBatchSize, int64(maxSizeBytes))
+	}
+}
+
+// StartBulk enters bulk indexing mode, for operations like the initial
+// scan of a large folder where throughput matters more than durability of
+// each individual batch. Batch size thresholds are enlarged until the
+// matching EndBulk call. Calls nest.
+func (db *Instance) StartBulk() {
+	atomic.AddInt64(&db.bulkDepth, 1)
+}
+
+// EndBulk leaves bulk indexing mode. When the last nested bulk operation
+// ends, the configured batch sizes are restored and a synced write is
+// issued so that everything written during the bulk phase becomes
+// durable.
+func (db *Instance) EndBulk() {
+	if atomic.AddInt64(&db.bulkDepth, -1) > 0 {
+		return
+	}
+
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(time.Now().Unix()))
+	key := append([]byte{KeyTypeMiscData}, []byte("lastBulkEnd")...)
+	if err := db.Put(key, buf, &opt.WriteOptions{Sync: true}); err != nil {
+		db.fail(err)
+	}
+}
+
+func (db *Instance) batchFlushRecords() int {
+	n := atomic.LoadInt64(&db.batchFlushSize)
+	if atomic.LoadInt64(&db.bulkDepth) > 0 {
+		n *= bulkBatchFactor
+	}
+	return int(n)
+}
+
+func (db *Instance) batchMaxSize() int {
+	n := atomic.LoadInt64(&db.maxBatchSize)
+	if atomic.LoadInt64(&db.bulkDepth) > 0 {
+		n *= bulkBatchFactor
+	}
+	return int(n)
+}
+
+// fail records err as a database health problem and returns it. The first
+// recorded error sticks; folders backed by this database surface it and
+// suspend scans and pulls until the process is restarted.
+func (db *Instance) fail(err error) error {
+	db.healthMut.Lock()
+	if db.unhealthy == nil {
+		db.unhealthy = err
+		l.Warnf("Database failure: %v", err)
+	}
+	db.healthMut.Unlock()
+	return err
+}
+
+// Unhealthy returns the first database error encountered, or nil if the
+// database is believed healthy.
+func (db *Instance) Unhealthy() error {
+	db.healthMut.Lock()
+	defer db.healthMut.Unlock()
+	return db.unhealthy
+}
+
+// noteDanglingVersion records that a dangling version list entry for the
+// given file was dropped, for later reconciliation by the model.
+func (db *Instance) noteDanglingVersion(folder, device, name []byte) {
+	dv := DanglingVersion{
+		Folder: string(folder),
+		File:   string(name),
+		Device: protocol.DeviceIDFromBytes(device),
+	}
+	l.Infof("Dropping dangling version list entry; folder %q, file %q, device %v", dv.Folder, dv.File, dv.Device)
+	db.danglingMut.Lock()
+	db.dangling = append(db.dangling, dv)
+	db.danglingMut.Unlock()
+}
+
+// TakeDanglingVersions returns the dangling version list entries dropped
+// since the previous call, and clears the list.
+func (db *Instance) TakeDanglingVersions() []DanglingVersion {
+	db.danglingMut.Lock()
+	dangling := db.dangling
+	db.dangling = nil
+	db.danglingMut.Unlock()
+	return dangling
+}
+
 // Location returns the filesystem path where the database is stored
 func (db *Instance) Location() string {
 	return db.location
 }
 
-func (db *Instance) genericReplace(folder, device []byte, fs []protocol.FileInfo, localSize, globalSize *sizeTracker, deleteFn deletionHandler) {
+func (db *Instance) genericReplace(folder, device []byte, fs []protocol.FileInfo, localSize, globalSize *sizeTracker, deleteFn deletionHandler) (err error) {
 	sort.Sort(fileList(fs)) // sort list on name, same as in the database
 
-	t := db.newReadWriteTransaction()
-	defer t.close()
+	t, err := db.newReadWriteTransaction()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := t.close(); err == nil {
+			err = cerr
+		}
+	}()
 
 	dbi := t.NewIterator(util.BytesPrefix(db.deviceKey(folder, device, nil)[:keyPrefixLen+keyFolderLen+keyDeviceLen]), nil)
 	defer dbi.Release()
@@ -130,14 +305,19 @@ func (db *Instance) genericReplace(folder, device []byte, fs []protocol.FileInfo
 		case moreFs && (!moreDb || cmp == -1):
 			l.Debugln("generic replace; missing - insert")
 			// Database is missing this file. Insert it.
-			t.insertFile(folder, device, fs[fsi])
+			if err := t.insertFile(folder, device, fs[fsi]); err != nil {
+				return err
+			}
 			if isLocalDevice {
 				localSize.addFile(fs[fsi])
 			}
 			if fs[fsi].IsInvalid() {
-				t.removeFromGlobal(folder, device, newName, globalSize)
+				err = t.removeFromGlobal(folder, device, newName, globalSize)
 			} else {
-				t.updateGlobal(folder, device, fs[fsi], globalSize)
+				_, err = t.updateGlobal(folder, device, fs[fsi], globalSize)
+			}
+			if err != nil {
+				return err
 			}
 			fsi++
 
@@ -150,15 +330,21 @@ func (db *Instance) genericReplace(folder, device []byte, fs []protocol.FileInfo
 			ef.Unmarshal(dbi.Value())
 			if !fs[fsi].Version.Equal(ef.Version) || fs[fsi].Invalid != ef.Invalid {
 				l.Debugln("generic replace; differs - insert")
-				t.insertFile(folder, device, fs[fsi])
+				t.removeSequence(folder, device, ef.Sequence)
+				if err := t.insertFile(folder, device, fs[fsi]); err != nil {
+					return err
+				}
 				if isLocalDevice {
 					localSize.removeFile(ef)
 					localSize.addFile(fs[fsi])
 				}
 				if fs[fsi].IsInvalid() {
-					t.removeFromGlobal(folder, device, newName, globalSize)
+					err = t.removeFromGlobal(folder, device, newName, globalSize)
 				} else {
-					t.updateGlobal(folder, device, fs[fsi], globalSize)
+					_, err = t.updateGlobal(folder, device, fs[fsi], globalSize)
+				}
+				if err != nil {
+					return err
 				}
 			} else {
 				l.Debugln("generic replace; equal - ignore")
@@ -169,53 +355,88 @@ func (db *Instance) genericReplace(folder, device []byte, fs []protocol.FileInfo
 
 		case moreDb && (!moreFs || cmp == 1):
 			l.Debugln("generic replace; exists - remove")
-			deleteFn(t, folder, device, oldName, dbi)
+			if err := deleteFn(t, folder, device, oldName, dbi); err != nil {
+				return err
+			}
 			moreDb = dbi.Next()
 		}
 
 		// Write out and reuse the batch every few records, to avoid the batch
 		// growing too large and thus allocating unnecessarily much memory.
-		t.checkFlush()
+		if err := t.checkFlush(); err != nil {
+			return err
+		}
 	}
+
+	return nil
 }
 
-func (db *Instance) replace(folder, device []byte, fs []protocol.FileInfo, localSize, globalSize *sizeTracker) {
-	db.genericReplace(folder, device, fs, localSize, globalSize, func(t readWriteTransaction, folder, device, name []byte, dbi iterator.Iterator) {
+func (db *Instance) replace(folder, device []byte, fs []protocol.FileInfo, localSize, globalSize *sizeTracker) error {
+	return db.genericReplace(folder, device, fs, localSize, globalSize, func(t readWriteTransaction, folder, device, name []byte, dbi iterator.Iterator) error {
 		// Database has a file that we are missing. Remove it.
 		l.Debugf("delete; folder=%q device=%v name=%q", folder, protocol.DeviceIDFromBytes(device), name)
-		t.removeFromGlobal(folder, device, name, globalSize)
+		if err := t.removeFromGlobal(folder, device, name, globalSize); err != nil {
+			return err
+		}
+		var ef FileInfoTruncated
+		if err := ef.Unmarshal(dbi.Value()); err == nil {
+			t.removeSequence(folder, device, ef.Sequence)
+		}
 		t.Delete(dbi.Key())
+		return nil
 	})
 }
 
-func (db *Instance) updateFiles(folder, device []byte, fs []protocol.FileInfo, localSize, globalSize *sizeTracker) {
-	t := db.newReadWriteTransaction()
-	defer t.close()
+func (db *Instance) updateFiles(folder, device []byte, fs []protocol.FileInfo, localSize, globalSize *sizeTracker) (err error) {
+	t, err := db.newReadWriteTransaction()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := t.close(); err == nil {
+			err = cerr
+		}
+	}()
 
+	return db.updateFilesInTransaction(t, folder, device, fs, localSize, globalSize, true)
+}
+
+// updateFilesInTransaction applies the file updates within an existing
+// transaction. With flush set the batch is written out and reused every
+// few records; a caller requiring the whole update to commit atomically
+// leaves it unset and flushes the transaction itself.
+func (db *Instance) updateFilesInTransaction(t readWriteTransaction, folder, device []byte, fs []protocol.FileInfo, localSize, globalSize *sizeTracker, flush bool) error {
 	var fk []byte
 	isLocalDevice := bytes.Equal(device, protocol.LocalDeviceID[:])
 	for _, f := range fs {
 		name := []byte(f.Name)
 		fk = db.deviceKeyInto(fk[:cap(fk)], folder, device, name)
 		bs, err := t.Get(fk, nil)
+		if err != nil && err != leveldb.ErrNotFound {
+			return db.fail(err)
+		}
 		if err == leveldb.ErrNotFound {
 			if isLocalDevice {
 				localSize.addFile(f)
 			}
 
-			t.insertFile(folder, device, f)
+			if err := t.insertFile(folder, device, f); err != nil {
+				return err
+			}
 			if f.IsInvalid() {
-				t.removeFromGlobal(folder, device, name, globalSize)
+				err = t.removeFromGlobal(folder, device, name, globalSize)
 			} else {
-				t.updateGlobal(folder, device, f, globalSize)
+				_, err = t.updateGlobal(folder, device, f, globalSize)
+			}
+			if err != nil {
+				return err
 			}
 			continue
 		}
 
 		var ef FileInfoTruncated
-		err = ef.Unmarshal(bs)
-		if err != nil {
-			panic(err)
+		if err := ef.Unmarshal(bs); err != nil {
+			return db.fail(err)
 		}
 		// The Invalid flag might change without the version being bumped.
 		if !ef.Version.Equal(f.Version) || ef.Invalid != f.Invalid {
@@ -224,18 +445,30 @@ func (db *Instance) updateFiles(folder, device []byte, fs []protocol.FileInfo, l
 				localSize.addFile(f)
 			}
 
-			t.insertFile(folder, device, f)
+			t.removeSequence(folder, device, ef.Sequence)
+			if err := t.insertFile(folder, device, f); err != nil {
+				return err
+			}
 			if f.IsInvalid() {
-				t.removeFromGlobal(folder, device, name, globalSize)
+				err = t.removeFromGlobal(folder, device, name, globalSize)
 			} else {
-				t.updateGlobal(folder, device, f, globalSize)
+				_, err = t.updateGlobal(folder, device, f, globalSize)
+			}
+			if err != nil {
+				return err
 			}
 		}
 
 		// Write out and reuse the batch every few records, to avoid the batch
 		// growing too large and thus allocating unnecessarily much memory.
-		t.checkFlush()
+		if flush {
+			if err := t.checkFlush(); err != nil {
+				return err
+			}
+		}
 	}
+
+	return nil
 }
 
 func (db *Instance) withHave(folder, device, prefix []byte, truncate bool, fn Iterator) {
@@ -270,8 +503,37 @@ func (db *Instance) withHave(folder, device, prefix []byte, truncate bool, fn It
 	}
 }
 
+// withHaveSequence calls fn for the local files with a sequence number
+// equal to or higher than startSeq, in sequence order. Stale index entries
+// left behind by older versions are skipped.
+func (db *Instance) withHaveSequence(folder []byte, startSeq int64, fn Iterator) {
+	t := db.newReadOnlyTransaction()
+	defer t.close()
+
+	r := util.BytesPrefix(db.folderScopedPrefix(KeyTypeSequence, folder))
+	r.Start = db.sequenceKey(folder, startSeq)
+	dbi := t.NewIterator(r, nil)
+	defer dbi.Release()
+
+	for dbi.Next() {
+		f, ok := getFile(t, dbi.Value())
+		if !ok || f.Sequence != db.sequenceKeySequence(dbi.Key()) {
+			// The file this entry pointed at has been replaced or removed
+			// since the entry was written.
+			continue
+		}
+
+		if cont := fn(f); !cont {
+			return
+		}
+	}
+}
+
 func (db *Instance) withAllFolderTruncated(folder []byte, fn func(device []byte, f FileInfoTruncated) bool) {
-	t := db.newReadWriteTransaction()
+	t, err := db.newReadWriteTransaction()
+	if err != nil {
+		return
+	}
 	defer t.close()
 
 	dbi := t.NewIterator(util.BytesPrefix(db.deviceKey(folder, nil, nil)[:keyPrefixLen+keyFolderLen]), nil)
@@ -305,7 +567,52 @@ func (db *Instance) withAllFolderTruncated(folder []byte, fn func(device []byte,
 }
 
 func (db *Instance) getFile(folder, device, file []byte) (protocol.FileInfo, bool) {
-	return getFile(db, db.deviceKey(folder, device, file))
+	var gen int64
+	if c := db.fileCache; c != nil {
+		gen = c.generation()
+	}
+	return db.cachedGetFile(db, db.deviceKey(folder, device, file), gen)
+}
+
+// SetFileCacheSize enables the in-memory cache of recently read file
+// records, holding up to the given number of entries. Zero disables the
+// cache. This should be called before the database is in use.
+func (db *Instance) SetFileCacheSize(entries int) {
+	if entries > 0 {
+		db.fileCache = newFileCache(entries)
+	} else {
+		db.fileCache = nil
+	}
+}
+
+// FileCacheStats returns usage statistics for the file cache. The zero
+// value is returned when the cache is disabled.
+func (db *Instance) FileCacheStats() CacheStats {
+	if db.fileCache == nil {
+		return CacheStats{}
+	}
+	return db.fileCache.stats()
+}
+
+// cachedGetFile is getFile via the file cache, when enabled. The reader
+// may be the database itself or a transaction snapshot; gen must be the
+// cache generation sampled no later than when the reader's view of the
+// database was established, so that a value made stale by a concurrent
+// commit is not cached.
+func (db *Instance) cachedGetFile(r dbReader, key []byte, gen int64) (protocol.FileInfo, bool) {
+	c := db.fileCache
+	if c == nil {
+		return getFile(r, key)
+	}
+
+	if f, ok := c.get(key); ok {
+		return f, true
+	}
+	f, ok := getFile(r, key)
+	if ok {
+		c.addUnlessChanged(key, f, gen)
+	}
+	return f, ok
 }
 
 func (db *Instance) getGlobal(folder, file []byte, truncate bool) (FileIntf, bool) {
@@ -429,16 +736,25 @@ func (db *Instance) availability(folder, file []byte) []protocol.DeviceID {
 	return devices
 }
 
-func (db *Instance) withNeed(folder, device []byte, truncate bool, fn Iterator) {
+func (db *Instance) withNeed(folder, device, prefix []byte, truncate bool, fn Iterator) {
 	t := db.newReadOnlyTransaction()
 	defer t.close()
 
-	dbi := t.NewIterator(util.BytesPrefix(db.globalKey(folder, nil)[:keyPrefixLen+keyFolderLen]), nil)
+	dbi := t.NewIterator(util.BytesPrefix(db.globalKey(folder, prefix)), nil)
 	defer dbi.Release()
 
+	slashedPrefix := prefix
+	if !bytes.HasSuffix(prefix, []byte{'/'}) {
+		slashedPrefix = append(slashedPrefix, '/')
+	}
+
 	var fk []byte
 nextFile:
 	for dbi.Next() {
+		if name := db.globalKeyName(dbi.Key()); len(prefix) > 0 && !bytes.Equal(name, prefix) && !bytes.HasPrefix(name, slashedPrefix) {
+			return
+		}
+
 		var vl VersionList
 		err := vl.Unmarshal(dbi.Value())
 		if err != nil {
@@ -541,33 +857,56 @@ func (db *Instance) ListFolders() []string {
 	return folders
 }
 
-func (db *Instance) dropFolder(folder []byte) {
-	t := db.newReadOnlyTransaction()
-	defer t.close()
+// WithHaveTruncated, WithGlobalTruncated and WithNeedTruncated expose the
+// iteration primitives directly on the Instance, for inspection tooling
+// working against a read-only database where a FileSet -- which repairs
+// the database as a side effect of being created -- cannot be used. File
+// names are in the raw database (slash separated) form.
 
-	// Remove all items related to the given folder from the device->file bucket
-	dbi := t.NewIterator(util.BytesPrefix([]byte{KeyTypeDevice}), nil)
-	for dbi.Next() {
-		itemFolder := db.deviceKeyFolder(dbi.Key())
-		if bytes.Equal(folder, itemFolder) {
-			db.Delete(dbi.Key(), nil)
-		}
-	}
-	dbi.Release()
+// WithHaveTruncated calls fn for the files announced by the given device
+// in the given folder.
+func (db *Instance) WithHaveTruncated(folder string, device protocol.DeviceID, fn Iterator) {
+	db.withHave([]byte(folder), device[:], nil, true, fn)
+}
 
-	// Remove all items related to the given folder from the global bucket
-	dbi = t.NewIterator(util.BytesPrefix([]byte{KeyTypeGlobal}), nil)
-	for dbi.Next() {
-		itemFolder := db.globalKeyFolder(dbi.Key())
-		if bytes.Equal(folder, itemFolder) {
-			db.Delete(dbi.Key(), nil)
-		}
-	}
-	dbi.Release()
+// WithGlobalTruncated calls fn for the global (newest) version of each
+// file in the given folder.
+func (db *Instance) WithGlobalTruncated(folder string, fn Iterator) {
+	db.withGlobal([]byte(folder), nil, true, fn)
+}
+
+// WithNeedTruncated calls fn for the files the given device needs in the
+// given folder.
+func (db *Instance) WithNeedTruncated(folder string, device protocol.DeviceID, fn Iterator) {
+	db.withNeed([]byte(folder), device[:], nil, true, fn)
+}
+
+func (db *Instance) dropFolder(folder []byte) {
+	// Device and global keys place the four byte folder index directly
+	// after the key type byte, so all of a folder's data in each bucket is
+	// one contiguous range that we can delete without inspecting
+	// individual keys.
+	db.dropPrefix(db.folderScopedPrefix(KeyTypeDevice, folder))
+	db.dropPrefix(db.folderScopedPrefix(KeyTypeGlobal, folder))
+	db.dropPrefix(db.folderScopedPrefix(KeyTypeSequence, folder))
+}
+
+// folderScopedPrefix returns the key prefix covering all keys of the given
+// type that belong to the given folder. It is valid for the key types where
+// the folder index immediately follows the key type byte, i.e. the device,
+// global, block and virtual mtime buckets.
+func (db *Instance) folderScopedPrefix(keyType byte, folder []byte) []byte {
+	prefix := make([]byte, keyPrefixLen+keyFolderLen)
+	prefix[0] = keyType
+	binary.BigEndian.PutUint32(prefix[keyPrefixLen:], db.folderIdx.ID(folder))
+	return prefix
 }
 
 func (db *Instance) checkGlobals(folder []byte, globalSize *sizeTracker) {
-	t := db.newReadWriteTransaction()
+	t, err := db.newReadWriteTransaction()
+	if err != nil {
+		return
+	}
 	defer t.close()
 
 	dbi := t.NewIterator(util.BytesPrefix(db.globalKey(folder, nil)[:keyPrefixLen+keyFolderLen]), nil)
@@ -611,18 +950,64 @@ func (db *Instance) checkGlobals(folder []byte, globalSize *sizeTracker) {
 		}
 
 		if len(newVL.Versions) != len(vl.Versions) {
-			t.Put(dbi.Key(), mustMarshal(&newVL))
+			buf, _ := marshalInto(&newVL, getMarshalBuffer()) // marshalling can't fail
+			t.Put(dbi.Key(), buf)
+			putMarshalBuffer(buf)
 			t.checkFlush()
 		}
 	}
 	l.Debugf("db check completed for %q", folder)
 }
 
+// checkSequences verifies the sequence index against the local file
+// records, adding entries that are missing and removing entries that point
+// at replaced or removed files. On a database from before the sequence
+// index existed this builds the index from scratch.
+func (db *Instance) checkSequences(folder []byte) {
+	t, err := db.newReadWriteTransaction()
+	if err != nil {
+		return
+	}
+	defer t.close()
+
+	// Drop entries pointing at files that have since been replaced or
+	// removed. This happens before the repair pass below so that a repair
+	// of the same sequence number ends up after the delete in the batch.
+	dbi := t.NewIterator(util.BytesPrefix(db.folderScopedPrefix(KeyTypeSequence, folder)), nil)
+	for dbi.Next() {
+		f, ok := getFile(t, dbi.Value())
+		if !ok || f.Sequence != db.sequenceKeySequence(dbi.Key()) {
+			t.Delete(dbi.Key())
+			t.checkFlush()
+		}
+	}
+	dbi.Release()
+
+	// Add entries missing for local files. On a database from before the
+	// sequence index existed this is all of them.
+	dbi = t.NewIterator(util.BytesPrefix(db.deviceKey(folder, protocol.LocalDeviceID[:], nil)[:keyPrefixLen+keyFolderLen+keyDeviceLen]), nil)
+	defer dbi.Release()
+	for dbi.Next() {
+		var f FileInfoTruncated
+		if err := f.Unmarshal(dbi.Value()); err != nil {
+			panic(err)
+		}
+		sk := db.sequenceKey(folder, f.Sequence)
+		if bs, err := t.Get(sk, nil); err != nil || !bytes.Equal(bs, dbi.Key()) {
+			t.Put(sk, dbi.Key())
+			t.checkFlush()
+		}
+	}
+
+	l.Debugf("sequence check completed for %q", folder)
+}
+
 // deviceKey returns a byte slice encoding the following information:
-//	   keyTypeDevice (1 byte)
-//	   folder (4 bytes)
-//	   device (4 bytes)
-//	   name (variable size)
+//
+//	keyTypeDevice (1 byte)
+//	folder (4 bytes)
+//	device (4 bytes)
+//	name (variable size)
 func (db *Instance) deviceKey(folder, device, file []byte) []byte {
 	return db.deviceKeyInto(nil, folder, device, file)
 }
@@ -663,9 +1048,10 @@ func (db *Instance) deviceKeyDevice(key []byte) []byte {
 }
 
 // globalKey returns a byte slice encoding the following information:
-//	   keyTypeGlobal (1 byte)
-//	   folder (4 bytes)
-//	   name (variable size)
+//
+//	keyTypeGlobal (1 byte)
+//	folder (4 bytes)
+//	name (variable size)
 func (db *Instance) globalKey(folder, file []byte) []byte {
 	k := make([]byte, keyPrefixLen+keyFolderLen+len(file))
 	k[0] = KeyTypeGlobal
@@ -719,17 +1105,93 @@ func (db *Instance) indexIDKey(device, folder []byte) []byte {
 	return k
 }
 
+// getWatermark returns the highest sequence number seen from the given
+// device for the given folder, or zero when nothing has been recorded.
+// Unlike the file records it is never dropped, so it survives the newest
+// records being replaced or pruned from the index.
+func (db *Instance) getWatermark(device, folder []byte) int64 {
+	key := db.watermarkKey(device, folder)
+	bs, err := db.Get(key, nil)
+	if err != nil || len(bs) != 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(bs))
+}
+
+// watermarksForFolder returns the persisted sequence watermarks for the
+// given folder, keyed by device ID.
+func (db *Instance) watermarksForFolder(folder []byte) map[protocol.DeviceID]int64 {
+	folderID := db.folderIdx.ID(folder)
+	res := make(map[protocol.DeviceID]int64)
+
+	t := db.newReadOnlyTransaction()
+	defer t.close()
+
+	dbi := t.NewIterator(util.BytesPrefix([]byte{KeyTypeIndexWatermark}), nil)
+	defer dbi.Release()
+
+	for dbi.Next() {
+		key := dbi.Key()
+		if binary.BigEndian.Uint32(key[keyPrefixLen+keyDeviceLen:]) != folderID {
+			continue
+		}
+		device, ok := db.deviceIdx.Val(binary.BigEndian.Uint32(key[keyPrefixLen:]))
+		if !ok || len(device) != len(protocol.DeviceID{}) {
+			continue
+		}
+		bs := dbi.Value()
+		if len(bs) != 8 {
+			continue
+		}
+		var id protocol.DeviceID
+		copy(id[:], device)
+		res[id] = int64(binary.BigEndian.Uint64(bs))
+	}
+	return res
+}
+
+func (db *Instance) setWatermark(device, folder []byte, seq int64) {
+	key := db.watermarkKey(device, folder)
+	bs := make([]byte, 8)
+	binary.BigEndian.PutUint64(bs, uint64(seq))
+	if err := db.Put(key, bs, nil); err != nil {
+		panic("storing index watermark: " + err.Error())
+	}
+}
+
+func (db *Instance) watermarkKey(device, folder []byte) []byte {
+	k := make([]byte, keyPrefixLen+keyDeviceLen+keyFolderLen)
+	k[0] = KeyTypeIndexWatermark
+	binary.BigEndian.PutUint32(k[keyPrefixLen:], db.deviceIdx.ID(device))
+	binary.BigEndian.PutUint32(k[keyPrefixLen+keyDeviceLen:], db.folderIdx.ID(folder))
+	return k
+}
+
 func (db *Instance) mtimesKey(folder []byte) []byte {
-	prefix := make([]byte, 5) // key type + 4 bytes folder idx number
-	prefix[0] = KeyTypeVirtualMtime
-	binary.BigEndian.PutUint32(prefix[1:], db.folderIdx.ID(folder))
-	return prefix
+	return db.folderScopedPrefix(KeyTypeVirtualMtime, folder)
 }
 
-// DropDeltaIndexIDs removes all index IDs from the database. This will
-// cause a full index transmission on the next connection.
+// sequenceKey returns the key for the sequence index entry with the given
+// sequence number. The value at the key is the device key of the local file
+// that was assigned the sequence number.
+func (db *Instance) sequenceKey(folder []byte, seq int64) []byte {
+	k := make([]byte, keyPrefixLen+keyFolderLen+keySequenceLen)
+	k[0] = KeyTypeSequence
+	binary.BigEndian.PutUint32(k[keyPrefixLen:], db.folderIdx.ID(folder))
+	binary.BigEndian.PutUint64(k[keyPrefixLen+keyFolderLen:], uint64(seq))
+	return k
+}
+
+func (db *Instance) sequenceKeySequence(key []byte) int64 {
+	return int64(binary.BigEndian.Uint64(key[keyPrefixLen+keyFolderLen:]))
+}
+
+// DropDeltaIndexIDs removes all index IDs and sequence watermarks from
+// the database. This will cause a full index transmission on the next
+// connection.
 func (db *Instance) DropDeltaIndexIDs() {
 	db.dropPrefix([]byte{KeyTypeIndexID})
+	db.dropPrefix([]byte{KeyTypeIndexWatermark})
 }
 
 func (db *Instance) dropMtimes(folder []byte) {
@@ -737,7 +1199,10 @@ func (db *Instance) dropMtimes(folder []byte) {
 }
 
 func (db *Instance) dropPrefix(prefix []byte) {
-	t := db.newReadWriteTransaction()
+	t, err := db.newReadWriteTransaction()
+	if err != nil {
+		return
+	}
 	defer t.close()
 
 	dbi := t.NewIterator(util.BytesPrefix(prefix), nil)
@@ -9,6 +9,8 @@ package db
 import (
 	"bytes"
 	"testing"
+
+	"github.com/syncthing/syncthing/lib/protocol"
 )
 
 func TestDeviceKey(t *testing.T) {
@@ -54,3 +56,180 @@ func TestGlobalKey(t *testing.T) {
 		t.Errorf("wrong name %q != %q", name2, name)
 	}
 }
+
+func TestDroppedDanglingVersion(t *testing.T) {
+	// A version list entry referencing a file record that does not exist
+	// should be dropped and noted when hit in the conflict resolution
+	// path, rather than cause a panic.
+
+	ldb := OpenMemory()
+	folder := "default"
+	s := NewFileSet(folder, ldb)
+
+	var devA, devB, devC protocol.DeviceID
+	devA[0] = 'A'
+	devB[0] = 'B'
+	devC[0] = 'C'
+
+	// Two files with concurrent versions; B's is the newer.
+	s.Update(devA, []protocol.FileInfo{{
+		Name:      "foo",
+		Version:   protocol.Vector{Counters: []protocol.Counter{{ID: 1, Value: 1}}},
+		ModifiedS: 10,
+	}})
+	s.Update(devB, []protocol.FileInfo{{
+		Name:      "foo",
+		Version:   protocol.Vector{Counters: []protocol.Counter{{ID: 2, Value: 1}}},
+		ModifiedS: 20,
+	}})
+
+	// Remove A's file record directly, leaving its version list entry
+	// dangling.
+	if err := ldb.Delete(ldb.deviceKey([]byte(folder), devA[:], []byte("foo")), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A third concurrent version walks the version list and hits the
+	// dangling entry.
+	s.Update(devC, []protocol.FileInfo{{
+		Name:      "foo",
+		Version:   protocol.Vector{Counters: []protocol.Counter{{ID: 3, Value: 1}}},
+		ModifiedS: 15,
+	}})
+
+	dangling := ldb.TakeDanglingVersions()
+	if len(dangling) != 1 {
+		t.Fatalf("expected one dangling version, got %d", len(dangling))
+	}
+	if dangling[0].Folder != folder || dangling[0].File != "foo" || dangling[0].Device != devA {
+		t.Errorf("unexpected dangling version %+v", dangling[0])
+	}
+	if dangling := ldb.TakeDanglingVersions(); dangling != nil {
+		t.Errorf("dangling versions not cleared; got %+v", dangling)
+	}
+
+	// B's file should have remained the global version.
+	gf, ok := s.GetGlobal("foo")
+	if !ok {
+		t.Fatal("no global file after dropping dangling version")
+	}
+	if gf.ModifiedS != 20 {
+		t.Errorf("wrong global file after dropping dangling version: %+v", gf)
+	}
+}
+
+func TestDatabaseErrorPropagation(t *testing.T) {
+	ldb := OpenMemory()
+	s := NewFileSet("test", ldb)
+
+	v := protocol.Vector{Counters: []protocol.Counter{{ID: 1, Value: 1}}}
+	if err := s.Update(protocol.LocalDeviceID, []protocol.FileInfo{{Name: "a", Version: v}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ldb.Unhealthy(); err != nil {
+		t.Fatal("healthy database flagged unhealthy:", err)
+	}
+
+	// Close the database out from under the file set. Further updates
+	// should return an error instead of panicking, and the database should
+	// be flagged unhealthy.
+
+	ldb.Close()
+
+	var dev protocol.DeviceID
+	dev[0] = 'A'
+	if err := s.Update(dev, []protocol.FileInfo{{Name: "b", Version: v}}); err == nil {
+		t.Error("expected an error updating a closed database")
+	}
+	if ldb.Unhealthy() == nil {
+		t.Error("database should be flagged unhealthy")
+	}
+}
+
+func TestBatchSizeTuning(t *testing.T) {
+	db := OpenMemory()
+
+	if db.batchFlushRecords() != defaultBatchFlushSize {
+		t.Errorf("unexpected default flush size %d", db.batchFlushRecords())
+	}
+	if db.batchMaxSize() != defaultMaxBatchSize {
+		t.Errorf("unexpected default max size %d", db.batchMaxSize())
+	}
+
+	db.SetBatchSizes(128, 512<<10)
+	if db.batchFlushRecords() != 128 {
+		t.Errorf("unexpected tuned flush size %d", db.batchFlushRecords())
+	}
+	if db.batchMaxSize() != 512<<10 {
+		t.Errorf("unexpected tuned max size %d", db.batchMaxSize())
+	}
+
+	// Zero values keep the current settings.
+	db.SetBatchSizes(0, 0)
+	if db.batchFlushRecords() != 128 || db.batchMaxSize() != 512<<10 {
+		t.Error("zero values should keep the current settings")
+	}
+
+	// Bulk mode enlarges the thresholds, and nests.
+	db.StartBulk()
+	db.StartBulk()
+	if db.batchFlushRecords() != 128*bulkBatchFactor {
+		t.Errorf("unexpected bulk flush size %d", db.batchFlushRecords())
+	}
+	db.EndBulk()
+	if db.batchFlushRecords() != 128*bulkBatchFactor {
+		t.Error("nested bulk mode should stay in effect")
+	}
+	db.EndBulk()
+	if db.batchFlushRecords() != 128 {
+		t.Errorf("unexpected flush size after bulk %d", db.batchFlushRecords())
+	}
+}
+
+func TestTransactionStats(t *testing.T) {
+	db := OpenMemory()
+
+	tx, err := db.newReadWriteTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Put([]byte("key1"), []byte("value"))
+	tx.Put([]byte("key2"), []byte("value"))
+	tx.Delete([]byte("key1"))
+	if err := tx.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := db.TransactionStats()
+	if stats.Puts != 2 {
+		t.Errorf("expected 2 puts, got %d", stats.Puts)
+	}
+	if stats.Deletes != 1 {
+		t.Errorf("expected 1 delete, got %d", stats.Deletes)
+	}
+	if stats.Flushes != 1 {
+		t.Errorf("expected 1 flush, got %d", stats.Flushes)
+	}
+	if stats.SlowTransactions != 0 {
+		t.Errorf("expected no slow transactions, got %d", stats.SlowTransactions)
+	}
+
+	// With a one nanosecond threshold, any transaction is slow.
+	db.SetSlowTransactionThreshold(1)
+	tx, err = db.newReadWriteTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Put([]byte("key3"), []byte("value"))
+	if err := tx.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	stats = db.TransactionStats()
+	if stats.SlowTransactions != 1 {
+		t.Errorf("expected 1 slow transaction, got %d", stats.SlowTransactions)
+	}
+	if stats.CommitTime <= 0 {
+		t.Errorf("expected nonzero commit time, got %v", stats.CommitTime)
+	}
+}
@@ -8,19 +8,69 @@ package db
 
 import (
 	"bytes"
+	"fmt"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/syncthing/syncthing/lib/protocol"
 	"github.com/syndtr/goleveldb/leveldb"
 )
 
+// TransactionStats describes the write transactions performed against the
+// database since it was opened.
+type TransactionStats struct {
+	Puts             int64         `json:"puts"`
+	Deletes          int64         `json:"deletes"`
+	Flushes          int64         `json:"flushes"`
+	CommitTime       time.Duration `json:"commitTime"`
+	SlowTransactions int64         `json:"slowTransactions"`
+}
+
+// TransactionStats returns statistics about write transactions against
+// this database, for judging whether index writes are a bottleneck.
+func (db *Instance) TransactionStats() TransactionStats {
+	return TransactionStats{
+		Puts:             atomic.LoadInt64(&db.txPuts),
+		Deletes:          atomic.LoadInt64(&db.txDeletes),
+		Flushes:          atomic.LoadInt64(&db.txFlushes),
+		CommitTime:       time.Duration(atomic.LoadInt64(&db.txCommitNs)),
+		SlowTransactions: atomic.LoadInt64(&db.txSlow),
+	}
+}
+
+// SetSlowTransactionThreshold makes write transactions taking longer than
+// the given duration log their details when they complete. Zero disables
+// the logging.
+func (db *Instance) SetSlowTransactionThreshold(d time.Duration) {
+	atomic.StoreInt64(&db.txSlowThresholdNs, int64(d))
+}
+
+// txCounters follows a single write transaction across its value copies,
+// for the slow transaction log.
+type txCounters struct {
+	started     time.Time
+	puts        int64
+	deletes     int64
+	flushes     int64
+	invalidated []string // device keys to drop from the file cache on commit
+}
+
 // A readOnlyTransaction represents a database snapshot.
 type readOnlyTransaction struct {
 	*leveldb.Snapshot
-	db *Instance
+	db       *Instance
+	cacheGen int64 // file cache generation when the snapshot was taken
 }
 
 func (db *Instance) newReadOnlyTransaction() readOnlyTransaction {
+	// The generation is sampled before the snapshot is taken; a commit
+	// sneaking in between the two makes the generation stale, which errs
+	// on the side of not caching.
+	var gen int64
+	if c := db.fileCache; c != nil {
+		gen = c.generation()
+	}
 	snap, err := db.GetSnapshot()
 	if err != nil {
 		panic(err)
@@ -28,6 +78,7 @@ func (db *Instance) newReadOnlyTransaction() readOnlyTransaction {
 	return readOnlyTransaction{
 		Snapshot: snap,
 		db:       db,
+		cacheGen: gen,
 	}
 }
 
@@ -36,7 +87,7 @@ func (t readOnlyTransaction) close() {
 }
 
 func (t readOnlyTransaction) getFile(folder, device, file []byte) (protocol.FileInfo, bool) {
-	return getFile(t, t.db.deviceKey(folder, device, file))
+	return t.db.cachedGetFile(t, t.db.deviceKey(folder, device, file), t.cacheGen)
 }
 
 // A readWriteTransaction is a readOnlyTransaction plus a batch for writes.
@@ -45,53 +96,138 @@ func (t readOnlyTransaction) getFile(folder, device, file []byte) (protocol.File
 type readWriteTransaction struct {
 	readOnlyTransaction
 	*leveldb.Batch
+	counters *txCounters
 }
 
-func (db *Instance) newReadWriteTransaction() readWriteTransaction {
-	t := db.newReadOnlyTransaction()
+func (db *Instance) newReadWriteTransaction() (readWriteTransaction, error) {
+	var gen int64
+	if c := db.fileCache; c != nil {
+		gen = c.generation()
+	}
+	snap, err := db.GetSnapshot()
+	if err != nil {
+		return readWriteTransaction{}, db.fail(err)
+	}
 	return readWriteTransaction{
-		readOnlyTransaction: t,
-		Batch:               new(leveldb.Batch),
+		readOnlyTransaction: readOnlyTransaction{
+			Snapshot: snap,
+			db:       db,
+			cacheGen: gen,
+		},
+		Batch:    new(leveldb.Batch),
+		counters: &txCounters{started: time.Now()},
+	}, nil
+}
+
+// Put shadows the batch method to count the operation.
+func (t readWriteTransaction) Put(key, value []byte) {
+	t.counters.puts++
+	t.invalidate(key)
+	t.Batch.Put(key, value)
+}
+
+// Delete shadows the batch method to count the operation.
+func (t readWriteTransaction) Delete(key []byte) {
+	t.counters.deletes++
+	t.invalidate(key)
+	t.Batch.Delete(key)
+}
+
+// invalidate drops the file cache entry for a device key that is about to
+// be written, and remembers the key so that it can be dropped again when
+// the batch commits.
+func (t readWriteTransaction) invalidate(key []byte) {
+	c := t.db.fileCache
+	if c == nil || len(key) == 0 || key[0] != KeyTypeDevice {
+		return
 	}
+	c.remove(key)
+	t.counters.invalidated = append(t.counters.invalidated, string(key))
 }
 
-func (t readWriteTransaction) close() {
-	t.flush()
+func (t readWriteTransaction) close() error {
+	err := t.flush()
 	t.readOnlyTransaction.close()
+
+	atomic.AddInt64(&t.db.txPuts, t.counters.puts)
+	atomic.AddInt64(&t.db.txDeletes, t.counters.deletes)
+	atomic.AddInt64(&t.db.txFlushes, t.counters.flushes)
+
+	if threshold := atomic.LoadInt64(&t.db.txSlowThresholdNs); threshold > 0 {
+		if elapsed := time.Since(t.counters.started); elapsed >= time.Duration(threshold) {
+			atomic.AddInt64(&t.db.txSlow, 1)
+			l.Infof("Slow database transaction: %d puts, %d deletes, %d flushes in %v", t.counters.puts, t.counters.deletes, t.counters.flushes, elapsed)
+		}
+	}
+
+	return err
 }
 
-func (t readWriteTransaction) checkFlush() {
-	if t.Batch.Len() > batchFlushSize {
-		t.flush()
+func (t readWriteTransaction) checkFlush() error {
+	if t.Batch.Len() > t.db.batchFlushRecords() {
+		if err := t.flush(); err != nil {
+			return err
+		}
 		t.Batch.Reset()
 	}
+	return nil
 }
 
-func (t readWriteTransaction) flush() {
+func (t readWriteTransaction) flush() error {
+	if t.Batch.Len() == 0 {
+		return nil
+	}
+	started := time.Now()
 	if err := t.db.Write(t.Batch, nil); err != nil {
-		panic(err)
+		return t.db.fail(err)
 	}
+	t.counters.flushes++
+	atomic.AddInt64(&t.db.txCommitNs, int64(time.Since(started)))
 	atomic.AddInt64(&t.db.committed, int64(t.Batch.Len()))
+
+	if c := t.db.fileCache; c != nil && len(t.counters.invalidated) > 0 {
+		c.commitRemove(t.counters.invalidated)
+		t.counters.invalidated = t.counters.invalidated[:0]
+	}
+	return nil
 }
 
-func (t readWriteTransaction) insertFile(folder, device []byte, file protocol.FileInfo) {
+func (t readWriteTransaction) insertFile(folder, device []byte, file protocol.FileInfo) error {
 	l.Debugf("insert; folder=%q device=%v %v", folder, protocol.DeviceIDFromBytes(device), file)
 
 	name := []byte(file.Name)
 	nk := t.db.deviceKey(folder, device, name)
-	t.Put(nk, mustMarshal(&file))
+	buf, err := marshalInto(&file, getMarshalBuffer())
+	if err != nil {
+		return t.db.fail(err)
+	}
+	t.Put(nk, buf)
+	putMarshalBuffer(buf)
+
+	if bytes.Equal(device, protocol.LocalDeviceID[:]) && file.Sequence > 0 {
+		t.Put(t.db.sequenceKey(folder, file.Sequence), nk)
+	}
+	return nil
+}
+
+// removeSequence removes the sequence index entry for the given sequence
+// number, for when the local file it pointed at is replaced or removed.
+func (t readWriteTransaction) removeSequence(folder, device []byte, seq int64) {
+	if bytes.Equal(device, protocol.LocalDeviceID[:]) && seq > 0 {
+		t.Delete(t.db.sequenceKey(folder, seq))
+	}
 }
 
 // updateGlobal adds this device+version to the version list for the given
 // file. If the device is already present in the list, the version is updated.
 // If the file does not have an entry in the global list, it is created.
-func (t readWriteTransaction) updateGlobal(folder, device []byte, file protocol.FileInfo, globalSize *sizeTracker) bool {
+func (t readWriteTransaction) updateGlobal(folder, device []byte, file protocol.FileInfo, globalSize *sizeTracker) (bool, error) {
 	l.Debugf("update global; folder=%q device=%v file=%q version=%d", folder, protocol.DeviceIDFromBytes(device), file.Name, file.Version)
 	name := []byte(file.Name)
 	gk := t.db.globalKey(folder, name)
 	svl, err := t.Get(gk, nil)
 	if err != nil && err != leveldb.ErrNotFound {
-		panic(err)
+		return false, t.db.fail(err)
 	}
 
 	var fl VersionList
@@ -101,14 +237,14 @@ func (t readWriteTransaction) updateGlobal(folder, device []byte, file protocol.
 	if len(svl) != 0 {
 		err = fl.Unmarshal(svl)
 		if err != nil {
-			panic(err)
+			return false, t.db.fail(err)
 		}
 
 		for i := range fl.Versions {
 			if bytes.Equal(fl.Versions[i].Device, device) {
 				if fl.Versions[i].Version.Equal(file.Version) {
 					// No need to do anything
-					return false
+					return false, nil
 				}
 
 				if i == 0 {
@@ -131,7 +267,7 @@ func (t readWriteTransaction) updateGlobal(folder, device []byte, file protocol.
 	insertedAt := -1
 	// Find a position in the list to insert this file. The file at the front
 	// of the list is the newer, the "global".
-	for i := range fl.Versions {
+	for i := 0; i < len(fl.Versions); i++ {
 		switch fl.Versions[i].Version.Compare(file.Version) {
 		case protocol.Equal, protocol.Lesser:
 			// The version at this point in the list is equal to or lesser
@@ -149,7 +285,14 @@ func (t readWriteTransaction) updateGlobal(folder, device []byte, file protocol.
 			// to determine the winner.)
 			of, ok := t.getFile(folder, fl.Versions[i].Device, name)
 			if !ok {
-				panic("file referenced in version list does not exist")
+				// The version list references a file record that does not
+				// exist. This should not happen, but the database may have
+				// been damaged. Drop the dangling entry and note it so the
+				// device's index can be requested anew.
+				t.db.noteDanglingVersion(folder, fl.Versions[i].Device, name)
+				fl.Versions = append(fl.Versions[:i], fl.Versions[i+1:]...)
+				i--
+				continue
 			}
 			if file.WinsConflict(of) {
 				fl.Versions = insertVersion(fl.Versions, i, nv)
@@ -172,27 +315,49 @@ done:
 			if hasOldFile {
 				// We have the old file that was removed at the head of the list.
 				globalSize.removeFile(oldFile)
-			} else if len(fl.Versions) > 1 {
-				// The previous newest version is now at index 1, grab it from there.
-				oldFile, ok := t.getFile(folder, fl.Versions[1].Device, name)
-				if !ok {
-					panic("file referenced in version list does not exist")
+			} else {
+				// The previous newest version is now at index 1, grab it
+				// from there. Entries referencing file records that do not
+				// exist are dropped and noted, as above.
+				for len(fl.Versions) > 1 {
+					oldFile, ok := t.getFile(folder, fl.Versions[1].Device, name)
+					if ok {
+						globalSize.removeFile(oldFile)
+						break
+					}
+					t.db.noteDanglingVersion(folder, fl.Versions[1].Device, name)
+					fl.Versions = append(fl.Versions[:1], fl.Versions[2:]...)
 				}
-				globalSize.removeFile(oldFile)
 			}
 		}
 	}
 
+	// Entries for devices that have left the cluster can never become the
+	// global version again; drop them while the list is rewritten anyway.
+	// The head is left in place — it defines the current global version and
+	// the size accounting — for the dropDeviceVersions sweep to handle.
+	for i := 1; i < len(fl.Versions); i++ {
+		if !t.db.validDevice(folder, fl.Versions[i].Device) {
+			fl.Versions = append(fl.Versions[:i], fl.Versions[i+1:]...)
+			i--
+		}
+	}
+
 	l.Debugf("new global after update: %v", fl)
-	t.Put(gk, mustMarshal(&fl))
+	buf, err := marshalInto(&fl, getMarshalBuffer())
+	if err != nil {
+		return false, t.db.fail(err)
+	}
+	t.Put(gk, buf)
+	putMarshalBuffer(buf)
 
-	return true
+	return true, nil
 }
 
 // removeFromGlobal removes the device from the global version list for the
 // given file. If the version list is empty after this, the file entry is
 // removed entirely.
-func (t readWriteTransaction) removeFromGlobal(folder, device, file []byte, globalSize *sizeTracker) {
+func (t readWriteTransaction) removeFromGlobal(folder, device, file []byte, globalSize *sizeTracker) error {
 	l.Debugf("remove from global; folder=%q device=%v file=%q", folder, protocol.DeviceIDFromBytes(device), file)
 
 	gk := t.db.globalKey(folder, file)
@@ -200,13 +365,13 @@ func (t readWriteTransaction) removeFromGlobal(folder, device, file []byte, glob
 	if err != nil {
 		// We might be called to "remove" a global version that doesn't exist
 		// if the first update for the file is already marked invalid.
-		return
+		return nil
 	}
 
 	var fl VersionList
 	err = fl.Unmarshal(svl)
 	if err != nil {
-		panic(err)
+		return t.db.fail(err)
 	}
 
 	removed := false
@@ -215,7 +380,7 @@ func (t readWriteTransaction) removeFromGlobal(folder, device, file []byte, glob
 			if i == 0 && globalSize != nil {
 				f, ok := t.getFile(folder, device, file)
 				if !ok {
-					panic("removing nonexistent file")
+					return t.db.fail(fmt.Errorf("removing nonexistent file %q from global list", file))
 				}
 				globalSize.removeFile(f)
 				removed = true
@@ -225,19 +390,34 @@ func (t readWriteTransaction) removeFromGlobal(folder, device, file []byte, glob
 		}
 	}
 
+	// Drop non-head entries for devices that have left the cluster while
+	// the list is rewritten anyway; see updateGlobal.
+	for i := 1; i < len(fl.Versions); i++ {
+		if !t.db.validDevice(folder, fl.Versions[i].Device) {
+			fl.Versions = append(fl.Versions[:i], fl.Versions[i+1:]...)
+			i--
+		}
+	}
+
 	if len(fl.Versions) == 0 {
 		t.Delete(gk)
 	} else {
 		l.Debugf("new global after remove: %v", fl)
-		t.Put(gk, mustMarshal(&fl))
+		buf, err := marshalInto(&fl, getMarshalBuffer())
+		if err != nil {
+			return t.db.fail(err)
+		}
+		t.Put(gk, buf)
+		putMarshalBuffer(buf)
 		if removed {
 			f, ok := t.getFile(folder, fl.Versions[0].Device, file)
 			if !ok {
-				panic("new global is nonexistent file")
+				return t.db.fail(fmt.Errorf("new global for %q is a nonexistent file", file))
 			}
 			globalSize.addFile(f)
 		}
 	}
+	return nil
 }
 
 func insertVersion(vl []FileVersion, i int, v FileVersion) []FileVersion {
@@ -248,13 +428,37 @@ func insertVersion(vl []FileVersion, i int, v FileVersion) []FileVersion {
 }
 
 type marshaller interface {
-	Marshal() ([]byte, error)
+	MarshalTo([]byte) (int, error)
+	ProtoSize() int
 }
 
-func mustMarshal(f marshaller) []byte {
-	bs, err := f.Marshal()
+// marshalBuffers holds scratch buffers for marshalling database values,
+// so that inserting many files does not allocate one short-lived slice
+// per file.
+var marshalBuffers = sync.Pool{
+	New: func() interface{} { return make([]byte, 0, 1024) },
+}
+
+func getMarshalBuffer() []byte {
+	return marshalBuffers.Get().([]byte)
+}
+
+func putMarshalBuffer(buf []byte) {
+	marshalBuffers.Put(buf[:0])
+}
+
+// marshalInto marshals f into buf, growing it as needed, and returns the
+// resulting slice. The contents are only valid until the buffer is
+// reused; leveldb batches copy the value on Put so this is safe there.
+func marshalInto(f marshaller, buf []byte) ([]byte, error) {
+	size := f.ProtoSize()
+	if cap(buf) < size {
+		buf = make([]byte, size)
+	}
+	buf = buf[:size]
+	n, err := f.MarshalTo(buf)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	return bs
+	return buf[:n], nil
 }
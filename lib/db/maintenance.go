@@ -0,0 +1,170 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import (
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	// How often we consider running a compaction.
+	compactionCheckInterval = time.Hour
+	// How long the database must have been without writes before we
+	// consider it idle enough to compact.
+	compactionIdlePeriod = 10 * time.Minute
+	// The pause between compacting individual key ranges, to spread the
+	// I/O load over time.
+	compactionRangePause = time.Second
+)
+
+// CompactionStats describes the compactions performed by the scheduler.
+type CompactionStats struct {
+	Runs         int           `json:"runs"`
+	LastRun      time.Time     `json:"lastRun"`
+	LastDuration time.Duration `json:"lastDuration"`
+	InProgress   bool          `json:"inProgress"`
+}
+
+// CompactionStats returns statistics about scheduled compactions of this
+// database.
+func (db *Instance) CompactionStats() CompactionStats {
+	db.compactionMut.Lock()
+	defer db.compactionMut.Unlock()
+	return db.compaction
+}
+
+// Compact runs an immediate compaction of the entire database and records
+// it in the compaction statistics. Unlike the scheduler it does not pause
+// between key ranges, so it should only be triggered when a write stall is
+// acceptable.
+func (db *Instance) Compact() error {
+	l.Debugln("Starting requested database compaction")
+	started := time.Now()
+
+	db.compactionMut.Lock()
+	db.compaction.InProgress = true
+	db.compactionMut.Unlock()
+
+	err := db.CompactRange(util.Range{})
+	duration := time.Since(started)
+
+	db.compactionMut.Lock()
+	if err == nil {
+		db.compaction.Runs++
+		db.compaction.LastRun = started
+		db.compaction.LastDuration = duration
+	}
+	db.compaction.InProgress = false
+	db.compactionMut.Unlock()
+
+	if err != nil {
+		l.Infoln("Database compaction:", err)
+	}
+	return err
+}
+
+// A CompactionScheduler compacts the database during idle periods, one
+// key range at a time with pauses in between, so that the work doesn't
+// all pile up into a surprise write stall after large index churn. It
+// implements the suture.Service interface.
+type CompactionScheduler struct {
+	db   *Instance
+	stop chan struct{}
+}
+
+func NewCompactionScheduler(db *Instance) *CompactionScheduler {
+	return &CompactionScheduler{
+		db:   db,
+		stop: make(chan struct{}),
+	}
+}
+
+func (s *CompactionScheduler) Serve() {
+	timer := time.NewTimer(compactionCheckInterval)
+	defer timer.Stop()
+
+	// Committed() at the time of the previous check, to detect idleness,
+	// and at the time of the previous compaction, to detect that there is
+	// anything new to compact at all.
+	lastSeen := s.db.Committed()
+	lastCompacted := int64(-1)
+
+	for {
+		select {
+		case <-timer.C:
+			committed := s.db.Committed()
+			if committed != lastSeen {
+				// The database is in use; check again once it has had a
+				// chance to become idle.
+				lastSeen = committed
+				timer.Reset(compactionIdlePeriod)
+				continue
+			}
+			if committed == lastCompacted {
+				// Nothing has been written since the last compaction.
+				timer.Reset(compactionCheckInterval)
+				continue
+			}
+
+			s.compact()
+			lastCompacted = committed
+			lastSeen = s.db.Committed()
+			timer.Reset(compactionCheckInterval)
+
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *CompactionScheduler) Stop() {
+	close(s.stop)
+}
+
+// compact runs a compaction of the whole database, one single byte key
+// prefix at a time with a pause between each, to limit the I/O rate.
+func (s *CompactionScheduler) compact() {
+	l.Debugln("Starting scheduled database compaction")
+	started := time.Now()
+
+	s.db.compactionMut.Lock()
+	s.db.compaction.InProgress = true
+	s.db.compactionMut.Unlock()
+
+	for prefix := 0; prefix < 256; prefix++ {
+		r := util.Range{Start: []byte{byte(prefix)}}
+		if prefix < 255 {
+			r.Limit = []byte{byte(prefix + 1)}
+		}
+		if err := s.db.CompactRange(r); err != nil {
+			l.Infoln("Database compaction:", err)
+			break
+		}
+
+		select {
+		case <-time.After(compactionRangePause):
+		case <-s.stop:
+			// Stopped mid run; don't record a completed run.
+			s.db.compactionMut.Lock()
+			s.db.compaction.InProgress = false
+			s.db.compactionMut.Unlock()
+			return
+		}
+	}
+
+	duration := time.Since(started)
+	l.Debugln("Scheduled database compaction took", duration)
+
+	s.db.compactionMut.Lock()
+	s.db.compaction.Runs++
+	s.db.compaction.LastRun = started
+	s.db.compaction.LastDuration = duration
+	s.db.compaction.InProgress = false
+	s.db.compactionMut.Unlock()
+}
@@ -0,0 +1,175 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/syncthing/syncthing/lib/osutil"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// currentSchemaVersion is the version of the database layout this build
+// writes and understands. It is bumped whenever a migration is added to
+// the list below.
+const currentSchemaVersion = 1
+
+var schemaVersionKey = append([]byte{KeyTypeMiscData}, []byte("schemaVersion")...)
+
+// A migration converts the database from the previous schema version to
+// its own. Migrations run in order at open time. They must be idempotent,
+// as a crash during a migration means it runs again on the next start.
+type migration struct {
+	version     int64
+	description string
+	run         func(db *Instance) error
+}
+
+var migrations = []migration{
+	{1, "build the sequence index", migrateSequenceIndex},
+}
+
+// migrateSequenceIndex builds the per-folder sequence index, which did not
+// exist in schema version 0.
+func migrateSequenceIndex(db *Instance) error {
+	for _, folder := range db.ListFolders() {
+		db.checkSequences([]byte(folder))
+	}
+	return db.Unhealthy()
+}
+
+// SchemaVersion returns the schema version the database is at. Zero is the
+// layout from before versioning was introduced.
+func (db *Instance) SchemaVersion() int64 {
+	bs, err := db.Get(schemaVersionKey, nil)
+	if err != nil || len(bs) != 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(bs))
+}
+
+func (db *Instance) setSchemaVersion(v int64) error {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(v))
+	if err := db.Put(schemaVersionKey, buf, &opt.WriteOptions{Sync: true}); err != nil {
+		return db.fail(err)
+	}
+	return nil
+}
+
+// PendingMigrations returns a description of each migration that migrate
+// would apply, for showing the user what is about to happen without doing
+// it.
+func (db *Instance) PendingMigrations() []string {
+	v := db.SchemaVersion()
+	if v >= currentSchemaVersion || db.isEmpty() {
+		return nil
+	}
+
+	var pending []string
+	for _, m := range migrations {
+		if m.version > v {
+			pending = append(pending, fmt.Sprintf("schema version %d: %s", m.version, m.description))
+		}
+	}
+	return pending
+}
+
+// migrate brings the database up to the current schema version, applying
+// pending migrations in order. A database at a newer version than we
+// understand is refused.
+func (db *Instance) migrate() error {
+	v := db.SchemaVersion()
+	switch {
+	case v == currentSchemaVersion:
+		return nil
+	case v > currentSchemaVersion:
+		return fmt.Errorf("database schema version %d is newer than the supported version %d; created by a newer Syncthing?", v, currentSchemaVersion)
+	}
+
+	if db.isEmpty() {
+		// There is nothing to migrate in a fresh database; just record
+		// the version.
+		return db.setSchemaVersion(currentSchemaVersion)
+	}
+
+	for _, m := range migrations {
+		if m.version <= v {
+			continue
+		}
+		l.Infof("Migrating database to schema version %d (%s)...", m.version, m.description)
+		if err := m.run(db); err != nil {
+			return fmt.Errorf("migrating to schema version %d: %v", m.version, err)
+		}
+		if err := db.setSchemaVersion(m.version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateWithBackup is migrate with a copy of the database set aside
+// first. If a migration fails the database directory is restored from the
+// copy, so that a downgrade to the previous version finds it untouched.
+func (db *Instance) migrateWithBackup() error {
+	v := db.SchemaVersion()
+	if v == currentSchemaVersion || db.isEmpty() {
+		// No migrations will run, so no backup is needed.
+		return db.migrate()
+	}
+
+	backup := db.location + ".migration-backup"
+	os.RemoveAll(backup)
+	if err := copyDir(db.location, backup); err != nil {
+		return fmt.Errorf("creating database backup: %v", err)
+	}
+
+	if err := db.migrate(); err != nil {
+		l.Infoln("Migration failed; restoring database backup")
+		db.DB.Close()
+		if rerr := os.RemoveAll(db.location); rerr == nil {
+			os.Rename(backup, db.location)
+		}
+		return err
+	}
+
+	os.RemoveAll(backup)
+	return nil
+}
+
+// isEmpty returns true when the database contains no file records.
+func (db *Instance) isEmpty() bool {
+	dbi := db.NewIterator(util.BytesPrefix([]byte{KeyTypeDevice}), nil)
+	defer dbi.Release()
+	return !dbi.Next()
+}
+
+// copyDir copies the regular files in src into dst, which is created. A
+// database directory is flat, so no recursion is needed.
+func copyDir(src, dst string) error {
+	if err := os.MkdirAll(dst, 0700); err != nil {
+		return err
+	}
+	fis, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		if fi.IsDir() {
+			continue
+		}
+		if err := osutil.Copy(filepath.Join(src, fi.Name()), filepath.Join(dst, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import (
+	"testing"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+func TestMigrations(t *testing.T) {
+	ldb := OpenMemory()
+
+	// A fresh database is at the current schema version, with nothing
+	// pending.
+	if v := ldb.SchemaVersion(); v != currentSchemaVersion {
+		t.Errorf("fresh database at schema version %d, expected %d", v, currentSchemaVersion)
+	}
+	if pending := ldb.PendingMigrations(); len(pending) != 0 {
+		t.Errorf("unexpected pending migrations %q", pending)
+	}
+
+	s := NewFileSet("test", ldb)
+	local := []protocol.FileInfo{
+		{Name: "a", Version: protocol.Vector{Counters: []protocol.Counter{{ID: 1, Value: 1000}}}},
+		{Name: "b", Version: protocol.Vector{Counters: []protocol.Counter{{ID: 1, Value: 1000}}}},
+	}
+	if err := s.Replace(protocol.LocalDeviceID, local); err != nil {
+		t.Fatal(err)
+	}
+
+	// Fake a schema version zero database by removing the version key and
+	// the sequence index.
+	if err := ldb.Delete(schemaVersionKey, nil); err != nil {
+		t.Fatal(err)
+	}
+	dbi := ldb.NewIterator(util.BytesPrefix([]byte{KeyTypeSequence}), nil)
+	for dbi.Next() {
+		if err := ldb.Delete(dbi.Key(), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dbi.Release()
+
+	// The migration to the current version is now pending, as the
+	// database is not empty.
+	if pending := ldb.PendingMigrations(); len(pending) != 1 {
+		t.Fatalf("expected one pending migration, got %q", pending)
+	}
+
+	if err := ldb.migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := ldb.SchemaVersion(); v != currentSchemaVersion {
+		t.Errorf("migrated database at schema version %d, expected %d", v, currentSchemaVersion)
+	}
+
+	// The sequence index was rebuilt by the migration.
+	var seqs []int64
+	ldb.withHaveSequence([]byte("test"), 0, func(f FileIntf) bool {
+		seqs = append(seqs, f.(protocol.FileInfo).Sequence)
+		return true
+	})
+	if len(seqs) != 2 || seqs[0] != 1 || seqs[1] != 2 {
+		t.Errorf("unexpected sequences %v after migration", seqs)
+	}
+}
@@ -37,7 +37,7 @@ func (n *NamespacedKV) Reset() {
 	batch := new(leveldb.Batch)
 	for it.Next() {
 		batch.Delete(it.Key())
-		if batch.Len() > batchFlushSize {
+		if batch.Len() > n.db.batchFlushRecords() {
 			if err := n.db.Write(batch, nil); err != nil {
 				panic(err)
 			}
@@ -151,6 +151,25 @@ func (n NamespacedKV) Bool(key string) (bool, bool) {
 	return valBs[0] == 0x0, true
 }
 
+// WithPrefixedKV calls fn for each key-value pair in the namespace whose key
+// begins with the given prefix, in key order. Iteration stops early if fn
+// returns false.
+func (n *NamespacedKV) WithPrefixedKV(prefix string, fn func(key string, val []byte) bool) {
+	fullPrefix := make([]byte, 0, len(n.prefix)+len(prefix))
+	fullPrefix = append(fullPrefix, n.prefix...)
+	fullPrefix = append(fullPrefix, []byte(prefix)...)
+	it := n.db.NewIterator(util.BytesPrefix(fullPrefix), nil)
+	defer it.Release()
+	for it.Next() {
+		key := string(it.Key()[len(n.prefix):])
+		val := make([]byte, len(it.Value()))
+		copy(val, it.Value())
+		if !fn(key, val) {
+			break
+		}
+	}
+}
+
 // Delete deletes the specified key. It is allowed to delete a nonexistent
 // key.
 func (n NamespacedKV) Delete(key string) {
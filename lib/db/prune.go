@@ -0,0 +1,113 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import (
+	"bytes"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// SetFolderDevices records the devices currently part of the folder's
+// cluster. Version list entries for any other device are dropped when the
+// lists are rewritten, and wholesale by dropDeviceVersions sweeps. With no
+// device set registered for a folder, nothing is dropped.
+func (db *Instance) SetFolderDevices(folder string, devices []protocol.DeviceID) {
+	set := make(map[string]struct{}, len(devices))
+	for _, device := range devices {
+		set[string(device[:])] = struct{}{}
+	}
+	db.validMut.Lock()
+	db.validDevices[folder] = set
+	db.validMut.Unlock()
+}
+
+// validDevice reports whether the device is part of the folder's cluster.
+// The local device is always valid, as is everything in folders with no
+// registered device set.
+func (db *Instance) validDevice(folder, device []byte) bool {
+	if bytes.Equal(device, protocol.LocalDeviceID[:]) {
+		return true
+	}
+	db.validMut.Lock()
+	set, ok := db.validDevices[string(folder)]
+	db.validMut.Unlock()
+	if !ok {
+		return true
+	}
+	_, ok = set[string(device)]
+	return ok
+}
+
+// dropDeviceVersions removes the version list entries and file records of
+// devices that are no longer part of the folder's cluster, and returns the
+// number of version entries dropped. The callers are responsible for
+// rebuilding any size accounting afterwards.
+func (db *Instance) dropDeviceVersions(folder []byte) int {
+	t, err := db.newReadWriteTransaction()
+	if err != nil {
+		return 0
+	}
+	defer t.close()
+
+	dropped := 0
+
+	// Drop stale entries from the global version lists, and the file
+	// records they point at.
+	dbi := t.NewIterator(util.BytesPrefix(db.globalKey(folder, nil)[:keyPrefixLen+keyFolderLen]), nil)
+	for dbi.Next() {
+		var vl VersionList
+		if err := vl.Unmarshal(dbi.Value()); err != nil {
+			db.fail(err)
+			dbi.Release()
+			return dropped
+		}
+
+		name := db.globalKeyName(dbi.Key())
+		changed := false
+		for i := 0; i < len(vl.Versions); i++ {
+			if db.validDevice(folder, vl.Versions[i].Device) {
+				continue
+			}
+			t.Delete(db.deviceKey(folder, vl.Versions[i].Device, name))
+			vl.Versions = append(vl.Versions[:i], vl.Versions[i+1:]...)
+			i--
+			changed = true
+			dropped++
+		}
+		if !changed {
+			continue
+		}
+
+		if len(vl.Versions) == 0 {
+			t.Delete(dbi.Key())
+			continue
+		}
+		buf, err := marshalInto(&vl, getMarshalBuffer())
+		if err != nil {
+			db.fail(err)
+			dbi.Release()
+			return dropped
+		}
+		t.Put(dbi.Key(), buf)
+		putMarshalBuffer(buf)
+	}
+	dbi.Release()
+
+	// Invalid files are not in any global version list, so a second sweep
+	// over the file records catches what the first one missed.
+	dbi = t.NewIterator(util.BytesPrefix(db.folderScopedPrefix(KeyTypeDevice, folder)), nil)
+	for dbi.Next() {
+		if !db.validDevice(folder, db.deviceKeyDevice(dbi.Key())) {
+			t.Delete(dbi.Key())
+		}
+	}
+	dbi.Release()
+
+	return dropped
+}
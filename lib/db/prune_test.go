@@ -0,0 +1,72 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import (
+	"testing"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+func TestDropDeviceVersions(t *testing.T) {
+	ldb := OpenMemory()
+	fs := NewFileSet("test", ldb)
+
+	devA := protocol.DeviceID{0x0a}
+	devB := protocol.DeviceID{0x0b}
+	v1 := protocol.Vector{Counters: []protocol.Counter{{ID: 1, Value: 1000}}}
+	v2 := protocol.Vector{Counters: []protocol.Counter{{ID: 1, Value: 1001}}}
+
+	// Both devices have "a"; devA has the newer version. Only devB has
+	// "b".
+	fs.Update(devA, []protocol.FileInfo{
+		{Name: "a", Version: v2, Sequence: 1},
+	})
+	fs.Update(devB, []protocol.FileInfo{
+		{Name: "a", Version: v1, Sequence: 1},
+		{Name: "b", Version: v1, Sequence: 2},
+	})
+
+	// devB leaves the cluster.
+	fs.SetDevices([]protocol.DeviceID{devA})
+
+	if n := fs.DropDeviceVersions(); n != 2 {
+		t.Errorf("dropped %d version entries, expected 2", n)
+	}
+
+	if _, ok := fs.Get(devB, "a"); ok {
+		t.Error("unexpected file record for removed device")
+	}
+	if _, ok := fs.Get(devA, "a"); !ok {
+		t.Error("file record for remaining device went missing")
+	}
+	if _, ok := fs.GetGlobal("b"); ok {
+		t.Error("unexpected global entry for file only the removed device had")
+	}
+	if files, _, _ := fs.GlobalSize(); files != 1 {
+		t.Errorf("unexpected global size %d after prune", files)
+	}
+
+	// Version lists rewritten after the device set changed drop stale
+	// non-head entries on their own; a subsequent sweep only needs to
+	// clean up the file record.
+	fs.Update(devB, []protocol.FileInfo{
+		{Name: "c", Version: v1, Sequence: 3},
+	})
+	fs.Update(devA, []protocol.FileInfo{
+		{Name: "c", Version: v1, Sequence: 2},
+	})
+	fs.Update(devA, []protocol.FileInfo{
+		{Name: "c", Version: v2, Sequence: 3},
+	})
+	if n := fs.DropDeviceVersions(); n != 0 {
+		t.Errorf("dropped %d version entries, expected none", n)
+	}
+	if _, ok := fs.Get(devB, "c"); ok {
+		t.Error("unexpected file record for removed device after sweep")
+	}
+}
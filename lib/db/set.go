@@ -32,6 +32,9 @@ type FileSet struct {
 
 	remoteSequence map[protocol.DeviceID]int64 // Highest seen sequence numbers for other devices
 	updateMutex    sync.Mutex                  // protects remoteSequence and database updates
+
+	feeds     []*ChangeFeed
+	feedMutex sync.Mutex // protects feeds
 }
 
 // FileIntf is the set of methods implemented by both protocol.FileInfo and
@@ -91,6 +94,14 @@ func (s *sizeTracker) removeFile(f FileIntf) {
 	s.mut.Unlock()
 }
 
+func (s *sizeTracker) reset() {
+	s.mut.Lock()
+	s.files = 0
+	s.deleted = 0
+	s.bytes = 0
+	s.mut.Unlock()
+}
+
 func (s *sizeTracker) Size() (files, deleted int, bytes int64) {
 	s.mut.Lock()
 	defer s.mut.Unlock()
@@ -104,9 +115,11 @@ func NewFileSet(folder string, db *Instance) *FileSet {
 		db:             db,
 		blockmap:       NewBlockMap(db, db.folderIdx.ID([]byte(folder))),
 		updateMutex:    sync.NewMutex(),
+		feedMutex:      sync.NewMutex(),
 	}
 
 	s.db.checkGlobals([]byte(folder), &s.globalSize)
+	s.db.checkSequences([]byte(folder))
 
 	var deviceID protocol.DeviceID
 	s.db.withAllFolderTruncated([]byte(folder), func(device []byte, f FileInfoTruncated) bool {
@@ -121,12 +134,25 @@ func NewFileSet(folder string, db *Instance) *FileSet {
 		}
 		return true
 	})
+
+	// The file records themselves may understate how far we've seen a
+	// remote device, if the newest ones have since been dropped from the
+	// index. The persisted watermarks have the authoritative answer.
+	for device, seq := range s.db.watermarksForFolder([]byte(folder)) {
+		if seq > s.remoteSequence[device] {
+			s.remoteSequence[device] = seq
+		}
+	}
 	l.Debugf("loaded sequence for %q: %#v", folder, s.sequence)
 
 	return &s
 }
 
-func (s *FileSet) Replace(device protocol.DeviceID, fs []protocol.FileInfo) {
+// Replace replaces the device's file list with the given one. It returns
+// an error if the database could not be updated, in which case the
+// database is marked unhealthy and the caller should stop using the
+// folder.
+func (s *FileSet) Replace(device protocol.DeviceID, fs []protocol.FileInfo) error {
 	l.Debugf("%s Replace(%v, [%d])", s.folder, device, len(fs))
 	normalizeFilenames(fs)
 
@@ -146,15 +172,26 @@ func (s *FileSet) Replace(device protocol.DeviceID, fs []protocol.FileInfo) {
 		}
 	} else {
 		s.remoteSequence[device] = maxSequence(fs)
+		s.db.setWatermark(device[:], []byte(s.folder), s.remoteSequence[device])
+	}
+	if err := s.db.replace([]byte(s.folder), device[:], fs, &s.localSize, &s.globalSize); err != nil {
+		return err
 	}
-	s.db.replace([]byte(s.folder), device[:], fs, &s.localSize, &s.globalSize)
 	if device == protocol.LocalDeviceID {
-		s.blockmap.Drop()
-		s.blockmap.Add(fs)
+		if err := s.blockmap.Drop(); err != nil {
+			return s.db.fail(err)
+		}
+		if err := s.blockmap.Add(fs); err != nil {
+			return s.db.fail(err)
+		}
+		s.notifyFeeds()
 	}
+	return nil
 }
 
-func (s *FileSet) Update(device protocol.DeviceID, fs []protocol.FileInfo) {
+// Update applies changed file records for the device. Like Replace, it
+// returns an error if the database could not be updated.
+func (s *FileSet) Update(device protocol.DeviceID, fs []protocol.FileInfo) error {
 	l.Debugf("%s Update(%v, [%d])", s.folder, device, len(fs))
 	normalizeFilenames(fs)
 
@@ -172,22 +209,49 @@ func (s *FileSet) Update(device protocol.DeviceID, fs []protocol.FileInfo) {
 				updates = append(updates, newFile)
 			}
 		}
-		s.blockmap.Discard(discards)
-		s.blockmap.Update(updates)
-	} else {
-		s.remoteSequence[device] = maxSequence(fs)
+		if err := s.blockmap.Discard(discards); err != nil {
+			return s.db.fail(err)
+		}
+		if err := s.blockmap.Update(updates); err != nil {
+			return s.db.fail(err)
+		}
+	} else if seq := maxSequence(fs); seq > s.remoteSequence[device] {
+		s.remoteSequence[device] = seq
+		s.db.setWatermark(device[:], []byte(s.folder), seq)
+	}
+	if err := s.db.updateFiles([]byte(s.folder), device[:], fs, &s.localSize, &s.globalSize); err != nil {
+		return err
 	}
-	s.db.updateFiles([]byte(s.folder), device[:], fs, &s.localSize, &s.globalSize)
+	if device == protocol.LocalDeviceID {
+		s.notifyFeeds()
+	}
+	return nil
 }
 
 func (s *FileSet) WithNeed(device protocol.DeviceID, fn Iterator) {
 	l.Debugf("%s WithNeed(%v)", s.folder, device)
-	s.db.withNeed([]byte(s.folder), device[:], false, nativeFileIterator(fn))
+	s.db.withNeed([]byte(s.folder), device[:], nil, false, nativeFileIterator(fn))
 }
 
 func (s *FileSet) WithNeedTruncated(device protocol.DeviceID, fn Iterator) {
 	l.Debugf("%s WithNeedTruncated(%v)", s.folder, device)
-	s.db.withNeed([]byte(s.folder), device[:], true, nativeFileIterator(fn))
+	s.db.withNeed([]byte(s.folder), device[:], nil, true, nativeFileIterator(fn))
+}
+
+// WithPrefixedNeedTruncated calls fn for the files needed by the given
+// device under the given subdirectory prefix only, without touching the
+// rest of the folder's need list.
+func (s *FileSet) WithPrefixedNeedTruncated(device protocol.DeviceID, prefix string, fn Iterator) {
+	l.Debugf("%s WithPrefixedNeedTruncated(%v, %q)", s.folder, device, prefix)
+	s.db.withNeed([]byte(s.folder), device[:], []byte(osutil.NormalizedFilename(prefix)), true, nativeFileIterator(fn))
+}
+
+// WithHaveSequence calls fn for the local files with a sequence number
+// equal to or higher than startSeq, in sequence order. The fn is called
+// with protocol.FileInfo values.
+func (s *FileSet) WithHaveSequence(startSeq int64, fn Iterator) {
+	l.Debugf("%s WithHaveSequence(%d)", s.folder, startSeq)
+	s.db.withHaveSequence([]byte(s.folder), startSeq, nativeFileIterator(fn))
 }
 
 func (s *FileSet) WithHave(device protocol.DeviceID, fn Iterator) {
@@ -219,6 +283,25 @@ func (s *FileSet) WithPrefixedGlobalTruncated(prefix string, fn Iterator) {
 	s.db.withGlobal([]byte(s.folder), []byte(osutil.NormalizedFilename(prefix)), true, nativeFileIterator(fn))
 }
 
+// WithPrefixedGlobalTruncatedPaged is WithPrefixedGlobalTruncated limited
+// to one page of the listing: the first offset files under the prefix are
+// skipped, and iteration stops after limit files have been passed to fn.
+// A limit of zero or less means no limit. Only the files on the page are
+// passed to fn, so the whole listing is never held in memory at once,
+// which makes this suitable for paging through very large directories.
+func (s *FileSet) WithPrefixedGlobalTruncatedPaged(prefix string, offset, limit int, fn Iterator) {
+	l.Debugf("%s WithPrefixedGlobalTruncatedPaged(%q, %d, %d)", s.folder, prefix, offset, limit)
+	s.db.withGlobal([]byte(s.folder), []byte(osutil.NormalizedFilename(prefix)), true, pagedIterator(offset, limit, nativeFileIterator(fn)))
+}
+
+// WithPrefixedHaveTruncatedPaged is the paged version of
+// WithPrefixedHaveTruncated, with the same semantics as
+// WithPrefixedGlobalTruncatedPaged.
+func (s *FileSet) WithPrefixedHaveTruncatedPaged(device protocol.DeviceID, prefix string, offset, limit int, fn Iterator) {
+	l.Debugf("%s WithPrefixedHaveTruncatedPaged(%v, %q, %d, %d)", s.folder, device, prefix, offset, limit)
+	s.db.withHave([]byte(s.folder), device[:], []byte(osutil.NormalizedFilename(prefix)), true, pagedIterator(offset, limit, nativeFileIterator(fn)))
+}
+
 func (s *FileSet) Get(device protocol.DeviceID, file string) (protocol.FileInfo, bool) {
 	f, ok := s.db.getFile([]byte(s.folder), device[:], []byte(osutil.NormalizedFilename(file)))
 	f.Name = osutil.NativeFilename(f.Name)
@@ -267,6 +350,48 @@ func (s *FileSet) GlobalSize() (files, deleted int, bytes int64) {
 	return s.globalSize.Size()
 }
 
+// RecalculateSizes rebuilds the local and global size accounting from the
+// database contents, for use after an integrity repair has modified the
+// index behind our back.
+func (s *FileSet) RecalculateSizes() {
+	s.updateMutex.Lock()
+	defer s.updateMutex.Unlock()
+	s.recalculateSizesLocked()
+}
+
+func (s *FileSet) recalculateSizesLocked() {
+	s.globalSize.reset()
+	s.db.checkGlobals([]byte(s.folder), &s.globalSize)
+
+	s.localSize.reset()
+	s.db.withHave([]byte(s.folder), protocol.LocalDeviceID[:], nil, true, func(f FileIntf) bool {
+		s.localSize.addFile(f)
+		return true
+	})
+}
+
+// SetDevices records which devices are part of the folder's cluster.
+// Version list entries and file records for other devices are stale and
+// may be dropped.
+func (s *FileSet) SetDevices(devices []protocol.DeviceID) {
+	s.db.SetFolderDevices(s.folder, devices)
+}
+
+// DropDeviceVersions removes the version list entries and file records of
+// all devices that are not part of the folder's cluster, as recorded with
+// SetDevices, and repairs the size accounting. It returns the number of
+// version entries dropped.
+func (s *FileSet) DropDeviceVersions() int {
+	s.updateMutex.Lock()
+	defer s.updateMutex.Unlock()
+
+	n := s.db.dropDeviceVersions([]byte(s.folder))
+	if n > 0 {
+		s.recalculateSizesLocked()
+	}
+	return n
+}
+
 func (s *FileSet) IndexID(device protocol.DeviceID) protocol.IndexID {
 	id := s.db.getIndexID(device[:], []byte(s.folder))
 	if id == 0 && device == protocol.LocalDeviceID {
@@ -284,6 +409,13 @@ func (s *FileSet) SetIndexID(device protocol.DeviceID, id protocol.IndexID) {
 	s.db.setIndexID(device[:], []byte(s.folder), id)
 }
 
+// TakeDanglingVersions returns the dangling version list entries dropped
+// from the underlying database since the previous call, and clears the
+// list. The entries may belong to any folder sharing the database.
+func (s *FileSet) TakeDanglingVersions() []DanglingVersion {
+	return s.db.TakeDanglingVersions()
+}
+
 func (s *FileSet) MtimeFS() *fs.MtimeFS {
 	prefix := s.db.mtimesKey([]byte(s.folder))
 	kv := NewNamespacedKV(s.db, string(prefix))
@@ -334,6 +466,23 @@ func normalizeFilenames(fs []protocol.FileInfo) {
 	}
 }
 
+// pagedIterator wraps fn so that the first offset files are skipped and
+// iteration stops once limit files have been passed on. A limit of zero
+// or less means no limit.
+func pagedIterator(offset, limit int, fn Iterator) Iterator {
+	n := 0
+	return func(f FileIntf) bool {
+		n++
+		if n <= offset {
+			return true
+		}
+		if limit > 0 && n > offset+limit {
+			return false
+		}
+		return fn(f)
+	}
+}
+
 func nativeFileIterator(fn Iterator) Iterator {
 	return func(fi FileIntf) bool {
 		switch f := fi.(type) {
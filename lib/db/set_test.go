@@ -9,7 +9,9 @@ package db_test
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sort"
 	"testing"
 
@@ -719,3 +721,328 @@ func TestIndexID(t *testing.T) {
 		t.Errorf("index ID changed; %d != %d", again, id)
 	}
 }
+
+func TestRemoteSequenceWatermark(t *testing.T) {
+	ldb := db.OpenMemory()
+
+	s := db.NewFileSet("test", ldb)
+
+	version := protocol.Vector{Counters: []protocol.Counter{{ID: 42, Value: 1000}}}
+	s.Update(remoteDevice0, []protocol.FileInfo{
+		{Name: "a", Sequence: 2, Version: version, Blocks: genBlocks(1)},
+		{Name: "b", Sequence: 5, Version: version, Blocks: genBlocks(2)},
+	})
+
+	if seq := s.Sequence(remoteDevice0); seq != 5 {
+		t.Errorf("sequence %d != 5", seq)
+	}
+
+	// An update with only lower sequence numbers must not make the
+	// watermark regress.
+	s.Update(remoteDevice0, []protocol.FileInfo{
+		{Name: "c", Sequence: 3, Version: version, Blocks: genBlocks(1)},
+	})
+	if seq := s.Sequence(remoteDevice0); seq != 5 {
+		t.Errorf("sequence %d != 5 after out of order update", seq)
+	}
+
+	// The watermark survives reopening the file set, even when the
+	// newest records have been dropped from the index in the meantime.
+	s.SetDevices(nil)
+	s.DropDeviceVersions()
+
+	s = db.NewFileSet("test", ldb)
+	if seq := s.Sequence(remoteDevice0); seq != 5 {
+		t.Errorf("sequence %d != 5 after reopen", seq)
+	}
+
+	// A replace resets it.
+	s.Replace(remoteDevice0, []protocol.FileInfo{
+		{Name: "a", Sequence: 2, Version: version, Blocks: genBlocks(1)},
+	})
+	if seq := s.Sequence(remoteDevice0); seq != 2 {
+		t.Errorf("sequence %d != 2 after replace", seq)
+	}
+}
+
+func sequenceList(s *db.FileSet, startSeq int64) []protocol.FileInfo {
+	var fs []protocol.FileInfo
+	s.WithHaveSequence(startSeq, func(fi db.FileIntf) bool {
+		fs = append(fs, fi.(protocol.FileInfo))
+		return true
+	})
+	return fs
+}
+
+func TestWithHaveSequence(t *testing.T) {
+	ldb := db.OpenMemory()
+
+	s := db.NewFileSet("test", ldb)
+
+	local := []protocol.FileInfo{
+		{Name: "a", Version: protocol.Vector{Counters: []protocol.Counter{{ID: myID, Value: 1000}}}, Blocks: genBlocks(1)},
+		{Name: "b", Version: protocol.Vector{Counters: []protocol.Counter{{ID: myID, Value: 1000}}}, Blocks: genBlocks(2)},
+		{Name: "c", Version: protocol.Vector{Counters: []protocol.Counter{{ID: myID, Value: 1000}}}, Blocks: genBlocks(3)},
+	}
+	s.Replace(protocol.LocalDeviceID, local)
+
+	fs := sequenceList(s, 1)
+	if len(fs) != 3 {
+		t.Fatalf("expected 3 files, got %d", len(fs))
+	}
+	for i, f := range fs {
+		if f.Sequence != int64(i+1) {
+			t.Errorf("wrong sequence %d for %q at position %d", f.Sequence, f.Name, i)
+		}
+	}
+
+	// Updating a file supersedes its old position in the feed.
+	b := local[1]
+	b.Version = b.Version.Update(myID)
+	s.Update(protocol.LocalDeviceID, []protocol.FileInfo{b})
+
+	fs = sequenceList(s, 1)
+	if len(fs) != 3 {
+		t.Fatalf("expected 3 files, got %d", len(fs))
+	}
+	if fs[0].Name != "a" || fs[1].Name != "c" || fs[2].Name != "b" {
+		t.Errorf("wrong order: %q, %q, %q", fs[0].Name, fs[1].Name, fs[2].Name)
+	}
+	if fs[2].Sequence != 4 {
+		t.Errorf("expected sequence 4 for updated file, got %d", fs[2].Sequence)
+	}
+
+	// Starting mid-feed skips the earlier entries.
+	fs = sequenceList(s, 3)
+	if len(fs) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(fs))
+	}
+	if fs[0].Name != "c" || fs[1].Name != "b" {
+		t.Errorf("wrong order: %q, %q", fs[0].Name, fs[1].Name)
+	}
+
+	// The index survives reopening the set.
+	s = db.NewFileSet("test", ldb)
+	if fs = sequenceList(s, 1); len(fs) != 3 {
+		t.Fatalf("expected 3 files after reopen, got %d", len(fs))
+	}
+}
+
+func TestChangeFeed(t *testing.T) {
+	ldb := db.OpenMemory()
+
+	s := db.NewFileSet("test", ldb)
+	local := []protocol.FileInfo{
+		{Name: "a", Version: protocol.Vector{Counters: []protocol.Counter{{ID: myID, Value: 1000}}}, Blocks: genBlocks(1)},
+		{Name: "b", Version: protocol.Vector{Counters: []protocol.Counter{{ID: myID, Value: 1000}}}, Blocks: genBlocks(2)},
+	}
+	s.Replace(protocol.LocalDeviceID, local)
+
+	feed := s.NewChangeFeed(0)
+	defer feed.Close()
+
+	// A new feed starts out signalled, as there may be changes to pick up.
+	select {
+	case <-feed.C():
+	default:
+		t.Fatal("expected feed to start out signalled")
+	}
+
+	var names []string
+	seq := feed.Next(func(fi db.FileIntf) bool {
+		names = append(names, fi.FileName())
+		return true
+	})
+	if len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Errorf("unexpected changes %q", names)
+	}
+	if seq != 2 {
+		t.Errorf("expected resume sequence 2, got %d", seq)
+	}
+
+	// New changes signal the feed and are returned by the next call.
+	a := local[0]
+	a.Version = a.Version.Update(myID)
+	s.Update(protocol.LocalDeviceID, []protocol.FileInfo{a})
+
+	select {
+	case <-feed.C():
+	default:
+		t.Fatal("expected feed to be signalled after update")
+	}
+
+	names = nil
+	seq = feed.Next(func(fi db.FileIntf) bool {
+		names = append(names, fi.FileName())
+		return true
+	})
+	if len(names) != 1 || names[0] != "a" {
+		t.Errorf("unexpected changes %q", names)
+	}
+
+	// Remote updates don't appear in the feed.
+	s.Update(remoteDevice0, []protocol.FileInfo{{Name: "x", Sequence: 1, Version: protocol.Vector{Counters: []protocol.Counter{{ID: 42, Value: 1000}}}, Blocks: genBlocks(1)}})
+	names = nil
+	feed.Next(func(fi db.FileIntf) bool {
+		names = append(names, fi.FileName())
+		return true
+	})
+	if len(names) != 0 {
+		t.Errorf("unexpected changes %q", names)
+	}
+
+	// A feed resumed from the returned sequence picks up where we left off.
+	resumed := s.NewChangeFeed(seq)
+	defer resumed.Close()
+	b := local[1]
+	b.Version = b.Version.Update(myID)
+	s.Update(protocol.LocalDeviceID, []protocol.FileInfo{b})
+	names = nil
+	resumed.Next(func(fi db.FileIntf) bool {
+		names = append(names, fi.FileName())
+		return true
+	})
+	if len(names) != 1 || names[0] != "b" {
+		t.Errorf("unexpected changes %q", names)
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syncthing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	loc := filepath.Join(dir, "index")
+
+	ldb, err := db.Open(loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := db.NewFileSet("test", ldb)
+	local := []protocol.FileInfo{
+		{Name: "a", Version: protocol.Vector{Counters: []protocol.Counter{{ID: myID, Value: 1000}}}},
+		{Name: "b", Version: protocol.Vector{Counters: []protocol.Counter{{ID: myID, Value: 1000}}}},
+	}
+	if err := s.Replace(protocol.LocalDeviceID, local); err != nil {
+		t.Fatal(err)
+	}
+	ldb.Close()
+
+	rdb, err := db.OpenReadOnly(loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rdb.Close()
+
+	if folders := rdb.ListFolders(); len(folders) != 1 || folders[0] != "test" {
+		t.Errorf("unexpected folders %q", folders)
+	}
+
+	var names []string
+	rdb.WithGlobalTruncated("test", func(f db.FileIntf) bool {
+		names = append(names, f.FileName())
+		return true
+	})
+	if len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Errorf("unexpected global files %q", names)
+	}
+
+	names = nil
+	rdb.WithHaveTruncated("test", protocol.LocalDeviceID, func(f db.FileIntf) bool {
+		names = append(names, f.FileName())
+		return true
+	})
+	if len(names) != 2 {
+		t.Errorf("unexpected have files %q", names)
+	}
+
+	// Writes fail in read-only mode.
+	if err := rdb.Put([]byte{0xff, 0xff}, nil, nil); err == nil {
+		t.Error("expected writes to a read-only database to fail")
+	}
+}
+
+func TestPagedGlobal(t *testing.T) {
+	ldb := db.OpenMemory()
+	s := db.NewFileSet("test", ldb)
+
+	var local []protocol.FileInfo
+	for i := 0; i < 10; i++ {
+		local = append(local, protocol.FileInfo{
+			Name:    fmt.Sprintf("dir/file%02d", i),
+			Version: protocol.Vector{Counters: []protocol.Counter{{ID: myID, Value: 1000}}},
+		})
+	}
+	if err := s.Replace(protocol.LocalDeviceID, local); err != nil {
+		t.Fatal(err)
+	}
+
+	page := func(offset, limit int) []string {
+		var names []string
+		s.WithPrefixedGlobalTruncatedPaged("dir", offset, limit, func(f db.FileIntf) bool {
+			names = append(names, f.FileName())
+			return true
+		})
+		return names
+	}
+
+	if names := page(0, 3); len(names) != 3 || names[0] != "dir/file00" || names[2] != "dir/file02" {
+		t.Errorf("unexpected first page %q", names)
+	}
+	if names := page(3, 3); len(names) != 3 || names[0] != "dir/file03" {
+		t.Errorf("unexpected second page %q", names)
+	}
+	// The last page may be short.
+	if names := page(9, 3); len(names) != 1 || names[0] != "dir/file09" {
+		t.Errorf("unexpected last page %q", names)
+	}
+	// Offset beyond the end gives an empty page.
+	if names := page(20, 3); len(names) != 0 {
+		t.Errorf("unexpected page beyond the end %q", names)
+	}
+	// Zero limit means no limit.
+	if names := page(0, 0); len(names) != 10 {
+		t.Errorf("unexpected unlimited page %q", names)
+	}
+}
+
+func TestPrefixedNeed(t *testing.T) {
+	ldb := db.OpenMemory()
+	m := db.NewFileSet("test", ldb)
+
+	local := []protocol.FileInfo{
+		{Name: "dir/a", Version: protocol.Vector{Counters: []protocol.Counter{{ID: myID, Value: 1000}}}},
+	}
+	remote := []protocol.FileInfo{
+		{Name: "dir/a", Version: protocol.Vector{Counters: []protocol.Counter{{ID: myID, Value: 1001}}}},
+		{Name: "dir/b", Version: protocol.Vector{Counters: []protocol.Counter{{ID: myID, Value: 1000}}}},
+		{Name: "dirx/c", Version: protocol.Vector{Counters: []protocol.Counter{{ID: myID, Value: 1000}}}},
+		{Name: "other/d", Version: protocol.Vector{Counters: []protocol.Counter{{ID: myID, Value: 1000}}}},
+	}
+
+	m.Replace(protocol.LocalDeviceID, local)
+	m.Replace(remoteDevice0, remote)
+
+	prefixedNeed := func(prefix string) []string {
+		var names []string
+		m.WithPrefixedNeedTruncated(protocol.LocalDeviceID, prefix, func(f db.FileIntf) bool {
+			names = append(names, f.FileName())
+			return true
+		})
+		return names
+	}
+
+	// Only the files under the prefix are needed; "dirx" must not match
+	// the prefix "dir".
+	if names := prefixedNeed("dir"); len(names) != 2 || names[0] != "dir/a" || names[1] != "dir/b" {
+		t.Errorf("unexpected needed files %q", names)
+	}
+	if names := prefixedNeed("other"); len(names) != 1 || names[0] != "other/d" {
+		t.Errorf("unexpected needed files %q", names)
+	}
+	// An empty prefix means the whole folder.
+	if names := prefixedNeed(""); len(names) != 4 {
+		t.Errorf("unexpected needed files %q", names)
+	}
+}
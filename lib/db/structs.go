@@ -63,3 +63,14 @@ func (f FileInfoTruncated) FileName() string {
 func (f FileInfoTruncated) ModTime() time.Time {
 	return time.Unix(f.ModifiedS, int64(f.ModifiedNs))
 }
+
+// MetadataValue returns the value of the given metadata key, or the empty
+// string if the key is not present.
+func (f FileInfoTruncated) MetadataValue(key string) string {
+	for _, kv := range f.Metadata {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,143 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import (
+	"sort"
+	"sync/atomic"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// A Transaction collects file updates against several FileSets backed by
+// the same database and commits them in a single leveldb batch. An
+// operation spanning folders — moving a file from one synced folder to
+// another, say — thus becomes visible in both folders at once, with no
+// window where only one side is updated.
+type Transaction struct {
+	db      *Instance
+	updates []transactionUpdate
+}
+
+type transactionUpdate struct {
+	fs     *FileSet
+	device protocol.DeviceID
+	files  []protocol.FileInfo
+}
+
+// NewTransaction returns an empty transaction against the given database.
+func NewTransaction(db *Instance) *Transaction {
+	return &Transaction{db: db}
+}
+
+// Update queues changed file records for the device in the given FileSet,
+// with the same semantics as FileSet.Update, to be applied on Commit. The
+// FileSet must be backed by the transaction's database.
+func (t *Transaction) Update(fs *FileSet, device protocol.DeviceID, files []protocol.FileInfo) {
+	if fs.db != t.db {
+		panic("bug: FileSet from a different database in transaction")
+	}
+	t.updates = append(t.updates, transactionUpdate{fs, device, files})
+}
+
+// Commit applies the queued updates and writes them to the database as a
+// single batch. On error nothing is committed and, as for FileSet.Update,
+// the database is marked unhealthy and the callers should stop using the
+// affected folders.
+func (t *Transaction) Commit() error {
+	if len(t.updates) == 0 {
+		return nil
+	}
+
+	// Take the update locks of all involved FileSets, in a stable order so
+	// that concurrent transactions cannot deadlock.
+	sets := t.fileSets()
+	for _, fs := range sets {
+		fs.updateMutex.Lock()
+		defer fs.updateMutex.Unlock()
+	}
+
+	wt, err := t.db.newReadWriteTransaction()
+	if err != nil {
+		return err
+	}
+
+	for _, u := range t.updates {
+		if err := t.apply(wt, u); err != nil {
+			// Abandon the batch; nothing is committed on error.
+			wt.Batch.Reset()
+			wt.close()
+			return err
+		}
+	}
+	if err := wt.close(); err != nil {
+		return err
+	}
+
+	for _, fs := range sets {
+		for _, u := range t.updates {
+			if u.fs == fs && u.device == protocol.LocalDeviceID {
+				fs.notifyFeeds()
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// apply queues a single update on the write transaction, mirroring
+// FileSet.Update except that nothing is flushed.
+func (t *Transaction) apply(wt readWriteTransaction, u transactionUpdate) error {
+	s := u.fs
+	l.Debugf("%s Update(%v, [%d]) in transaction", s.folder, u.device, len(u.files))
+	normalizeFilenames(u.files)
+
+	if u.device == protocol.LocalDeviceID {
+		discards := make([]protocol.FileInfo, 0, len(u.files))
+		updates := make([]protocol.FileInfo, 0, len(u.files))
+		for i, newFile := range u.files {
+			u.files[i].Sequence = atomic.AddInt64(&s.sequence, 1)
+			existingFile, ok := s.db.getFile([]byte(s.folder), u.device[:], []byte(newFile.Name))
+			if !ok || !existingFile.Version.Equal(newFile.Version) {
+				discards = append(discards, existingFile)
+				updates = append(updates, newFile)
+			}
+		}
+		s.blockmap.discardBatch(wt, discards)
+		s.blockmap.updateBatch(wt, updates)
+	} else if seq := maxSequence(u.files); seq > s.remoteSequence[u.device] {
+		s.remoteSequence[u.device] = seq
+		s.db.setWatermark(u.device[:], []byte(s.folder), seq)
+	}
+	return s.db.updateFilesInTransaction(wt, []byte(s.folder), u.device[:], u.files, &s.localSize, &s.globalSize, false)
+}
+
+// fileSets returns the distinct FileSets touched by the transaction,
+// sorted by folder, which is the lock order.
+func (t *Transaction) fileSets() []*FileSet {
+	var sets []*FileSet
+	for _, u := range t.updates {
+		seen := false
+		for _, fs := range sets {
+			if fs == u.fs {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			sets = append(sets, u.fs)
+		}
+	}
+	sort.Sort(fileSetsByFolder(sets))
+	return sets
+}
+
+type fileSetsByFolder []*FileSet
+
+func (s fileSetsByFolder) Len() int           { return len(s) }
+func (s fileSetsByFolder) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s fileSetsByFolder) Less(i, j int) bool { return s[i].folder < s[j].folder }
@@ -0,0 +1,97 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package db
+
+import (
+	"testing"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+func TestTransactionCommit(t *testing.T) {
+	ldb := OpenMemory()
+	fsA := NewFileSet("folderA", ldb)
+	fsB := NewFileSet("folderB", ldb)
+
+	blocks := genBlocks(2)
+	v1 := protocol.Vector{Counters: []protocol.Counter{{ID: 1, Value: 1000}}}
+	v2 := protocol.Vector{Counters: []protocol.Counter{{ID: 1, Value: 1001}}}
+
+	if err := fsA.Update(protocol.LocalDeviceID, []protocol.FileInfo{
+		{Name: "file", Version: v1, Blocks: blocks},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Move the file from folderA to folderB in one transaction.
+	tx := NewTransaction(ldb)
+	tx.Update(fsA, protocol.LocalDeviceID, []protocol.FileInfo{
+		{Name: "file", Version: v2, Deleted: true},
+	})
+	tx.Update(fsB, protocol.LocalDeviceID, []protocol.FileInfo{
+		{Name: "file", Version: v1, Blocks: blocks},
+	})
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if f, ok := fsA.Get(protocol.LocalDeviceID, "file"); !ok || !f.IsDeleted() {
+		t.Errorf("expected deleted file in folderA, got %v (%v)", f, ok)
+	}
+	if f, ok := fsB.Get(protocol.LocalDeviceID, "file"); !ok || f.IsDeleted() {
+		t.Errorf("expected file in folderB, got %v (%v)", f, ok)
+	}
+
+	// Sequences were assigned per folder, as for plain updates.
+	if seq := fsA.Sequence(protocol.LocalDeviceID); seq != 2 {
+		t.Errorf("unexpected folderA sequence %d", seq)
+	}
+	if seq := fsB.Sequence(protocol.LocalDeviceID); seq != 1 {
+		t.Errorf("unexpected folderB sequence %d", seq)
+	}
+
+	// The block map was moved along with the file.
+	finder := NewBlockFinder(ldb)
+	if found := finder.Iterate([]string{"folderA"}, blocks[0].Hash, func(string, string, int32) bool { return true }); found {
+		t.Error("unexpected block map entry in folderA")
+	}
+	if found := finder.Iterate([]string{"folderB"}, blocks[0].Hash, func(folder, file string, index int32) bool {
+		return file == "file"
+	}); !found {
+		t.Error("expected block map entry in folderB")
+	}
+}
+
+func TestTransactionRemote(t *testing.T) {
+	ldb := OpenMemory()
+	fsA := NewFileSet("folderA", ldb)
+	fsB := NewFileSet("folderB", ldb)
+
+	remote := protocol.DeviceID{42}
+	v := protocol.Vector{Counters: []protocol.Counter{{ID: 42, Value: 1000}}}
+
+	tx := NewTransaction(ldb)
+	tx.Update(fsA, remote, []protocol.FileInfo{
+		{Name: "a", Version: v, Sequence: 7},
+	})
+	tx.Update(fsB, remote, []protocol.FileInfo{
+		{Name: "b", Version: v, Sequence: 3},
+	})
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if seq := fsA.Sequence(remote); seq != 7 {
+		t.Errorf("unexpected folderA remote sequence %d", seq)
+	}
+	if seq := fsB.Sequence(remote); seq != 3 {
+		t.Errorf("unexpected folderB remote sequence %d", seq)
+	}
+	if _, ok := fsB.Get(remote, "b"); !ok {
+		t.Error("expected file in folderB")
+	}
+}
@@ -27,6 +27,7 @@ type CachingMux interface {
 	FinderService
 	Add(finder Finder, cacheTime, negCacheTime time.Duration, priority int)
 	ChildErrors() map[string]error
+	ChildStatus() map[string]ServiceStatus
 }
 
 type cachingMux struct {
@@ -159,6 +160,28 @@ func (m *cachingMux) ChildErrors() map[string]error {
 	return children
 }
 
+// ChildStatus returns the health of each child finder. Finders that only
+// report an error have their status derived from it.
+func (m *cachingMux) ChildStatus() map[string]ServiceStatus {
+	children := make(map[string]ServiceStatus, len(m.finders))
+	m.mut.RLock()
+	for _, f := range m.finders {
+		if s, ok := f.Finder.(statuser); ok {
+			children[f.String()] = s.Status()
+			continue
+		}
+		var status ServiceStatus
+		if err := f.Error(); err != nil {
+			status.Error = err.Error()
+		} else {
+			status.OK = true
+		}
+		children[f.String()] = status
+	}
+	m.mut.RUnlock()
+	return children
+}
+
 func (m *cachingMux) Cache() map[protocol.DeviceID]CacheEntry {
 	// Res will be the "total" cache, i.e. the union of our cache and all our
 	// children's caches.
@@ -29,6 +29,22 @@ type CacheEntry struct {
 	instanceID int64     // for local discovery, the instance ID (random on each restart)
 }
 
+// A ServiceStatus is the client's view of the health of a discovery
+// server: whether the last operation against it succeeded, the error if
+// not, and when and how fast the last successful operation was.
+type ServiceStatus struct {
+	OK          bool      `json:"ok"`
+	Error       string    `json:"error,omitempty"`
+	LastSuccess time.Time `json:"lastSuccess"`
+	LatencyMs   int64     `json:"latencyMs"`
+}
+
+// A Finder may additionally implement statuser, in which case it reports
+// richer health information than the plain Error.
+type statuser interface {
+	Status() ServiceStatus
+}
+
 // A FinderService is a Finder that has background activity and must be run as
 // a suture.Service.
 type FinderService interface {
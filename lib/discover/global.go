@@ -137,6 +137,7 @@ func (c *globalClient) Lookup(device protocol.DeviceID) (addresses []string, err
 	q.Set("device", device.String())
 	qURL.RawQuery = q.Encode()
 
+	start := time.Now()
 	resp, err := c.queryClient.Get(qURL.String())
 	if err != nil {
 		l.Debugln("globalClient.Lookup", qURL, err)
@@ -163,6 +164,9 @@ func (c *globalClient) Lookup(device protocol.DeviceID) (addresses []string, err
 
 	var ann announcement
 	err = json.Unmarshal(bs, &ann)
+	if err == nil {
+		c.noteSuccess(time.Since(start))
+	}
 	return ann.Addresses, err
 }
 
@@ -219,6 +223,7 @@ func (c *globalClient) sendAnnouncement(timer *time.Timer) {
 
 	l.Debugf("Announcement: %s", postData)
 
+	start := time.Now()
 	resp, err := c.announceClient.Post(c.server, "application/json", bytes.NewReader(postData))
 	if err != nil {
 		l.Debugln("announce POST:", err)
@@ -248,6 +253,7 @@ func (c *globalClient) sendAnnouncement(timer *time.Timer) {
 	}
 
 	c.setError(nil)
+	c.noteSuccess(time.Since(start))
 
 	if h := resp.Header.Get("Reannounce-After"); h != "" {
 		// The server has a recommendation on when we should
@@ -370,8 +376,10 @@ func (c *idCheckingHTTPClient) Post(url, ctype string, data io.Reader) (*http.Re
 }
 
 type errorHolder struct {
-	err error
-	mut stdsync.Mutex // uses stdlib sync as I want this to be trivially embeddable, and there is no risk of blocking
+	err         error
+	lastSuccess time.Time
+	latency     time.Duration
+	mut         stdsync.Mutex // uses stdlib sync as I want this to be trivially embeddable, and there is no risk of blocking
 }
 
 func (e *errorHolder) setError(err error) {
@@ -380,9 +388,30 @@ func (e *errorHolder) setError(err error) {
 	e.mut.Unlock()
 }
 
+func (e *errorHolder) noteSuccess(latency time.Duration) {
+	e.mut.Lock()
+	e.lastSuccess = time.Now()
+	e.latency = latency
+	e.mut.Unlock()
+}
+
 func (e *errorHolder) Error() error {
 	e.mut.Lock()
 	err := e.err
 	e.mut.Unlock()
 	return err
 }
+
+func (e *errorHolder) Status() ServiceStatus {
+	e.mut.Lock()
+	status := ServiceStatus{
+		OK:          e.err == nil,
+		LastSuccess: e.lastSuccess,
+		LatencyMs:   e.latency.Nanoseconds() / 1e6,
+	}
+	if e.err != nil {
+		status.Error = e.err.Error()
+	}
+	e.mut.Unlock()
+	return status
+}
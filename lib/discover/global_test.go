@@ -8,6 +8,7 @@ package discover
 
 import (
 	"crypto/tls"
+	"errors"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -256,3 +257,25 @@ func (f *fakeAddressLister) ExternalAddresses() []string {
 func (f *fakeAddressLister) AllAddresses() []string {
 	return []string{"tcp://0.0.0.0:22000", "tcp://192.168.0.1:22000"}
 }
+
+func TestStatus(t *testing.T) {
+	var e errorHolder
+
+	e.setError(errors.New("something failed"))
+	if s := e.Status(); s.OK || s.Error != "something failed" {
+		t.Errorf("unexpected status %+v", s)
+	}
+
+	e.setError(nil)
+	e.noteSuccess(42 * time.Millisecond)
+	s := e.Status()
+	if !s.OK || s.Error != "" {
+		t.Errorf("unexpected status %+v", s)
+	}
+	if s.LastSuccess.IsZero() {
+		t.Error("expected a last success time")
+	}
+	if s.LatencyMs != 42 {
+		t.Errorf("unexpected latency %d ms", s.LatencyMs)
+	}
+}
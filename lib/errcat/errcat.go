@@ -0,0 +1,91 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package errcat provides a catalog of user-facing errors with stable
+// identifiers. API consumers get the identifier alongside the human
+// readable text, so GUIs can translate errors and automation can match on
+// them without parsing English prose.
+package errcat
+
+import (
+	"fmt"
+
+	"github.com/syncthing/syncthing/lib/sync"
+)
+
+// An Error is an error with a stable identifier from the catalog.
+type Error struct {
+	code  string
+	text  string
+	cause error
+}
+
+func (e *Error) Error() string {
+	return e.text
+}
+
+// Code returns the stable identifier of the error.
+func (e *Error) Code() string {
+	return e.code
+}
+
+// Cause returns the underlying error for wrapped errors, or nil.
+func (e *Error) Cause() error {
+	return e.cause
+}
+
+var (
+	catalogMut = sync.NewMutex()
+	catalog    = make(map[string]string)
+)
+
+// New registers the code with its default English text in the catalog and
+// returns the corresponding error. It is meant to be called from package
+// scoped variable initializers; registering the same code twice with
+// different texts panics.
+func New(code, text string) *Error {
+	catalogMut.Lock()
+	defer catalogMut.Unlock()
+
+	if prev, ok := catalog[code]; ok && prev != text {
+		panic(fmt.Sprintf("error code %q registered twice with different texts", code))
+	}
+	catalog[code] = text
+	return &Error{code: code, text: text}
+}
+
+// Wrap returns an error carrying the given code and the text of err, for
+// errors whose text is determined at runtime. The code should still be
+// registered with New somewhere so that it appears in the catalog. Wrap
+// returns nil when err is nil.
+func Wrap(code string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{code: code, text: err.Error(), cause: err}
+}
+
+// Code returns the stable identifier carried by err, or the empty string
+// for errors that are not from the catalog.
+func Code(err error) string {
+	if ce, ok := err.(*Error); ok {
+		return ce.code
+	}
+	return ""
+}
+
+// Catalog returns the codes and default English texts of all registered
+// errors.
+func Catalog() map[string]string {
+	catalogMut.Lock()
+	defer catalogMut.Unlock()
+
+	res := make(map[string]string, len(catalog))
+	for code, text := range catalog {
+		res[code] = text
+	}
+	return res
+}
@@ -0,0 +1,58 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package errcat
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewAndCode(t *testing.T) {
+	err := New("test-something-wrong", "something went wrong")
+	if err.Error() != "something went wrong" {
+		t.Errorf("unexpected text %q", err.Error())
+	}
+	if Code(err) != "test-something-wrong" {
+		t.Errorf("unexpected code %q", Code(err))
+	}
+	if Code(errors.New("plain")) != "" {
+		t.Error("plain errors should have no code")
+	}
+
+	if text := Catalog()["test-something-wrong"]; text != "something went wrong" {
+		t.Errorf("unexpected catalog text %q", text)
+	}
+
+	// Registering the same code with the same text is fine...
+	New("test-something-wrong", "something went wrong")
+
+	// ... but a different text panics.
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic registering a conflicting text")
+		}
+	}()
+	New("test-something-wrong", "something else entirely")
+}
+
+func TestWrap(t *testing.T) {
+	if Wrap("test-wrapped", nil) != nil {
+		t.Error("wrapping nil should return nil")
+	}
+
+	cause := errors.New("underlying problem")
+	err := Wrap("test-wrapped", cause)
+	if err.Error() != "underlying problem" {
+		t.Errorf("unexpected text %q", err.Error())
+	}
+	if Code(err) != "test-wrapped" {
+		t.Errorf("unexpected code %q", Code(err))
+	}
+	if err.(*Error).Cause() != cause {
+		t.Error("cause should be preserved")
+	}
+}
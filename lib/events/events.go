@@ -11,6 +11,7 @@ import (
 	"errors"
 	"runtime"
 	stdsync "sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/syncthing/syncthing/lib/sync"
@@ -44,6 +45,14 @@ const (
 	FolderScanProgress
 	ListenAddressesChanged
 	LoginAttempt
+	DeviceHashFailure
+	HoldChanged
+	WatchBudgetExceeded
+	RelayBudgetThreshold
+	TrafficCapReached
+	DanglingVersionDropped
+	ChangeStormDetected
+	BlockmapGCProgress
 
 	AllEvents = (1 << iota) - 1
 )
@@ -102,6 +111,22 @@ func (t EventType) String() string {
 		return "ListenAddressesChanged"
 	case LoginAttempt:
 		return "LoginAttempt"
+	case DeviceHashFailure:
+		return "DeviceHashFailure"
+	case HoldChanged:
+		return "HoldChanged"
+	case WatchBudgetExceeded:
+		return "WatchBudgetExceeded"
+	case RelayBudgetThreshold:
+		return "RelayBudgetThreshold"
+	case TrafficCapReached:
+		return "TrafficCapReached"
+	case DanglingVersionDropped:
+		return "DanglingVersionDropped"
+	case ChangeStormDetected:
+		return "ChangeStormDetected"
+	case BlockmapGCProgress:
+		return "BlockmapGCProgress"
 	default:
 		return "Unknown"
 	}
@@ -111,6 +136,17 @@ func (t EventType) MarshalText() ([]byte, error) {
 	return []byte(t.String()), nil
 }
 
+// ParseEventType returns the event type corresponding to the given name, or
+// zero if the name is unknown.
+func ParseEventType(name string) EventType {
+	for t := EventType(1); t < AllEvents; t <<= 1 {
+		if t.String() == name {
+			return t
+		}
+	}
+	return 0
+}
+
 const BufferSize = 64
 
 type Logger struct {
@@ -134,6 +170,7 @@ type Subscription struct {
 	mask    EventType
 	events  chan Event
 	timeout *time.Timer
+	dropped int64 // number of events dropped due to a full buffer; atomic
 }
 
 var Default = NewLogger()
@@ -169,7 +206,20 @@ func (l *Logger) Log(t EventType, data interface{}) {
 			select {
 			case s.events <- e:
 			default:
-				// if s.events is not ready, drop the event
+				// The subscriber is not keeping up. Drop the oldest
+				// buffered event to make room for the new one, so that a
+				// slow consumer sees a gap in old events rather than
+				// missing out on current ones.
+				select {
+				case <-s.events:
+					atomic.AddInt64(&s.dropped, 1)
+				default:
+				}
+				select {
+				case s.events <- e:
+				default:
+					atomic.AddInt64(&s.dropped, 1)
+				}
 			}
 		}
 	}
@@ -264,6 +314,12 @@ func (s *Subscription) C() <-chan Event {
 	return s.events
 }
 
+// Dropped returns the number of events that have been dropped from this
+// subscription because the subscriber did not keep up.
+func (s *Subscription) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
 type bufferedSubscription struct {
 	sub  *Subscription
 	buf  []Event
@@ -110,6 +110,31 @@ func TestBufferOverflow(t *testing.T) {
 	}
 }
 
+func TestBufferOverflowDropsOldest(t *testing.T) {
+	l := NewLogger()
+
+	s := l.Subscribe(AllEvents)
+	defer l.Unsubscribe(s)
+
+	for i := 0; i < BufferSize*2; i++ {
+		l.Log(DeviceConnected, "foo")
+	}
+
+	if dropped := s.Dropped(); dropped != BufferSize {
+		t.Errorf("Expected %d dropped events, got %d", BufferSize, dropped)
+	}
+
+	// The oldest events are dropped, so the first event we receive should
+	// be the one following the dropped ones.
+	ev, err := s.Poll(timeout)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if ev.SubscriptionID != BufferSize+1 {
+		t.Errorf("Expected first event to be %d, got %d", BufferSize+1, ev.SubscriptionID)
+	}
+}
+
 func TestUnsubscribe(t *testing.T) {
 	l := NewLogger()
 
@@ -310,3 +335,14 @@ func TestSinceUsesSubscriptionId(t *testing.T) {
 		t.Fatal("Incorrect number of events:", len(events))
 	}
 }
+
+func TestParseEventType(t *testing.T) {
+	for ev := EventType(1); ev < AllEvents; ev <<= 1 {
+		if parsed := ParseEventType(ev.String()); parsed != ev {
+			t.Errorf("Parsing %q gave %v, not %v", ev.String(), parsed, ev)
+		}
+	}
+	if parsed := ParseEventType("Unknown"); parsed != 0 {
+		t.Errorf("Parsing an unknown name should give zero, not %v", parsed)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package fixtures generates reproducible pseudo-random folder trees for
+// tests and benchmarks. Names, sizes, contents and modification times all
+// derive from the seed in the spec, so the same spec produces an identical
+// tree on every run, and performance numbers from different versions
+// compare like with like.
+package fixtures
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Generated files get modification times in the month before this fixed
+// point, so that trees are identical regardless of when they are created.
+var epoch = time.Unix(1234567890, 0)
+
+// A Spec describes a folder tree to generate.
+type Spec struct {
+	Seed       int64 // seed for the pseudo-random generator
+	Files      int   // number of files to create
+	MaxSizeExp uint  // file sizes are powers of two up to 2^MaxSizeExp bytes, with jitter
+}
+
+// A ChangeOp is one kind of step in a change script.
+type ChangeOp int
+
+const (
+	OpCreate ChangeOp = iota
+	OpModify
+	OpDelete
+)
+
+// A Change is one step of a change script: apply the operation to the
+// given number of files.
+type Change struct {
+	Op    ChangeOp
+	Count int
+}
+
+// Generate creates the described tree under dir, which must exist.
+func Generate(dir string, spec Spec) error {
+	rng := rand.New(rand.NewSource(spec.Seed))
+	for i := 0; i < spec.Files; i++ {
+		if err := createFile(dir, rng, spec.MaxSizeExp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Apply runs a change script against a previously generated tree. Given
+// the same spec, script and starting tree it always makes the same
+// changes. The pseudo-random stream is offset from the one used by
+// Generate so that created files don't repeat generated ones.
+func Apply(dir string, spec Spec, script []Change) error {
+	rng := rand.New(rand.NewSource(spec.Seed + 1))
+	for _, c := range script {
+		for i := 0; i < c.Count; i++ {
+			switch c.Op {
+			case OpCreate:
+				if err := createFile(dir, rng, spec.MaxSizeExp); err != nil {
+					return err
+				}
+
+			case OpModify, OpDelete:
+				files, err := listFiles(dir)
+				if err != nil {
+					return err
+				}
+				if len(files) == 0 {
+					continue
+				}
+				path := files[rng.Intn(len(files))]
+				if c.Op == OpDelete {
+					if err := os.Remove(path); err != nil {
+						return err
+					}
+					continue
+				}
+				if err := writeFile(path, rng, spec.MaxSizeExp); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ParseScript parses a change script on the form "create:10,modify:5,delete:2".
+func ParseScript(s string) ([]Change, error) {
+	var script []Change
+	for _, step := range strings.Split(s, ",") {
+		parts := strings.SplitN(step, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("parse script: bad step %q", step)
+		}
+		count, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("parse script: bad count %q", parts[1])
+		}
+		var op ChangeOp
+		switch parts[0] {
+		case "create":
+			op = OpCreate
+		case "modify":
+			op = OpModify
+		case "delete":
+			op = OpDelete
+		default:
+			return nil, fmt.Errorf("parse script: unknown operation %q", parts[0])
+		}
+		script = append(script, Change{Op: op, Count: count})
+	}
+	return script, nil
+}
+
+func createFile(dir string, rng *rand.Rand, maxExp uint) error {
+	name := randomName(rng)
+	sub := filepath.Join(dir, string(name[0]), name[0:2])
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(sub, name), rng, maxExp)
+}
+
+func writeFile(path string, rng *rand.Rand, maxExp uint) error {
+	size := int64(1) << uint(rng.Intn(int(maxExp)+1))
+	jitter := int64(128 * 1024)
+	if jitter > size {
+		jitter = size
+	}
+	size += rng.Int63n(jitter + 1)
+
+	buf := make([]byte, size)
+	rng.Read(buf)
+	if err := ioutil.WriteFile(path, buf, 0644); err != nil {
+		return err
+	}
+
+	t := epoch.Add(-time.Duration(rng.Intn(30*86400)) * time.Second)
+	return os.Chtimes(path, t, t)
+}
+
+// listFiles returns the paths of all regular files under dir, sorted, so
+// that picks from the list are deterministic.
+func listFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+func randomName(rng *rand.Rand) string {
+	b := make([]byte, 16)
+	for i := range b {
+		b[i] = byte('a' + rng.Intn(26))
+	}
+	return string(b)
+}
@@ -0,0 +1,108 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package fixtures
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// treeHash returns a digest over the names and contents of all files in
+// the tree, for comparing trees.
+func treeHash(t *testing.T, dir string) [sha256.Size]byte {
+	h := sha256.New()
+	files, err := listFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range files {
+		rel, _ := filepath.Rel(dir, path)
+		bs, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.Write([]byte(rel))
+		h.Write(bs)
+	}
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+func tempTree(t *testing.T, spec Spec) string {
+	dir, err := ioutil.TempDir("", "fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Generate(dir, spec); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestGenerateReproducible(t *testing.T) {
+	spec := Spec{Seed: 42, Files: 25, MaxSizeExp: 12}
+
+	dir1 := tempTree(t, spec)
+	defer os.RemoveAll(dir1)
+	dir2 := tempTree(t, spec)
+	defer os.RemoveAll(dir2)
+
+	if treeHash(t, dir1) != treeHash(t, dir2) {
+		t.Error("same spec should generate identical trees")
+	}
+
+	dir3 := tempTree(t, Spec{Seed: 43, Files: 25, MaxSizeExp: 12})
+	defer os.RemoveAll(dir3)
+
+	if treeHash(t, dir1) == treeHash(t, dir3) {
+		t.Error("different seeds should generate different trees")
+	}
+}
+
+func TestApplyScript(t *testing.T) {
+	spec := Spec{Seed: 42, Files: 25, MaxSizeExp: 12}
+	script, err := ParseScript("create:5,modify:3,delete:10")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir1 := tempTree(t, spec)
+	defer os.RemoveAll(dir1)
+	dir2 := tempTree(t, spec)
+	defer os.RemoveAll(dir2)
+
+	if err := Apply(dir1, spec, script); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := listFiles(dir1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 20 {
+		t.Errorf("expected 20 files after script, got %d", len(files))
+	}
+
+	if err := Apply(dir2, spec, script); err != nil {
+		t.Fatal(err)
+	}
+	if treeHash(t, dir1) != treeHash(t, dir2) {
+		t.Error("same script should make the same changes")
+	}
+}
+
+func TestParseScriptErrors(t *testing.T) {
+	for _, s := range []string{"", "create", "create:x", "explode:1"} {
+		if _, err := ParseScript(s); err == nil {
+			t.Errorf("expected an error parsing %q", s)
+		}
+	}
+}
@@ -299,6 +299,13 @@ func parseIgnoreFile(fd io.Reader, currentFile string, seen map[string]bool) ([]
 				return fmt.Errorf("invalid pattern %q in ignore file (%v)", line, err)
 			}
 			patterns = append(patterns, pattern)
+		} else if strings.HasPrefix(line, "#preset ") {
+			name := strings.TrimSpace(line[len("#preset "):])
+			includes, err := loadPreset(name, currentFile, seen)
+			if err != nil {
+				return fmt.Errorf("preset %q: %v", name, err)
+			}
+			patterns = append(patterns, includes...)
 		} else if strings.HasPrefix(line, "#include ") {
 			includeRel := line[len("#include "):]
 			includeFile := filepath.Join(filepath.Dir(currentFile), includeRel)
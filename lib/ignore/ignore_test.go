@@ -737,3 +737,63 @@ func TestIssue3639(t *testing.T) {
 		t.Error("Should not match 'foo'")
 	}
 }
+
+func TestPresets(t *testing.T) {
+	stignore := `
+	#preset os-junk
+	#preset dev-junk
+	myfile
+	`
+	pats := New(true)
+	err := pats.Parse(bytes.NewBufferString(stignore), ".stignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		f string
+		r bool
+	}{
+		{".DS_Store", true},
+		{filepath.Join("photos", ".DS_Store"), true},
+		{"Thumbs.db", true},
+		{"desktop.ini", true},
+		{filepath.Join("project", "node_modules"), true},
+		{filepath.Join("project", "node_modules", "dep", "index.js"), true},
+		{filepath.Join("project", ".venv", "bin", "python"), true},
+		{"target", true},
+		{"myfile", true},
+		{"otherfile", false},
+	}
+
+	for i, tc := range tests {
+		if r := pats.Match(tc.f); r.IsIgnored() != tc.r {
+			t.Errorf("Incorrect match #%d (%s); E: %v, A: %v", i, tc.f, tc.r, r)
+		}
+	}
+
+	// The OS junk patterns are deletable so they don't hold up directory
+	// removals.
+	if !pats.Match(".DS_Store").IsDeletable() {
+		t.Error(".DS_Store should be deletable")
+	}
+	if pats.Match("target").IsDeletable() {
+		t.Error("target should not be deletable")
+	}
+}
+
+func TestPresetErrors(t *testing.T) {
+	pats := New(true)
+
+	if err := pats.Parse(bytes.NewBufferString("#preset nosuch"), ".stignore"); err == nil {
+		t.Error("Expected an error for an unknown preset")
+	}
+
+	stignore := `
+	#preset os-junk
+	#preset os-junk
+	`
+	if err := pats.Parse(bytes.NewBufferString(stignore), ".stignore"); err == nil {
+		t.Error("Expected an error for a duplicate preset")
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package ignore
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// presets are the built in pattern collections, selectable from an
+// ignore file with a "#preset name" line. They are maintained in code
+// rather than shipped as files so that upgrades apply everywhere without
+// touching the user's .stignore.
+var presets = map[string][]string{
+	// Metadata droppings from the various operating systems. Deletable,
+	// so they don't hold up directory removals.
+	"os-junk": {
+		"(?d).DS_Store",
+		"(?d)Thumbs.db",
+		"(?d)desktop.ini",
+	},
+	// Build and dependency directories that are regenerated from
+	// sources and only cause churn when synced.
+	"dev-junk": {
+		"node_modules",
+		"target",
+		".venv",
+	},
+}
+
+// Presets returns the names of the built in ignore presets, sorted.
+func Presets() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func loadPreset(name, currentFile string, seen map[string]bool) ([]Pattern, error) {
+	key := "#preset " + name
+	if seen[key] {
+		return nil, fmt.Errorf("Multiple include of preset %q", name)
+	}
+	seen[key] = true
+
+	lines, ok := presets[name]
+	if !ok {
+		return nil, fmt.Errorf("no such preset")
+	}
+
+	return parseIgnoreFile(strings.NewReader(strings.Join(lines, "\n")), currentFile, seen)
+}
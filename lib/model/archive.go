@@ -0,0 +1,173 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/syncthing/syncthing/lib/db"
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syncthing/syncthing/lib/scanner"
+)
+
+// An archiveWriter writes a sequence of files to an archive of some
+// format.
+type archiveWriter interface {
+	WriteFile(name string, size int64, modTime time.Time, data func(io.Writer) error) error
+	Close() error
+}
+
+// ExportArchive streams the current global view of the folder, or the
+// subtree under prefix, as an archive in the given format ("tar" or
+// "zip"). File data is read from the local copy where we have one and
+// requested block by block from connected peers otherwise.
+func (m *Model) ExportArchive(folder, prefix, format string, w io.Writer) error {
+	m.fmut.RLock()
+	fs, ok := m.folderFiles[folder]
+	folderCfg := m.folderCfgs[folder]
+	m.fmut.RUnlock()
+	if !ok {
+		return fmt.Errorf("no such folder %q", folder)
+	}
+
+	hash, err := scanner.HashAlgorithmFromString(folderCfg.HashAlgorithm)
+	if err != nil {
+		return err
+	}
+
+	var arch archiveWriter
+	switch format {
+	case "", "tar":
+		arch = tarArchiveWriter{tar.NewWriter(w)}
+	case "zip":
+		arch = zipArchiveWriter{zip.NewWriter(w)}
+	default:
+		return fmt.Errorf("unknown archive format %q", format)
+	}
+
+	var names []string
+	fs.WithPrefixedGlobalTruncated(prefix, func(fi db.FileIntf) bool {
+		if !fi.IsDeleted() && !fi.IsInvalid() && !fi.IsDirectory() && !fi.IsSymlink() {
+			names = append(names, fi.FileName())
+		}
+		return true
+	})
+
+	for _, name := range names {
+		gf, ok := fs.GetGlobal(name)
+		if !ok || gf.IsDeleted() || gf.IsInvalid() {
+			// The file went away between listing and now.
+			continue
+		}
+		err := arch.WriteFile(gf.Name, gf.Size, gf.ModTime(), func(dst io.Writer) error {
+			return m.streamFileBlocks(folder, folderCfg.Path(), gf, hash, dst)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return arch.Close()
+}
+
+// streamFileBlocks writes the file contents to dst, block by block. Each
+// block is read from the local copy if it verifies, otherwise requested
+// from the cluster.
+func (m *Model) streamFileBlocks(folder, folderPath string, file protocol.FileInfo, hash scanner.HashAlgorithm, dst io.Writer) error {
+	fd, err := os.Open(filepath.Join(folderPath, filepath.FromSlash(file.Name)))
+	if err == nil {
+		defer fd.Close()
+	} else {
+		fd = nil
+	}
+
+	buf := make([]byte, protocol.BlockSize)
+	for _, block := range file.Blocks {
+		buf = buf[:block.Size]
+		got := false
+		if fd != nil {
+			if _, err := fd.ReadAt(buf, block.Offset); err == nil {
+				if _, err := hash.VerifyBuffer(buf, block); err == nil {
+					got = true
+				}
+			}
+		}
+		if !got {
+			bs, err := m.requestBlock(folder, file, block, hash)
+			if err != nil {
+				return fmt.Errorf("%s at offset %d: %v", file.Name, block.Offset, err)
+			}
+			buf = bs
+		}
+		if _, err := dst.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requestBlock fetches a single verified block from some connected device
+// that advertises the file.
+func (m *Model) requestBlock(folder string, file protocol.FileInfo, block protocol.BlockInfo, hash scanner.HashAlgorithm) ([]byte, error) {
+	var lastError error
+	for _, selected := range m.Availability(folder, file.Name, file.Version, block) {
+		buf, err := m.requestGlobal(selected.ID, folder, file.Name, block.Offset, int(block.Size), block.Hash, selected.FromTemporary)
+		if err != nil {
+			lastError = err
+			continue
+		}
+		if _, err := hash.VerifyBuffer(buf, block); err != nil {
+			lastError = err
+			continue
+		}
+		return buf, nil
+	}
+	if lastError != nil {
+		return nil, lastError
+	}
+	return nil, errNoDevice
+}
+
+type tarArchiveWriter struct {
+	*tar.Writer
+}
+
+func (w tarArchiveWriter) WriteFile(name string, size int64, modTime time.Time, data func(io.Writer) error) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: modTime,
+	}
+	if err := w.WriteHeader(hdr); err != nil {
+		return err
+	}
+	return data(w.Writer)
+}
+
+type zipArchiveWriter struct {
+	*zip.Writer
+}
+
+func (w zipArchiveWriter) WriteFile(name string, size int64, modTime time.Time, data func(io.Writer) error) error {
+	hdr := &zip.FileHeader{
+		Name:   name,
+		Method: zip.Deflate,
+	}
+	hdr.SetModTime(modTime)
+	fw, err := w.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	return data(fw)
+}
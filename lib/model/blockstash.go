@@ -0,0 +1,90 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/syncthing/syncthing/lib/osutil"
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syncthing/syncthing/lib/scanner"
+)
+
+// blockStash is an optional content addressable store of verified block
+// data, shared across all folders. Blocks are kept in files named by their
+// hash, so a future pull of the same content anywhere can reuse local data
+// even after the file it originally came from was deleted.
+type blockStash struct {
+	dir string
+}
+
+func newBlockStash(dir string) *blockStash {
+	return &blockStash{dir: dir}
+}
+
+// path returns the file name for the given block hash. The files are spread
+// over 256 subdirectories to keep directory sizes reasonable.
+func (s *blockStash) path(hash []byte) string {
+	hex := fmt.Sprintf("%x", hash)
+	return filepath.Join(s.dir, hex[:2], hex)
+}
+
+// Save writes the given verified block data to the stash. Failure to stash
+// a block doesn't affect the pull it came from, so errors are logged and
+// not returned.
+func (s *blockStash) Save(hash, data []byte) {
+	path := s.path(hash)
+	if _, err := os.Lstat(path); err == nil {
+		// Already stashed.
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		l.Debugln("block stash: creating directory:", err)
+		return
+	}
+
+	fd, err := osutil.CreateAtomic(path, 0644)
+	if err != nil {
+		l.Debugln("block stash: creating file:", err)
+		return
+	}
+	if _, err := fd.Write(data); err != nil {
+		l.Debugln("block stash: writing:", err)
+		fd.Close()
+		return
+	}
+	if err := fd.Close(); err != nil {
+		l.Debugln("block stash: closing:", err)
+	}
+}
+
+// Read fetches the given block from the stash into buf, which must be
+// sized to the block, and verifies it against the expected hash using the
+// folder's hash algorithm. Corrupt stash entries are removed.
+func (s *blockStash) Read(block protocol.BlockInfo, buf []byte, hash scanner.HashAlgorithm) bool {
+	path := s.path(block.Hash)
+	fd, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	_, err = io.ReadFull(fd, buf)
+	fd.Close()
+	if err != nil {
+		return false
+	}
+
+	if _, err := hash.VerifyBuffer(buf, block); err != nil {
+		l.Debugln("block stash: verifying:", err)
+		os.Remove(path)
+		return false
+	}
+	return true
+}
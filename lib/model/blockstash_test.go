@@ -0,0 +1,61 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syncthing/syncthing/lib/scanner"
+)
+
+func TestBlockStash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syncthing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stash := newBlockStash(dir)
+
+	data := []byte("some block data")
+	hash := sha256.Sum256(data)
+	block := protocol.BlockInfo{
+		Size: int32(len(data)),
+		Hash: hash[:],
+	}
+
+	buf := make([]byte, len(data))
+	if stash.Read(block, buf, scanner.SHA256) {
+		t.Error("Read should fail for a block that was never stashed")
+	}
+
+	stash.Save(block.Hash, data)
+
+	if !stash.Read(block, buf, scanner.SHA256) {
+		t.Fatal("Read should succeed for a stashed block")
+	}
+	if !bytes.Equal(buf, data) {
+		t.Error("Read returned incorrect data")
+	}
+
+	// Corrupt the stash entry; Read should detect the mismatch and remove
+	// the entry.
+	if err := ioutil.WriteFile(stash.path(block.Hash), []byte("corrupt block!!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if stash.Read(block, buf, scanner.SHA256) {
+		t.Error("Read should fail for a corrupt stash entry")
+	}
+	if _, err := os.Lstat(stash.path(block.Hash)); !os.IsNotExist(err) {
+		t.Error("Corrupt stash entry should have been removed")
+	}
+}
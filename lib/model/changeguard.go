@@ -0,0 +1,155 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"time"
+
+	"github.com/syncthing/syncthing/lib/db"
+	"github.com/syncthing/syncthing/lib/events"
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syncthing/syncthing/lib/sync"
+)
+
+// defaultChangeWindow is the measurement window for the change storm
+// guard, for folders that don't configure one.
+const defaultChangeWindow = 10 * time.Minute
+
+// changeGuard tracks how much of a folder each remote device modifies or
+// deletes within a time window, and latches into a blocked state when a
+// device exceeds the configured limit. A blocked device's index updates
+// are ignored until the user explicitly confirms the changes, which
+// limits the damage a ransomware infested peer can do to the cluster.
+type changeGuard struct {
+	mut     sync.Mutex
+	windows map[changeGuardKey]*changeGuardWindow
+}
+
+type changeGuardKey struct {
+	folder string
+	device protocol.DeviceID
+}
+
+type changeGuardWindow struct {
+	start   time.Time
+	changes int
+	blocked bool
+}
+
+func newChangeGuard() *changeGuard {
+	return &changeGuard{
+		mut:     sync.NewMutex(),
+		windows: make(map[changeGuardKey]*changeGuardWindow),
+	}
+}
+
+// record adds changes to the device's current window. It returns whether
+// the device is blocked, and whether this call is what blocked it. total
+// is the number of files in the folder and limitPct the configured
+// percentage limit.
+func (g *changeGuard) record(folder string, device protocol.DeviceID, changes, total, limitPct int, window time.Duration) (blocked, tripped bool) {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	key := changeGuardKey{folder, device}
+	w, ok := g.windows[key]
+	if !ok {
+		w = &changeGuardWindow{start: time.Now()}
+		g.windows[key] = w
+	}
+
+	if w.blocked {
+		return true, false
+	}
+
+	if time.Since(w.start) > window {
+		w.start = time.Now()
+		w.changes = 0
+	}
+
+	w.changes += changes
+	if total > 0 && w.changes*100 > limitPct*total {
+		w.blocked = true
+		return true, true
+	}
+	return false, false
+}
+
+// clear resets the guard state for the given folder and device.
+func (g *changeGuard) clear(folder string, device protocol.DeviceID) {
+	g.mut.Lock()
+	delete(g.windows, changeGuardKey{folder, device})
+	g.mut.Unlock()
+}
+
+// blockChangeStorm returns true when an incoming index update should be
+// dropped because the sending device tripped the folder's change storm
+// guard. Only changes to files we already have a global record of count
+// towards the limit; a device announcing a pile of new files is business
+// as usual.
+func (m *Model) blockChangeStorm(deviceID protocol.DeviceID, folder string, files *db.FileSet, fs []protocol.FileInfo) bool {
+	m.fmut.RLock()
+	cfg := m.folderCfgs[folder]
+	m.fmut.RUnlock()
+
+	if cfg.RemoteChangeLimitPct <= 0 {
+		return false
+	}
+
+	changes := 0
+	for _, f := range fs {
+		if cur, ok := files.GetGlobal(f.Name); ok && !f.Version.Equal(cur.Version) {
+			changes++
+		}
+	}
+
+	window := defaultChangeWindow
+	if cfg.RemoteChangeWindowS > 0 {
+		window = time.Duration(cfg.RemoteChangeWindowS) * time.Second
+	}
+	total, _, _ := files.GlobalSize()
+
+	blocked, tripped := m.changeGuard.record(folder, deviceID, changes, total, cfg.RemoteChangeLimitPct, window)
+	if tripped {
+		l.Warnf("Device %v changed more than %d%% of folder %q within %v; ignoring its changes until confirmed", deviceID, cfg.RemoteChangeLimitPct, folder, window)
+		events.Default.Log(events.ChangeStormDetected, map[string]interface{}{
+			"folder": folder,
+			"device": deviceID.String(),
+			"limit":  cfg.RemoteChangeLimitPct,
+		})
+	} else if blocked {
+		l.Debugf("Ignoring %d changes from blocked device %v for folder %q", len(fs), deviceID, folder)
+	}
+	return blocked
+}
+
+// AcceptChanges confirms a change storm alert for the given folder and
+// device. The guard resets and the device's index data is dropped, so the
+// next connection starts over with a full index exchange, including the
+// changes that were held back.
+func (m *Model) AcceptChanges(folder string, device protocol.DeviceID) {
+	m.changeGuard.clear(folder, device)
+
+	m.fmut.RLock()
+	fs := m.folderFiles[folder]
+	m.fmut.RUnlock()
+	if fs == nil {
+		return
+	}
+	if err := fs.Replace(device, nil); err != nil {
+		m.folderDatabaseError(folder, err)
+		return
+	}
+	fs.SetIndexID(device, 0)
+
+	m.pmut.RLock()
+	conn, ok := m.conn[device]
+	m.pmut.RUnlock()
+	if ok {
+		closeRawConn(conn)
+	}
+}
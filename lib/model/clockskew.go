@@ -0,0 +1,73 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// clockSkewThreshold is the measured skew above which we warn the user and,
+// for devices with compensation enabled, adjust incoming modification
+// times. Skews below it are indistinguishable from message transit time.
+const clockSkewThreshold = 30 * time.Second
+
+// updateClockSkew measures the clock skew against the given device, from
+// the wall clock it announced on its own entry in the cluster config. The
+// measurement includes the message transit time, so small skews are noise.
+func (m *Model) updateClockSkew(deviceID protocol.DeviceID, cm protocol.ClusterConfig) {
+	received := time.Now()
+	for _, folder := range cm.Folders {
+		for _, dev := range folder.Devices {
+			if !bytes.Equal(dev.ID, deviceID[:]) || dev.LocalTime == 0 {
+				continue
+			}
+
+			skew := time.Unix(0, dev.LocalTime).Sub(received)
+			m.pmut.Lock()
+			m.deviceClockSkew[deviceID] = skew
+			m.pmut.Unlock()
+
+			if skew >= clockSkewThreshold || skew <= -clockSkewThreshold {
+				l.Warnf("Clock on device %v differs from ours by about %v; correct the clock on one side, or modification times will be unreliable", deviceID, skew.Round(time.Second))
+			}
+			return
+		}
+	}
+}
+
+// DeviceClockSkew returns the measured clock skew against the given device.
+// Positive means the remote clock is ahead of ours. Zero is returned when
+// no measurement has been made.
+func (m *Model) DeviceClockSkew(device protocol.DeviceID) time.Duration {
+	m.pmut.RLock()
+	defer m.pmut.RUnlock()
+	return m.deviceClockSkew[device]
+}
+
+// compensateClockSkew shifts the modification times of incoming index
+// entries into our clock frame, when the device has compensation enabled
+// and the measured skew is above the threshold. This keeps a device with a
+// wrong clock from perpetually winning modification time conflicts.
+func (m *Model) compensateClockSkew(deviceID protocol.DeviceID, fs []protocol.FileInfo) {
+	cfg, ok := m.cfg.Devices()[deviceID]
+	if !ok || !cfg.CompensateClockSkew {
+		return
+	}
+
+	skew := m.DeviceClockSkew(deviceID)
+	if skew < clockSkewThreshold && skew > -clockSkewThreshold {
+		return
+	}
+
+	secs := int64(skew / time.Second)
+	for i := range fs {
+		fs[i].ModifiedS -= secs
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syncthing/syncthing/lib/config"
+	"github.com/syncthing/syncthing/lib/db"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+func TestClockSkew(t *testing.T) {
+	fcfg := config.NewFolderConfiguration("default", "testdata")
+	fcfg.Devices = []config.FolderDeviceConfiguration{
+		{DeviceID: device1},
+		{DeviceID: protocol.LocalDeviceID},
+	}
+	dcfg := config.NewDeviceConfiguration(device1, "device1")
+	dcfg.CompensateClockSkew = true
+	cfg := config.Wrap("/tmp/test", config.Configuration{
+		Folders: []config.FolderConfiguration{fcfg},
+		Devices: []config.DeviceConfiguration{dcfg},
+	})
+
+	ldb := db.OpenMemory()
+	m := NewModel(cfg, protocol.LocalDeviceID, "device", "syncthing", "dev", ldb, nil)
+	m.AddFolder(fcfg)
+
+	// device1's clock is an hour ahead of ours.
+	skew := time.Hour
+	m.updateClockSkew(device1, protocol.ClusterConfig{
+		Folders: []protocol.Folder{{
+			ID: "default",
+			Devices: []protocol.Device{
+				{ID: device2[:], LocalTime: time.Now().Add(42 * time.Hour).UnixNano()}, // relayed; ignored
+				{ID: device1[:], LocalTime: time.Now().Add(skew).UnixNano()},
+			},
+		}},
+	})
+
+	measured := m.DeviceClockSkew(device1)
+	if measured < skew-time.Minute || measured > skew+time.Minute {
+		t.Errorf("measured skew %v, expected about %v", measured, skew)
+	}
+
+	// Modification times announced by device1 are shifted into our clock
+	// frame, to within the measurement noise.
+	now := time.Now().Unix()
+	fs := []protocol.FileInfo{{Name: "a", ModifiedS: now + 3600}}
+	m.compensateClockSkew(device1, fs)
+	if diff := fs[0].ModifiedS - now; diff < -60 || diff > 60 {
+		t.Errorf("compensated mtime off by %d seconds", diff)
+	}
+
+	// Devices without compensation enabled are left alone.
+	m.pmut.Lock()
+	m.deviceClockSkew[device2] = skew
+	m.pmut.Unlock()
+	fs = []protocol.FileInfo{{Name: "a", ModifiedS: now + 3600}}
+	m.compensateClockSkew(device2, fs)
+	if fs[0].ModifiedS != now+3600 {
+		t.Error("unexpected compensation for device without the option")
+	}
+
+	// Small skews are treated as transit time noise.
+	m.updateClockSkew(device1, protocol.ClusterConfig{
+		Folders: []protocol.Folder{{
+			ID:      "default",
+			Devices: []protocol.Device{{ID: device1[:], LocalTime: time.Now().Add(time.Second).UnixNano()}},
+		}},
+	})
+	fs = []protocol.FileInfo{{Name: "a", ModifiedS: now}}
+	m.compensateClockSkew(device1, fs)
+	if fs[0].ModifiedS != now {
+		t.Error("unexpected compensation below the threshold")
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"fmt"
+
+	"github.com/syncthing/syncthing/lib/config"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// ConfigUpdate is called when a device pushes a config fragment to us. It
+// is honored only when the sending device is designated a remote admin in
+// our configuration; updates from anyone else are logged and dropped.
+func (m *Model) ConfigUpdate(deviceID protocol.DeviceID, update protocol.ConfigUpdate) {
+	if !m.cfg.Devices()[deviceID].RemoteAdmin {
+		l.Infof("Dropping config update from %v; it is not a designated remote admin", deviceID)
+		return
+	}
+
+	var changed bool
+
+	// Add devices we don't know, and update addresses and names of those
+	// we do. Our own entry is never touched.
+	for _, device := range update.Devices {
+		var id protocol.DeviceID
+		copy(id[:], device.ID)
+
+		if id == m.id {
+			continue
+		}
+
+		devChanged := false
+		deviceCfg, ok := m.cfg.Devices()[id]
+		if !ok {
+			l.Infof("Adding device %v to config (pushed by admin %v)", id, deviceID)
+			deviceCfg = config.DeviceConfiguration{
+				DeviceID:    id,
+				Compression: m.cfg.Devices()[deviceID].Compression,
+				Addresses:   []string{"dynamic"},
+				CertName:    device.CertName,
+			}
+			devChanged = true
+		}
+		if len(device.Addresses) > 0 && !stringSlicesEqual(deviceCfg.Addresses, device.Addresses) {
+			l.Infof("Updating addresses of device %v (pushed by admin %v)", id, deviceID)
+			deviceCfg.Addresses = device.Addresses
+			devChanged = true
+		}
+		if device.Name != "" && deviceCfg.Name == "" {
+			deviceCfg.Name = device.Name
+			devChanged = true
+		}
+		if devChanged {
+			m.cfg.SetDevice(deviceCfg)
+			changed = true
+		}
+	}
+
+	// Add listed devices to the sharing lists of folders we have. We
+	// can't add folders we don't have, as we have no idea where they
+	// would live on disk.
+	m.fmut.Lock()
+	for _, folder := range update.Folders {
+		if _, ok := m.folderDevices[folder.ID]; !ok {
+			l.Infof("Ignoring config update for unknown folder %q from admin %v", folder.ID, deviceID)
+			continue
+		}
+
+	nextDevice:
+		for _, device := range folder.Devices {
+			var id protocol.DeviceID
+			copy(id[:], device.ID)
+
+			if id == m.id {
+				continue
+			}
+			if _, ok := m.cfg.Devices()[id]; !ok {
+				l.Infof("Ignoring unknown device %v in config update for folder %q", id, folder.ID)
+				continue
+			}
+
+			for _, er := range m.deviceFolders[id] {
+				if er == folder.ID {
+					// We already share the folder with this device.
+					continue nextDevice
+				}
+			}
+
+			l.Infof("Adding device %v to share %q (pushed by admin %v)", id, folder.ID, deviceID)
+
+			m.deviceFolders[id] = append(m.deviceFolders[id], folder.ID)
+			m.folderDevices[folder.ID] = append(m.folderDevices[folder.ID], id)
+
+			folderCfg := m.cfg.Folders()[folder.ID]
+			folderCfg.Devices = append(folderCfg.Devices, config.FolderDeviceConfiguration{
+				DeviceID: id,
+			})
+			m.cfg.SetFolder(folderCfg)
+
+			changed = true
+		}
+	}
+	m.fmut.Unlock()
+
+	if changed {
+		m.cfg.Save()
+	}
+}
+
+// PushConfigUpdate sends a config update to the given device. The device
+// applies it only if it has designated us as a remote admin.
+func (m *Model) PushConfigUpdate(deviceID protocol.DeviceID, update protocol.ConfigUpdate) error {
+	m.pmut.RLock()
+	conn, ok := m.conn[deviceID]
+	m.pmut.RUnlock()
+	if !ok {
+		return fmt.Errorf("device %v is not connected", deviceID)
+	}
+
+	conn.ConfigUpdate(update)
+	return nil
+}
+
+func stringSlicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
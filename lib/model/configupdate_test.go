@@ -0,0 +1,61 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/syncthing/syncthing/lib/config"
+	"github.com/syncthing/syncthing/lib/db"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+func TestConfigUpdate(t *testing.T) {
+	ldb := db.OpenMemory()
+
+	fcfg := config.NewFolderConfiguration("default", "testdata")
+	fcfg.Devices = []config.FolderDeviceConfiguration{{DeviceID: device1}}
+	cfg := config.Wrap("/tmp/test", config.Configuration{
+		Version: config.CurrentVersion,
+		Folders: []config.FolderConfiguration{fcfg},
+		Devices: []config.DeviceConfiguration{
+			{DeviceID: device1, RemoteAdmin: true},
+			{DeviceID: device2},
+		},
+	})
+
+	m := NewModel(cfg, protocol.LocalDeviceID, "device", "syncthing", "dev", ldb, nil)
+	m.AddFolder(fcfg)
+
+	update := protocol.ConfigUpdate{
+		Folders: []protocol.Folder{{
+			ID:      "default",
+			Devices: []protocol.Device{{ID: device2[:]}},
+		}},
+		Devices: []protocol.Device{{
+			ID:        device2[:],
+			Addresses: []string{"tcp://192.0.2.1:22000"},
+		}},
+	}
+
+	// A device that is not a designated remote admin doesn't get to
+	// change anything.
+	m.ConfigUpdate(device2, update)
+	if devs := cfg.Folders()["default"].Devices; len(devs) != 1 {
+		t.Errorf("non-admin update should have been dropped; folder has %d devices", len(devs))
+	}
+
+	// The admin's update is applied.
+	m.ConfigUpdate(device1, update)
+
+	if devs := cfg.Folders()["default"].Devices; len(devs) != 2 {
+		t.Errorf("expected 2 devices on folder after admin update, got %d", len(devs))
+	}
+	if addrs := cfg.Devices()[device2].Addresses; len(addrs) != 1 || addrs[0] != "tcp://192.0.2.1:22000" {
+		t.Errorf("unexpected addresses for device2: %v", addrs)
+	}
+}
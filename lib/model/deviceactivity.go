@@ -42,6 +42,14 @@ func (m *deviceActivity) leastBusy(availability []Availability) (Availability, b
 	return selected, found
 }
 
+// outstanding returns the number of requests currently in progress
+// against the device.
+func (m *deviceActivity) outstanding(device protocol.DeviceID) int {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return m.act[device]
+}
+
 func (m *deviceActivity) using(availability Availability) {
 	m.mut.Lock()
 	m.act[availability.ID]++
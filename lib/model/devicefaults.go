@@ -0,0 +1,65 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syncthing/syncthing/lib/sync"
+)
+
+// maxHashFailures is the number of consecutive block hash verification
+// failures after which a device is considered suspect and down-ranked.
+const maxHashFailures = 3
+
+// deviceFaults tracks consecutive block hash verification failures per
+// device, so that devices repeatedly serving corrupt data can be down-ranked
+// when selecting where to pull from. It is safe for use from multiple
+// goroutines.
+type deviceFaults struct {
+	failures map[protocol.DeviceID]int
+	mut      sync.Mutex
+}
+
+func newDeviceFaults() *deviceFaults {
+	return &deviceFaults{
+		failures: make(map[protocol.DeviceID]int),
+		mut:      sync.NewMutex(),
+	}
+}
+
+// hashFailure records a hash verification failure against the given device
+// and returns the number of consecutive failures recorded for it.
+func (m *deviceFaults) hashFailure(device protocol.DeviceID) int {
+	m.mut.Lock()
+	m.failures[device]++
+	count := m.failures[device]
+	m.mut.Unlock()
+	return count
+}
+
+// success resets the failure count for the given device.
+func (m *deviceFaults) success(device protocol.DeviceID) {
+	m.mut.Lock()
+	delete(m.failures, device)
+	m.mut.Unlock()
+}
+
+// partition splits the given availability list into devices in good standing
+// and devices that have repeatedly served corrupt data. The latter should
+// only be used when no device in good standing can serve the block.
+func (m *deviceFaults) partition(availability []Availability) (good, suspect []Availability) {
+	m.mut.Lock()
+	for _, av := range availability {
+		if m.failures[av.ID] >= maxHashFailures {
+			suspect = append(suspect, av)
+		} else {
+			good = append(good, av)
+		}
+	}
+	m.mut.Unlock()
+	return good, suspect
+}
@@ -0,0 +1,50 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+func TestDeviceFaults(t *testing.T) {
+	n0 := Availability{protocol.DeviceID([32]byte{1, 2, 3, 4}), false}
+	n1 := Availability{protocol.DeviceID([32]byte{5, 6, 7, 8}), true}
+	devices := []Availability{n0, n1}
+	df := newDeviceFaults()
+
+	if good, suspect := df.partition(devices); len(good) != 2 || len(suspect) != 0 {
+		t.Errorf("All devices should be in good standing, got %v good, %v suspect", good, suspect)
+	}
+
+	// Fewer than maxHashFailures failures should not down-rank the device.
+	for i := 0; i < maxHashFailures-1; i++ {
+		df.hashFailure(n0.ID)
+	}
+	if good, suspect := df.partition(devices); len(good) != 2 || len(suspect) != 0 {
+		t.Errorf("All devices should still be in good standing, got %v good, %v suspect", good, suspect)
+	}
+
+	// One more failure and the device becomes suspect.
+	if count := df.hashFailure(n0.ID); count != maxHashFailures {
+		t.Errorf("Expected %d failures, got %d", maxHashFailures, count)
+	}
+	good, suspect := df.partition(devices)
+	if len(good) != 1 || good[0] != n1 {
+		t.Errorf("n1 should be the only device in good standing, got %v", good)
+	}
+	if len(suspect) != 1 || suspect[0] != n0 {
+		t.Errorf("n0 should be suspect, got %v", suspect)
+	}
+
+	// A successful verification resets the failure count.
+	df.success(n0.ID)
+	if good, suspect := df.partition(devices); len(good) != 2 || len(suspect) != 0 {
+		t.Errorf("All devices should be in good standing again, got %v good, %v suspect", good, suspect)
+	}
+}
@@ -35,6 +35,16 @@ func (f *folder) Jobs() ([]string, []string) {
 
 func (f *folder) BringToFront(string) {}
 
+func (f *folder) SendToBack(string) {}
+
+func (f *folder) SkipJob(string) {}
+
+func (f *folder) RetryJob(string) {}
+
+func (f *folder) PullQueue() []PullQueueItem {
+	return nil
+}
+
 func (f *folder) scanSubdirsIfHealthy(subDirs []string) error {
 	if err := f.model.CheckFolderHealth(f.folderID); err != nil {
 		l.Infoln("Skipping folder", f.folderID, "scan due to folder error:", err)
@@ -0,0 +1,166 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/syncthing/syncthing/lib/db"
+)
+
+// analysisTopEntries is the number of entries reported in the largest
+// files and deepest paths lists.
+const analysisTopEntries = 10
+
+// sizeBucketLimits holds the histogram bucket boundaries, in bytes. Sizes
+// grow by a factor of four from 1 KiB; an open ended bucket at the end
+// catches everything larger.
+var sizeBucketLimits = []int64{
+	1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10,
+	1 << 20, 4 << 20, 16 << 20, 64 << 20, 256 << 20,
+	1 << 30,
+}
+
+// ExtensionStats summarizes the files sharing one filename extension.
+type ExtensionStats struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// A SizeBucket counts the files up to the given size. The last bucket is
+// open ended and has UpTo set to -1.
+type SizeBucket struct {
+	UpTo  int64 `json:"upTo"`
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// A LargeFile is an entry in the largest files list.
+type LargeFile struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// A DeepPath is an entry in the deepest paths list.
+type DeepPath struct {
+	Name  string `json:"name"`
+	Depth int    `json:"depth"`
+}
+
+// FolderAnalysis summarizes the contents of a folder: counts and bytes by
+// filename extension, a file size histogram, the largest files and the
+// deepest paths. It is computed from the index database alone, without
+// touching the filesystem, as an aid in crafting ignore patterns and
+// understanding where the space goes.
+type FolderAnalysis struct {
+	Files         int                       `json:"files"`
+	Directories   int                       `json:"directories"`
+	Bytes         int64                     `json:"bytes"`
+	Extensions    map[string]ExtensionStats `json:"extensions"`
+	SizeHistogram []SizeBucket              `json:"sizeHistogram"`
+	Largest       []LargeFile               `json:"largest"`
+	Deepest       []DeepPath                `json:"deepest"`
+}
+
+// FolderAnalysis analyzes the global state of the given folder from the
+// index database.
+func (m *Model) FolderAnalysis(folder string) (FolderAnalysis, error) {
+	m.fmut.RLock()
+	fs, ok := m.folderFiles[folder]
+	m.fmut.RUnlock()
+	if !ok {
+		return FolderAnalysis{}, fmt.Errorf("no such folder %q", folder)
+	}
+	return analyzeFolder(fs), nil
+}
+
+func analyzeFolder(fs *db.FileSet) FolderAnalysis {
+	res := FolderAnalysis{
+		Extensions:    make(map[string]ExtensionStats),
+		SizeHistogram: make([]SizeBucket, len(sizeBucketLimits)+1),
+	}
+	for i, limit := range sizeBucketLimits {
+		res.SizeHistogram[i].UpTo = limit
+	}
+	res.SizeHistogram[len(sizeBucketLimits)].UpTo = -1
+
+	sep := string(filepath.Separator)
+	fs.WithGlobalTruncated(func(f db.FileIntf) bool {
+		if f.IsDeleted() {
+			return true
+		}
+
+		name := f.FileName()
+		depth := strings.Count(name, sep) + 1
+		res.Deepest = insertDeepPath(res.Deepest, DeepPath{Name: name, Depth: depth})
+
+		if f.IsDirectory() {
+			res.Directories++
+			return true
+		}
+
+		size := f.FileSize()
+		res.Files++
+		res.Bytes += size
+
+		ext := strings.ToLower(filepath.Ext(name))
+		stats := res.Extensions[ext]
+		stats.Files++
+		stats.Bytes += size
+		res.Extensions[ext] = stats
+
+		bucket := len(sizeBucketLimits)
+		for i, limit := range sizeBucketLimits {
+			if size <= limit {
+				bucket = i
+				break
+			}
+		}
+		res.SizeHistogram[bucket].Files++
+		res.SizeHistogram[bucket].Bytes += size
+
+		res.Largest = insertLargeFile(res.Largest, LargeFile{Name: name, Size: size})
+
+		return true
+	})
+
+	return res
+}
+
+// insertLargeFile inserts the file into the size ordered top list,
+// keeping it at most analysisTopEntries long.
+func insertLargeFile(list []LargeFile, f LargeFile) []LargeFile {
+	i := sort.Search(len(list), func(i int) bool { return list[i].Size < f.Size })
+	if i >= analysisTopEntries {
+		return list
+	}
+	list = append(list, LargeFile{})
+	copy(list[i+1:], list[i:])
+	list[i] = f
+	if len(list) > analysisTopEntries {
+		list = list[:analysisTopEntries]
+	}
+	return list
+}
+
+// insertDeepPath is insertLargeFile for the deepest paths list.
+func insertDeepPath(list []DeepPath, p DeepPath) []DeepPath {
+	i := sort.Search(len(list), func(i int) bool { return list[i].Depth < p.Depth })
+	if i >= analysisTopEntries {
+		return list
+	}
+	list = append(list, DeepPath{})
+	copy(list[i+1:], list[i:])
+	list[i] = p
+	if len(list) > analysisTopEntries {
+		list = list[:analysisTopEntries]
+	}
+	return list
+}
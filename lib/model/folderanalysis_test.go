@@ -0,0 +1,61 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/syncthing/syncthing/lib/db"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+func TestAnalyzeFolder(t *testing.T) {
+	ldb := db.OpenMemory()
+	fs := db.NewFileSet("default", ldb)
+
+	version := protocol.Vector{Counters: []protocol.Counter{{ID: 1, Value: 1000}}}
+	fs.Update(protocol.LocalDeviceID, []protocol.FileInfo{
+		{Name: "a.txt", Version: version, Size: 500},
+		{Name: "b.TXT", Version: version, Size: 2000},
+		{Name: "dir", Version: version, Type: protocol.FileInfoTypeDirectory},
+		{Name: "dir/big.bin", Version: version, Size: 5 << 20},
+		{Name: "gone", Version: version, Deleted: true},
+	})
+
+	res := analyzeFolder(fs)
+
+	if res.Files != 3 || res.Directories != 1 {
+		t.Errorf("unexpected counts: %d files, %d directories", res.Files, res.Directories)
+	}
+	if exp := int64(500 + 2000 + 5<<20); res.Bytes != exp {
+		t.Errorf("unexpected total size %d, expected %d", res.Bytes, exp)
+	}
+
+	// Extensions are case folded.
+	if s := res.Extensions[".txt"]; s.Files != 2 || s.Bytes != 2500 {
+		t.Errorf("unexpected .txt stats %+v", s)
+	}
+	if s := res.Extensions[".bin"]; s.Files != 1 {
+		t.Errorf("unexpected .bin stats %+v", s)
+	}
+
+	// The 500 byte file lands in the first bucket, the 2000 byte one in
+	// the second.
+	if b := res.SizeHistogram[0]; b.Files != 1 || b.Bytes != 500 {
+		t.Errorf("unexpected first bucket %+v", b)
+	}
+	if b := res.SizeHistogram[1]; b.Files != 1 || b.Bytes != 2000 {
+		t.Errorf("unexpected second bucket %+v", b)
+	}
+
+	if len(res.Largest) != 3 || res.Largest[0].Name != "dir/big.bin" {
+		t.Errorf("unexpected largest files %+v", res.Largest)
+	}
+	if len(res.Deepest) == 0 || res.Deepest[0].Name != "dir/big.bin" || res.Deepest[0].Depth != 2 {
+		t.Errorf("unexpected deepest paths %+v", res.Deepest)
+	}
+}
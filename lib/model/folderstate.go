@@ -20,6 +20,7 @@ const (
 	FolderScanning
 	FolderSyncing
 	FolderError
+	FolderPreparing
 )
 
 func (s folderState) String() string {
@@ -32,6 +33,8 @@ func (s folderState) String() string {
 		return "syncing"
 	case FolderError:
 		return "error"
+	case FolderPreparing:
+		return "preparing"
 	default:
 		return "unknown"
 	}
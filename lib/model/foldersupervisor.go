@@ -0,0 +1,95 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// The restart backoff after a folder runner panic starts here and
+	// doubles for each consecutive failure, up to the maximum.
+	folderRestartMinBackoff = 10 * time.Second
+	folderRestartMaxBackoff = 5 * time.Minute
+	// A runner that has been up for this long is considered healthy again
+	// and the backoff resets.
+	folderRestartStablePeriod = 10 * time.Minute
+)
+
+// The folderRunner is the part of the folder service interface the
+// supervisor needs.
+type folderRunner interface {
+	Serve()
+	Stop()
+	setError(err error)
+}
+
+// A folderSupervisor runs a folder runner and recovers panics in it. The
+// folder is marked as errored and the runner restarted with backoff, so a
+// bug triggered by one folder's scanner or puller doesn't take down the
+// entire process.
+type folderSupervisor struct {
+	folder  string
+	runner  folderRunner
+	backoff time.Duration
+	stop    chan struct{}
+}
+
+func newFolderSupervisor(folder string, runner folderRunner) *folderSupervisor {
+	return &folderSupervisor{
+		folder:  folder,
+		runner:  runner,
+		backoff: folderRestartMinBackoff,
+		stop:    make(chan struct{}),
+	}
+}
+
+func (s *folderSupervisor) Serve() {
+	backoff := s.backoff
+	for {
+		started := time.Now()
+		err := s.serveRunner()
+		if err == nil {
+			// The runner returned normally; we're done.
+			return
+		}
+
+		l.Warnf("Folder %q: %v; restarting in %v", s.folder, err, backoff)
+		s.runner.setError(err)
+
+		if time.Since(started) > folderRestartStablePeriod {
+			backoff = s.backoff
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-s.stop:
+			return
+		}
+
+		if backoff *= 2; backoff > folderRestartMaxBackoff {
+			backoff = folderRestartMaxBackoff
+		}
+	}
+}
+
+func (s *folderSupervisor) Stop() {
+	close(s.stop)
+	s.runner.Stop()
+}
+
+// serveRunner runs the runner, converting a panic into an error return.
+func (s *folderSupervisor) serveRunner() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("runner panic: %v", r)
+		}
+	}()
+	s.runner.Serve()
+	return nil
+}
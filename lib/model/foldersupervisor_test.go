@@ -0,0 +1,81 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type panickyRunner struct {
+	serves    int
+	errors    chan error
+	restarted chan struct{}
+	stop      chan struct{}
+}
+
+func (r *panickyRunner) Serve() {
+	r.serves++
+	if r.serves == 1 {
+		panic("dying horribly")
+	}
+	close(r.restarted)
+	<-r.stop
+}
+
+func (r *panickyRunner) Stop() {
+	close(r.stop)
+}
+
+func (r *panickyRunner) setError(err error) {
+	r.errors <- err
+}
+
+func TestFolderSupervisorRecoversPanic(t *testing.T) {
+	runner := &panickyRunner{
+		errors:    make(chan error, 1),
+		restarted: make(chan struct{}),
+		stop:      make(chan struct{}),
+	}
+	sup := newFolderSupervisor("default", runner)
+	sup.backoff = 10 * time.Millisecond
+
+	done := make(chan struct{})
+	go func() {
+		sup.Serve()
+		close(done)
+	}()
+
+	// The panic is converted into a folder error.
+	select {
+	case err := <-runner.errors:
+		if !strings.Contains(err.Error(), "dying horribly") {
+			t.Errorf("Unexpected error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for folder error")
+	}
+
+	// The runner is restarted and, once it returns normally, the
+	// supervisor is done with it.
+	select {
+	case <-runner.restarted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for runner restart")
+	}
+	sup.Stop()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for supervisor to stop")
+	}
+
+	if runner.serves != 2 {
+		t.Errorf("Runner was served %d times, expected 2", runner.serves)
+	}
+}
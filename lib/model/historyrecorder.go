@@ -0,0 +1,149 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"time"
+
+	"github.com/syncthing/syncthing/lib/stats"
+)
+
+// How often a history sample is recorded for each folder.
+const historySampleInterval = time.Hour
+
+// historyRecorder periodically snapshots per-folder sizes, transfer
+// counters and error counts into the folder history time series, which
+// backs the statistics graphs in the GUI.
+type historyRecorder struct {
+	model *Model
+	stop  chan struct{}
+}
+
+func newHistoryRecorder(m *Model) *historyRecorder {
+	return &historyRecorder{
+		model: m,
+		stop:  make(chan struct{}),
+	}
+}
+
+func (rec *historyRecorder) Serve() {
+	rec.sample()
+	ticker := time.NewTicker(historySampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			rec.sample()
+		case <-rec.stop:
+			return
+		}
+	}
+}
+
+func (rec *historyRecorder) Stop() {
+	close(rec.stop)
+}
+
+func (rec *historyRecorder) sample() {
+	m := rec.model
+
+	m.fmut.RLock()
+	folders := make([]string, 0, len(m.folderCfgs))
+	for folder := range m.folderCfgs {
+		folders = append(folders, folder)
+	}
+	m.fmut.RUnlock()
+
+	now := time.Now()
+	for _, folder := range folders {
+		globalFiles, _, globalBytes := m.GlobalSize(folder)
+		localFiles, _, localBytes := m.LocalSize(folder)
+		in, out, errs := m.folderActivity(folder)
+		m.folderHistoryRef(folder).Record(stats.HistorySample{
+			When:        now,
+			GlobalFiles: globalFiles,
+			GlobalBytes: globalBytes,
+			LocalFiles:  localFiles,
+			LocalBytes:  localBytes,
+			InBytes:     in,
+			OutBytes:    out,
+			Errors:      errs,
+		})
+	}
+}
+
+// folderIOCounters accumulates transfer and error totals for a folder
+// since the process started. The history samples store them cumulatively,
+// so a restart shows up as at worst a flat spot in the derived rates.
+type folderIOCounters struct {
+	inBytes  int64
+	outBytes int64
+	errors   int
+}
+
+func (m *Model) folderHistoryRef(folder string) *stats.FolderHistoryReference {
+	m.fmut.Lock()
+	defer m.fmut.Unlock()
+
+	sr, ok := m.folderHistoryRefs[folder]
+	if !ok {
+		sr = stats.NewFolderHistoryReference(m.db, folder)
+		m.folderHistoryRefs[folder] = sr
+	}
+
+	return sr
+}
+
+func (m *Model) addFolderInBytes(folder string, bytes int64) {
+	m.folderIOMut.Lock()
+	m.folderIOCounter(folder).inBytes += bytes
+	m.folderIOMut.Unlock()
+}
+
+func (m *Model) addFolderOutBytes(folder string, bytes int64) {
+	m.folderIOMut.Lock()
+	m.folderIOCounter(folder).outBytes += bytes
+	m.folderIOMut.Unlock()
+}
+
+func (m *Model) addFolderError(folder string) {
+	m.folderIOMut.Lock()
+	m.folderIOCounter(folder).errors++
+	m.folderIOMut.Unlock()
+}
+
+// folderIOCounter returns the counters for the given folder, creating
+// them if necessary. Callers must hold folderIOMut.
+func (m *Model) folderIOCounter(folder string) *folderIOCounters {
+	c, ok := m.folderIO[folder]
+	if !ok {
+		c = &folderIOCounters{}
+		m.folderIO[folder] = c
+	}
+	return c
+}
+
+func (m *Model) folderActivity(folder string) (inBytes, outBytes int64, errors int) {
+	m.folderIOMut.Lock()
+	c := m.folderIOCounter(folder)
+	inBytes, outBytes, errors = c.inBytes, c.outBytes, c.errors
+	m.folderIOMut.Unlock()
+	return
+}
+
+// FolderHistory returns the retained history samples for the given folder
+// taken at or after the given time, oldest first. A zero time returns the
+// full history.
+func (m *Model) FolderHistory(folder string, since time.Time) []stats.HistorySample {
+	m.fmut.RLock()
+	_, ok := m.folderCfgs[folder]
+	m.fmut.RUnlock()
+	if !ok {
+		return nil
+	}
+	return m.folderHistoryRef(folder).Since(since)
+}
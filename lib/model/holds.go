@@ -0,0 +1,148 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/syncthing/syncthing/lib/events"
+	"github.com/syncthing/syncthing/lib/osutil"
+)
+
+// Advisory holds are stored in a well known file in the folder root. The
+// file syncs between devices like any other, which is what propagates the
+// holds through the cluster. A hold is purely advisory - it is surfaced
+// via events and, when the folder is configured to honor holds, defers
+// pulling changes to the held path.
+
+const holdsFileName = ".stholds"
+
+// A FileHold marks a path as held by a device, advising other devices not
+// to modify it.
+type FileHold struct {
+	Device   string    `json:"device"`
+	PlacedAt time.Time `json:"placedAt"`
+}
+
+// loadHolds returns the holds for the folder rooted at dir. A missing
+// holds file means no holds.
+func loadHolds(dir string) (map[string]FileHold, error) {
+	fd, err := os.Open(filepath.Join(dir, holdsFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var holds map[string]FileHold
+	if err := json.NewDecoder(fd).Decode(&holds); err != nil {
+		return nil, err
+	}
+	return holds, nil
+}
+
+// saveHolds writes the holds for the folder rooted at dir, removing the
+// holds file entirely when no holds remain.
+func saveHolds(dir string, holds map[string]FileHold) error {
+	path := filepath.Join(dir, holdsFileName)
+	if len(holds) == 0 {
+		err := os.Remove(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	fd, err := osutil.CreateAtomic(path, 0644)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(fd).Encode(holds); err != nil {
+		fd.Close()
+		return err
+	}
+	return fd.Close()
+}
+
+// Holds returns the current advisory holds for the folder.
+func (m *Model) Holds(folder string) (map[string]FileHold, error) {
+	m.fmut.RLock()
+	fcfg, ok := m.folderCfgs[folder]
+	m.fmut.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no such folder: %s", folder)
+	}
+	return loadHolds(fcfg.Path())
+}
+
+// PlaceHold places an advisory hold on the file on behalf of this device.
+// An existing hold by another device is left in place and reported as an
+// error.
+func (m *Model) PlaceHold(folder, file string) error {
+	return m.updateHold(folder, file, true)
+}
+
+// ReleaseHold releases this device's advisory hold on the file.
+func (m *Model) ReleaseHold(folder, file string) error {
+	return m.updateHold(folder, file, false)
+}
+
+func (m *Model) updateHold(folder, file string, place bool) error {
+	m.fmut.RLock()
+	fcfg, ok := m.folderCfgs[folder]
+	m.fmut.RUnlock()
+	if !ok {
+		return fmt.Errorf("no such folder: %s", folder)
+	}
+
+	dir := fcfg.Path()
+	holds, err := loadHolds(dir)
+	if err != nil {
+		return err
+	}
+
+	if cur, ok := holds[file]; ok && cur.Device != m.id.String() {
+		if place {
+			return fmt.Errorf("%s is already held by %s", file, cur.Device)
+		}
+		// Releasing another device's hold is allowed - holds are advisory
+		// and the holder may no longer be around to do it.
+	}
+
+	if place {
+		if holds == nil {
+			holds = make(map[string]FileHold)
+		}
+		holds[file] = FileHold{Device: m.id.String(), PlacedAt: time.Now().Truncate(time.Second)}
+	} else {
+		if _, ok := holds[file]; !ok {
+			return nil
+		}
+		delete(holds, file)
+	}
+
+	if err := saveHolds(dir, holds); err != nil {
+		return err
+	}
+
+	events.Default.Log(events.HoldChanged, map[string]interface{}{
+		"folder": folder,
+		"file":   file,
+		"device": m.id.String(),
+		"held":   place,
+	})
+
+	// Rescan the holds file so the change is propagated to the other
+	// devices right away.
+	return m.ScanFolderSubdirs(folder, []string{holdsFileName})
+}
@@ -0,0 +1,55 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHoldsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syncthing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// No holds file means no holds.
+	holds, err := loadHolds(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(holds) != 0 {
+		t.Fatalf("Expected no holds, got %v", holds)
+	}
+
+	holds = map[string]FileHold{
+		"some/file.bin": {Device: "device1", PlacedAt: time.Now().Truncate(time.Second)},
+	}
+	if err := saveHolds(dir, holds); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadHolds(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 1 || loaded["some/file.bin"].Device != "device1" {
+		t.Fatalf("Unexpected holds after load: %v", loaded)
+	}
+
+	// Saving an empty set removes the holds file.
+	if err := saveHolds(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, holdsFileName)); !os.IsNotExist(err) {
+		t.Fatal("Expected the holds file to be removed")
+	}
+}
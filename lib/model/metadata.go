@@ -0,0 +1,60 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syncthing/syncthing/lib/sync"
+)
+
+// A MetadataApplier applies key/value metadata to a file that has been
+// created or updated by the puller. Appliers are the receiving side of
+// the scanner's metadata providers, for features like extended
+// attributes, labels and content types.
+type MetadataApplier func(path string, metadata map[string]string) error
+
+var (
+	metadataApplierMut = sync.NewMutex()
+	metadataAppliers   []MetadataApplier
+)
+
+// RegisterMetadataApplier adds an applier that will be called for each
+// file the puller creates or updates. Appliers should be registered at
+// startup, before pulling begins.
+func RegisterMetadataApplier(applier MetadataApplier) {
+	metadataApplierMut.Lock()
+	metadataAppliers = append(metadataAppliers, applier)
+	metadataApplierMut.Unlock()
+}
+
+// applyMetadata runs the registered appliers for the given file and
+// returns the first error encountered.
+func applyMetadata(path string, file protocol.FileInfo) error {
+	if len(file.Metadata) == 0 {
+		return nil
+	}
+
+	metadataApplierMut.Lock()
+	appliers := metadataAppliers
+	metadataApplierMut.Unlock()
+
+	if len(appliers) == 0 {
+		return nil
+	}
+
+	metadata := make(map[string]string, len(file.Metadata))
+	for _, kv := range file.Metadata {
+		metadata[kv.Key] = kv.Value
+	}
+
+	for _, applier := range appliers {
+		if err := applier(path, metadata); err != nil {
+			return err
+		}
+	}
+	return nil
+}
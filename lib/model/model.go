@@ -9,9 +9,9 @@ package model
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha256"
 	"crypto/tls"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -27,16 +27,19 @@ import (
 	"github.com/syncthing/syncthing/lib/config"
 	"github.com/syncthing/syncthing/lib/connections"
 	"github.com/syncthing/syncthing/lib/db"
+	"github.com/syncthing/syncthing/lib/errcat"
 	"github.com/syncthing/syncthing/lib/events"
 	"github.com/syncthing/syncthing/lib/fs"
 	"github.com/syncthing/syncthing/lib/ignore"
 	"github.com/syncthing/syncthing/lib/osutil"
+	"github.com/syncthing/syncthing/lib/previews"
 	"github.com/syncthing/syncthing/lib/protocol"
 	"github.com/syncthing/syncthing/lib/scanner"
 	"github.com/syncthing/syncthing/lib/stats"
 	"github.com/syncthing/syncthing/lib/symlinks"
 	"github.com/syncthing/syncthing/lib/sync"
 	"github.com/syncthing/syncthing/lib/versioner"
+	"github.com/syncthing/syncthing/lib/watch"
 	"github.com/thejerf/suture"
 )
 
@@ -48,9 +51,13 @@ const (
 
 type service interface {
 	BringToFront(string)
+	SendToBack(string)
+	SkipJob(string)
+	RetryJob(string)
 	DelayScan(d time.Duration)
 	IndexUpdated()              // Remote index was updated notification
 	Jobs() ([]string, []string) // In progress, Queued
+	PullQueue() []PullQueueItem
 	Scan(subs []string) error
 	Serve()
 	Stop()
@@ -84,6 +91,7 @@ type Model struct {
 
 	folderCfgs         map[string]config.FolderConfiguration                  // folder -> cfg
 	folderFiles        map[string]*db.FileSet                                 // folder -> files
+	folderDBs          map[string]*db.Instance                                // folder -> separate database, for folders that use one
 	folderDevices      map[string][]protocol.DeviceID                         // folder -> deviceIDs
 	deviceFolders      map[protocol.DeviceID][]string                         // deviceID -> folders
 	deviceStatRefs     map[protocol.DeviceID]*stats.DeviceStatisticsReference // deviceID -> statsRef
@@ -91,6 +99,10 @@ type Model struct {
 	folderRunners      map[string]service                                     // folder -> puller or scanner
 	folderRunnerTokens map[string][]suture.ServiceToken                       // folder -> tokens for puller or scanner
 	folderStatRefs     map[string]*stats.FolderStatisticsReference            // folder -> statsRef
+	folderHistoryRefs  map[string]*stats.FolderHistoryReference               // folder -> history time series
+	folderProvRefs     map[string]*stats.ProvenanceReference                  // folder -> provenance records
+	localHints         resourceHints                                          // hints we announce about ourselves
+	suspendedFolders   map[string]bool                                        // folders paused due to battery or metered connection
 	fmut               sync.RWMutex                                           // protects the above
 
 	conn            map[protocol.DeviceID]connections.Connection
@@ -98,7 +110,14 @@ type Model struct {
 	helloMessages   map[protocol.DeviceID]protocol.HelloResult
 	devicePaused    map[protocol.DeviceID]bool
 	deviceDownloads map[protocol.DeviceID]*deviceDownloadState
-	pmut            sync.RWMutex // protects the above
+	deviceHints     map[protocol.DeviceID]resourceHints // hints announced by connected devices
+	deviceClockSkew map[protocol.DeviceID]time.Duration // measured clock skew; positive when the remote clock is ahead
+	pmut            sync.RWMutex                        // protects the above
+
+	changeGuard *changeGuard
+
+	folderIO    map[string]*folderIOCounters // folder -> cumulative transfer and error counters
+	folderIOMut sync.Mutex                   // protects the above
 }
 
 type folderFactory func(*Model, config.FolderConfiguration, versioner.Versioner, *fs.MtimeFS) service
@@ -108,18 +127,24 @@ var (
 	folderFactories = make(map[config.FolderType]folderFactory, 0)
 )
 
-// errors returned by the CheckFolderHealth method
+// User-facing errors, with stable identifiers for the API error catalog.
 var (
-	errFolderPathEmpty     = errors.New("folder path empty")
-	errFolderPathMissing   = errors.New("folder path missing")
-	errFolderMarkerMissing = errors.New("folder marker missing")
-	errHomeDiskNoSpace     = errors.New("home disk has insufficient free space")
-	errFolderNoSpace       = errors.New("folder has insufficient free space")
-	errUnsupportedSymlink  = errors.New("symlink not supported")
-	errInvalidFilename     = errors.New("filename is invalid")
-	errDeviceUnknown       = errors.New("unknown device")
-	errDevicePaused        = errors.New("device is paused")
-	errDeviceIgnored       = errors.New("device is ignored")
+	errFolderPathEmpty     = errcat.New("folder-path-empty", "folder path empty")
+	errFolderPathMissing   = errcat.New("folder-path-missing", "folder path missing")
+	errFolderMarkerMissing = errcat.New("folder-marker-missing", "folder marker missing")
+	errHomeDiskNoSpace     = errcat.New("home-no-space", "home disk has insufficient free space")
+	errFolderNoSpace       = errcat.New("folder-no-space", "folder has insufficient free space")
+	errFolderOverlap       = errcat.New("folder-overlap", "folder path overlaps another folder")
+	errFolderReadOnly      = errcat.New("folder-read-only", "folder filesystem is not writable")
+	errUnsupportedSymlink  = errcat.New("unsupported-symlink", "symlink not supported")
+	errInvalidFilename     = errcat.New("invalid-filename", "filename is invalid")
+	errDeviceUnknown       = errcat.New("device-unknown", "unknown device")
+	errDevicePaused        = errcat.New("device-paused", "device is paused")
+	errDeviceIgnored       = errcat.New("device-ignored", "device is ignored")
+	errDatabaseUnhealthy   = errcat.New("database-unhealthy", "database unhealthy")
+	errFolderMissing       = errcat.New("folder-missing", "no such folder")
+	errInvalidSubpath      = errcat.New("invalid-subpath", "invalid subpath")
+	errNoArchivedVersion   = errcat.New("no-archived-version", "no archived version for the given time")
 )
 
 // NewModel creates and starts a new model. The model starts in read-only mode,
@@ -145,6 +170,7 @@ func NewModel(cfg *config.Wrapper, id protocol.DeviceID, deviceName, clientName,
 		clientVersion:      clientVersion,
 		folderCfgs:         make(map[string]config.FolderConfiguration),
 		folderFiles:        make(map[string]*db.FileSet),
+		folderDBs:          make(map[string]*db.Instance),
 		folderDevices:      make(map[string][]protocol.DeviceID),
 		deviceFolders:      make(map[protocol.DeviceID][]string),
 		deviceStatRefs:     make(map[protocol.DeviceID]*stats.DeviceStatisticsReference),
@@ -152,17 +178,29 @@ func NewModel(cfg *config.Wrapper, id protocol.DeviceID, deviceName, clientName,
 		folderRunners:      make(map[string]service),
 		folderRunnerTokens: make(map[string][]suture.ServiceToken),
 		folderStatRefs:     make(map[string]*stats.FolderStatisticsReference),
+		folderHistoryRefs:  make(map[string]*stats.FolderHistoryReference),
+		folderProvRefs:     make(map[string]*stats.ProvenanceReference),
 		conn:               make(map[protocol.DeviceID]connections.Connection),
 		closed:             make(map[protocol.DeviceID]chan struct{}),
 		helloMessages:      make(map[protocol.DeviceID]protocol.HelloResult),
 		devicePaused:       make(map[protocol.DeviceID]bool),
 		deviceDownloads:    make(map[protocol.DeviceID]*deviceDownloadState),
+		deviceHints:        make(map[protocol.DeviceID]resourceHints),
+		deviceClockSkew:    make(map[protocol.DeviceID]time.Duration),
+		suspendedFolders:   make(map[string]bool),
+		changeGuard:        newChangeGuard(),
+		folderIO:           make(map[string]*folderIOCounters),
+		folderIOMut:        sync.NewMutex(),
 		fmut:               sync.NewRWMutex(),
 		pmut:               sync.NewRWMutex(),
 	}
 	if cfg.Options().ProgressUpdateIntervalS > -1 {
 		go m.progressEmitter.Serve()
 	}
+	m.Add(newRelayBudgetMonitor(m))
+	m.Add(newTrafficCapMonitor(m))
+	m.Add(newPowerMonitor(m))
+	m.Add(newHistoryRecorder(m))
 	cfg.Subscribe(m)
 
 	return m
@@ -255,9 +293,18 @@ func (m *Model) startFolderLocked(folder string) config.FolderType {
 
 	m.warnAboutOverwritingProtectedFiles(folder)
 
-	token := m.Add(p)
+	token := m.Add(newFolderSupervisor(folder, p))
 	m.folderRunnerTokens[folder] = append(m.folderRunnerTokens[folder], token)
 
+	if cfg.WatchJournal {
+		if backend, err := watch.NewBackend(folder, cfg.Path(), cfg.WatchBudget); err != nil {
+			l.Infof("Change journal for folder %q not started: %v", folder, err)
+		} else {
+			token := m.Add(watch.NewAggregator(folder, cfg.Path(), backend, m))
+			m.folderRunnerTokens[folder] = append(m.folderRunnerTokens[folder], token)
+		}
+	}
+
 	return cfg.Type
 }
 
@@ -301,7 +348,18 @@ func (m *Model) AddFolder(cfg config.FolderConfiguration) {
 
 func (m *Model) addFolderLocked(cfg config.FolderConfiguration) {
 	m.folderCfgs[cfg.ID] = cfg
-	m.folderFiles[cfg.ID] = db.NewFileSet(cfg.ID, m.db)
+
+	ldb := m.db
+	if cfg.SeparateDatabase {
+		if fdb, err := db.Open(m.folderDBPath(cfg.ID)); err != nil {
+			l.Warnf("Cannot open separate database for folder %q, using the main index: %v", cfg.ID, err)
+		} else {
+			m.folderDBs[cfg.ID] = fdb
+			ldb = fdb
+		}
+	}
+	m.folderFiles[cfg.ID] = db.NewFileSet(cfg.ID, ldb)
+	m.folderFiles[cfg.ID].SetDevices(cfg.DeviceIDs())
 
 	m.folderDevices[cfg.ID] = make([]protocol.DeviceID, len(cfg.Devices))
 	for i, device := range cfg.Devices {
@@ -316,13 +374,32 @@ func (m *Model) addFolderLocked(cfg config.FolderConfiguration) {
 	m.folderIgnores[cfg.ID] = ignores
 }
 
+// folderDBPath returns the location of a folder's separate index database,
+// next to the main index. The folder ID is hashed as it may contain
+// characters unsuitable for a directory name.
+func (m *Model) folderDBPath(folder string) string {
+	hash := sha256.Sum256([]byte(folder))
+	return filepath.Join(filepath.Dir(m.db.Location()), fmt.Sprintf("index-folder-%x.db", hash[:8]))
+}
+
 func (m *Model) RemoveFolder(folder string) {
 	m.fmut.Lock()
 	m.pmut.Lock()
 
+	var dbPath string
+	if fdb, ok := m.folderDBs[folder]; ok {
+		dbPath = fdb.Location()
+	}
+
 	m.tearDownFolderLocked(folder)
 	// Remove it from the database
-	db.DropFolder(m.db, folder)
+	if dbPath != "" {
+		// The folder has its own database; the teardown closed it, so it
+		// can simply be deleted.
+		os.RemoveAll(dbPath)
+	} else {
+		db.DropFolder(m.db, folder)
+	}
 
 	m.pmut.Unlock()
 	m.fmut.Unlock()
@@ -341,6 +418,13 @@ func (m *Model) tearDownFolderLocked(folder string) {
 		}
 	}
 
+	// Close the folder's separate database, if it has one, so that it can
+	// be reopened or deleted.
+	if fdb, ok := m.folderDBs[folder]; ok {
+		delete(m.folderDBs, folder)
+		fdb.Close()
+	}
+
 	// Clean up our config maps
 	delete(m.folderCfgs, folder)
 	delete(m.folderFiles, folder)
@@ -349,6 +433,8 @@ func (m *Model) tearDownFolderLocked(folder string) {
 	delete(m.folderRunners, folder)
 	delete(m.folderRunnerTokens, folder)
 	delete(m.folderStatRefs, folder)
+	delete(m.folderHistoryRefs, folder)
+	delete(m.folderProvRefs, folder)
 	for dev, folders := range m.deviceFolders {
 		m.deviceFolders[dev] = stringSliceWithout(folders, folder)
 	}
@@ -379,6 +465,7 @@ type ConnectionInfo struct {
 	Address       string
 	ClientVersion string
 	Type          string
+	ClockSkew     time.Duration
 }
 
 func (info ConnectionInfo) MarshalJSON() ([]byte, error) {
@@ -386,11 +473,15 @@ func (info ConnectionInfo) MarshalJSON() ([]byte, error) {
 		"at":            info.At,
 		"inBytesTotal":  info.InBytesTotal,
 		"outBytesTotal": info.OutBytesTotal,
+		"lastRead":      info.LastRead,
+		"lastWrite":     info.LastWrite,
+		"pingIntervalS": int(info.PingInterval / time.Second),
 		"connected":     info.Connected,
 		"paused":        info.Paused,
 		"address":       info.Address,
 		"clientVersion": info.ClientVersion,
 		"type":          info.Type,
+		"clockSkewS":    int(info.ClockSkew / time.Second),
 	})
 }
 
@@ -416,6 +507,7 @@ func (m *Model) ConnectionStats() map[string]interface{} {
 			ci.Type = conn.Type
 			ci.Connected = ok
 			ci.Statistics = conn.Statistics()
+			ci.ClockSkew = m.deviceClockSkew[device]
 			if addr := conn.RemoteAddr(); addr != nil {
 				ci.Address = addr.String()
 			}
@@ -441,6 +533,120 @@ func (m *Model) ConnectionStats() map[string]interface{} {
 	return res
 }
 
+// FolderSummary describes the synchronization status of a folder.
+type FolderSummary struct {
+	State         string    `json:"state"`
+	StateChanged  time.Time `json:"stateChanged"`
+	Error         string    `json:"error,omitempty"`
+	GlobalFiles   int       `json:"globalFiles"`
+	GlobalDeleted int       `json:"globalDeleted"`
+	GlobalBytes   int64     `json:"globalBytes"`
+	LocalFiles    int       `json:"localFiles"`
+	LocalDeleted  int       `json:"localDeleted"`
+	LocalBytes    int64     `json:"localBytes"`
+	NeedFiles     int       `json:"needFiles"`
+	NeedDeletes   int       `json:"needDeletes"`
+	NeedBytes     int64     `json:"needBytes"`
+	Completion    float64   `json:"completion"`
+	Sequence      int64     `json:"sequence"`
+}
+
+// DeviceSummary describes the connection state of a device.
+type DeviceSummary struct {
+	Connected     bool   `json:"connected"`
+	Paused        bool   `json:"paused"`
+	Address       string `json:"address,omitempty"`
+	ClientVersion string `json:"clientVersion,omitempty"`
+}
+
+// SystemSummary is the state of all folders and devices at one point in
+// time.
+type SystemSummary struct {
+	MyID    string                   `json:"myID"`
+	Devices map[string]DeviceSummary `json:"devices"`
+	Folders map[string]FolderSummary `json:"folders"`
+}
+
+// SystemSummary returns the state of all folders and devices, gathered in
+// a single pass under the model locks so that the numbers are mutually
+// consistent, rather than assembled from a series of separate calls with
+// updates happening in between.
+func (m *Model) SystemSummary() SystemSummary {
+	m.fmut.RLock()
+	m.pmut.RLock()
+	defer m.pmut.RUnlock()
+	defer m.fmut.RUnlock()
+
+	sum := SystemSummary{
+		MyID:    m.id.String(),
+		Devices: make(map[string]DeviceSummary),
+		Folders: make(map[string]FolderSummary),
+	}
+
+	for device := range m.cfg.Devices() {
+		hello := m.helloMessages[device]
+		versionString := hello.ClientVersion
+		if hello.ClientName != "syncthing" {
+			versionString = hello.ClientName + " " + hello.ClientVersion
+		}
+		ds := DeviceSummary{
+			Paused:        m.devicePaused[device],
+			ClientVersion: strings.TrimSpace(versionString),
+		}
+		if conn, ok := m.conn[device]; ok {
+			ds.Connected = true
+			if addr := conn.RemoteAddr(); addr != nil {
+				ds.Address = addr.String()
+			}
+		}
+		sum.Devices[device.String()] = ds
+	}
+
+	for folder, rf := range m.folderFiles {
+		var fsum FolderSummary
+		fsum.GlobalFiles, fsum.GlobalDeleted, fsum.GlobalBytes = rf.GlobalSize()
+		fsum.LocalFiles, fsum.LocalDeleted, fsum.LocalBytes = rf.LocalSize()
+
+		ignores := m.folderIgnores[folder]
+		cfg := m.folderCfgs[folder]
+		rf.WithNeedTruncated(protocol.LocalDeviceID, func(f db.FileIntf) bool {
+			if shouldIgnore(f, ignores, cfg.IgnoreDelete) {
+				return true
+			}
+			nf, de, by := sizeOfFile(f)
+			fsum.NeedFiles += nf
+			fsum.NeedDeletes += de
+			fsum.NeedBytes += by
+			return true
+		})
+
+		if fsum.GlobalBytes > 0 {
+			fsum.Completion = 100 * (1 - float64(fsum.NeedBytes)/float64(fsum.GlobalBytes))
+		} else {
+			fsum.Completion = 100
+		}
+
+		fsum.Sequence = rf.Sequence(protocol.LocalDeviceID)
+		for _, n := range m.folderDevices[folder] {
+			fsum.Sequence += rf.Sequence(n)
+		}
+
+		if runner, ok := m.folderRunners[folder]; ok {
+			state, changed, err := runner.getState()
+			fsum.State, fsum.StateChanged = state.String(), changed
+			if err != nil {
+				fsum.Error = err.Error()
+			}
+		} else {
+			fsum.State = FolderPreparing.String()
+		}
+
+		sum.Folders[folder] = fsum
+	}
+
+	return sum
+}
+
 // DeviceStatistics returns statistics about each device
 func (m *Model) DeviceStatistics() map[string]stats.DeviceStatistics {
 	var res = make(map[string]stats.DeviceStatistics)
@@ -450,6 +656,39 @@ func (m *Model) DeviceStatistics() map[string]stats.DeviceStatistics {
 	return res
 }
 
+// DBCompactionStats returns statistics about scheduled compactions of the
+// database.
+func (m *Model) DBCompactionStats() db.CompactionStats {
+	return m.db.CompactionStats()
+}
+
+// DBTransactionStats returns statistics about write transactions against
+// the database.
+func (m *Model) DBTransactionStats() db.TransactionStats {
+	return m.db.TransactionStats()
+}
+
+// CompactDB runs an immediate compaction of the index database, including
+// any separate per-folder databases.
+func (m *Model) CompactDB() error {
+	m.fmut.RLock()
+	fdbs := make([]*db.Instance, 0, len(m.folderDBs))
+	for _, fdb := range m.folderDBs {
+		fdbs = append(fdbs, fdb)
+	}
+	m.fmut.RUnlock()
+
+	if err := m.db.Compact(); err != nil {
+		return err
+	}
+	for _, fdb := range fdbs {
+		if err := fdb.Compact(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // FolderStatistics returns statistics about each folder
 func (m *Model) FolderStatistics() map[string]stats.FolderStatistics {
 	var res = make(map[string]stats.FolderStatistics)
@@ -563,6 +802,62 @@ func (m *Model) LocalSize(folder string) (nfiles, deleted int, bytes int64) {
 	return
 }
 
+// FolderDiskUsage describes the logical versus physical disk usage of a
+// folder. The logical number is the sum of all local file sizes, while the
+// physical number counts each distinct block only the first time it is
+// seen, so blocks duplicated within or across folders are free.
+type FolderDiskUsage struct {
+	Logical  int64 `json:"logical"`
+	Physical int64 `json:"physical"`
+}
+
+// DiskUsage scans the database and computes the deduplication aware disk
+// usage for each folder. It is an expensive operation, iterating all local
+// files in all folders and keeping a set of seen block hashes in memory.
+func (m *Model) DiskUsage() map[string]FolderDiskUsage {
+	m.fmut.RLock()
+	folders := make(map[string]*db.FileSet, len(m.folderFiles))
+	for folder, rf := range m.folderFiles {
+		folders[folder] = rf
+	}
+	m.fmut.RUnlock()
+
+	// Iterate the folders in a stable order, so that a block shared
+	// between folders is consistently attributed to the same one.
+	names := make([]string, 0, len(folders))
+	for folder := range folders {
+		names = append(names, folder)
+	}
+	sort.Strings(names)
+
+	res := make(map[string]FolderDiskUsage, len(folders))
+	seen := make(map[string]struct{})
+	for _, folder := range names {
+		rf := folders[folder]
+		var usage FolderDiskUsage
+		rf.WithHave(protocol.LocalDeviceID, func(fi db.FileIntf) bool {
+			f := fi.(protocol.FileInfo)
+			for _, block := range f.Blocks {
+				usage.Logical += int64(block.Size)
+				if _, ok := seen[string(block.Hash)]; !ok {
+					seen[string(block.Hash)] = struct{}{}
+					usage.Physical += int64(block.Size)
+				}
+			}
+			return true
+		})
+		res[folder] = usage
+	}
+	return res
+}
+
+// BlockStats summarizes the block map contents per folder and across
+// folders. Like DiskUsage it is an expensive operation, scanning the
+// entire block map.
+func (m *Model) BlockStats() db.BlockStats {
+	return m.db.BlockStats()
+}
+
 // NeedSize returns the number and total size of currently needed files.
 func (m *Model) NeedSize(folder string) (nfiles, ndeletes int, bytes int64) {
 	m.fmut.RLock()
@@ -589,8 +884,9 @@ func (m *Model) NeedSize(folder string) (nfiles, ndeletes int, bytes int64) {
 
 // NeedFolderFiles returns paginated list of currently needed files in
 // progress, queued, and to be queued on next puller iteration, as well as the
-// total number of files currently needed.
-func (m *Model) NeedFolderFiles(folder string, page, perpage int) ([]db.FileInfoTruncated, []db.FileInfoTruncated, []db.FileInfoTruncated, int) {
+// total number of files currently needed. A non-empty prefix restricts the
+// result to the given subdirectory.
+func (m *Model) NeedFolderFiles(folder, prefix string, page, perpage int) ([]db.FileInfoTruncated, []db.FileInfoTruncated, []db.FileInfoTruncated, int) {
 	m.fmut.RLock()
 	defer m.fmut.RUnlock()
 
@@ -610,6 +906,10 @@ func (m *Model) NeedFolderFiles(folder string, page, perpage int) ([]db.FileInfo
 	runner, ok := m.folderRunners[folder]
 	if ok {
 		allProgressNames, allQueuedNames := runner.Jobs()
+		if prefix != "" {
+			allProgressNames = filterByPrefix(allProgressNames, prefix)
+			allQueuedNames = filterByPrefix(allQueuedNames, prefix)
+		}
 
 		var progressNames, queuedNames []string
 		progressNames, skip, get = getChunk(allProgressNames, skip, get)
@@ -637,7 +937,7 @@ func (m *Model) NeedFolderFiles(folder string, page, perpage int) ([]db.FileInfo
 	rest = make([]db.FileInfoTruncated, 0, perpage)
 	ignores := m.folderIgnores[folder]
 	cfg := m.folderCfgs[folder]
-	rf.WithNeedTruncated(protocol.LocalDeviceID, func(f db.FileIntf) bool {
+	rf.WithPrefixedNeedTruncated(protocol.LocalDeviceID, prefix, func(f db.FileIntf) bool {
 		if shouldIgnore(f, ignores, cfg.IgnoreDelete) {
 			return true
 		}
@@ -660,6 +960,22 @@ func (m *Model) NeedFolderFiles(folder string, page, perpage int) ([]db.FileInfo
 	return progress, queued, rest, total
 }
 
+// filterByPrefix returns the names that are equal to or directly below
+// the given directory prefix.
+func filterByPrefix(names []string, prefix string) []string {
+	slashed := prefix
+	if !strings.HasSuffix(slashed, "/") {
+		slashed += "/"
+	}
+	var filtered []string
+	for _, name := range names {
+		if name == prefix || strings.HasPrefix(name, slashed) {
+			filtered = append(filtered, name)
+		}
+	}
+	return filtered
+}
+
 // Index is called when a new device is connected and we receive their full index.
 // Implements the protocol.Model interface.
 func (m *Model) Index(deviceID protocol.DeviceID, folder string, fs []protocol.FileInfo) {
@@ -689,7 +1005,17 @@ func (m *Model) Index(deviceID protocol.DeviceID, folder string, fs []protocol.F
 	m.deviceDownloads[deviceID].Update(folder, makeForgetUpdate(fs))
 	m.pmut.RUnlock()
 
-	files.Replace(deviceID, fs)
+	if m.blockChangeStorm(deviceID, folder, files, fs) {
+		return
+	}
+
+	m.compensateClockSkew(deviceID, fs)
+
+	if err := files.Replace(deviceID, fs); err != nil {
+		m.folderDatabaseError(folder, err)
+		return
+	}
+	m.reconcileDanglingVersions(files)
 
 	events.Default.Log(events.RemoteIndexUpdated, map[string]interface{}{
 		"device":  deviceID.String(),
@@ -722,7 +1048,17 @@ func (m *Model) IndexUpdate(deviceID protocol.DeviceID, folder string, fs []prot
 	m.deviceDownloads[deviceID].Update(folder, makeForgetUpdate(fs))
 	m.pmut.RUnlock()
 
-	files.Update(deviceID, fs)
+	if m.blockChangeStorm(deviceID, folder, files, fs) {
+		return
+	}
+
+	m.compensateClockSkew(deviceID, fs)
+
+	if err := files.Update(deviceID, fs); err != nil {
+		m.folderDatabaseError(folder, err)
+		return
+	}
+	m.reconcileDanglingVersions(files)
 
 	events.Default.Log(events.RemoteIndexUpdated, map[string]interface{}{
 		"device":  deviceID.String(),
@@ -734,6 +1070,47 @@ func (m *Model) IndexUpdate(deviceID protocol.DeviceID, folder string, fs []prot
 	runner.IndexUpdated()
 }
 
+// folderDatabaseError surfaces a database error for the given folder. The
+// folder runner enters the error state, and CheckFolderHealth keeps it
+// there -- and scans and pulls suspended -- until the process is
+// restarted, as we cannot trust the index anymore.
+func (m *Model) folderDatabaseError(folder string, err error) {
+	l.Warnf(`Folder "%v": database error: %v`, folder, err)
+
+	m.fmut.RLock()
+	runner, ok := m.folderRunners[folder]
+	m.fmut.RUnlock()
+	if ok {
+		runner.setError(errDatabaseUnhealthy)
+	}
+}
+
+// reconcileDanglingVersions processes version list entries that were found
+// referencing nonexistent file records and were dropped by the database
+// layer. We record the incident as an event and clear the affected
+// device's index ID, causing its full index to be requested again on the
+// next connection.
+func (m *Model) reconcileDanglingVersions(files *db.FileSet) {
+	for _, dv := range files.TakeDanglingVersions() {
+		l.Infof("Dropped dangling version list entry for %q / %q; re-requesting index from %v", dv.Folder, dv.File, dv.Device)
+
+		if dv.Device != protocol.LocalDeviceID {
+			m.fmut.RLock()
+			fs, ok := m.folderFiles[dv.Folder]
+			m.fmut.RUnlock()
+			if ok {
+				fs.SetIndexID(dv.Device, 0)
+			}
+		}
+
+		events.Default.Log(events.DanglingVersionDropped, map[string]interface{}{
+			"folder": dv.Folder,
+			"file":   dv.File,
+			"device": dv.Device.String(),
+		})
+	}
+}
+
 func (m *Model) folderSharedWith(folder string, deviceID protocol.DeviceID) bool {
 	m.fmut.RLock()
 	defer m.fmut.RUnlock()
@@ -764,6 +1141,9 @@ func (m *Model) ClusterConfig(deviceID protocol.DeviceID, cm protocol.ClusterCon
 		panic("bug: ClusterConfig called on closed or nonexistent connection")
 	}
 
+	m.updateDeviceHints(deviceID, cm)
+	m.updateClockSkew(deviceID, cm)
+
 	dbLocation := filepath.Dir(m.db.Location())
 
 	m.fmut.Lock()
@@ -777,7 +1157,7 @@ func (m *Model) ClusterConfig(deviceID protocol.DeviceID, cm protocol.ClusterCon
 			l.Infof("Unexpected folder ID %q sent from device %q; ensure that the folder exists and that this device is selected under \"Share With\" in the folder configuration.", folder.ID, deviceID)
 			continue
 		}
-		if !folder.DisableTempIndexes {
+		if !folder.DisableTempIndexes && !m.folderCfgs[folder.ID].EncryptTempFiles {
 			tempIndexFolders = append(tempIndexFolders, folder.ID)
 		}
 
@@ -852,11 +1232,12 @@ func (m *Model) ClusterConfig(deviceID protocol.DeviceID, cm protocol.ClusterCon
 			}
 		}
 
-		go sendIndexes(conn, folder.ID, fs, m.folderIgnores[folder.ID], startSequence, dbLocation)
+		go sendIndexes(conn, folder.ID, fs, m.folderIgnores[folder.ID], startSequence, dbLocation, m.deviceCapabilities(deviceID))
 	}
 	m.fmut.Unlock()
 
-	// This breaks if we send multiple CM messages during the same connection.
+	// Chunked CM messages carry disjoint folder sets, so the subscriptions
+	// from each chunk simply add up.
 	if len(tempIndexFolders) > 0 {
 		m.pmut.RLock()
 		conn, ok := m.conn[deviceID]
@@ -957,6 +1338,8 @@ func (m *Model) Closed(conn protocol.Connection, err error) {
 	delete(m.conn, device)
 	delete(m.helloMessages, device)
 	delete(m.deviceDownloads, device)
+	delete(m.deviceHints, device)
+	delete(m.deviceClockSkew, device)
 	closed := m.closed[device]
 	delete(m.closed, device)
 	m.pmut.Unlock()
@@ -994,6 +1377,20 @@ func (m *Model) Request(deviceID protocol.DeviceID, folder, name string, offset
 		l.Warnf("Request from %s for file %s in unshared folder %q", deviceID, name, folder)
 		return protocol.ErrNoSuchFile
 	}
+
+	if m.cfg.Devices()[deviceID].ServeBlocksOnlyInLan {
+		m.pmut.RLock()
+		conn, ok := m.conn[deviceID]
+		m.pmut.RUnlock()
+		if !ok || !conn.IsLAN {
+			// The device is configured to receive blocks only over the
+			// local network, and this connection isn't. It'll have to get
+			// the data from a closer peer.
+			l.Debugf("%v REQ(in): %s: refused; blocks served in LAN only", m, deviceID)
+			return protocol.ErrGeneric
+		}
+	}
+
 	if deviceID != protocol.LocalDeviceID {
 		l.Debugf("%v REQ(in): %s: %q / %q o=%d s=%d t=%v", m, deviceID, folder, name, offset, len(buf), fromTemporary)
 	}
@@ -1055,9 +1452,12 @@ func (m *Model) Request(deviceID protocol.DeviceID, folder, name string, offset
 
 	// Only check temp files if the flag is set, and if we are set to advertise
 	// the temp indexes.
-	if fromTemporary && !folderCfg.DisableTempIndexes {
+	// Encrypted temp files are not readable by others even if temp indexes
+	// are otherwise enabled.
+	if fromTemporary && !folderCfg.DisableTempIndexes && !folderCfg.EncryptTempFiles {
 		tempFn := filepath.Join(folderPath, defTempNamer.TempName(name))
 		if err := readOffsetIntoBuf(tempFn, offset, buf); err == nil {
+			m.addFolderOutBytes(folder, int64(len(buf)))
 			return nil
 		}
 		// Fall through to reading from a non-temp file, just incase the temp
@@ -1070,6 +1470,7 @@ func (m *Model) Request(deviceID protocol.DeviceID, folder, name string, offset
 	} else if err != nil {
 		return protocol.ErrGeneric
 	}
+	m.addFolderOutBytes(folder, int64(len(buf)))
 	return nil
 }
 
@@ -1208,9 +1609,10 @@ func (m *Model) OnHello(remoteID protocol.DeviceID, addr net.Addr, hello protoco
 // GetHello is called when we are about to connect to some remote device.
 func (m *Model) GetHello(protocol.DeviceID) protocol.HelloIntf {
 	return &protocol.Hello{
-		DeviceName:    m.deviceName,
-		ClientName:    m.clientName,
-		ClientVersion: m.clientVersion,
+		DeviceName:           m.deviceName,
+		ClientName:           m.clientName,
+		ClientVersion:        m.clientVersion,
+		ChunkedClusterConfig: true,
 	}
 }
 
@@ -1258,6 +1660,30 @@ func (m *Model) AddConnection(conn connections.Connection, hello protocol.HelloR
 
 	l.Infof(`Device %s client is "%s %s" named "%s"`, deviceID, hello.ClientName, hello.ClientVersion, hello.DeviceName)
 
+	// Tell the connection which files must not have their response data
+	// compressed, per folder extension rules.
+	if hc, ok := conn.Connection.(interface {
+		SetResponseCompressionHint(func(folder, name string) bool)
+	}); ok {
+		hc.SetResponseCompressionHint(m.allowResponseCompression)
+	}
+
+	// Back off serving blocks when the uplink is saturated, unless the
+	// user has turned that off.
+	if cc, ok := conn.Connection.(interface {
+		SetCongestionAvoidance(bool)
+	}); ok {
+		cc.SetCongestionAvoidance(m.cfg.Options().CongestionAvoidance)
+	}
+
+	// Only chunk large cluster configs for peers that announced accepting
+	// them; older peers drop the connection on a repeated ClusterConfig.
+	if cc, ok := conn.Connection.(interface {
+		SetClusterConfigChunking(bool)
+	}); ok {
+		cc.SetClusterConfigChunking(hello.ChunkedClusterConfig)
+	}
+
 	conn.Start()
 
 	cm := m.generateClusterConfig(deviceID)
@@ -1274,6 +1700,19 @@ func (m *Model) AddConnection(conn connections.Connection, hello protocol.HelloR
 	m.deviceWasSeen(deviceID)
 }
 
+// allowResponseCompression returns false when an extension rule for the
+// folder says response data for the given file should not be compressed.
+func (m *Model) allowResponseCompression(folder, name string) bool {
+	folderCfg, ok := m.cfg.Folders()[folder]
+	if !ok {
+		return true
+	}
+	if rule, ok := folderCfg.ExtensionRule(name); ok && rule.NoCompress {
+		return false
+	}
+	return true
+}
+
 func (m *Model) PauseDevice(device protocol.DeviceID) {
 	m.pmut.Lock()
 	m.devicePaused[device] = true
@@ -1353,11 +1792,23 @@ func (m *Model) folderStatRef(folder string) *stats.FolderStatisticsReference {
 	return sr
 }
 
+func (m *Model) folderProvRef(folder string) *stats.ProvenanceReference {
+	m.fmut.Lock()
+	defer m.fmut.Unlock()
+
+	pr, ok := m.folderProvRefs[folder]
+	if !ok {
+		pr = stats.NewProvenanceReference(m.db, folder)
+		m.folderProvRefs[folder] = pr
+	}
+	return pr
+}
+
 func (m *Model) receivedFile(folder string, file protocol.FileInfo) {
 	m.folderStatRef(folder).ReceivedFile(file.Name, file.IsDeleted())
 }
 
-func sendIndexes(conn protocol.Connection, folder string, fs *db.FileSet, ignores *ignore.Matcher, startSequence int64, dbLocation string) {
+func sendIndexes(conn protocol.Connection, folder string, fs *db.FileSet, ignores *ignore.Matcher, startSequence int64, dbLocation string, caps resourceHints) {
 	deviceID := conn.ID()
 	name := conn.Name()
 	var err error
@@ -1365,7 +1816,7 @@ func sendIndexes(conn protocol.Connection, folder string, fs *db.FileSet, ignore
 	l.Debugf("sendIndexes for %s-%s/%q starting (slv=%d)", deviceID, name, folder, startSequence)
 	defer l.Debugf("sendIndexes for %s-%s/%q exiting: %v", deviceID, name, folder, err)
 
-	minSequence, err := sendIndexTo(startSequence, conn, folder, fs, ignores, dbLocation)
+	minSequence, err := sendIndexTo(startSequence, conn, folder, fs, ignores, dbLocation, caps)
 
 	// Subscribe to LocalIndexUpdated (we have new information to send) and
 	// DeviceDisconnected (it might be us who disconnected, so we should
@@ -1388,7 +1839,7 @@ func sendIndexes(conn protocol.Connection, folder string, fs *db.FileSet, ignore
 			continue
 		}
 
-		minSequence, err = sendIndexTo(minSequence, conn, folder, fs, ignores, dbLocation)
+		minSequence, err = sendIndexTo(minSequence, conn, folder, fs, ignores, dbLocation, caps)
 
 		// Wait a short amount of time before entering the next loop. If there
 		// are continuous changes happening to the local index, this gives us
@@ -1397,7 +1848,7 @@ func sendIndexes(conn protocol.Connection, folder string, fs *db.FileSet, ignore
 	}
 }
 
-func sendIndexTo(minSequence int64, conn protocol.Connection, folder string, fs *db.FileSet, ignores *ignore.Matcher, dbLocation string) (int64, error) {
+func sendIndexTo(minSequence int64, conn protocol.Connection, folder string, fs *db.FileSet, ignores *ignore.Matcher, dbLocation string, caps resourceHints) (int64, error) {
 	deviceID := conn.ID()
 	name := conn.Name()
 	batch := make([]protocol.FileInfo, 0, indexBatchSize)
@@ -1406,24 +1857,21 @@ func sendIndexTo(minSequence int64, conn protocol.Connection, folder string, fs
 	maxSequence := minSequence
 	var err error
 
-	sorter := NewIndexSorter(dbLocation)
-	defer sorter.Close()
-
-	fs.WithHave(protocol.LocalDeviceID, func(fi db.FileIntf) bool {
-		f := fi.(protocol.FileInfo)
-		if f.Sequence <= minSequence {
-			return true
-		}
-
+	send := func(f protocol.FileInfo) bool {
 		if f.Sequence > maxSequence {
 			maxSequence = f.Sequence
 		}
 
-		sorter.Append(f)
-		return true
-	})
+		if !caps.hardLinks {
+			// The receiver has not announced the hard link capability, so
+			// don't confuse it with link groups.
+			f.LinkGroup = ""
+		}
+		if !caps.fileMetadata {
+			// Likewise for the metadata extension.
+			f.Metadata = nil
+		}
 
-	sorter.Sorted(func(f protocol.FileInfo) bool {
 		if len(batch) == indexBatchSize || currentBatchSize > indexTargetSize {
 			if initial {
 				if err = conn.Index(folder, batch); err != nil {
@@ -1445,7 +1893,27 @@ func sendIndexTo(minSequence int64, conn protocol.Connection, folder string, fs
 		batch = append(batch, f)
 		currentBatchSize += f.ProtoSize()
 		return true
-	})
+	}
+
+	if initial {
+		// The initial index covers the whole folder and is sent in name
+		// order, which requires a spill-to-disk sort of the contents.
+		sorter := NewIndexSorter(dbLocation)
+		defer sorter.Close()
+
+		fs.WithHave(protocol.LocalDeviceID, func(fi db.FileIntf) bool {
+			sorter.Append(fi.(protocol.FileInfo))
+			return true
+		})
+		sorter.Sorted(send)
+	} else {
+		// Delta updates cover only the files above the watermark. The
+		// sequence index returns them in sending order directly, without
+		// iterating the rest of the folder.
+		fs.WithHaveSequence(minSequence+1, func(fi db.FileIntf) bool {
+			return send(fi.(protocol.FileInfo))
+		})
+	}
 
 	if initial && err == nil {
 		err = conn.Index(folder, batch)
@@ -1469,23 +1937,72 @@ func (m *Model) updateLocalsFromScanning(folder string, fs []protocol.FileInfo)
 	folderCfg := m.folderCfgs[folder]
 	m.fmut.RUnlock()
 
+	// Changes detected by scanning originate from ourselves.
+	prov := m.folderProvRef(folder)
+	now := time.Now()
+	us := m.id.String()
+	for _, file := range fs {
+		prov.Record(file.Name, fmt.Sprintf("%v", file.Version), us, file.IsDeleted(), now)
+	}
+
 	// Fire the LocalChangeDetected event to notify listeners about local updates.
 	m.localChangeDetected(folderCfg, fs)
 }
 
 func (m *Model) updateLocalsFromPulling(folder string, fs []protocol.FileInfo) {
 	m.updateLocals(folder, fs)
+
+	m.fmut.RLock()
+	files := m.folderFiles[folder]
+	m.fmut.RUnlock()
+	if files == nil {
+		return
+	}
+
+	// Attribute each pulled change to the first device announcing the
+	// version we just applied. With concurrent modifications on several
+	// devices the attribution is approximate, but it answers "who changed
+	// this file" for the common case.
+	prov := m.folderProvRef(folder)
+	now := time.Now()
+	for _, file := range fs {
+		by := ""
+		if av := files.Availability(file.Name); len(av) > 0 {
+			by = av[0].String()
+		}
+		prov.Record(file.Name, fmt.Sprintf("%v", file.Version), by, file.IsDeleted(), now)
+	}
+}
+
+// FileProvenance returns the provenance record for the given file: which
+// device the version we have came from, and when it was applied.
+func (m *Model) FileProvenance(folder, file string) (stats.FileProvenance, bool) {
+	return m.folderProvRef(folder).Get(file)
+}
+
+// FolderProvenanceSummary returns the number of changes applied per
+// originating device and the most recent change for the folder.
+func (m *Model) FolderProvenanceSummary(folder string) stats.ProvenanceSummary {
+	return m.folderProvRef(folder).Summary()
 }
 
 func (m *Model) updateLocals(folder string, fs []protocol.FileInfo) {
 	m.fmut.RLock()
 	files := m.folderFiles[folder]
+	folderCfg := m.folderCfgs[folder]
 	m.fmut.RUnlock()
 	if files == nil {
 		// The folder doesn't exist.
 		return
 	}
-	files.Update(protocol.LocalDeviceID, fs)
+	if err := files.Update(protocol.LocalDeviceID, fs); err != nil {
+		m.folderDatabaseError(folder, err)
+		return
+	}
+
+	if folderCfg.GeneratePreviews {
+		previewJobs.queueFor(folderCfg.Path(), fs)
+	}
 
 	filenames := make([]string, len(fs))
 	for i, file := range fs {
@@ -1550,7 +2067,11 @@ func (m *Model) requestGlobal(deviceID protocol.DeviceID, folder, name string, o
 
 	l.Debugf("%v REQ(out): %s: %q / %q o=%d s=%d h=%x ft=%t", m, deviceID, folder, name, offset, size, hash, fromTemporary)
 
-	return nc.Request(folder, name, offset, size, hash, fromTemporary)
+	buf, err := nc.Request(folder, name, offset, size, hash, fromTemporary)
+	if err == nil {
+		m.addFolderInBytes(folder, int64(len(buf)))
+	}
+	return buf, err
 }
 
 func (m *Model) ScanFolders() map[string]error {
@@ -1604,7 +2125,7 @@ func (m *Model) ScanFolderSubdirs(folder string, subs []string) error {
 	// scan them before they have started, so that's what we need to check for
 	// here.
 	if !ok {
-		return errors.New("no such folder")
+		return errFolderMissing
 	}
 
 	return runner.Scan(subs)
@@ -1614,7 +2135,7 @@ func (m *Model) internalScanFolderSubdirs(folder string, subDirs []string) error
 	for i, sub := range subDirs {
 		sub = osutil.NativeFilename(sub)
 		if p := filepath.Clean(filepath.Join(folder, sub)); !strings.HasPrefix(p, folder) {
-			return errors.New("invalid subpath")
+			return errInvalidSubpath
 		}
 		subDirs[i] = sub
 	}
@@ -1643,7 +2164,7 @@ func (m *Model) internalScanFolderSubdirs(folder string, subDirs []string) error
 	// scan them before they have started, so that's what we need to check for
 	// here.
 	if !ok {
-		return errors.New("no such folder")
+		return errFolderMissing
 	}
 
 	if err := m.CheckFolderHealth(folder); err != nil {
@@ -1652,6 +2173,13 @@ func (m *Model) internalScanFolderSubdirs(folder string, subDirs []string) error
 		return err
 	}
 
+	if fs.Sequence(protocol.LocalDeviceID) == 0 {
+		// The initial scan of a fresh folder writes very many small
+		// batches; bulk mode enlarges them until the scan is done.
+		m.db.StartBulk()
+		defer m.db.EndBulk()
+	}
+
 	if err := ignores.Load(filepath.Join(folderCfg.Path(), ".stignore")); err != nil && !os.IsNotExist(err) {
 		err = fmt.Errorf("loading ignores: %v", err)
 		runner.setError(err)
@@ -1674,12 +2202,19 @@ func (m *Model) internalScanFolderSubdirs(folder string, subDirs []string) error
 
 	runner.setState(FolderScanning)
 
+	hashAlg, err := scanner.HashAlgorithmFromString(folderCfg.HashAlgorithm)
+	if err != nil {
+		runner.setError(err)
+		return err
+	}
+
 	fchan, err := scanner.Walk(scanner.Config{
 		Folder:                folderCfg.ID,
 		Dir:                   folderCfg.Path(),
 		Subs:                  subDirs,
 		Matcher:               ignores,
 		BlockSize:             protocol.BlockSize,
+		Hash:                  hashAlg,
 		TempNamer:             defTempNamer,
 		TempLifetime:          time.Duration(m.cfg.Options().KeepTemporariesH) * time.Hour,
 		CurrentFiler:          cFiler{m, folder},
@@ -1689,6 +2224,7 @@ func (m *Model) internalScanFolderSubdirs(folder string, subDirs []string) error
 		Hashers:               m.numHashers(folder),
 		ShortID:               m.shortID,
 		ProgressTickIntervalS: folderCfg.ScanProgressIntervalS,
+		DetectContentType:     folderCfg.DetectContentTypes,
 		Cancel:                cancel,
 	})
 
@@ -1862,6 +2398,7 @@ func (m *Model) generateClusterConfig(device protocol.DeviceID) protocol.Cluster
 	var message protocol.ClusterConfig
 
 	m.fmut.RLock()
+	hints := m.localHints
 	for _, folder := range m.deviceFolders[device] {
 		folderCfg := m.cfg.Folders()[folder]
 		fs := m.folderFiles[folder]
@@ -1872,7 +2409,7 @@ func (m *Model) generateClusterConfig(device protocol.DeviceID) protocol.Cluster
 			ReadOnly:           folderCfg.Type == config.FolderTypeReadOnly,
 			IgnorePermissions:  folderCfg.IgnorePerms,
 			IgnoreDelete:       folderCfg.IgnoreDelete,
-			DisableTempIndexes: folderCfg.DisableTempIndexes,
+			DisableTempIndexes: folderCfg.DisableTempIndexes || folderCfg.EncryptTempFiles,
 		}
 
 		for _, device := range m.folderDevices[folder] {
@@ -1904,6 +2441,19 @@ func (m *Model) generateClusterConfig(device protocol.DeviceID) protocol.Cluster
 				MaxSequence: maxSequence,
 			}
 
+			if device == m.id {
+				// Announce our own resource hints, so the other side can
+				// avoid using us as a primary block source if warranted.
+				protocolDevice.SpinningDisk = hints.spinningDisk
+				protocolDevice.Metered = hints.metered
+				protocolDevice.BatteryPowered = hints.batteryPowered
+				protocolDevice.HardLinks = true
+				protocolDevice.FileMetadata = true
+				protocolDevice.IndexDictionary = true
+				// And our wall clock, for skew detection on the other side.
+				protocolDevice.LocalTime = time.Now().UnixNano()
+			}
+
 			protocolFolder.Devices = append(protocolFolder.Devices, protocolDevice)
 		}
 		message.Folders = append(message.Folders, protocolFolder)
@@ -1918,6 +2468,12 @@ func (m *Model) State(folder string) (string, time.Time, error) {
 	runner, ok := m.folderRunners[folder]
 	m.fmut.RUnlock()
 	if !ok {
+		if _, exists := m.cfg.Folders()[folder]; exists {
+			// The folder is configured but its runner has not been
+			// started yet, which happens during startup while folders
+			// come up in the background.
+			return FolderPreparing.String(), time.Time{}, nil
+		}
 		// The returned error should be an actual folder error, so returning
 		// errors.New("does not exist") or similar here would be
 		// inappropriate.
@@ -1927,6 +2483,19 @@ func (m *Model) State(folder string) (string, time.Time, error) {
 	return state.String(), changed, err
 }
 
+// DropDeviceVersions removes lingering index data for devices that are no
+// longer sharing the given folder, and returns the number of version list
+// entries dropped.
+func (m *Model) DropDeviceVersions(folder string) (int, error) {
+	m.fmut.RLock()
+	fs, ok := m.folderFiles[folder]
+	m.fmut.RUnlock()
+	if !ok {
+		return 0, fmt.Errorf("no such folder %q", folder)
+	}
+	return fs.DropDeviceVersions(), nil
+}
+
 func (m *Model) Override(folder string) {
 	m.fmut.RLock()
 	fs, ok := m.folderFiles[folder]
@@ -2057,9 +2626,15 @@ func (m *Model) GlobalDirectoryTree(folder, prefix string, levels int, dirsonly
 		}
 
 		if !dirsonly && base != "" {
-			last[base] = []interface{}{
+			entry := []interface{}{
 				f.ModTime(), f.FileSize(),
 			}
+			if ct := f.MetadataValue(scanner.ContentTypeKey); ct != "" {
+				// The content type, when detected, lets clients build
+				// media aware views without reading file contents.
+				entry = append(entry, ct)
+			}
+			last[base] = entry
 		}
 
 		return true
@@ -2068,6 +2643,107 @@ func (m *Model) GlobalDirectoryTree(folder, prefix string, levels int, dirsonly
 	return output
 }
 
+// indexSnapshotHeader is the first document of an index snapshot stream.
+type indexSnapshotHeader struct {
+	Folder  string    `json:"folder"`
+	Device  string    `json:"device"`
+	Created time.Time `json:"created"`
+}
+
+// ExportIndex writes a portable snapshot of the local index of the given
+// folder to w, as a stream of JSON documents: a header followed by one
+// file entry per document, including blocklists.
+func (m *Model) ExportIndex(folder string, w io.Writer) error {
+	m.fmut.RLock()
+	fs, ok := m.folderFiles[folder]
+	m.fmut.RUnlock()
+	if !ok {
+		return fmt.Errorf("no such folder %q", folder)
+	}
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(indexSnapshotHeader{
+		Folder:  folder,
+		Device:  m.id.String(),
+		Created: time.Now(),
+	}); err != nil {
+		return err
+	}
+
+	var err error
+	fs.WithHave(protocol.LocalDeviceID, func(fi db.FileIntf) bool {
+		f := fi.(protocol.FileInfo)
+		// Sequence numbers are local to a device and are reassigned on
+		// import.
+		f.Sequence = 0
+		err = enc.Encode(f)
+		return err == nil
+	})
+	return err
+}
+
+// ImportIndex reads an index snapshot created by ExportIndex and applies
+// it as the local index of the given folder, so that a device whose data
+// was pre-seeded by external copy can skip the initial hashing scan. It
+// returns the number of imported entries.
+func (m *Model) ImportIndex(folder string, r io.Reader) (int, error) {
+	m.fmut.RLock()
+	_, ok := m.folderFiles[folder]
+	m.fmut.RUnlock()
+	if !ok {
+		return 0, fmt.Errorf("no such folder %q", folder)
+	}
+
+	dec := json.NewDecoder(r)
+	var hdr indexSnapshotHeader
+	if err := dec.Decode(&hdr); err != nil {
+		return 0, err
+	}
+
+	imported := 0
+	batch := make([]protocol.FileInfo, 0, indexBatchSize)
+	for {
+		var f protocol.FileInfo
+		if err := dec.Decode(&f); err == io.EOF {
+			break
+		} else if err != nil {
+			return imported, err
+		}
+		f.Sequence = 0
+		batch = append(batch, f)
+		if len(batch) == indexBatchSize {
+			m.updateLocals(folder, batch)
+			imported += len(batch)
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		m.updateLocals(folder, batch)
+		imported += len(batch)
+	}
+	return imported, nil
+}
+
+// CheckDBIntegrity runs an integrity check over the index database at the
+// given repair level and returns the per folder results. After a repair
+// the in-memory size accounting of affected folders is rebuilt from the
+// database.
+func (m *Model) CheckDBIntegrity(level db.CheckLevel) []db.CheckResult {
+	res := m.db.CheckIntegrity(level)
+	if level == db.CheckFix {
+		m.fmut.RLock()
+		for _, r := range res {
+			if r.Repaired {
+				if fs, ok := m.folderFiles[r.Folder]; ok {
+					fs.RecalculateSizes()
+				}
+			}
+		}
+		m.fmut.RUnlock()
+	}
+	return res
+}
+
 func (m *Model) Availability(folder, file string, version protocol.Vector, block protocol.BlockInfo) []Availability {
 	// Acquire this lock first, as the value returned from foldersFiles can
 	// get heavily modified on Close()
@@ -2099,6 +2775,109 @@ func (m *Model) Availability(folder, file string, version protocol.Vector, block
 	return availabilities
 }
 
+// A FileHolder describes one device's copy of a file: the version that
+// device has announced in its index, whether that matches the current
+// global version, and whether the device is currently connected.
+type FileHolder struct {
+	Device     protocol.DeviceID `json:"device"`
+	Version    protocol.Vector   `json:"version"`
+	Deleted    bool              `json:"deleted"`
+	Invalid    bool              `json:"invalid"`
+	HaveGlobal bool              `json:"haveGlobal"`
+	Connected  bool              `json:"connected"`
+}
+
+// A BlockHolder is a file whose latest scanned version contains a given
+// block, together with the devices that hold that file.
+type BlockHolder struct {
+	Folder  string       `json:"folder"`
+	File    string       `json:"file"`
+	Index   int32        `json:"index"`
+	Holders []FileHolder `json:"holders"`
+}
+
+// FileHolders returns, for each device sharing the folder, the version of
+// the given file that device has announced. Devices that have never
+// announced the file are not listed. It returns nil for unknown folders.
+func (m *Model) FileHolders(folder, file string) []FileHolder {
+	// Lock order must be the same as in Availability.
+	m.pmut.RLock()
+	defer m.pmut.RUnlock()
+
+	m.fmut.RLock()
+	fs, ok := m.folderFiles[folder]
+	devices := m.folderDevices[folder]
+	m.fmut.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	global, haveGlobal := fs.GetGlobal(file)
+
+	var holders []FileHolder
+	for _, device := range devices {
+		f, ok := fs.Get(device, file)
+		if !ok {
+			continue
+		}
+		_, connected := m.conn[device]
+		holders = append(holders, FileHolder{
+			Device:     device,
+			Version:    f.Version,
+			Deleted:    f.IsDeleted(),
+			Invalid:    f.IsInvalid(),
+			HaveGlobal: haveGlobal && f.Version.Equal(global.Version),
+			Connected:  device == m.id || connected,
+		})
+	}
+
+	return holders
+}
+
+// BlockHolders returns the files whose latest scanned version contains the
+// block with the given hash, and for each of them the devices holding the
+// file. An empty folder means all folders.
+func (m *Model) BlockHolders(folder string, hash []byte) []BlockHolder {
+	var folders []string
+	if folder != "" {
+		folders = []string{folder}
+	} else {
+		m.fmut.RLock()
+		for folder := range m.folderCfgs {
+			folders = append(folders, folder)
+		}
+		m.fmut.RUnlock()
+	}
+
+	var holders []BlockHolder
+	m.finder.Iterate(folders, hash, func(folder, file string, index int32) bool {
+		holders = append(holders, BlockHolder{
+			Folder:  folder,
+			File:    file,
+			Index:   index,
+			Holders: m.FileHolders(folder, file),
+		})
+		// Keep iterating; we want all files containing the block, not
+		// just the first.
+		return false
+	})
+
+	return holders
+}
+
+// OpenPreview opens the generated preview image for the given file, for
+// folders that generate previews. The caller is responsible for closing
+// the returned file.
+func (m *Model) OpenPreview(folder, file string) (*os.File, error) {
+	m.fmut.RLock()
+	folderCfg, ok := m.folderCfgs[folder]
+	m.fmut.RUnlock()
+	if !ok || !folderCfg.GeneratePreviews {
+		return nil, os.ErrNotExist
+	}
+	return previews.Open(folderCfg.Path(), file)
+}
+
 // BringToFront bumps the given files priority in the job queue.
 func (m *Model) BringToFront(folder, file string) {
 	m.pmut.RLock()
@@ -2110,19 +2889,227 @@ func (m *Model) BringToFront(folder, file string) {
 	}
 }
 
+// SendToBack demotes the given file to the end of the job queue.
+func (m *Model) SendToBack(folder, file string) {
+	m.pmut.RLock()
+	defer m.pmut.RUnlock()
+
+	runner, ok := m.folderRunners[folder]
+	if ok {
+		runner.SendToBack(file)
+	}
+}
+
+// SkipJob removes the given file from the job queue for the current pull
+// iteration.
+func (m *Model) SkipJob(folder, file string) {
+	m.pmut.RLock()
+	defer m.pmut.RUnlock()
+
+	runner, ok := m.folderRunners[folder]
+	if ok {
+		runner.SkipJob(file)
+	}
+}
+
+// RetryJob clears any pull error for the given file and triggers a new
+// attempt at it.
+func (m *Model) RetryJob(folder, file string) {
+	m.pmut.RLock()
+	defer m.pmut.RUnlock()
+
+	runner, ok := m.folderRunners[folder]
+	if ok {
+		runner.RetryJob(file)
+	}
+}
+
+// folderVersionsDir returns the directory where archived versions are kept
+// for the given folder.
+func folderVersionsDir(folderCfg config.FolderConfiguration) string {
+	if path := folderCfg.Versioning.Params["versionsPath"]; path != "" {
+		if filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(folderCfg.Path(), path)
+	}
+	return filepath.Join(folderCfg.Path(), ".stversions")
+}
+
+// FolderVersions returns all archived versions for the given folder, keyed
+// by file name.
+func (m *Model) FolderVersions(folder string) (map[string][]versioner.ArchivedVersion, error) {
+	folderCfg, ok := m.cfg.Folders()[folder]
+	if !ok {
+		return nil, errFolderMissing
+	}
+
+	versions, err := versioner.ListArchivedVersions(folderVersionsDir(folderCfg))
+	if os.IsNotExist(err) {
+		// No versions have been archived yet.
+		return map[string][]versioner.ArchivedVersion{}, nil
+	}
+	return versions, err
+}
+
+// VersionsAsOf returns, for each file that has archived versions, the
+// archived copy that represented the file content at the given time. Files
+// without an entry in the result were already at their current content at
+// that time, as far as the version store knows.
+func (m *Model) VersionsAsOf(folder string, asOf time.Time) (map[string]versioner.ArchivedVersion, error) {
+	versions, err := m.FolderVersions(folder)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]versioner.ArchivedVersion)
+	for name, vs := range versions {
+		if v, ok := versioner.VersionAsOf(vs, asOf); ok {
+			res[name] = v
+		}
+	}
+	return res, nil
+}
+
+// RestoreVersionsAsOf restores the given paths to their content as of the
+// given time, archiving the current copies so the restore itself can be
+// undone. It returns a map from path to error message for the paths that
+// could not be restored.
+func (m *Model) RestoreVersionsAsOf(folder string, asOf time.Time, paths []string) (map[string]string, error) {
+	folderCfg, ok := m.cfg.Folders()[folder]
+	if !ok {
+		return nil, errFolderMissing
+	}
+
+	versionsDir := folderVersionsDir(folderCfg)
+	versions, err := m.FolderVersions(folder)
+	if err != nil {
+		return nil, err
+	}
+
+	restoreErrors := make(map[string]string)
+	var restored []string
+	for _, path := range paths {
+		if err := restoreVersionAsOf(folderCfg.Path(), versionsDir, versions[path], path, asOf); err != nil {
+			restoreErrors[path] = err.Error()
+		} else {
+			restored = append(restored, path)
+		}
+	}
+
+	if len(restored) > 0 {
+		// Pick up the restored files so they are announced to the cluster.
+		// Any scan error will surface through the usual channels.
+		m.ScanFolderSubdirs(folder, restored)
+	}
+
+	return restoreErrors, nil
+}
+
+func restoreVersionAsOf(folderPath, versionsDir string, versions []versioner.ArchivedVersion, path string, asOf time.Time) error {
+	v, ok := versioner.VersionAsOf(versions, asOf)
+	if !ok {
+		return errNoArchivedVersion
+	}
+
+	target := filepath.Join(folderPath, filepath.FromSlash(path))
+	source := filepath.Join(versionsDir, v.Path)
+
+	// Archive the current copy, if any, so that the restore can be undone.
+	if _, err := osutil.Lstat(target); err == nil {
+		archived := filepath.Join(versionsDir, versioner.TagFilename(filepath.FromSlash(path), time.Now()))
+		if err := os.MkdirAll(filepath.Dir(archived), 0755); err != nil {
+			return err
+		}
+		if err := osutil.Rename(target, archived); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	return osutil.Copy(source, target)
+}
+
+// PullQueue returns the contents of the pull queue for the given folder,
+// in queue order.
+func (m *Model) PullQueue(folder string) []PullQueueItem {
+	m.pmut.RLock()
+	defer m.pmut.RUnlock()
+
+	runner, ok := m.folderRunners[folder]
+	if !ok {
+		return nil
+	}
+	return runner.PullQueue()
+}
+
 // CheckFolderHealth checks the folder for common errors and returns the
 // current folder error, or nil if the folder is healthy.
+// folderDependencyPending returns the folder that the given folder is
+// configured to wait for, and true, when that folder has not yet reached
+// its configured completion level. Dependencies on unknown folders and
+// dependency cycles are ignored rather than stalling pulls forever.
+func (m *Model) folderDependencyPending(folder string) (string, bool) {
+	cfgs := m.cfg.Folders()
+
+	dep := cfgs[folder].PullDependsOn
+	if dep == "" {
+		return "", false
+	}
+	if _, ok := cfgs[dep]; !ok {
+		return "", false
+	}
+
+	// Walk the dependency chain; if it leads back to us there is a cycle
+	// and waiting would deadlock the folders involved.
+	seen := map[string]bool{folder: true}
+	for next := dep; next != ""; next = cfgs[next].PullDependsOn {
+		if seen[next] {
+			l.Debugln("folder", folder, "has a dependency cycle via", next, "; ignoring dependency")
+			return "", false
+		}
+		seen[next] = true
+	}
+
+	target := cfgs[folder].PullDependsOnPct
+	if target <= 0 || target > 100 {
+		target = 100
+	}
+
+	_, _, globalBytes := m.GlobalSize(dep)
+	_, _, needBytes := m.NeedSize(dep)
+	completion := 100.0
+	if globalBytes > 0 {
+		completion = 100 * float64(globalBytes-needBytes) / float64(globalBytes)
+	}
+	if completion >= target {
+		return "", false
+	}
+	return dep, true
+}
+
 func (m *Model) CheckFolderHealth(id string) error {
 	folder, ok := m.cfg.Folders()[id]
 	if !ok {
-		return errors.New("folder does not exist")
+		return errFolderMissing
 	}
 
 	// Check for folder errors, with the most serious and specific first and
 	// generic ones like out of space on the home disk later. Note the
 	// inverted error flow (err==nil checks) here.
 
-	err := m.checkFolderPath(folder)
+	err := m.checkFolderDatabase(id)
+	if err == nil {
+		err = m.checkFolderPath(folder)
+	}
+	if err == nil {
+		err = m.checkFolderOverlap(folder)
+	}
+	if err == nil {
+		err = m.checkFolderWritable(folder)
+	}
 	if err == nil {
 		err = m.checkFolderFreeSpace(folder)
 	}
@@ -2137,6 +3124,21 @@ func (m *Model) CheckFolderHealth(id string) error {
 	return err
 }
 
+// checkFolderDatabase returns nil if the database holding the folder's
+// index has not encountered any errors.
+func (m *Model) checkFolderDatabase(folder string) error {
+	m.fmut.RLock()
+	fdb, ok := m.folderDBs[folder]
+	m.fmut.RUnlock()
+	if !ok {
+		fdb = m.db
+	}
+	if fdb.Unhealthy() != nil {
+		return errDatabaseUnhealthy
+	}
+	return nil
+}
+
 // checkFolderPath returns nil if the folder path exists and has the marker file.
 func (m *Model) checkFolderPath(folder config.FolderConfiguration) error {
 	if folder.Path() == "" {
@@ -2154,6 +3156,65 @@ func (m *Model) checkFolderPath(folder config.FolderConfiguration) error {
 	return nil
 }
 
+// checkFolderOverlap returns nil if the folder path, with symlinks
+// resolved, does not equal or nest within the path of another configured
+// folder. Overlapping folders scan and sync each other's changes in a
+// feedback loop, so they are kept in the error state instead.
+func (m *Model) checkFolderOverlap(folder config.FolderConfiguration) error {
+	path := resolveFolderPath(folder)
+	for id, other := range m.cfg.Folders() {
+		if id == folder.ID {
+			continue
+		}
+		if config.FolderPathsOverlap(path, resolveFolderPath(other)) {
+			return errcat.Wrap(errFolderOverlap.Code(), fmt.Errorf("folder path overlaps folder %q", id))
+		}
+	}
+	return nil
+}
+
+// resolveFolderPath returns the folder path with symlinks resolved, so
+// that overlap detection catches folders nested by way of a symlink.
+func resolveFolderPath(folder config.FolderConfiguration) string {
+	path := folder.Path()
+	if path == "" {
+		return ""
+	}
+	return resolveSymlinks(filepath.Clean(path))
+}
+
+// resolveSymlinks resolves symlinks in as much of the path as exists, so
+// that paths that do not (yet) exist still compare correctly against
+// their resolved parents.
+func resolveSymlinks(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	dir := filepath.Dir(path)
+	if dir == path {
+		return path
+	}
+	return filepath.Join(resolveSymlinks(dir), filepath.Base(path))
+}
+
+// checkFolderWritable returns nil if the folder's filesystem accepts
+// writes, or if the folder type does not receive changes. Filesystems
+// remounted read only after errors, and write protected removable media,
+// otherwise cause an endless stream of failed pulls. The folder resumes
+// by itself when writability returns, through the regular health checks.
+func (m *Model) checkFolderWritable(folder config.FolderConfiguration) error {
+	if folder.Type == config.FolderTypeReadOnly {
+		// A send only folder does not need write access.
+		return nil
+	}
+
+	if err := osutil.IsWritable(folder.Path()); err != nil {
+		return errFolderReadOnly
+	}
+
+	return nil
+}
+
 // checkFolderFreeSpace returns nil if the folder has the required amount of
 // free space, or if folder free space checking is disabled.
 func (m *Model) checkFolderFreeSpace(folder config.FolderConfiguration) error {
@@ -2217,6 +3278,13 @@ func (m *Model) runnerExchangeError(folder config.FolderConfiguration, err error
 
 func (m *Model) ResetFolder(folder string) {
 	l.Infof("Cleaning data for folder %q", folder)
+	m.fmut.RLock()
+	fdb, ok := m.folderDBs[folder]
+	m.fmut.RUnlock()
+	if ok {
+		db.DropFolder(fdb, folder)
+		return
+	}
 	db.DropFolder(m.db, folder)
 }
 
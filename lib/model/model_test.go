@@ -276,6 +276,8 @@ func (f *FakeConnection) DownloadProgress(folder string, updates []protocol.File
 	})
 }
 
+func (FakeConnection) ConfigUpdate(update protocol.ConfigUpdate) {}
+
 func BenchmarkRequest(b *testing.B) {
 	db := db.OpenMemory()
 	m := NewModel(defaultConfig, protocol.LocalDeviceID, "device", "syncthing", "dev", db, nil)
@@ -318,7 +320,12 @@ func TestDeviceRename(t *testing.T) {
 		ClientName:    "syncthing",
 		ClientVersion: "v0.9.4",
 	}
-	defer os.Remove("tmpconfig.xml")
+	tmpdir, err := ioutil.TempDir("", "syncthing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	cfgFile := filepath.Join(tmpdir, "tmpconfig.xml")
 
 	rawCfg := config.New(device1)
 	rawCfg.Devices = []config.DeviceConfiguration{
@@ -326,7 +333,7 @@ func TestDeviceRename(t *testing.T) {
 			DeviceID: device1,
 		},
 	}
-	cfg := config.Wrap("tmpconfig.xml", rawCfg)
+	cfg := config.Wrap(cfgFile, rawCfg)
 
 	db := db.OpenMemory()
 	m := NewModel(cfg, protocol.LocalDeviceID, "device", "syncthing", "dev", db, nil)
@@ -372,7 +379,7 @@ func TestDeviceRename(t *testing.T) {
 		t.Errorf("Device name got overwritten")
 	}
 
-	cfgw, err := config.Load("tmpconfig.xml", protocol.LocalDeviceID)
+	cfgw, err := config.Load(cfgFile, protocol.LocalDeviceID)
 	if err != nil {
 		t.Error(err)
 		return
@@ -1733,6 +1740,119 @@ func TestIssue3496(t *testing.T) {
 	}
 }
 
+func TestFileHolders(t *testing.T) {
+	dbi := db.OpenMemory()
+	m := NewModel(defaultConfig, protocol.LocalDeviceID, "device", "syncthing", "dev", dbi, nil)
+	m.AddFolder(defaultFolderConfig)
+	m.StartFolder("default")
+	m.ServeBackground()
+	defer m.Stop()
+
+	files := genFiles(2)
+	m.Index(device1, "default", files)
+	addFakeConn(m, device1)
+
+	holders := m.FileHolders("default", "file0")
+	if len(holders) != 1 {
+		t.Fatalf("Expected one holder, got %d", len(holders))
+	}
+	if holders[0].Device != device1 {
+		t.Errorf("Expected device1 as holder, got %v", holders[0].Device)
+	}
+	if !holders[0].HaveGlobal {
+		t.Error("device1 should hold the global version")
+	}
+	if !holders[0].Connected {
+		t.Error("device1 should be listed as connected")
+	}
+
+	if holders := m.FileHolders("default", "nonexistent"); len(holders) != 0 {
+		t.Errorf("Expected no holders for unknown file, got %v", holders)
+	}
+	if holders := m.FileHolders("nonexistent", "file0"); holders != nil {
+		t.Errorf("Expected nil for unknown folder, got %v", holders)
+	}
+}
+
+func TestChangeStormGuard(t *testing.T) {
+	// Use an empty folder so the local scan doesn't contribute to the
+	// global file count the guard measures against.
+	tmpdir, err := ioutil.TempDir("", "syncthing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	fcfg := config.FolderConfiguration{
+		ID:                   "default",
+		RawPath:              tmpdir,
+		Devices:              []config.FolderDeviceConfiguration{{DeviceID: device1}},
+		RemoteChangeLimitPct: 50,
+	}
+	if err := fcfg.CreateMarker(); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Wrap("/tmp/test", config.Configuration{
+		Folders: []config.FolderConfiguration{fcfg},
+		Devices: []config.DeviceConfiguration{
+			{
+				DeviceID: device1,
+			},
+		},
+	})
+
+	ldb := db.OpenMemory()
+	m := NewModel(cfg, protocol.LocalDeviceID, "device", "syncthing", "dev", ldb, nil)
+	m.AddFolder(fcfg)
+	m.StartFolder("default")
+	m.ServeBackground()
+	defer m.Stop()
+
+	// A device announcing new files is business as usual.
+
+	files := genFiles(10)
+	for i := range files {
+		files[i].Version = protocol.Vector{Counters: []protocol.Counter{{ID: 42, Value: 1}}}
+	}
+	m.Index(device1, "default", files)
+	if _, ok := m.CurrentGlobalFile("default", "file0"); !ok {
+		t.Fatal("initial index should have been accepted")
+	}
+
+	// Deleting most of the folder in one go should trip the guard and the
+	// batch should be ignored.
+
+	deleted := make([]protocol.FileInfo, 8)
+	for i := range deleted {
+		deleted[i] = files[i]
+		deleted[i].Deleted = true
+		deleted[i].Blocks = nil
+		deleted[i].Version = deleted[i].Version.Update(device1.Short())
+	}
+	m.IndexUpdate(device1, "default", deleted)
+
+	if f, ok := m.CurrentGlobalFile("default", "file0"); !ok || f.IsDeleted() {
+		t.Error("change storm should have been ignored")
+	}
+
+	// Further changes from the blocked device are ignored too.
+
+	mod := files[9]
+	mod.Version = mod.Version.Update(device1.Short())
+	m.IndexUpdate(device1, "default", []protocol.FileInfo{mod})
+	if f, _ := m.CurrentGlobalFile("default", mod.Name); f.Version.Equal(mod.Version) {
+		t.Error("update after trip should have been ignored")
+	}
+
+	// Confirming resets the guard and drops the device's index data, so
+	// the next connection starts over.
+
+	m.AcceptChanges("default", device1)
+	if _, ok := m.CurrentGlobalFile("default", "file0"); ok {
+		t.Error("index data should be gone after confirmation")
+	}
+}
+
 func addFakeConn(m *Model, dev protocol.DeviceID) {
 	conn1 := connections.Connection{
 		IntermediateConnection: connections.IntermediateConnection{
@@ -1805,3 +1925,144 @@ func (fakeConn) SetReadDeadline(time.Time) error {
 func (fakeConn) SetWriteDeadline(time.Time) error {
 	return nil
 }
+
+func TestFolderDependencyPending(t *testing.T) {
+	ldb := db.OpenMemory()
+
+	// "scripts" is 90% complete: ten files of 100 bytes each globally, of
+	// which we have nine.
+	files := genFiles(10)
+	for i := range files {
+		files[i].Version = protocol.Vector{Counters: []protocol.Counter{{ID: 42, Value: 1}}}
+		files[i].Size = 100
+	}
+	set := db.NewFileSet("scripts", ldb)
+	set.Update(device1, files)
+	set.Update(protocol.LocalDeviceID, files[:9])
+
+	fcfgData := config.NewFolderConfiguration("data", "testdata")
+	fcfgData.PullDependsOn = "scripts"
+	fcfgScripts := config.NewFolderConfiguration("scripts", "testdata")
+	cfg := config.Wrap("/tmp/test", config.Configuration{
+		Folders: []config.FolderConfiguration{fcfgData, fcfgScripts},
+		Devices: []config.DeviceConfiguration{{DeviceID: device1}},
+	})
+
+	m := NewModel(cfg, protocol.LocalDeviceID, "device", "syncthing", "dev", ldb, nil)
+	m.AddFolder(fcfgData)
+	m.AddFolder(fcfgScripts)
+
+	if dep, waiting := m.folderDependencyPending("scripts"); waiting {
+		t.Errorf("scripts has no dependency but waits for %q", dep)
+	}
+
+	// The default target is full completion, which scripts has not reached.
+	if dep, waiting := m.folderDependencyPending("data"); !waiting || dep != "scripts" {
+		t.Errorf("data should wait for scripts, got %q, %v", dep, waiting)
+	}
+
+	// A lower target that is already met clears the wait.
+	fcfgData.PullDependsOnPct = 50
+	cfg.SetFolder(fcfgData)
+	if dep, waiting := m.folderDependencyPending("data"); waiting {
+		t.Errorf("data should not wait at a 50%% target, but waits for %q", dep)
+	}
+	fcfgData.PullDependsOnPct = 0
+	cfg.SetFolder(fcfgData)
+
+	// A dependency cycle is detected and ignored.
+	fcfgScripts.PullDependsOn = "data"
+	cfg.SetFolder(fcfgScripts)
+	if dep, waiting := m.folderDependencyPending("data"); waiting {
+		t.Errorf("cyclic dependency should be ignored, but data waits for %q", dep)
+	}
+
+	// An unknown dependency is ignored.
+	fcfgData.PullDependsOn = "nonexistent"
+	cfg.SetFolder(fcfgData)
+	if dep, waiting := m.folderDependencyPending("data"); waiting {
+		t.Errorf("unknown dependency should be ignored, but data waits for %q", dep)
+	}
+}
+
+func TestSystemSummary(t *testing.T) {
+	ldb := db.OpenMemory()
+
+	// Ten files of 100 bytes each globally, of which we have nine.
+	files := genFiles(10)
+	for i := range files {
+		files[i].Version = protocol.Vector{Counters: []protocol.Counter{{ID: 42, Value: 1}}}
+		files[i].Size = 100
+	}
+	set := db.NewFileSet("default", ldb)
+	set.Update(device1, files)
+	set.Update(protocol.LocalDeviceID, files[:9])
+
+	fcfg := config.NewFolderConfiguration("default", "testdata")
+	fcfg.Devices = []config.FolderDeviceConfiguration{{DeviceID: device1}}
+	cfg := config.Wrap("/tmp/test", config.Configuration{
+		Folders: []config.FolderConfiguration{fcfg},
+		Devices: []config.DeviceConfiguration{{DeviceID: device1}},
+	})
+
+	m := NewModel(cfg, protocol.LocalDeviceID, "device", "syncthing", "dev", ldb, nil)
+	m.AddFolder(fcfg)
+
+	sum := m.SystemSummary()
+
+	if sum.MyID != protocol.LocalDeviceID.String() {
+		t.Errorf("wrong myID %q", sum.MyID)
+	}
+
+	ds, ok := sum.Devices[device1.String()]
+	if !ok {
+		t.Fatal("expected a summary entry for device1")
+	}
+	if ds.Connected {
+		t.Error("device1 should not be connected")
+	}
+
+	fs, ok := sum.Folders["default"]
+	if !ok {
+		t.Fatal("expected a summary entry for the default folder")
+	}
+	if fs.GlobalFiles != 10 || fs.GlobalBytes != 1000 {
+		t.Errorf("unexpected global counts: %d files, %d bytes", fs.GlobalFiles, fs.GlobalBytes)
+	}
+	if fs.LocalFiles != 9 || fs.LocalBytes != 900 {
+		t.Errorf("unexpected local counts: %d files, %d bytes", fs.LocalFiles, fs.LocalBytes)
+	}
+	if fs.NeedFiles != 1 || fs.NeedBytes != 100 {
+		t.Errorf("unexpected need counts: %d files, %d bytes", fs.NeedFiles, fs.NeedBytes)
+	}
+	if fs.Completion != 90 {
+		t.Errorf("unexpected completion %f", fs.Completion)
+	}
+	if fs.State != FolderPreparing.String() {
+		t.Errorf("unexpected state %q for folder without a running folder", fs.State)
+	}
+}
+
+func TestCheckFolderOverlap(t *testing.T) {
+	ldb := db.OpenMemory()
+
+	fcfgOuter := config.NewFolderConfiguration("outer", "testdata")
+	fcfgInner := config.NewFolderConfiguration("inner", filepath.Join("testdata", "foo"))
+	fcfgOther := config.NewFolderConfiguration("other", "testdata-other")
+	cfg := config.Wrap("/tmp/test", config.Configuration{
+		Folders: []config.FolderConfiguration{fcfgOuter, fcfgInner, fcfgOther},
+		Devices: []config.DeviceConfiguration{{DeviceID: device1}},
+	})
+
+	m := NewModel(cfg, protocol.LocalDeviceID, "device", "syncthing", "dev", ldb, nil)
+
+	if err := m.checkFolderOverlap(cfg.Folders()["inner"]); err == nil {
+		t.Error("expected inner folder to overlap outer")
+	}
+	if err := m.checkFolderOverlap(cfg.Folders()["outer"]); err == nil {
+		t.Error("expected outer folder to overlap inner")
+	}
+	if err := m.checkFolderOverlap(cfg.Folders()["other"]); err != nil {
+		t.Error("unexpected overlap for unrelated folder:", err)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"fmt"
+
+	"github.com/syncthing/syncthing/lib/db"
+	"github.com/syncthing/syncthing/lib/ignore"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// pendingDiffMaxEntries caps the length of each operation list in a
+// pending changes diff. The summary counts and byte totals always cover
+// the full set.
+const pendingDiffMaxEntries = 1000
+
+// A PendingOperation describes one file level operation in a pending
+// changes diff. Size is the global size of the file, or the size of the
+// record being removed for deletions.
+type PendingOperation struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// A PendingDiff is the set of operations required to bring one device up
+// to the global state. Files and Deletes count the operations, Bytes the
+// data that needs transferring. Each list is capped at
+// pendingDiffMaxEntries entries; Truncated is set when entries were
+// dropped.
+type PendingDiff struct {
+	Additions     []PendingOperation `json:"additions"`
+	Modifications []PendingOperation `json:"modifications"`
+	Deletions     []PendingOperation `json:"deletions"`
+	Files         int                `json:"files"`
+	Deletes       int                `json:"deletes"`
+	Bytes         int64              `json:"bytes"`
+	Truncated     bool               `json:"truncated"`
+}
+
+// PendingChanges describes what would happen if the given folder synced
+// up to global state: the operations the local device needs to perform,
+// and those each remote device sharing the folder needs from us and the
+// rest of the cluster.
+type PendingChanges struct {
+	Local  PendingDiff            `json:"local"`
+	Remote map[string]PendingDiff `json:"remote"`
+}
+
+// PendingChanges returns the structured diff between each device's
+// current state and the global state of the given folder, computed from
+// the index database.
+func (m *Model) PendingChanges(folder string) (PendingChanges, error) {
+	m.fmut.RLock()
+	defer m.fmut.RUnlock()
+
+	fs, ok := m.folderFiles[folder]
+	if !ok {
+		return PendingChanges{}, fmt.Errorf("no such folder %q", folder)
+	}
+
+	ignores := m.folderIgnores[folder]
+	cfg := m.folderCfgs[folder]
+
+	res := PendingChanges{
+		Local:  pendingDiff(fs, protocol.LocalDeviceID, ignores, cfg.IgnoreDelete),
+		Remote: make(map[string]PendingDiff, len(m.folderDevices[folder])),
+	}
+	for _, device := range m.folderDevices[folder] {
+		// Ignore patterns are local; what a remote device chooses to
+		// ignore is not known to us, so its diff is unfiltered.
+		res.Remote[device.String()] = pendingDiff(fs, device, nil, false)
+	}
+	return res, nil
+}
+
+func pendingDiff(fs *db.FileSet, device protocol.DeviceID, ignores *ignore.Matcher, ignoreDelete bool) PendingDiff {
+	var diff PendingDiff
+	fs.WithNeedTruncated(device, func(f db.FileIntf) bool {
+		if shouldIgnore(f, ignores, ignoreDelete) {
+			return true
+		}
+
+		name := f.FileName()
+		have, haveOK := fs.Get(device, name)
+
+		switch {
+		case f.IsDeleted():
+			diff.Deletes++
+			op := PendingOperation{Name: name}
+			if haveOK {
+				op.Size = have.Size
+			}
+			diff.Deletions = appendPendingOp(diff.Deletions, op, &diff.Truncated)
+
+		case !haveOK || have.IsDeleted() || have.IsInvalid():
+			diff.Files++
+			diff.Bytes += f.FileSize()
+			diff.Additions = appendPendingOp(diff.Additions, PendingOperation{Name: name, Size: f.FileSize()}, &diff.Truncated)
+
+		default:
+			diff.Files++
+			diff.Bytes += f.FileSize()
+			diff.Modifications = appendPendingOp(diff.Modifications, PendingOperation{Name: name, Size: f.FileSize()}, &diff.Truncated)
+		}
+		return true
+	})
+	return diff
+}
+
+// appendPendingOp appends the operation unless the list is already at
+// the cap, in which case it sets the truncation flag instead.
+func appendPendingOp(list []PendingOperation, op PendingOperation, truncated *bool) []PendingOperation {
+	if len(list) >= pendingDiffMaxEntries {
+		*truncated = true
+		return list
+	}
+	return append(list, op)
+}
@@ -0,0 +1,65 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/syncthing/syncthing/lib/db"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+func TestPendingDiff(t *testing.T) {
+	ldb := db.OpenMemory()
+	fs := db.NewFileSet("default", ldb)
+
+	v1 := protocol.Vector{Counters: []protocol.Counter{{ID: 1, Value: 1000}}}
+	v2 := protocol.Vector{Counters: []protocol.Counter{{ID: 1, Value: 1001}}}
+
+	// Local state: an up to date file, an outdated file, a file the
+	// remote doesn't know about, and a file that is deleted globally.
+	fs.Update(protocol.LocalDeviceID, []protocol.FileInfo{
+		{Name: "same", Version: v1, Size: 100},
+		{Name: "outdated", Version: v1, Size: 100},
+		{Name: "localonly", Version: v1, Size: 300},
+		{Name: "removed", Version: v1, Size: 400},
+	})
+
+	// Remote state: a newer version of the outdated file, a file we
+	// don't have, and a deletion of the removed file.
+	fs.Update(device1, []protocol.FileInfo{
+		{Name: "same", Version: v1, Size: 100},
+		{Name: "outdated", Version: v2, Size: 200},
+		{Name: "new", Version: v1, Size: 1000},
+		{Name: "removed", Version: v2, Deleted: true},
+	})
+
+	diff := pendingDiff(fs, protocol.LocalDeviceID, nil, false)
+
+	if diff.Files != 2 || diff.Deletes != 1 || diff.Bytes != 1200 {
+		t.Errorf("unexpected local summary %+v", diff)
+	}
+	if len(diff.Additions) != 1 || diff.Additions[0].Name != "new" || diff.Additions[0].Size != 1000 {
+		t.Errorf("unexpected additions %+v", diff.Additions)
+	}
+	if len(diff.Modifications) != 1 || diff.Modifications[0].Name != "outdated" || diff.Modifications[0].Size != 200 {
+		t.Errorf("unexpected modifications %+v", diff.Modifications)
+	}
+	// Deletions report the size of the record being removed.
+	if len(diff.Deletions) != 1 || diff.Deletions[0].Name != "removed" || diff.Deletions[0].Size != 400 {
+		t.Errorf("unexpected deletions %+v", diff.Deletions)
+	}
+	if diff.Truncated {
+		t.Error("unexpected truncation")
+	}
+
+	// The remote needs the file only we have.
+	diff = pendingDiff(fs, device1, nil, false)
+	if diff.Files != 1 || len(diff.Additions) != 1 || diff.Additions[0].Name != "localonly" {
+		t.Errorf("unexpected remote diff %+v", diff)
+	}
+}
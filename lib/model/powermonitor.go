@@ -0,0 +1,97 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"time"
+
+	"github.com/syncthing/syncthing/lib/osutil"
+)
+
+// How often the power and network state is sampled.
+const powerCheckInterval = 30 * time.Second
+
+// powerMonitor periodically checks whether we are running on battery
+// power or a metered connection, updates the resource hints we announce
+// about ourselves, and suspends transfers and scheduled scans for folders
+// configured to pause under those conditions.
+type powerMonitor struct {
+	model *Model
+	stop  chan struct{}
+}
+
+func newPowerMonitor(m *Model) *powerMonitor {
+	return &powerMonitor{
+		model: m,
+		stop:  make(chan struct{}),
+	}
+}
+
+func (mon *powerMonitor) Serve() {
+	mon.check()
+	ticker := time.NewTicker(powerCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			mon.check()
+		case <-mon.stop:
+			return
+		}
+	}
+}
+
+func (mon *powerMonitor) Stop() {
+	close(mon.stop)
+}
+
+func (mon *powerMonitor) check() {
+	// Errors mean the state cannot be determined on this platform, in
+	// which case we assume the best.
+	onBattery, err := osutil.OnBattery()
+	if err != nil {
+		onBattery = false
+	}
+
+	onMetered := mon.model.cfg.Options().MeteredConnection
+	if metered, err := osutil.OnMeteredConnection(); err == nil && metered {
+		onMetered = true
+	}
+
+	mon.model.setPowerState(onBattery, onMetered)
+}
+
+// setPowerState updates the announced resource hints and recomputes which
+// folders should have transfers and scheduled scans suspended.
+func (m *Model) setPowerState(onBattery, onMetered bool) {
+	m.fmut.Lock()
+	m.localHints.batteryPowered = onBattery
+	m.localHints.metered = onMetered
+
+	for id, cfg := range m.folderCfgs {
+		suspend := cfg.PauseOnBattery && onBattery || cfg.PauseOnMetered && onMetered
+		if suspend && !m.suspendedFolders[id] {
+			l.Infof("Folder %q suspended (on battery or metered connection)", id)
+		} else if !suspend && m.suspendedFolders[id] {
+			l.Infof("Folder %q resumed", id)
+		}
+		if suspend {
+			m.suspendedFolders[id] = true
+		} else {
+			delete(m.suspendedFolders, id)
+		}
+	}
+	m.fmut.Unlock()
+}
+
+// folderSuspended returns true while the folder should not pull or run
+// scheduled scans, per its power configuration.
+func (m *Model) folderSuspended(folder string) bool {
+	m.fmut.RLock()
+	defer m.fmut.RUnlock()
+	return m.suspendedFolders[folder]
+}
@@ -0,0 +1,45 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/syncthing/syncthing/lib/db"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+func TestSetPowerState(t *testing.T) {
+	ldb := db.OpenMemory()
+	m := NewModel(defaultConfig, protocol.LocalDeviceID, "device", "syncthing", "dev", ldb, nil)
+
+	fcfg := defaultFolderConfig.Copy()
+	fcfg.PauseOnBattery = true
+	m.AddFolder(fcfg)
+
+	if m.folderSuspended("default") {
+		t.Error("folder should not be suspended initially")
+	}
+
+	m.setPowerState(true, false)
+	if !m.folderSuspended("default") {
+		t.Error("folder should be suspended on battery")
+	}
+	if hints := m.generateClusterConfig(device1); len(hints.Folders) > 0 {
+		for _, dev := range hints.Folders[0].Devices {
+			if protocol.DeviceIDFromBytes(dev.ID) == protocol.LocalDeviceID && !dev.BatteryPowered {
+				t.Error("battery hint should be announced on our own entry")
+			}
+		}
+	}
+
+	// Metered alone does not suspend this folder.
+	m.setPowerState(false, true)
+	if m.folderSuspended("default") {
+		t.Error("folder should not be suspended on metered only")
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"github.com/syncthing/syncthing/lib/previews"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// previewJobs is the shared preview generation queue, serving all folders
+// that have previews enabled.
+var previewJobs = newPreviewQueue()
+
+type previewJob struct {
+	folderPath string
+	file       string
+	remove     bool
+}
+
+// previewQueue generates image previews in the background, one at a time,
+// so that a burst of scanned or pulled photos doesn't compete with hashing
+// and pulling for CPU.
+type previewQueue struct {
+	jobs chan previewJob
+}
+
+func newPreviewQueue() *previewQueue {
+	q := &previewQueue{
+		jobs: make(chan previewJob, 1024),
+	}
+	go q.serve()
+	return q
+}
+
+// queueFor inspects a set of updated local files and queues preview
+// generation for the image files among them, and preview removal for the
+// deleted ones. If the queue is full jobs are dropped; the affected files
+// get another chance at the next update or rescan.
+func (q *previewQueue) queueFor(folderPath string, fs []protocol.FileInfo) {
+	for _, f := range fs {
+		if f.IsDirectory() || f.IsSymlink() || f.IsInvalid() {
+			continue
+		}
+		if !previews.IsImage(f.Name) {
+			continue
+		}
+		select {
+		case q.jobs <- previewJob{folderPath, f.Name, f.IsDeleted()}:
+		default:
+			l.Debugln("preview queue full; dropping", f.Name)
+		}
+	}
+}
+
+func (q *previewQueue) serve() {
+	for job := range q.jobs {
+		if job.remove {
+			previews.Remove(job.folderPath, job.file)
+			continue
+		}
+		if err := previews.Generate(job.folderPath, job.file); err != nil {
+			l.Debugf("generating preview for %q in %s: %v", job.file, job.folderPath, err)
+		}
+	}
+}
@@ -15,7 +15,7 @@ import (
 )
 
 type jobQueue struct {
-	progress []string
+	progress []jobQueueEntry
 	queued   []jobQueueEntry
 	mut      sync.Mutex
 }
@@ -26,6 +26,15 @@ type jobQueueEntry struct {
 	modified time.Time
 }
 
+// A PullQueueItem describes one entry in the pull queue, as exposed over the
+// REST API.
+type PullQueueItem struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	State    string    `json:"state"` // "inProgress" or "queued"
+}
+
 func newJobQueue() *jobQueue {
 	return &jobQueue{
 		mut: sync.NewMutex(),
@@ -46,11 +55,11 @@ func (q *jobQueue) Pop() (string, bool) {
 		return "", false
 	}
 
-	f := q.queued[0].name
+	f := q.queued[0]
 	q.queued = q.queued[1:]
 	q.progress = append(q.progress, f)
 
-	return f, true
+	return f.name, true
 }
 
 func (q *jobQueue) BringToFront(filename string) {
@@ -76,7 +85,7 @@ func (q *jobQueue) Done(file string) {
 	defer q.mut.Unlock()
 
 	for i := range q.progress {
-		if q.progress[i] == file {
+		if q.progress[i].name == file {
 			copy(q.progress[i:], q.progress[i+1:])
 			q.progress = q.progress[:len(q.progress)-1]
 			return
@@ -89,7 +98,9 @@ func (q *jobQueue) Jobs() ([]string, []string) {
 	defer q.mut.Unlock()
 
 	progress := make([]string, len(q.progress))
-	copy(progress, q.progress)
+	for i := range q.progress {
+		progress[i] = q.progress[i].name
+	}
 
 	queued := make([]string, len(q.queued))
 	for i := range q.queued {
@@ -99,6 +110,55 @@ func (q *jobQueue) Jobs() ([]string, []string) {
 	return progress, queued
 }
 
+// Items returns the current contents of the queue as PullQueueItems, with
+// in progress entries first, in queue order.
+func (q *jobQueue) Items() []PullQueueItem {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	items := make([]PullQueueItem, 0, len(q.progress)+len(q.queued))
+	for _, e := range q.progress {
+		items = append(items, PullQueueItem{e.name, e.size, e.modified, "inProgress"})
+	}
+	for _, e := range q.queued {
+		items = append(items, PullQueueItem{e.name, e.size, e.modified, "queued"})
+	}
+	return items
+}
+
+// SendToBack moves the given filename to the end of the queue, if it is
+// currently queued.
+func (q *jobQueue) SendToBack(filename string) {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	for i, cur := range q.queued {
+		if cur.name == filename {
+			// Shift the elements after the selected element one step to the
+			// left, overwriting the selected element
+			copy(q.queued[i:], q.queued[i+1:])
+			// Put the selected element at the back
+			q.queued[len(q.queued)-1] = cur
+			return
+		}
+	}
+}
+
+// Skip removes the given filename from the queue, if it is currently
+// queued. The file will be considered again on the next pull iteration.
+func (q *jobQueue) Skip(filename string) {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	for i, cur := range q.queued {
+		if cur.name == filename {
+			copy(q.queued[i:], q.queued[i+1:])
+			q.queued = q.queued[:len(q.queued)-1]
+			return
+		}
+	}
+}
+
 func (q *jobQueue) Shuffle() {
 	q.mut.Lock()
 	defer q.mut.Unlock()
@@ -161,6 +161,82 @@ func TestBringToFront(t *testing.T) {
 	}
 }
 
+func TestSendToBack(t *testing.T) {
+	q := newJobQueue()
+	q.Push("f1", 0, time.Time{})
+	q.Push("f2", 0, time.Time{})
+	q.Push("f3", 0, time.Time{})
+	q.Push("f4", 0, time.Time{})
+
+	q.SendToBack("f4") // corner case: does nothing
+
+	_, queued := q.Jobs()
+	if diff, equal := messagediff.PrettyDiff([]string{"f1", "f2", "f3", "f4"}, queued); !equal {
+		t.Errorf("Order does not match. Diff:\n%s", diff)
+	}
+
+	q.SendToBack("f2")
+
+	_, queued = q.Jobs()
+	if diff, equal := messagediff.PrettyDiff([]string{"f1", "f3", "f4", "f2"}, queued); !equal {
+		t.Errorf("Order does not match. Diff:\n%s", diff)
+	}
+
+	q.SendToBack("f1") // corner case: first element
+
+	_, queued = q.Jobs()
+	if diff, equal := messagediff.PrettyDiff([]string{"f3", "f4", "f2", "f1"}, queued); !equal {
+		t.Errorf("Order does not match. Diff:\n%s", diff)
+	}
+
+	q.SendToBack("f5") // corner case: does not exist
+
+	_, queued = q.Jobs()
+	if diff, equal := messagediff.PrettyDiff([]string{"f3", "f4", "f2", "f1"}, queued); !equal {
+		t.Errorf("Order does not match. Diff:\n%s", diff)
+	}
+}
+
+func TestSkip(t *testing.T) {
+	q := newJobQueue()
+	q.Push("f1", 0, time.Time{})
+	q.Push("f2", 0, time.Time{})
+	q.Push("f3", 0, time.Time{})
+
+	q.Skip("f2")
+
+	_, queued := q.Jobs()
+	if diff, equal := messagediff.PrettyDiff([]string{"f1", "f3"}, queued); !equal {
+		t.Errorf("Order does not match. Diff:\n%s", diff)
+	}
+
+	q.Skip("f4") // corner case: does not exist
+
+	_, queued = q.Jobs()
+	if diff, equal := messagediff.PrettyDiff([]string{"f1", "f3"}, queued); !equal {
+		t.Errorf("Order does not match. Diff:\n%s", diff)
+	}
+}
+
+func TestQueueItems(t *testing.T) {
+	q := newJobQueue()
+	q.Push("f1", 10, time.Time{})
+	q.Push("f2", 20, time.Time{})
+
+	q.Pop()
+
+	items := q.Items()
+	if len(items) != 2 {
+		t.Fatalf("Wrong length %d", len(items))
+	}
+	if items[0].Name != "f1" || items[0].Size != 10 || items[0].State != "inProgress" {
+		t.Errorf("Unexpected first item %+v", items[0])
+	}
+	if items[1].Name != "f2" || items[1].Size != 20 || items[1].State != "queued" {
+		t.Errorf("Unexpected second item %+v", items[1])
+	}
+}
+
 func TestShuffle(t *testing.T) {
 	q := newJobQueue()
 	q.Push("f1", 0, time.Time{})
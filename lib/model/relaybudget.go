@@ -0,0 +1,127 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/syncthing/syncthing/lib/events"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// How often the relay byte counters are sampled.
+const relayBudgetCheckInterval = time.Minute
+
+// Percentages of the monthly relay budget at which an event is emitted.
+var relayBudgetThresholds = []int64{75, 90, 100}
+
+// relayBudgetMonitor periodically samples the byte counters of relay
+// connections and accounts the traffic against the per-device monthly
+// relay budget. It emits RelayBudgetThreshold events as the budget is
+// approached and closes the relay connection once the budget is
+// exhausted, leaving only direct connections until the next month.
+type relayBudgetMonitor struct {
+	model *Model
+	stop  chan struct{}
+
+	lastTotals map[protocol.DeviceID]int64
+	notified   map[string]bool
+}
+
+func newRelayBudgetMonitor(m *Model) *relayBudgetMonitor {
+	return &relayBudgetMonitor{
+		model:      m,
+		stop:       make(chan struct{}),
+		lastTotals: make(map[protocol.DeviceID]int64),
+		notified:   make(map[string]bool),
+	}
+}
+
+func (mon *relayBudgetMonitor) Serve() {
+	ticker := time.NewTicker(relayBudgetCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			mon.check()
+		case <-mon.stop:
+			return
+		}
+	}
+}
+
+func (mon *relayBudgetMonitor) Stop() {
+	close(mon.stop)
+}
+
+func (mon *relayBudgetMonitor) check() {
+	m := mon.model
+
+	m.pmut.RLock()
+	conns := make(map[protocol.DeviceID]protocol.Statistics)
+	for id, conn := range m.conn {
+		if strings.HasPrefix(conn.Type, "Relay") {
+			conns[id] = conn.Statistics()
+		}
+	}
+	m.pmut.RUnlock()
+
+	for id, stats := range conns {
+		total := stats.InBytesTotal + stats.OutBytesTotal
+		last := mon.lastTotals[id]
+		if total < last {
+			// The counters restarted; this is a new connection.
+			last = 0
+		}
+		mon.lastTotals[id] = total
+		if delta := total - last; delta > 0 {
+			m.deviceStatRef(id).AddRelayBytes(delta)
+		}
+
+		budget := m.cfg.Devices()[id].MonthlyRelayBudget
+		if budget <= 0 {
+			continue
+		}
+		used := m.deviceStatRef(id).GetRelayBytesMonth()
+
+		for _, pct := range relayBudgetThresholds {
+			if used*100 < pct*budget {
+				break
+			}
+			key := fmt.Sprintf("%v/%s/%d", id, time.Now().Format("2006-01"), pct)
+			if mon.notified[key] {
+				continue
+			}
+			mon.notified[key] = true
+			l.Infof("Relay traffic to %v has reached %d%% of the monthly budget (%d of %d bytes)", id, pct, used, budget)
+			events.Default.Log(events.RelayBudgetThreshold, map[string]interface{}{
+				"device":    id.String(),
+				"threshold": pct,
+				"used":      used,
+				"budget":    budget,
+			})
+		}
+
+		if used >= budget {
+			l.Infof("Monthly relay budget for %v exhausted; closing relay connection", id)
+			m.close(id)
+		}
+	}
+}
+
+// DeviceRelayBudgetExceeded returns true when the device has a monthly
+// relay budget configured and it has been spent, in which case only
+// direct connections should be attempted.
+func (m *Model) DeviceRelayBudgetExceeded(deviceID protocol.DeviceID) bool {
+	budget := m.cfg.Devices()[deviceID].MonthlyRelayBudget
+	if budget <= 0 {
+		return false
+	}
+	return m.deviceStatRef(deviceID).GetRelayBytesMonth() >= budget
+}
@@ -0,0 +1,126 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	stdsync "sync"
+	"time"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+const (
+	// The number of outstanding requests per device is sized from the
+	// estimated bandwidth-delay product of the connection, within these
+	// bounds.
+	minOutstandingRequests = 2
+	maxOutstandingRequests = 64
+
+	// Gain of the exponentially weighted moving average for the delivery
+	// rate.
+	requestEWMAGain = 0.25
+)
+
+// requestLimiter estimates the bandwidth-delay product of each device
+// connection and limits the number of outstanding block requests so that
+// the pipe is kept full without tying up memory in overbuffering. The
+// number of puller routines is just an upper bound; the limiter decides
+// how many requests are actually in flight per device.
+type requestLimiter struct {
+	mut     stdsync.Mutex
+	cond    *stdsync.Cond
+	devices map[protocol.DeviceID]*requestWindow
+}
+
+type requestWindow struct {
+	outstanding int
+	limit       int
+	rtt         float64 // minimum observed round trip, seconds
+	rate        float64 // aggregate delivery rate, bytes per second
+}
+
+func newRequestLimiter() *requestLimiter {
+	r := &requestLimiter{
+		devices: make(map[protocol.DeviceID]*requestWindow),
+	}
+	r.cond = stdsync.NewCond(&r.mut)
+	return r
+}
+
+// acquire blocks until the device has room for another outstanding
+// request.
+func (r *requestLimiter) acquire(device protocol.DeviceID) {
+	r.mut.Lock()
+	w := r.window(device)
+	for w.outstanding >= w.limit {
+		r.cond.Wait()
+	}
+	w.outstanding++
+	r.mut.Unlock()
+}
+
+// release accounts a completed request. Successful requests contribute a
+// round trip time and rate measurement; failed ones only give their slot
+// back.
+func (r *requestLimiter) release(device protocol.DeviceID, bytes int, elapsed time.Duration) {
+	r.mut.Lock()
+	w := r.window(device)
+	w.outstanding--
+
+	if bytes > 0 && elapsed > 0 {
+		// The base round trip time is taken as the minimum elapsed time
+		// seen so far. The elapsed time of a single request includes any
+		// queueing behind other outstanding requests, so the average
+		// would inflate as the window grows; the minimum does not.
+		if rtt := elapsed.Seconds(); w.rtt == 0 || rtt < w.rtt {
+			w.rtt = rtt
+		}
+
+		// The delivery rate of the connection as a whole is the rate of
+		// this request times the number of requests sharing the pipe.
+		rate := float64(bytes) / elapsed.Seconds() * float64(w.limit)
+		w.rate += requestEWMAGain * (rate - w.rate)
+
+		// Size the window to the bandwidth-delay product, with one extra
+		// request so there is always something queued to send.
+		limit := int(w.rate*w.rtt/protocol.BlockSize+0.5) + 1
+		if limit < minOutstandingRequests {
+			limit = minOutstandingRequests
+		} else if limit > maxOutstandingRequests {
+			limit = maxOutstandingRequests
+		}
+		w.limit = limit
+	}
+
+	r.cond.Broadcast()
+	r.mut.Unlock()
+}
+
+// expectedSeconds estimates the time until one more standard sized block
+// requested from the device would be delivered, with ahead requests
+// already in line before it. Zero is returned while the delivery rate is
+// still unknown, so that new connections get probed.
+func (r *requestLimiter) expectedSeconds(device protocol.DeviceID, ahead int) float64 {
+	r.mut.Lock()
+	w := r.window(device)
+	rate, rtt := w.rate, w.rtt
+	r.mut.Unlock()
+
+	if rate == 0 {
+		return 0
+	}
+	return rtt + float64(ahead+1)*protocol.BlockSize/rate
+}
+
+func (r *requestLimiter) window(device protocol.DeviceID) *requestWindow {
+	w, ok := r.devices[device]
+	if !ok {
+		w = &requestWindow{limit: minOutstandingRequests}
+		r.devices[device] = w
+	}
+	return w
+}
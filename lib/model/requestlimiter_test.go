@@ -0,0 +1,55 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+func TestRequestLimiter(t *testing.T) {
+	r := newRequestLimiter()
+
+	// A new device starts out with the minimum window.
+	if limit := r.window(device1).limit; limit != minOutstandingRequests {
+		t.Errorf("initial limit is %d, expected %d", limit, minOutstandingRequests)
+	}
+
+	// Fast, high rate responses should grow the window towards the
+	// maximum.
+	for i := 0; i < 100; i++ {
+		r.acquire(device1)
+		r.release(device1, protocol.BlockSize, 500*time.Millisecond)
+	}
+	grown := r.window(device1).limit
+	if grown <= minOutstandingRequests {
+		t.Errorf("limit did not grow from fast responses (limit is %d)", grown)
+	}
+
+	// Slow, low rate responses should shrink it again.
+	for i := 0; i < 100; i++ {
+		r.acquire(device1)
+		r.release(device1, protocol.BlockSize, 10*time.Second)
+	}
+	if limit := r.window(device1).limit; limit >= grown {
+		t.Errorf("limit did not shrink from slow responses (limit is %d)", limit)
+	}
+
+	// Failed requests give their slot back without affecting the window.
+	before := r.window(device1).limit
+	r.acquire(device1)
+	r.release(device1, 0, 0)
+	w := r.window(device1)
+	if w.limit != before {
+		t.Errorf("failed request changed the limit from %d to %d", before, w.limit)
+	}
+	if w.outstanding != 0 {
+		t.Errorf("failed request left %d outstanding", w.outstanding)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"bytes"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// resourceHints describes the capabilities and constraints a device has
+// announced about itself in its cluster config.
+type resourceHints struct {
+	spinningDisk   bool
+	metered        bool
+	batteryPowered bool
+	hardLinks      bool // capability: understands link groups on FileInfos
+	fileMetadata   bool // capability: understands metadata on FileInfos
+	indexDict      bool // capability: accepts dictionary compressed index messages
+}
+
+// constrained returns true for devices that should not be chosen as a
+// primary block source.
+func (h resourceHints) constrained() bool {
+	return h.metered || h.batteryPowered
+}
+
+// SetLocalResourceHints sets the hints we announce about ourselves in
+// cluster configs sent from now on. Already connected devices learn the
+// new hints on the next reconnect.
+func (m *Model) SetLocalResourceHints(spinningDisk, metered, batteryPowered bool) {
+	m.fmut.Lock()
+	m.localHints = resourceHints{
+		spinningDisk:   spinningDisk,
+		metered:        metered,
+		batteryPowered: batteryPowered,
+	}
+	m.fmut.Unlock()
+}
+
+// updateDeviceHints records the resource hints the given device announced
+// about itself, from its own entry in any of the folders in the cluster
+// config. Entries for other devices are relayed information and ignored.
+func (m *Model) updateDeviceHints(deviceID protocol.DeviceID, cm protocol.ClusterConfig) {
+	for _, folder := range cm.Folders {
+		for _, dev := range folder.Devices {
+			if !bytes.Equal(dev.ID, deviceID[:]) {
+				continue
+			}
+			m.pmut.Lock()
+			m.deviceHints[deviceID] = resourceHints{
+				spinningDisk:   dev.SpinningDisk,
+				metered:        dev.Metered,
+				batteryPowered: dev.BatteryPowered,
+				hardLinks:      dev.HardLinks,
+				fileMetadata:   dev.FileMetadata,
+				indexDict:      dev.IndexDictionary,
+			}
+			if dev.IndexDictionary {
+				// The device accepts dictionary compressed indexes;
+				// tell the connection to start sending them.
+				if conn, ok := m.conn[deviceID]; ok {
+					if dc, ok := conn.Connection.(interface {
+						SetIndexDictionary(bool)
+					}); ok {
+						dc.SetIndexDictionary(true)
+					}
+				}
+			}
+			m.pmut.Unlock()
+			return
+		}
+	}
+}
+
+// deviceCapabilities returns the hints the device has announced about
+// itself, including the capability flags consulted when sending indexes.
+func (m *Model) deviceCapabilities(device protocol.DeviceID) resourceHints {
+	m.pmut.RLock()
+	defer m.pmut.RUnlock()
+	return m.deviceHints[device]
+}
+
+// partitionConstrained splits the given availability list into devices
+// free to serve blocks and devices that have announced being battery
+// powered or on a metered connection. The latter should only be used when
+// no unconstrained device can serve the block.
+func (m *Model) partitionConstrained(availability []Availability) (free, constrained []Availability) {
+	m.pmut.RLock()
+	for _, av := range availability {
+		if m.deviceHints[av.ID].constrained() {
+			constrained = append(constrained, av)
+		} else {
+			free = append(free, av)
+		}
+	}
+	m.pmut.RUnlock()
+	return free, constrained
+}
@@ -0,0 +1,85 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/syncthing/syncthing/lib/config"
+	"github.com/syncthing/syncthing/lib/db"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+func TestResourceHints(t *testing.T) {
+	fcfg := config.NewFolderConfiguration("default", "testdata")
+	fcfg.Devices = []config.FolderDeviceConfiguration{
+		{DeviceID: device1},
+		{DeviceID: protocol.LocalDeviceID},
+	}
+	cfg := config.Wrap("/tmp/test", config.Configuration{
+		Folders: []config.FolderConfiguration{fcfg},
+		Devices: []config.DeviceConfiguration{config.NewDeviceConfiguration(device1, "device1")},
+	})
+
+	ldb := db.OpenMemory()
+	m := NewModel(cfg, protocol.LocalDeviceID, "device", "syncthing", "dev", ldb, nil)
+	m.AddFolder(fcfg)
+	m.ServeBackground()
+	defer m.Stop()
+
+	// device1 announces itself as battery powered on its own entry; the
+	// hints on the device2 entry are relayed information and ignored.
+	m.updateDeviceHints(device1, protocol.ClusterConfig{
+		Folders: []protocol.Folder{{
+			ID: "default",
+			Devices: []protocol.Device{
+				{ID: device2[:], Metered: true},
+				{ID: device1[:], BatteryPowered: true},
+			},
+		}},
+	})
+
+	av1 := Availability{ID: device1}
+	av2 := Availability{ID: device2}
+	free, constrained := m.partitionConstrained([]Availability{av1, av2})
+	if len(free) != 1 || free[0] != av2 {
+		t.Errorf("device2 should be unconstrained, got %v", free)
+	}
+	if len(constrained) != 1 || constrained[0] != av1 {
+		t.Errorf("device1 should be constrained, got %v", constrained)
+	}
+
+	// A spinning disk alone does not make a device constrained.
+	m.updateDeviceHints(device1, protocol.ClusterConfig{
+		Folders: []protocol.Folder{{
+			ID:      "default",
+			Devices: []protocol.Device{{ID: device1[:], SpinningDisk: true}},
+		}},
+	})
+	if _, constrained = m.partitionConstrained([]Availability{av1}); len(constrained) != 0 {
+		t.Errorf("device1 should be unconstrained, got %v", constrained)
+	}
+
+	// Our own hints end up on our entry in generated cluster configs, and
+	// only there.
+	m.SetLocalResourceHints(false, true, false)
+	cm := m.generateClusterConfig(device1)
+	if len(cm.Folders) != 1 || len(cm.Folders[0].Devices) != 2 {
+		t.Fatalf("unexpected cluster config: %v", cm)
+	}
+	seenLocal := false
+	for _, dev := range cm.Folders[0].Devices {
+		onOwnEntry := protocol.DeviceIDFromBytes(dev.ID) == protocol.LocalDeviceID
+		if dev.Metered != onOwnEntry {
+			t.Errorf("unexpected metered hint %v on device %x", dev.Metered, dev.ID)
+		}
+		seenLocal = seenLocal || onOwnEntry
+	}
+	if !seenLocal {
+		t.Error("cluster config should contain our own entry")
+	}
+}
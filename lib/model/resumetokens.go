@@ -0,0 +1,67 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syncthing/syncthing/lib/sync"
+)
+
+// A resumeTokens registry remembers, for files whose copy/pull cycle
+// failed partway, which blocks had already been written to the temporary
+// file. When the file is retried — after the source device reconnects, or
+// with another device that has the file — only the unanswered block
+// requests are reissued instead of rehashing the temporary file and
+// restarting the cycle from scratch. Tokens are process local; after a
+// restart we fall back to rehashing the temporary file as before. It is
+// safe for use from multiple goroutines.
+type resumeTokens struct {
+	tokens map[string]resumeToken
+	mut    sync.Mutex
+}
+
+type resumeToken struct {
+	version   protocol.Vector
+	available []int32
+}
+
+func newResumeTokens() *resumeTokens {
+	return &resumeTokens{
+		tokens: make(map[string]resumeToken),
+		mut:    sync.NewMutex(),
+	}
+}
+
+// save records the blocks available in the temporary file for the given
+// file. Nothing is recorded when no blocks made it to disk.
+func (r *resumeTokens) save(file protocol.FileInfo, available []int32) {
+	if len(available) == 0 {
+		return
+	}
+	r.mut.Lock()
+	r.tokens[file.Name] = resumeToken{
+		version:   file.Version,
+		available: available,
+	}
+	r.mut.Unlock()
+}
+
+// take returns the recorded available blocks for the given file and
+// forgets the token. It returns false when no token exists or when the
+// token was recorded for another version of the file.
+func (r *resumeTokens) take(file protocol.FileInfo) ([]int32, bool) {
+	r.mut.Lock()
+	token, ok := r.tokens[file.Name]
+	if ok {
+		delete(r.tokens, file.Name)
+	}
+	r.mut.Unlock()
+	if !ok || !token.version.Equal(file.Version) {
+		return nil, false
+	}
+	return token.available, true
+}
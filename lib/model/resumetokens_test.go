@@ -0,0 +1,47 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+func TestResumeTokens(t *testing.T) {
+	r := newResumeTokens()
+	v1 := protocol.Vector{Counters: []protocol.Counter{{ID: 1, Value: 1}}}
+	v2 := protocol.Vector{Counters: []protocol.Counter{{ID: 1, Value: 2}}}
+	file := protocol.FileInfo{Name: "file", Version: v1}
+
+	if _, ok := r.take(file); ok {
+		t.Error("unexpected token before save")
+	}
+
+	r.save(file, []int32{0, 2, 5})
+	avail, ok := r.take(file)
+	if !ok || len(avail) != 3 || avail[0] != 0 || avail[1] != 2 || avail[2] != 5 {
+		t.Errorf("unexpected token %v (%v)", avail, ok)
+	}
+
+	// The token is consumed by take.
+	if _, ok := r.take(file); ok {
+		t.Error("token not consumed")
+	}
+
+	// A token for another version of the file does not apply.
+	r.save(file, []int32{1})
+	if _, ok := r.take(protocol.FileInfo{Name: "file", Version: v2}); ok {
+		t.Error("unexpected token for changed version")
+	}
+
+	// Empty tokens are not recorded.
+	r.save(file, nil)
+	if _, ok := r.take(file); ok {
+		t.Error("unexpected empty token")
+	}
+}
@@ -48,6 +48,15 @@ func (f *roFolder) Serve() {
 			return
 
 		case <-f.scan.timer.C:
+			if f.model.folderSuspended(f.folderID) {
+				// Scheduled scans are suspended while on battery power
+				// or a metered connection; explicitly requested scans
+				// still run.
+				l.Debugln(f, "skip scan (suspended)")
+				f.scan.Reschedule()
+				continue
+			}
+
 			if err := f.model.CheckFolderHealth(f.folderID); err != nil {
 				l.Infoln("Skipping folder", f.folderID, "scan due to folder error:", err)
 				f.scan.Reschedule()
@@ -46,15 +46,21 @@ type pullBlockState struct {
 // copied.
 type copyBlocksState struct {
 	*sharedPullerState
-	blocks []protocol.BlockInfo
+	blocks  []protocol.BlockInfo
+	noDelta bool // skip local block reuse; pull all blocks from the network
 }
 
 // Which filemode bits to preserve
 const retainBits = os.ModeSetgid | os.ModeSetuid | os.ModeSticky
 
 var (
-	activity    = newDeviceActivity()
-	errNoDevice = errors.New("peers who had this file went away, or the file has changed while syncing. will retry later")
+	activity             = newDeviceActivity()
+	faults               = newDeviceFaults()
+	requests             = newRequestLimiter()
+	sources              = newSourceTracker()
+	errNoDevice          = errors.New("peers who had this file went away, or the file has changed while syncing. will retry later")
+	errEncryptedTempFile = errors.New("temp file from a previous run is encrypted and cannot be reused")
+	errWholeFileRule     = errors.New("extension rule forces whole file replacement")
 )
 
 const (
@@ -67,7 +73,6 @@ const (
 
 const (
 	defaultCopiers     = 1
-	defaultPullers     = 16
 	defaultPullerSleep = 10 * time.Second
 	defaultPullerPause = 60 * time.Second
 )
@@ -91,14 +96,25 @@ type rwFolder struct {
 	allowSparse    bool
 	checkFreeSpace bool
 	ignoreDelete   bool
+	hash           scanner.HashAlgorithm
+	encryptTemp    bool
+	honorHolds     bool
+	extensionRules []config.ExtensionRuleConfiguration
+
+	deletionQuorum       int
+	deletionQuorumMaxAge time.Duration
+	pendingDeletions     map[string]time.Time // name -> when we first saw the deletion; accessed from pullerIteration only
 
 	copiers int
 	pullers int
 
 	queue       *jobQueue
+	resume      *resumeTokens
 	dbUpdates   chan dbUpdateJob
 	pullTimer   *time.Timer
-	remoteIndex chan struct{} // An index update was received, we should re-evaluate needs
+	remoteIndex chan struct{}     // An index update was received, we should re-evaluate needs
+	stash       *blockStash       // nil when the block stash is disabled
+	linkGroups  map[string]string // link group -> name of a member file, for hard link recreation
 
 	errors    map[string]string // path -> error string
 	errorsMut sync.Mutex
@@ -126,16 +142,33 @@ func newRWFolder(model *Model, cfg config.FolderConfiguration, ver versioner.Ver
 		allowSparse:    !cfg.DisableSparseFiles,
 		checkFreeSpace: cfg.MinDiskFreePct != 0,
 		ignoreDelete:   cfg.IgnoreDelete,
+		encryptTemp:    cfg.EncryptTempFiles,
+		honorHolds:     cfg.HonorHolds,
+		extensionRules: cfg.ExtensionRules,
+
+		deletionQuorum:       cfg.DeletionQuorum,
+		deletionQuorumMaxAge: time.Duration(cfg.DeletionQuorumMaxAgeS) * time.Second,
+		pendingDeletions:     make(map[string]time.Time),
 
 		queue:       newJobQueue(),
+		resume:      newResumeTokens(),
 		pullTimer:   time.NewTimer(time.Second),
 		remoteIndex: make(chan struct{}, 1), // This needs to be 1-buffered so that we queue a notification if we're busy doing a pull when it comes.
+		linkGroups:  make(map[string]string),
 
 		errorsMut: sync.NewMutex(),
 
 		initialScanCompleted: make(chan struct{}),
 	}
 
+	// An invalid algorithm name is caught when the folder is scanned; here
+	// we quietly get the default.
+	f.hash, _ = scanner.HashAlgorithmFromString(cfg.HashAlgorithm)
+
+	if dir := model.cfg.Options().BlockStashDir; dir != "" {
+		f.stash = newBlockStash(dir)
+	}
+
 	f.configureCopiersAndPullers(cfg)
 
 	return f
@@ -146,7 +179,10 @@ func (f *rwFolder) configureCopiersAndPullers(config config.FolderConfiguration)
 		f.copiers = defaultCopiers
 	}
 	if f.pullers == 0 {
-		f.pullers = defaultPullers
+		// Enough routines to cover the largest request window the limiter
+		// will grant a device; how many requests are actually in flight is
+		// governed per device by the request limiter.
+		f.pullers = maxOutstandingRequests
 	}
 
 	if config.PullerPauseS == 0 {
@@ -205,6 +241,22 @@ func (f *rwFolder) Serve() {
 				continue
 			}
 
+			if f.model.folderSuspended(f.folderID) {
+				// Transfers are suspended while on battery power or a
+				// metered connection, per the folder configuration.
+				l.Debugln(f, "skip (suspended)")
+				f.pullTimer.Reset(f.sleep)
+				continue
+			}
+
+			if dep, waiting := f.model.folderDependencyPending(f.folderID); waiting {
+				// The folder we depend on has not caught up yet; wait with
+				// pulling until it has.
+				l.Debugln(f, "skip (waiting for folder", dep, ")")
+				f.pullTimer.Reset(f.sleep)
+				continue
+			}
+
 			f.model.fmut.RLock()
 			curIgnores := f.model.folderIgnores[f.folderID]
 			f.model.fmut.RUnlock()
@@ -291,6 +343,14 @@ func (f *rwFolder) Serve() {
 		// this is the easiest way to make sure we are not doing both at the
 		// same time.
 		case <-f.scan.timer.C:
+			if f.model.folderSuspended(f.folderID) {
+				// Scheduled scans are suspended while on battery power
+				// or a metered connection; explicitly requested scans
+				// still run.
+				l.Debugln(f, "skip scan (suspended)")
+				f.scan.Reschedule()
+				continue
+			}
 			err := f.scanSubdirsIfHealthy(nil)
 			f.scan.Reschedule()
 			if err != nil {
@@ -388,6 +448,14 @@ func (f *rwFolder) pullerIteration(ignores *ignore.Matcher) int {
 
 	changed := 0
 
+	var holds map[string]FileHold
+	if f.honorHolds {
+		var err error
+		if holds, err = loadHolds(f.dir); err != nil {
+			l.Infof("Puller (folder %q): loading holds: %v", f.folderID, err)
+		}
+	}
+
 	fileDeletions := map[string]protocol.FileInfo{}
 	dirDeletions := []protocol.FileInfo{}
 	buckets := map[string][]protocol.FileInfo{}
@@ -430,6 +498,13 @@ func (f *rwFolder) pullerIteration(ignores *ignore.Matcher) int {
 			return true
 		}
 
+		if hold, ok := holds[intf.FileName()]; ok && hold.Device != f.model.id.String() {
+			// The path is under an advisory hold by another device; defer
+			// changing it until the hold is released.
+			l.Debugln(f, "deferring", intf.FileName(), "held by", hold.Device)
+			return true
+		}
+
 		if err := fileValid(intf); err != nil {
 			// The file isn't valid so we can't process it. Pretend that we
 			// tried and set the error for the file.
@@ -439,6 +514,13 @@ func (f *rwFolder) pullerIteration(ignores *ignore.Matcher) int {
 		}
 
 		file := intf.(protocol.FileInfo)
+
+		if file.IsDeleted() && f.deferDeletion(folderFiles, file.Name) {
+			// The deletion hasn't been seen from enough devices yet;
+			// leave the file alone for now.
+			return true
+		}
+
 		l.Debugln(f, "handling", file.Name)
 		if !handleFile(file) {
 			// A new or changed file or symlink. This is the only case where
@@ -566,6 +648,38 @@ nextFile:
 }
 
 // handleDir creates or updates the given directory
+// deferDeletion returns true when a remote deletion should not be applied
+// yet because fewer than deletionQuorum devices have announced it. It
+// tracks when we first saw each pending deletion, so that deletions older
+// than deletionQuorumMaxAge are let through regardless. Called from
+// pullerIteration only.
+func (f *rwFolder) deferDeletion(folderFiles *db.FileSet, name string) bool {
+	if f.deletionQuorum <= 1 {
+		return false
+	}
+
+	if len(folderFiles.Availability(name)) >= f.deletionQuorum {
+		// Enough devices agree on the deletion.
+		delete(f.pendingDeletions, name)
+		return false
+	}
+
+	first, ok := f.pendingDeletions[name]
+	if !ok {
+		f.pendingDeletions[name] = time.Now()
+		l.Infof("Puller (folder %q, file %q): deferring deletion until seen from %d devices", f.folderID, name, f.deletionQuorum)
+		return true
+	}
+
+	if f.deletionQuorumMaxAge > 0 && time.Since(first) > f.deletionQuorumMaxAge {
+		// We've waited long enough; apply the deletion anyway.
+		delete(f.pendingDeletions, name)
+		return false
+	}
+
+	return true
+}
+
 func (f *rwFolder) handleDir(file protocol.FileInfo) {
 	var err error
 	events.Default.Log(events.ItemStarted, map[string]string{
@@ -940,48 +1054,101 @@ func (f *rwFolder) handleFile(file protocol.FileInfo, copyChan chan<- copyBlocks
 		}
 	}
 
+	if file.LinkGroup != "" && !file.IsSymlink() {
+		// The file is a hard link on the source device. If another member
+		// of the link group is already in sync here, recreate the link
+		// instead of duplicating the content.
+		if f.maybeHardLink(file, tempName, realName) {
+			return
+		}
+	}
+
 	scanner.PopulateOffsets(file.Blocks)
 
+	rule, _ := f.extensionRule(file.Name)
+
 	var blocks []protocol.BlockInfo
 	var blocksSize int64
 	var reused []int32
 
-	// Check for an old temporary file which might have some blocks we could
-	// reuse.
-	tempBlocks, err := scanner.HashFile(tempName, protocol.BlockSize, nil)
-	if err == nil {
-		// Check for any reusable blocks in the temp file
-		tempCopyBlocks, _ := scanner.BlockDiff(tempBlocks, file.Blocks)
+	// A resumption token from a previous failed attempt tells us which
+	// blocks already made it into the temp file, letting us skip rehashing
+	// it and reissue only the requests that went unanswered. Whole file
+	// rules void block reuse entirely, resumed or not.
+	resumed := false
+	if !f.encryptTemp && !rule.WholeFile {
+		if avail, ok := f.resume.take(file); ok {
+			if _, err := os.Lstat(tempName); err == nil {
+				have := make(map[int32]struct{}, len(avail))
+				for _, i := range avail {
+					have[i] = struct{}{}
+				}
+				for i, block := range file.Blocks {
+					if _, ok := have[int32(i)]; ok {
+						reused = append(reused, int32(i))
+					} else {
+						blocks = append(blocks, block)
+						blocksSize += int64(block.Size)
+					}
+				}
+				resumed = true
+				l.Debugf("%v resuming %s; %d blocks already present", f, file.Name, len(reused))
+			}
+		}
+	}
 
-		// block.String() returns a string unique to the block
-		existingBlocks := make(map[string]struct{}, len(tempCopyBlocks))
-		for _, block := range tempCopyBlocks {
-			existingBlocks[block.String()] = struct{}{}
+	if !resumed {
+		// Check for an old temporary file which might have some blocks we could
+		// reuse.
+		tempBlocks, err := scanner.HashFile(tempName, protocol.BlockSize, nil, f.hash)
+		if err == nil && f.encryptTemp {
+			// A temp file from a previous run cannot be reused when temp file
+			// encryption is in use, as the key died with the process that
+			// wrote it.
+			osutil.InWritableDir(os.Remove, tempName)
+			err = errEncryptedTempFile
 		}
+		if err == nil && rule.WholeFile {
+			// An extension rule says the file is replaced wholesale on any
+			// change, so blocks from a previous temp file are not reused
+			// either.
+			osutil.InWritableDir(os.Remove, tempName)
+			err = errWholeFileRule
+		}
+		if err == nil {
+			// Check for any reusable blocks in the temp file
+			tempCopyBlocks, _ := scanner.BlockDiff(tempBlocks, file.Blocks)
 
-		// Since the blocks are already there, we don't need to get them.
-		for i, block := range file.Blocks {
-			_, ok := existingBlocks[block.String()]
-			if !ok {
-				blocks = append(blocks, block)
-				blocksSize += int64(block.Size)
-			} else {
-				reused = append(reused, int32(i))
+			// block.String() returns a string unique to the block
+			existingBlocks := make(map[string]struct{}, len(tempCopyBlocks))
+			for _, block := range tempCopyBlocks {
+				existingBlocks[block.String()] = struct{}{}
 			}
-		}
 
-		// The sharedpullerstate will know which flags to use when opening the
-		// temp file depending if we are reusing any blocks or not.
-		if len(reused) == 0 {
-			// Otherwise, discard the file ourselves in order for the
-			// sharedpuller not to panic when it fails to exclusively create a
-			// file which already exists
-			osutil.InWritableDir(os.Remove, tempName)
+			// Since the blocks are already there, we don't need to get them.
+			for i, block := range file.Blocks {
+				_, ok := existingBlocks[block.String()]
+				if !ok {
+					blocks = append(blocks, block)
+					blocksSize += int64(block.Size)
+				} else {
+					reused = append(reused, int32(i))
+				}
+			}
+
+			// The sharedpullerstate will know which flags to use when opening the
+			// temp file depending if we are reusing any blocks or not.
+			if len(reused) == 0 {
+				// Otherwise, discard the file ourselves in order for the
+				// sharedpuller not to panic when it fails to exclusively create a
+				// file which already exists
+				osutil.InWritableDir(os.Remove, tempName)
+			}
+		} else {
+			// Copy the blocks, as we don't want to shuffle them on the FileInfo
+			blocks = append(blocks, file.Blocks...)
+			blocksSize = file.Size
 		}
-	} else {
-		// Copy the blocks, as we don't want to shuffle them on the FileInfo
-		blocks = append(blocks, file.Blocks...)
-		blocksSize = file.Size
 	}
 
 	if f.checkFreeSpace {
@@ -1005,6 +1172,15 @@ func (f *rwFolder) handleFile(file protocol.FileInfo, copyChan chan<- copyBlocks
 		"action": "update",
 	})
 
+	var encryptor *tempEncryptor
+	if f.encryptTemp {
+		var err error
+		if encryptor, err = newTempEncryptor(); err != nil {
+			f.newError(file.Name, err)
+			return
+		}
+	}
+
 	s := sharedPullerState{
 		file:             file,
 		folder:           f.folderID,
@@ -1021,6 +1197,7 @@ func (f *rwFolder) handleFile(file protocol.FileInfo, copyChan chan<- copyBlocks
 		mut:              sync.NewRWMutex(),
 		sparse:           f.allowSparse,
 		created:          time.Now(),
+		encryptor:        encryptor,
 	}
 
 	l.Debugf("%v need file %s; copy %d, reused %v", f, file.Name, len(blocks), reused)
@@ -1028,10 +1205,96 @@ func (f *rwFolder) handleFile(file protocol.FileInfo, copyChan chan<- copyBlocks
 	cs := copyBlocksState{
 		sharedPullerState: &s,
 		blocks:            blocks,
+		noDelta:           rule.NoDelta || rule.WholeFile,
 	}
 	copyChan <- cs
 }
 
+// extensionRule returns the first extension rule matching the given file
+// name, if any.
+func (f *rwFolder) extensionRule(name string) (config.ExtensionRuleConfiguration, bool) {
+	for _, rule := range f.extensionRules {
+		if rule.Matches(name) {
+			return rule, true
+		}
+	}
+	return config.ExtensionRuleConfiguration{}, false
+}
+
+// maybeHardLink satisfies the file by hard linking to another member of
+// the same link group, when one is already in sync locally. It returns
+// false when there is no suitable link source, in which case the file is
+// pulled normally and remembered as a future link source.
+func (f *rwFolder) maybeHardLink(file protocol.FileInfo, tempName, realName string) bool {
+	src, ok := f.linkGroups[file.LinkGroup]
+	if !ok || src == file.Name {
+		f.linkGroups[file.LinkGroup] = file.Name
+		return false
+	}
+
+	// The source must be in sync according to the database, with the same
+	// content as the file we need...
+	cur, ok := f.model.CurrentFolderFile(f.folderID, src)
+	if !ok || cur.IsDeleted() || cur.IsInvalid() || cur.IsSymlink() ||
+		len(cur.Blocks) != len(file.Blocks) || !scanner.BlocksEqual(cur.Blocks, file.Blocks) {
+		return false
+	}
+
+	// ...and unmodified on disk, same check as before a pull.
+	srcName := filepath.Join(f.dir, src)
+	if info, err := f.mtimeFS.Lstat(srcName); err != nil ||
+		!info.ModTime().Equal(cur.ModTime()) || info.Size() != cur.Size {
+		return false
+	}
+
+	l.Debugln(f, "hard linking", file.Name, "to", src)
+
+	events.Default.Log(events.ItemStarted, map[string]string{
+		"folder": f.folderID,
+		"item":   file.Name,
+		"type":   "file",
+		"action": "update",
+	})
+
+	f.queue.Done(file.Name)
+
+	err := func() error {
+		if f.versioner != nil {
+			// Let the versioner archive the old file, if any, before we
+			// replace it. Archiving a non-existent file is not an error.
+			if err := f.versioner.Archive(realName); err != nil {
+				return err
+			}
+		}
+		// Link to the temp name first, then rename into place so an old
+		// version of the file is replaced atomically.
+		osutil.InWritableDir(os.Remove, tempName)
+		if err := osutil.InWritableDir(func(name string) error {
+			return os.Link(srcName, name)
+		}, tempName); err != nil {
+			return err
+		}
+		return osutil.TryRename(tempName, realName)
+	}()
+
+	events.Default.Log(events.ItemFinished, map[string]interface{}{
+		"folder": f.folderID,
+		"item":   file.Name,
+		"error":  events.Error(err),
+		"type":   "file",
+		"action": "update",
+	})
+
+	if err != nil {
+		l.Infoln("Puller: hard link:", err)
+		f.newError(file.Name, err)
+	} else {
+		f.dbUpdates <- dbUpdateJob{file, dbUpdateHandleFile}
+	}
+
+	return true
+}
+
 // shortcutFile sets file mode and modification time, when that's the only
 // thing that has changed.
 func (f *rwFolder) shortcutFile(file protocol.FileInfo) error {
@@ -1046,6 +1309,13 @@ func (f *rwFolder) shortcutFile(file protocol.FileInfo) error {
 
 	f.mtimeFS.Chtimes(realName, file.ModTime(), file.ModTime()) // never fails
 
+	// Apply any extended metadata through the registered appliers.
+	if err := applyMetadata(realName, file); err != nil {
+		l.Infof("Puller (folder %q, file %q): shortcut: metadata: %v", f.folderID, file.Name, err)
+		f.newError(file.Name, err)
+		return err
+	}
+
 	// This may have been a conflict. We should merge the version vectors so
 	// that our clock doesn't move backwards.
 	if cur, ok := f.model.CurrentFolderFile(f.folderID, file.Name); ok {
@@ -1086,15 +1356,11 @@ func (f *rwFolder) copierRoutine(in <-chan copyBlocksState, pullChan chan<- pull
 			f.model.progressEmitter.Register(state.sharedPullerState)
 		}
 
-		folderRoots := make(map[string]string)
-		var folders []string
-		f.model.fmut.RLock()
-		for folder, cfg := range f.model.folderCfgs {
-			folderRoots[folder] = cfg.Path()
-			folders = append(folders, folder)
-		}
-		f.model.fmut.RUnlock()
-
+		// Collect the blocks that need a lookup, so that we can resolve
+		// all of them against the block map in a single pass instead of
+		// setting up a new iterator per block.
+		lookups := make([]protocol.BlockInfo, 0, len(state.blocks))
+		hashes := make([][]byte, 0, len(state.blocks))
 		for _, block := range state.blocks {
 			if f.allowSparse && state.reused == 0 && block.IsEmpty() {
 				// The block is a block of all zeroes, and we are not reusing
@@ -1107,43 +1373,29 @@ func (f *rwFolder) copierRoutine(in <-chan copyBlocksState, pullChan chan<- pull
 				state.copiedFromOrigin()
 				continue
 			}
+			lookups = append(lookups, block)
+			hashes = append(hashes, block.Hash)
+		}
 
-			buf = buf[:int(block.Size)]
-			found := f.model.finder.Iterate(folders, block.Hash, func(folder, file string, index int32) bool {
-				fd, err := os.Open(filepath.Join(folderRoots[folder], file))
-				if err != nil {
-					return false
-				}
+		copied := make([]bool, len(lookups))
+		if !state.noDelta {
+			f.copyFromLocalBlocks(state, lookups, hashes, copied, buf)
+		}
 
-				_, err = fd.ReadAt(buf, protocol.BlockSize*int64(index))
-				fd.Close()
-				if err != nil {
-					return false
-				}
+		for i, block := range lookups {
+			found := copied[i]
 
-				hash, err := scanner.VerifyBuffer(buf, block)
-				if err != nil {
-					if hash != nil {
-						l.Debugf("Finder block mismatch in %s:%s:%d expected %q got %q", folder, file, index, block.Hash, hash)
-						err = f.model.finder.Fix(folder, file, index, block.Hash, hash)
-						if err != nil {
-							l.Warnln("finder fix:", err)
-						}
-					} else {
-						l.Debugln("Finder failed to verify buffer", err)
+			if !found && !state.noDelta && state.failed() == nil && f.stash != nil {
+				buf = buf[:int(block.Size)]
+				if f.stash.Read(block, buf, f.hash) {
+					// The block is not present in any local file, but we had it
+					// in the block stash.
+					if _, err := dstFd.WriteAt(buf, block.Offset); err != nil {
+						state.fail("dst write", err)
 					}
-					return false
-				}
-
-				_, err = dstFd.WriteAt(buf, block.Offset)
-				if err != nil {
-					state.fail("dst write", err)
-				}
-				if file == state.file.Name {
-					state.copiedFromOrigin()
+					found = true
 				}
-				return true
-			})
+			}
 
 			if state.failed() != nil {
 				break
@@ -1164,6 +1416,104 @@ func (f *rwFolder) copierRoutine(in <-chan copyBlocksState, pullChan chan<- pull
 	}
 }
 
+// copyFromLocalBlocks resolves the given blocks against the block map and
+// copies those found in local files into the temp file, marking them in
+// copied. It is the delta transfer part of the copier.
+func (f *rwFolder) copyFromLocalBlocks(state copyBlocksState, lookups []protocol.BlockInfo, hashes [][]byte, copied []bool, buf []byte) {
+	dstFd, err := state.tempFile()
+	if err != nil {
+		return
+	}
+
+	folderRoots := make(map[string]string)
+	var folders []string
+	f.model.fmut.RLock()
+	for folder, cfg := range f.model.folderCfgs {
+		folderRoots[folder] = cfg.Path()
+		folders = append(folders, folder)
+	}
+	f.model.fmut.RUnlock()
+
+	// Prefer blocks from the folder being pulled into, which are
+	// likely on the same disk, over copies in other folders.
+	score := func(folder, file string) int {
+		if folder == f.folderID {
+			return 1
+		}
+		return 0
+	}
+	f.model.finder.IterateMany(folders, hashes, score, func(i int, folder, file string, index int32) bool {
+		if state.failed() != nil {
+			// Stop wasting effort on a file that has already failed.
+			return true
+		}
+
+		block := lookups[i]
+		buf = buf[:int(block.Size)]
+
+		fd, err := os.Open(filepath.Join(folderRoots[folder], file))
+		if err != nil {
+			return false
+		}
+
+		_, err = fd.ReadAt(buf, protocol.BlockSize*int64(index))
+		fd.Close()
+		if err != nil {
+			return false
+		}
+
+		hash, err := f.hash.VerifyBuffer(buf, block)
+		if err != nil {
+			if hash != nil {
+				l.Debugf("Finder block mismatch in %s:%s:%d expected %q got %q", folder, file, index, block.Hash, hash)
+				err = f.model.finder.Fix(folder, file, index, block.Hash, hash)
+				if err != nil {
+					l.Warnln("finder fix:", err)
+				}
+			} else {
+				l.Debugln("Finder failed to verify buffer", err)
+			}
+			return false
+		}
+
+		_, err = dstFd.WriteAt(buf, block.Offset)
+		if err != nil {
+			state.fail("dst write", err)
+		}
+		if file == state.file.Name {
+			state.copiedFromOrigin()
+		}
+		copied[i] = true
+		return true
+	})
+}
+
+// minExpectedWait floors the expected delivery time used for source
+// selection, so that the reliability factor still separates devices we
+// know nothing about from devices that keep failing requests.
+const minExpectedWait = 0.01 // seconds
+
+// selectPullSource picks the candidate with the shortest expected delivery
+// time for the next block, weighing the requests already in flight and the
+// measured delivery rate of each connection against its reliability. This
+// spreads concurrent requests for the blocks of a single file over several
+// sources in proportion to what each one actually delivers.
+func selectPullSource(candidates []Availability) (Availability, bool) {
+	var selected Availability
+	var best float64
+	found := false
+	for _, c := range candidates {
+		wait := requests.expectedSeconds(c.ID, activity.outstanding(c.ID))
+		if wait < minExpectedWait {
+			wait = minExpectedWait
+		}
+		if score := wait / sources.reliability(c.ID); !found || score < best {
+			selected, best, found = c, score, true
+		}
+	}
+	return selected, found
+}
+
 func (f *rwFolder) pullerRoutine(in <-chan pullBlockState, out chan<- *sharedPullerState) {
 	for state := range in {
 		if state.failed() != nil {
@@ -1190,12 +1540,27 @@ func (f *rwFolder) pullerRoutine(in <-chan pullBlockState, out chan<- *sharedPul
 
 		var lastError error
 		candidates := f.model.Availability(f.folderID, state.file.Name, state.file.Version, state.block)
+		// Devices that have repeatedly served us corrupt data are tried
+		// only when no device in good standing can serve the block.
+		candidates, suspect := faults.partition(candidates)
+		// Devices that have announced being battery powered or on a
+		// metered connection are tried only when no unconstrained device
+		// can serve the block.
+		candidates, constrained := f.model.partitionConstrained(candidates)
 		for {
-			// Select the least busy device to pull the block from. If we found no
-			// feasible device at all, fail the block (and in the long run, the
-			// file).
-			selected, found := activity.leastBusy(candidates)
+			// Select the most promising device to pull the block from. If we
+			// found no feasible device at all, fail the block (and in the long
+			// run, the file).
+			selected, found := selectPullSource(candidates)
 			if !found {
+				if len(constrained) > 0 {
+					candidates, constrained = constrained, nil
+					continue
+				}
+				if len(suspect) > 0 {
+					candidates, suspect = suspect, nil
+					continue
+				}
 				if lastError != nil {
 					state.fail("pull", lastError)
 				} else {
@@ -1207,22 +1572,48 @@ func (f *rwFolder) pullerRoutine(in <-chan pullBlockState, out chan<- *sharedPul
 			candidates = removeAvailability(candidates, selected)
 
 			// Fetch the block, while marking the selected device as in use so that
-			// leastBusy can select another device when someone else asks.
+			// leastBusy can select another device when someone else asks. The
+			// request limiter keeps the number of requests in flight to the
+			// device in proportion to the bandwidth-delay product of the
+			// connection.
 			activity.using(selected)
+			requests.acquire(selected.ID)
+			started := time.Now()
 			buf, lastError := f.model.requestGlobal(selected.ID, f.folderID, state.file.Name, state.block.Offset, int(state.block.Size), state.block.Hash, selected.FromTemporary)
+			if lastError == nil {
+				requests.release(selected.ID, int(state.block.Size), time.Since(started))
+			} else {
+				requests.release(selected.ID, 0, 0)
+			}
 			activity.done(selected)
 			if lastError != nil {
 				l.Debugln("request:", f.folderID, state.file.Name, state.block.Offset, state.block.Size, "returned error:", lastError)
+				sources.failure(selected.ID)
 				continue
 			}
 
 			// Verify that the received block matches the desired hash, if not
 			// try pulling it from another device.
-			_, lastError = scanner.VerifyBuffer(buf, state.block)
+			_, lastError = f.hash.VerifyBuffer(buf, state.block)
 			if lastError != nil {
 				l.Debugln("request:", f.folderID, state.file.Name, state.block.Offset, state.block.Size, "hash mismatch")
+				sources.failure(selected.ID)
+				if count := faults.hashFailure(selected.ID); count >= maxHashFailures {
+					events.Default.Log(events.DeviceHashFailure, map[string]interface{}{
+						"device":   selected.ID.String(),
+						"folder":   f.folderID,
+						"file":     state.file.Name,
+						"failures": count,
+					})
+				}
 				continue
 			}
+			faults.success(selected.ID)
+			sources.success(selected.ID)
+
+			if f.stash != nil {
+				f.stash.Save(state.block.Hash, buf)
+			}
 
 			// Save the block data we got from the cluster
 			_, err = fd.WriteAt(buf, state.block.Offset)
@@ -1238,6 +1629,14 @@ func (f *rwFolder) pullerRoutine(in <-chan pullBlockState, out chan<- *sharedPul
 }
 
 func (f *rwFolder) performFinish(state *sharedPullerState) error {
+	if state.encryptor != nil {
+		// The temp file contents are encrypted; decipher in place before
+		// the file is moved to its final name.
+		if err := state.encryptor.decryptFile(state.tempName); err != nil {
+			return err
+		}
+	}
+
 	// Set the correct permission bits on the new file
 	if !f.ignorePermissions(state.file) {
 		if err := os.Chmod(state.tempName, os.FileMode(state.file.Permissions&0777)); err != nil {
@@ -1294,6 +1693,11 @@ func (f *rwFolder) performFinish(state *sharedPullerState) error {
 	// Set the correct timestamp on the new file
 	f.mtimeFS.Chtimes(state.realName, state.file.ModTime(), state.file.ModTime()) // never fails
 
+	// Apply any extended metadata through the registered appliers.
+	if err := applyMetadata(state.realName, state.file); err != nil {
+		return err
+	}
+
 	// If it's a symlink, the target of the symlink is inside the file.
 	if state.file.IsSymlink() {
 		content, err := ioutil.ReadFile(state.realName)
@@ -1315,6 +1719,17 @@ func (f *rwFolder) performFinish(state *sharedPullerState) error {
 		}
 	}
 
+	if state.file.Name == holdsFileName {
+		// The holds file was updated by a remote device; surface the new
+		// set of holds.
+		if holds, err := loadHolds(f.dir); err == nil {
+			events.Default.Log(events.HoldChanged, map[string]interface{}{
+				"folder": f.folderID,
+				"holds":  holds,
+			})
+		}
+	}
+
 	// Record the updated file in the index
 	f.dbUpdates <- dbUpdateJob{state.file, dbUpdateHandleFile}
 	return nil
@@ -1329,6 +1744,12 @@ func (f *rwFolder) finisherRoutine(in <-chan *sharedPullerState) {
 
 			if err == nil {
 				err = f.performFinish(state)
+			} else if !f.encryptTemp {
+				// The copy/pull cycle failed partway — typically a source
+				// device went away. Remember which blocks made it into the
+				// temporary file, so that a retry reissues only the
+				// unanswered requests.
+				f.resume.save(state.file, state.Available())
 			}
 
 			if err != nil {
@@ -1355,10 +1776,35 @@ func (f *rwFolder) BringToFront(filename string) {
 	f.queue.BringToFront(filename)
 }
 
+// Moves the given filename to the back of the job queue
+func (f *rwFolder) SendToBack(filename string) {
+	f.queue.SendToBack(filename)
+}
+
+// Removes the given filename from the job queue for this pull iteration
+func (f *rwFolder) SkipJob(filename string) {
+	f.queue.Skip(filename)
+}
+
+// RetryJob clears any error recorded for the given filename, bumps it to the
+// front of the queue and triggers a new pull iteration.
+func (f *rwFolder) RetryJob(filename string) {
+	f.errorsMut.Lock()
+	delete(f.errors, filename)
+	f.errorsMut.Unlock()
+
+	f.queue.BringToFront(filename)
+	f.IndexUpdated()
+}
+
 func (f *rwFolder) Jobs() ([]string, []string) {
 	return f.queue.Jobs()
 }
 
+func (f *rwFolder) PullQueue() []PullQueueItem {
+	return f.queue.Items()
+}
+
 // dbUpdaterRoutine aggregates db updates and commits them in batches no
 // larger than 1000 items, and no more delayed than 2 seconds.
 func (f *rwFolder) dbUpdaterRoutine() {
@@ -1511,6 +1957,7 @@ func (f *rwFolder) newError(path string, err error) {
 	}
 
 	f.errors[path] = err.Error()
+	f.model.addFolderError(f.folderID)
 }
 
 func (f *rwFolder) clearErrors() {
@@ -72,6 +72,7 @@ func setUpRwFolder(model *Model) rwFolder {
 		},
 		dir:       "testdata",
 		queue:     newJobQueue(),
+		resume:    newResumeTokens(),
 		errors:    make(map[string]string),
 		errorsMut: sync.NewMutex(),
 	}
@@ -223,7 +224,7 @@ func TestCopierFinder(t *testing.T) {
 	}
 
 	// Verify that the fetched blocks have actually been written to the temp file
-	blks, err := scanner.HashFile(tempFile, protocol.BlockSize, nil)
+	blks, err := scanner.HashFile(tempFile, protocol.BlockSize, nil, scanner.SHA256)
 	if err != nil {
 		t.Log(err)
 	}
@@ -459,3 +460,50 @@ func TestDeregisterOnFailInPull(t *testing.T) {
 		t.Fatal("Didn't get anything to the finisher")
 	}
 }
+
+func TestDeferDeletion(t *testing.T) {
+	ldb := db.OpenMemory()
+	fs := db.NewFileSet("default", ldb)
+
+	version := protocol.Vector{Counters: []protocol.Counter{{ID: 42, Value: 1}}}
+	deleted := protocol.FileInfo{
+		Name:    "filex",
+		Deleted: true,
+		Version: version,
+	}
+	fs.Update(device1, []protocol.FileInfo{deleted})
+
+	f := rwFolder{
+		folder: folder{
+			stateTracker: newStateTracker("default"),
+		},
+		deletionQuorum:   2,
+		pendingDeletions: make(map[string]time.Time),
+	}
+
+	// One device has announced the deletion; it should be deferred.
+	if !f.deferDeletion(fs, "filex") {
+		t.Error("deletion seen from one device should be deferred")
+	}
+	if _, ok := f.pendingDeletions["filex"]; !ok {
+		t.Error("deferred deletion should be pending")
+	}
+
+	// A second device announcing the same deletion meets the quorum.
+	fs.Update(device2, []protocol.FileInfo{deleted})
+	if f.deferDeletion(fs, "filex") {
+		t.Error("deletion seen from two devices should be applied")
+	}
+	if _, ok := f.pendingDeletions["filex"]; ok {
+		t.Error("applied deletion should no longer be pending")
+	}
+
+	// A deletion that has waited out the max age goes through without
+	// quorum.
+	fs.Replace(device2, nil)
+	f.deletionQuorumMaxAge = time.Minute
+	f.pendingDeletions["filex"] = time.Now().Add(-2 * time.Minute)
+	if f.deferDeletion(fs, "filex") {
+		t.Error("deletion older than the max age should be applied")
+	}
+}
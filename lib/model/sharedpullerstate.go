@@ -29,6 +29,7 @@ type sharedPullerState struct {
 	version     protocol.Vector // The current (old) version
 	sparse      bool
 	created     time.Time
+	encryptor   *tempEncryptor // Encrypts the temp file contents; nil when disabled
 
 	// Mutable, must be locked for access
 	err              error        // The first error we hit
@@ -83,7 +84,7 @@ func (s *sharedPullerState) tempFile() (io.WriterAt, error) {
 
 	// If the temp file is already open, return the file descriptor
 	if s.fd != nil {
-		return lockedWriterAt{&s.mut, s.fd}, nil
+		return s.writerLocked(), nil
 	}
 
 	// Ensure that the parent directory is writable. This is
@@ -174,7 +175,18 @@ func (s *sharedPullerState) tempFile() (io.WriterAt, error) {
 	// Same fd will be used by all writers
 	s.fd = fd
 
-	return lockedWriterAt{&s.mut, s.fd}, nil
+	return s.writerLocked(), nil
+}
+
+// writerLocked returns the writer to hand to the copier and puller
+// routines, with the temp file wrapped in encryption when that is
+// configured. Must be called with the lock held.
+func (s *sharedPullerState) writerLocked() io.WriterAt {
+	w := io.WriterAt(lockedWriterAt{&s.mut, s.fd})
+	if s.encryptor != nil {
+		return encryptedWriterAt{w, s.encryptor}
+	}
+	return w
 }
 
 // sourceFile opens the existing source file for reading
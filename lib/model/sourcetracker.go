@@ -0,0 +1,64 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syncthing/syncthing/lib/sync"
+)
+
+// Gain of the exponentially weighted moving average for per source
+// reliability.
+const sourceEWMAGain = 0.25
+
+// A sourceTracker records how reliably each device has served block
+// requests, as an exponentially weighted ratio of successful requests. It
+// is safe for use from multiple goroutines.
+type sourceTracker struct {
+	rel map[protocol.DeviceID]float64
+	mut sync.Mutex
+}
+
+func newSourceTracker() *sourceTracker {
+	return &sourceTracker{
+		rel: make(map[protocol.DeviceID]float64),
+		mut: sync.NewMutex(),
+	}
+}
+
+// success records a served block request for the device.
+func (t *sourceTracker) success(device protocol.DeviceID) {
+	t.record(device, 1)
+}
+
+// failure records a failed or corrupt block request for the device.
+func (t *sourceTracker) failure(device protocol.DeviceID) {
+	t.record(device, 0)
+}
+
+func (t *sourceTracker) record(device protocol.DeviceID, outcome float64) {
+	t.mut.Lock()
+	rel, ok := t.rel[device]
+	if !ok {
+		rel = 1
+	}
+	t.rel[device] = rel + sourceEWMAGain*(outcome-rel)
+	t.mut.Unlock()
+}
+
+// reliability returns the tracked success ratio for the device, between
+// zero and one. Devices we know nothing about get the benefit of the
+// doubt.
+func (t *sourceTracker) reliability(device protocol.DeviceID) float64 {
+	t.mut.Lock()
+	rel, ok := t.rel[device]
+	t.mut.Unlock()
+	if !ok {
+		return 1
+	}
+	return rel
+}
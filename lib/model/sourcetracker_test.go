@@ -0,0 +1,67 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+func TestSourceTrackerReliability(t *testing.T) {
+	tr := newSourceTracker()
+	dev := protocol.DeviceID{0xf0}
+
+	if rel := tr.reliability(dev); rel != 1 {
+		t.Errorf("unknown device reliability %v, expected 1", rel)
+	}
+
+	tr.failure(dev)
+	after := tr.reliability(dev)
+	if after >= 1 {
+		t.Errorf("reliability %v did not decrease on failure", after)
+	}
+
+	for i := 0; i < 20; i++ {
+		tr.failure(dev)
+	}
+	low := tr.reliability(dev)
+	if low > 0.1 {
+		t.Errorf("reliability %v too high after repeated failures", low)
+	}
+
+	for i := 0; i < 20; i++ {
+		tr.success(dev)
+	}
+	if rel := tr.reliability(dev); rel < 0.9 {
+		t.Errorf("reliability %v did not recover on success", rel)
+	}
+}
+
+func TestSelectPullSource(t *testing.T) {
+	good := protocol.DeviceID{0xf1}
+	bad := protocol.DeviceID{0xf2}
+
+	// Both devices have unknown rates so their expected waits are equal;
+	// the failing device loses on reliability.
+	for i := 0; i < 10; i++ {
+		sources.failure(bad)
+	}
+
+	candidates := []Availability{{ID: bad}, {ID: good}}
+	selected, found := selectPullSource(candidates)
+	if !found {
+		t.Fatal("expected a device to be selected")
+	}
+	if selected.ID != good {
+		t.Errorf("selected %v, expected the reliable device", selected.ID)
+	}
+
+	if _, found := selectPullSource(nil); found {
+		t.Error("unexpected selection from no candidates")
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"os"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// A tempEncryptor enciphers data written to a pull temp file with AES-CTR
+// under a random key that is held in memory only. As CTR mode is
+// symmetric, the same transformation deciphers the temp file in place
+// before the final rename. The key dies with the process, so an encrypted
+// temp file from a previous run can never be reused.
+type tempEncryptor struct {
+	block cipher.Block
+}
+
+func newTempEncryptor() (*tempEncryptor, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &tempEncryptor{block: block}, nil
+}
+
+// xorKeyStreamAt enciphers or deciphers buf, which represents file
+// contents starting at the given offset. The offset must be a multiple of
+// the AES block size; in practice it is always a multiple of the protocol
+// block size.
+func (e *tempEncryptor) xorKeyStreamAt(buf []byte, offset int64) {
+	var iv [aes.BlockSize]byte
+	binary.BigEndian.PutUint64(iv[8:], uint64(offset)/aes.BlockSize)
+	cipher.NewCTR(e.block, iv[:]).XORKeyStream(buf, buf)
+}
+
+// decryptFile deciphers the file in place.
+func (e *tempEncryptor) decryptFile(path string) error {
+	fd, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, protocol.BlockSize)
+	var offset int64
+	for {
+		n, err := fd.ReadAt(buf, offset)
+		if n > 0 {
+			e.xorKeyStreamAt(buf[:n], offset)
+			if _, err := fd.WriteAt(buf[:n], offset); err != nil {
+				fd.Close()
+				return err
+			}
+			offset += int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fd.Close()
+			return err
+		}
+	}
+
+	return fd.Close()
+}
+
+// An encryptedWriterAt enciphers data on its way to the underlying
+// writer. The caller's buffer is left untouched, as it may be used again
+// after the write.
+type encryptedWriterAt struct {
+	wr  io.WriterAt
+	enc *tempEncryptor
+}
+
+func (w encryptedWriterAt) WriteAt(p []byte, off int64) (n int, err error) {
+	c := make([]byte, len(p))
+	copy(c, p)
+	w.enc.xorKeyStreamAt(c, off)
+	return w.wr.WriteAt(c, off)
+}
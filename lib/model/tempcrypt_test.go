@@ -0,0 +1,72 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+func TestTempEncryptor(t *testing.T) {
+	enc, err := newTempEncryptor()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fd, err := ioutil.TempFile("", "syncthing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fd.Name())
+
+	// Two full blocks and a partial one, written out of order like the
+	// puller does.
+	data := make([]byte, 2*protocol.BlockSize+1234)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	w := encryptedWriterAt{fd, enc}
+	for _, offset := range []int64{2 * protocol.BlockSize, 0, protocol.BlockSize} {
+		end := offset + protocol.BlockSize
+		if end > int64(len(data)) {
+			end = int64(len(data))
+		}
+		if _, err := w.WriteAt(data[offset:end], offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fd.Close()
+
+	// The file on disk should not contain the plaintext.
+	onDisk, err := ioutil.ReadFile(fd.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(onDisk) != len(data) {
+		t.Fatalf("Size mismatch: %d != %d", len(onDisk), len(data))
+	}
+	if bytes.Contains(onDisk, data[:1024]) {
+		t.Error("Found plaintext in the encrypted temp file")
+	}
+
+	// Decrypting in place should give back the plaintext.
+	if err := enc.decryptFile(fd.Name()); err != nil {
+		t.Fatal(err)
+	}
+	onDisk, err = ioutil.ReadFile(fd.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(onDisk, data) {
+		t.Error("Decrypted temp file does not match the plaintext")
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"time"
+
+	"github.com/syncthing/syncthing/lib/events"
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// How often the per-device byte counters are sampled.
+const trafficCapCheckInterval = time.Minute
+
+// trafficCapMonitor periodically samples the byte counters of device
+// connections and accounts the traffic against the per-device monthly
+// send and receive caps. When a cap is exceeded the device is paused and
+// a TrafficCapReached event emitted; when the month rolls over, devices
+// paused by the monitor are resumed again.
+type trafficCapMonitor struct {
+	model *Model
+	stop  chan struct{}
+
+	lastIn       map[protocol.DeviceID]int64
+	lastOut      map[protocol.DeviceID]int64
+	pausedByCaps map[protocol.DeviceID]bool
+}
+
+func newTrafficCapMonitor(m *Model) *trafficCapMonitor {
+	return &trafficCapMonitor{
+		model:        m,
+		stop:         make(chan struct{}),
+		lastIn:       make(map[protocol.DeviceID]int64),
+		lastOut:      make(map[protocol.DeviceID]int64),
+		pausedByCaps: make(map[protocol.DeviceID]bool),
+	}
+}
+
+func (mon *trafficCapMonitor) Serve() {
+	ticker := time.NewTicker(trafficCapCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			mon.check()
+		case <-mon.stop:
+			return
+		}
+	}
+}
+
+func (mon *trafficCapMonitor) Stop() {
+	close(mon.stop)
+}
+
+func (mon *trafficCapMonitor) check() {
+	m := mon.model
+
+	m.pmut.RLock()
+	conns := make(map[protocol.DeviceID]protocol.Statistics)
+	for id, conn := range m.conn {
+		conns[id] = conn.Statistics()
+	}
+	m.pmut.RUnlock()
+
+	for id, stats := range conns {
+		in, out := stats.InBytesTotal, stats.OutBytesTotal
+		lastIn, lastOut := mon.lastIn[id], mon.lastOut[id]
+		if in < lastIn || out < lastOut {
+			// The counters restarted; this is a new connection.
+			lastIn, lastOut = 0, 0
+		}
+		mon.lastIn[id], mon.lastOut[id] = in, out
+		if in > lastIn || out > lastOut {
+			m.deviceStatRef(id).AddTransferBytes(in-lastIn, out-lastOut)
+		}
+	}
+
+	for id, dcfg := range m.cfg.Devices() {
+		if dcfg.MonthlySendCap <= 0 && dcfg.MonthlyReceiveCap <= 0 {
+			continue
+		}
+
+		usedIn, usedOut := m.deviceStatRef(id).GetTransferBytesMonth()
+		exceeded := (dcfg.MonthlySendCap > 0 && usedOut >= dcfg.MonthlySendCap) ||
+			(dcfg.MonthlyReceiveCap > 0 && usedIn >= dcfg.MonthlyReceiveCap)
+
+		switch {
+		case exceeded && !m.IsPaused(id):
+			l.Infof("Monthly transfer cap for %v reached (%d in, %d out bytes); pausing device", id, usedIn, usedOut)
+			events.Default.Log(events.TrafficCapReached, map[string]interface{}{
+				"device":     id.String(),
+				"inBytes":    usedIn,
+				"outBytes":   usedOut,
+				"sendCap":    dcfg.MonthlySendCap,
+				"receiveCap": dcfg.MonthlyReceiveCap,
+			})
+			mon.pausedByCaps[id] = true
+			m.PauseDevice(id)
+
+		case !exceeded && mon.pausedByCaps[id]:
+			// A new month; resume the device, unless the user paused it
+			// themselves in the meantime.
+			delete(mon.pausedByCaps, id)
+			if m.IsPaused(id) {
+				l.Infof("Monthly transfer cap for %v reset; resuming device", id)
+				m.ResumeDevice(id)
+			}
+		}
+	}
+}
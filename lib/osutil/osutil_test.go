@@ -212,3 +212,28 @@ func TestDiskUsage(t *testing.T) {
 		t.Error("Disk is full?", free)
 	}
 }
+
+func TestIsWritable(t *testing.T) {
+	err := os.RemoveAll("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("testdata")
+
+	os.Mkdir("testdata", 0700)
+	if err := osutil.IsWritable("testdata"); err != nil {
+		t.Error("testdata should be writable:", err)
+	}
+
+	if os.Getuid() == 0 {
+		// Root passes permission checks regardless of mode bits, and we
+		// can't remount anything read only from here.
+		t.Log("skipping read only check; running as root")
+		return
+	}
+
+	os.Mkdir("testdata/ro", 0500)
+	if err := osutil.IsWritable("testdata/ro"); err == nil {
+		t.Error("testdata/ro should not be writable")
+	}
+}
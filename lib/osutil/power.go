@@ -0,0 +1,21 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package osutil
+
+import "errors"
+
+// ErrNoPowerStatus is returned when the power source of the system cannot
+// be determined on this platform.
+var ErrNoPowerStatus = errors.New("power status not available")
+
+// OnMeteredConnection returns true when the current network connection is
+// known to be metered. Detection is not available on all platforms; where
+// it isn't, ErrNoPowerStatus is returned and the caller should fall back
+// to configuration.
+func OnMeteredConnection() (bool, error) {
+	return false, ErrNoPowerStatus
+}
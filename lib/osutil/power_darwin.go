@@ -0,0 +1,21 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package osutil
+
+import (
+	"bytes"
+	"os/exec"
+)
+
+// OnBattery returns true when the system is running on battery power.
+func OnBattery() (bool, error) {
+	out, err := exec.Command("pmset", "-g", "ps").CombinedOutput()
+	if err != nil {
+		return false, ErrNoPowerStatus
+	}
+	return bytes.Contains(out, []byte("Battery Power")), nil
+}
@@ -0,0 +1,49 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build linux android
+
+package osutil
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// OnBattery returns true when the system is running on battery power, that
+// is when a battery is present and no power supply reports being online.
+func OnBattery() (bool, error) {
+	supplies, err := filepath.Glob("/sys/class/power_supply/*")
+	if err != nil || len(supplies) == 0 {
+		return false, ErrNoPowerStatus
+	}
+
+	haveBattery := false
+	for _, supply := range supplies {
+		typ, err := ioutil.ReadFile(filepath.Join(supply, "type"))
+		if err != nil {
+			continue
+		}
+		if strings.TrimSpace(string(typ)) == "Battery" {
+			haveBattery = true
+			continue
+		}
+		online, err := ioutil.ReadFile(filepath.Join(supply, "online"))
+		if err == nil && strings.TrimSpace(string(online)) == "1" {
+			// A mains, USB or UPS supply is online; we're not running
+			// on battery.
+			return false, nil
+		}
+	}
+
+	if !haveBattery {
+		// Desktops have no battery and may not report an online supply
+		// either.
+		return false, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,15 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build !linux,!android,!windows,!darwin
+
+package osutil
+
+// OnBattery returns true when the system is running on battery power. It
+// is not implemented on this platform.
+func OnBattery() (bool, error) {
+	return false, ErrNoPowerStatus
+}
@@ -0,0 +1,19 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build !windows
+
+package osutil
+
+import "syscall"
+
+// IsWritable returns nil if the given directory can be written to, and
+// the reason why not otherwise. In particular it notices filesystems
+// that have been remounted read only after errors, and write protected
+// removable media.
+func IsWritable(path string) error {
+	return syscall.Access(path, 0x2 /* W_OK */)
+}
@@ -0,0 +1,28 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build windows
+
+package osutil
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// IsWritable returns nil if the given directory can be written to, and
+// the reason why not otherwise. There is no access(2) equivalent that
+// covers write protected media on Windows, so we probe by creating and
+// removing a temporary file.
+func IsWritable(path string) error {
+	fd, err := ioutil.TempFile(path, ".syncthing.writable-check")
+	if err != nil {
+		return err
+	}
+	fd.Close()
+	os.Remove(fd.Name())
+	return nil
+}
@@ -0,0 +1,22 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package previews
+
+import (
+	"os"
+	"strings"
+
+	"github.com/syncthing/syncthing/lib/logger"
+)
+
+var (
+	l = logger.DefaultLogger.NewFacility("previews", "Image preview generation")
+)
+
+func init() {
+	l.SetDebug("previews", strings.Contains(os.Getenv("STTRACE"), "previews") || os.Getenv("STTRACE") == "all")
+}
@@ -0,0 +1,123 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package previews generates small JPEG previews of image files into a
+// sidecar store inside the folder. The store is skipped by the scanner so
+// the previews themselves never enter the index.
+package previews
+
+import (
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/syncthing/syncthing/lib/osutil"
+)
+
+// DirName is the name of the sidecar store, relative to the folder root.
+const DirName = ".stpreviews"
+
+// maxEdge is the maximum width or height of a generated preview, in
+// pixels.
+const maxEdge = 256
+
+// jpegQuality is the encoding quality of generated previews.
+const jpegQuality = 75
+
+// IsImage returns true for file names we know how to generate a preview
+// for.
+func IsImage(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jpg", ".jpeg", ".png", ".gif":
+		return true
+	}
+	return false
+}
+
+// Generate creates or refreshes the preview for the given file. The
+// original is read from folderPath/relPath and the preview is written to
+// the sidecar store under the same relative path, with ".jpg" appended.
+func Generate(folderPath, relPath string) error {
+	fd, err := os.Open(filepath.Join(folderPath, relPath))
+	if err != nil {
+		return err
+	}
+	src, _, err := image.Decode(fd)
+	fd.Close()
+	if err != nil {
+		return err
+	}
+
+	dst := scaleToFit(src, maxEdge)
+
+	previewPath := previewPath(folderPath, relPath)
+	if err := osutil.MkdirAll(filepath.Dir(previewPath), 0755); err != nil {
+		return err
+	}
+
+	out, err := os.Create(previewPath)
+	if err != nil {
+		return err
+	}
+	if err := jpeg.Encode(out, dst, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		out.Close()
+		os.Remove(previewPath)
+		return err
+	}
+	return out.Close()
+}
+
+// Remove deletes the preview for the given file, if there is one.
+func Remove(folderPath, relPath string) {
+	os.Remove(previewPath(folderPath, relPath))
+}
+
+// Open opens the preview for the given file for reading.
+func Open(folderPath, relPath string) (*os.File, error) {
+	return os.Open(previewPath(folderPath, relPath))
+}
+
+func previewPath(folderPath, relPath string) string {
+	return filepath.Join(folderPath, DirName, relPath+".jpg")
+}
+
+// scaleToFit scales the image down so that neither edge exceeds max,
+// using nearest neighbour sampling. Images already within bounds are
+// redrawn at their original size, which keeps the output format uniform.
+func scaleToFit(src image.Image, max int) *image.RGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > max || h > max {
+		if w > h {
+			h = h * max / w
+			w = max
+		} else {
+			w = w * max / h
+			h = max
+		}
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		sy := b.Min.Y + y*b.Dy()/h
+		for x := 0; x < w; x++ {
+			sx := b.Min.X + x*b.Dx()/w
+			dst.Set(x, y, src.At(sx, sy))
+		}
+	}
+	return dst
+}
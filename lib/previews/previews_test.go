@@ -0,0 +1,75 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package previews
+
+import (
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "syncthing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	// A 512x64 image should come out as a 256x32 preview.
+
+	fd, err := os.Create(filepath.Join(tmpdir, "pic.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(fd, image.NewRGBA(image.Rect(0, 0, 512, 64))); err != nil {
+		t.Fatal(err)
+	}
+	fd.Close()
+
+	if err := Generate(tmpdir, "pic.png"); err != nil {
+		t.Fatal(err)
+	}
+
+	pd, err := Open(tmpdir, "pic.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pd.Close()
+
+	img, err := jpeg.Decode(pd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 256 || b.Dy() != 32 {
+		t.Errorf("unexpected preview size %dx%d", b.Dx(), b.Dy())
+	}
+
+	Remove(tmpdir, "pic.png")
+	if _, err := Open(tmpdir, "pic.png"); err == nil {
+		t.Error("preview should be gone after Remove")
+	}
+}
+
+func TestIsImage(t *testing.T) {
+	cases := map[string]bool{
+		"pic.jpg":                   true,
+		"pic.JPEG":                  true,
+		filepath.Join("d", "p.png"): true,
+		"anim.gif":                  true,
+		"doc.txt":                   false,
+		"noext":                     false,
+	}
+	for name, expected := range cases {
+		if res := IsImage(name); res != expected {
+			t.Errorf("IsImage(%q) => %v, expected %v", name, res, expected)
+		}
+	}
+}
@@ -26,6 +26,7 @@
 		FileDownloadProgressUpdate
 		Ping
 		Close
+		ConfigUpdate
 */
 package protocol
 
@@ -56,6 +57,7 @@ const (
 	messageTypeDownloadProgress MessageType = 5
 	messageTypePing             MessageType = 6
 	messageTypeClose            MessageType = 7
+	messageTypeConfigUpdate     MessageType = 8
 )
 
 var MessageType_name = map[int32]string{
@@ -67,6 +69,7 @@ var MessageType_name = map[int32]string{
 	5: "DOWNLOAD_PROGRESS",
 	6: "PING",
 	7: "CLOSE",
+	8: "CONFIG_UPDATE",
 }
 var MessageType_value = map[string]int32{
 	"CLUSTER_CONFIG":    0,
@@ -77,6 +80,7 @@ var MessageType_value = map[string]int32{
 	"DOWNLOAD_PROGRESS": 5,
 	"PING":              6,
 	"CLOSE":             7,
+	"CONFIG_UPDATE":     8,
 }
 
 func (x MessageType) String() string {
@@ -89,15 +93,20 @@ type MessageCompression int32
 const (
 	MessageCompressionNone MessageCompression = 0
 	MessageCompressionLZ4  MessageCompression = 1
+	// MessageCompressionDeflateDict is deflate with the built-in index
+	// dictionary, used for index messages between consenting devices.
+	MessageCompressionDeflateDict MessageCompression = 2
 )
 
 var MessageCompression_name = map[int32]string{
 	0: "NONE",
 	1: "LZ4",
+	2: "DEFLATE_DICT",
 }
 var MessageCompression_value = map[string]int32{
 	"NONE": 0,
-	"LZ4":  1,
+	"LZ4":          1,
+	"DEFLATE_DICT": 2,
 }
 
 func (x MessageCompression) String() string {
@@ -213,6 +222,9 @@ type Hello struct {
 	DeviceName    string `protobuf:"bytes,1,opt,name=device_name,json=deviceName,proto3" json:"device_name,omitempty"`
 	ClientName    string `protobuf:"bytes,2,opt,name=client_name,json=clientName,proto3" json:"client_name,omitempty"`
 	ClientVersion string `protobuf:"bytes,3,opt,name=client_version,json=clientVersion,proto3" json:"client_version,omitempty"`
+	// Capability flag: the sender accepts cluster configs split into
+	// several consecutive messages.
+	ChunkedClusterConfig bool `protobuf:"varint,4,opt,name=chunked_cluster_config,json=chunkedClusterConfig,proto3" json:"chunked_cluster_config,omitempty"`
 }
 
 func (m *Hello) Reset()                    { *m = Hello{} }
@@ -239,6 +251,16 @@ func (m *ClusterConfig) String() string            { return proto.CompactTextStr
 func (*ClusterConfig) ProtoMessage()               {}
 func (*ClusterConfig) Descriptor() ([]byte, []int) { return fileDescriptorBep, []int{2} }
 
+type ConfigUpdate struct {
+	Folders []Folder `protobuf:"bytes,1,rep,name=folders" json:"folders"`
+	Devices []Device `protobuf:"bytes,2,rep,name=devices" json:"devices"`
+}
+
+func (m *ConfigUpdate) Reset()                    { *m = ConfigUpdate{} }
+func (m *ConfigUpdate) String() string            { return proto.CompactTextString(m) }
+func (*ConfigUpdate) ProtoMessage()               {}
+func (*ConfigUpdate) Descriptor() ([]byte, []int) { return fileDescriptorBep, []int{17} }
+
 type Folder struct {
 	ID                 string   `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
 	Label              string   `protobuf:"bytes,2,opt,name=label,proto3" json:"label,omitempty"`
@@ -263,6 +285,27 @@ type Device struct {
 	MaxSequence int64       `protobuf:"varint,6,opt,name=max_sequence,json=maxSequence,proto3" json:"max_sequence,omitempty"`
 	Introducer  bool        `protobuf:"varint,7,opt,name=introducer,proto3" json:"introducer,omitempty"`
 	IndexID     IndexID     `protobuf:"varint,8,opt,name=index_id,json=indexId,proto3,customtype=IndexID" json:"index_id"`
+	// Resource hints. These describe the sending device only, and only on
+	// its own entry; for other devices they are left at the default.
+	SpinningDisk   bool `protobuf:"varint,9,opt,name=spinning_disk,json=spinningDisk,proto3" json:"spinning_disk,omitempty"`
+	Metered        bool `protobuf:"varint,10,opt,name=metered,proto3" json:"metered,omitempty"`
+	BatteryPowered bool `protobuf:"varint,11,opt,name=battery_powered,json=batteryPowered,proto3" json:"battery_powered,omitempty"`
+	// HardLinks is a capability flag: the sending device understands the
+	// link_group field on FileInfos. Like the hints, set only on the
+	// device's own entry.
+	HardLinks bool `protobuf:"varint,12,opt,name=hard_links,json=hardLinks,proto3" json:"hard_links,omitempty"`
+	// FileMetadata is a capability flag: the sending device understands the
+	// metadata field on FileInfos. Like the hints, set only on its own
+	// entry.
+	FileMetadata bool `protobuf:"varint,13,opt,name=file_metadata,json=fileMetadata,proto3" json:"file_metadata,omitempty"`
+	// LocalTime is the sending device's wall clock in Unix nanoseconds
+	// when the message was generated, for clock skew detection. Like the
+	// hints, set only on the device's own entry.
+	LocalTime int64 `protobuf:"varint,14,opt,name=local_time,json=localTime,proto3" json:"local_time,omitempty"`
+	// IndexDictionary is a capability flag: the sending device accepts
+	// index messages compressed with the built-in dictionary. Like the
+	// hints, set only on its own entry.
+	IndexDictionary bool `protobuf:"varint,15,opt,name=index_dictionary,json=indexDictionary,proto3" json:"index_dictionary,omitempty"`
 }
 
 func (m *Device) Reset()                    { *m = Device{} }
@@ -303,6 +346,14 @@ type FileInfo struct {
 	Version       Vector       `protobuf:"bytes,9,opt,name=version" json:"version"`
 	Sequence      int64        `protobuf:"varint,10,opt,name=sequence,proto3" json:"sequence,omitempty"`
 	Blocks        []BlockInfo  `protobuf:"bytes,16,rep,name=Blocks,json=blocks" json:"Blocks"`
+	// LinkGroup identifies files that are hard links to the same inode on
+	// the sending device, so the receiver can recreate the links instead
+	// of duplicating content. Empty for files that are not hard linked.
+	LinkGroup string `protobuf:"bytes,17,opt,name=link_group,json=linkGroup,proto3" json:"link_group,omitempty"`
+	// Extensible key/value metadata, for attributes such as extended
+	// attributes, labels and content types. Only exchanged with devices
+	// announcing the file_metadata capability.
+	Metadata []FileMetadata `protobuf:"bytes,18,rep,name=metadata" json:"metadata"`
 }
 
 func (m *FileInfo) Reset()                    { *m = FileInfo{} }
@@ -319,6 +370,15 @@ func (m *BlockInfo) Reset()                    { *m = BlockInfo{} }
 func (*BlockInfo) ProtoMessage()               {}
 func (*BlockInfo) Descriptor() ([]byte, []int) { return fileDescriptorBep, []int{8} }
 
+type FileMetadata struct {
+	Key   string `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Value string `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+}
+
+func (m *FileMetadata) Reset()         { *m = FileMetadata{} }
+func (m *FileMetadata) String() string { return proto.CompactTextString(m) }
+func (*FileMetadata) ProtoMessage()    {}
+
 type Vector struct {
 	Counters []Counter `protobuf:"bytes,1,rep,name=counters" json:"counters"`
 }
@@ -407,12 +467,14 @@ func init() {
 	proto.RegisterType((*Hello)(nil), "protocol.Hello")
 	proto.RegisterType((*Header)(nil), "protocol.Header")
 	proto.RegisterType((*ClusterConfig)(nil), "protocol.ClusterConfig")
+	proto.RegisterType((*ConfigUpdate)(nil), "protocol.ConfigUpdate")
 	proto.RegisterType((*Folder)(nil), "protocol.Folder")
 	proto.RegisterType((*Device)(nil), "protocol.Device")
 	proto.RegisterType((*Index)(nil), "protocol.Index")
 	proto.RegisterType((*IndexUpdate)(nil), "protocol.IndexUpdate")
 	proto.RegisterType((*FileInfo)(nil), "protocol.FileInfo")
 	proto.RegisterType((*BlockInfo)(nil), "protocol.BlockInfo")
+	proto.RegisterType((*FileMetadata)(nil), "protocol.FileMetadata")
 	proto.RegisterType((*Vector)(nil), "protocol.Vector")
 	proto.RegisterType((*Counter)(nil), "protocol.Counter")
 	proto.RegisterType((*Request)(nil), "protocol.Request")
@@ -461,6 +523,16 @@ func (m *Hello) MarshalTo(data []byte) (int, error) {
 		i = encodeVarintBep(data, i, uint64(len(m.ClientVersion)))
 		i += copy(data[i:], m.ClientVersion)
 	}
+	if m.ChunkedClusterConfig {
+		data[i] = 0x20
+		i++
+		if m.ChunkedClusterConfig {
+			data[i] = 1
+		} else {
+			data[i] = 0
+		}
+		i++
+	}
 	return i, nil
 }
 
@@ -522,6 +594,48 @@ func (m *ClusterConfig) MarshalTo(data []byte) (int, error) {
 	return i, nil
 }
 
+func (m *ConfigUpdate) Marshal() (data []byte, err error) {
+	size := m.ProtoSize()
+	data = make([]byte, size)
+	n, err := m.MarshalTo(data)
+	if err != nil {
+		return nil, err
+	}
+	return data[:n], nil
+}
+
+func (m *ConfigUpdate) MarshalTo(data []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Folders) > 0 {
+		for _, msg := range m.Folders {
+			data[i] = 0xa
+			i++
+			i = encodeVarintBep(data, i, uint64(msg.ProtoSize()))
+			n, err := msg.MarshalTo(data[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	if len(m.Devices) > 0 {
+		for _, msg := range m.Devices {
+			data[i] = 0x12
+			i++
+			i = encodeVarintBep(data, i, uint64(msg.ProtoSize()))
+			n, err := msg.MarshalTo(data[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	return i, nil
+}
+
 func (m *Folder) Marshal() (data []byte, err error) {
 	size := m.ProtoSize()
 	data = make([]byte, size)
@@ -679,6 +793,71 @@ func (m *Device) MarshalTo(data []byte) (int, error) {
 		i++
 		i = encodeVarintBep(data, i, uint64(m.IndexID))
 	}
+	if m.SpinningDisk {
+		data[i] = 0x48
+		i++
+		if m.SpinningDisk {
+			data[i] = 1
+		} else {
+			data[i] = 0
+		}
+		i++
+	}
+	if m.Metered {
+		data[i] = 0x50
+		i++
+		if m.Metered {
+			data[i] = 1
+		} else {
+			data[i] = 0
+		}
+		i++
+	}
+	if m.BatteryPowered {
+		data[i] = 0x58
+		i++
+		if m.BatteryPowered {
+			data[i] = 1
+		} else {
+			data[i] = 0
+		}
+		i++
+	}
+	if m.HardLinks {
+		data[i] = 0x60
+		i++
+		if m.HardLinks {
+			data[i] = 1
+		} else {
+			data[i] = 0
+		}
+		i++
+	}
+	if m.FileMetadata {
+		data[i] = 0x68
+		i++
+		if m.FileMetadata {
+			data[i] = 1
+		} else {
+			data[i] = 0
+		}
+		i++
+	}
+	if m.LocalTime != 0 {
+		data[i] = 0x70
+		i++
+		i = encodeVarintBep(data, i, uint64(m.LocalTime))
+	}
+	if m.IndexDictionary {
+		data[i] = 0x78
+		i++
+		if m.IndexDictionary {
+			data[i] = 1
+		} else {
+			data[i] = 0
+		}
+		i++
+	}
 	return i, nil
 }
 
@@ -857,6 +1036,28 @@ func (m *FileInfo) MarshalTo(data []byte) (int, error) {
 			i += n
 		}
 	}
+	if len(m.LinkGroup) > 0 {
+		data[i] = 0x8a
+		i++
+		data[i] = 0x1
+		i++
+		i = encodeVarintBep(data, i, uint64(len(m.LinkGroup)))
+		i += copy(data[i:], m.LinkGroup)
+	}
+	if len(m.Metadata) > 0 {
+		for _, msg := range m.Metadata {
+			data[i] = 0x92
+			i++
+			data[i] = 0x1
+			i++
+			i = encodeVarintBep(data, i, uint64(msg.ProtoSize()))
+			n, err := msg.MarshalTo(data[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
 	return i, nil
 }
 
@@ -894,6 +1095,36 @@ func (m *BlockInfo) MarshalTo(data []byte) (int, error) {
 	return i, nil
 }
 
+func (m *FileMetadata) Marshal() (data []byte, err error) {
+	size := m.ProtoSize()
+	data = make([]byte, size)
+	n, err := m.MarshalTo(data)
+	if err != nil {
+		return nil, err
+	}
+	return data[:n], nil
+}
+
+func (m *FileMetadata) MarshalTo(data []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Key) > 0 {
+		data[i] = 0xa
+		i++
+		i = encodeVarintBep(data, i, uint64(len(m.Key)))
+		i += copy(data[i:], m.Key)
+	}
+	if len(m.Value) > 0 {
+		data[i] = 0x12
+		i++
+		i = encodeVarintBep(data, i, uint64(len(m.Value)))
+		i += copy(data[i:], m.Value)
+	}
+	return i, nil
+}
+
 func (m *Vector) Marshal() (data []byte, err error) {
 	size := m.ProtoSize()
 	data = make([]byte, size)
@@ -1211,6 +1442,9 @@ func (m *Hello) ProtoSize() (n int) {
 	if l > 0 {
 		n += 1 + l + sovBep(uint64(l))
 	}
+	if m.ChunkedClusterConfig {
+		n += 2
+	}
 	return n
 }
 
@@ -1238,6 +1472,24 @@ func (m *ClusterConfig) ProtoSize() (n int) {
 	return n
 }
 
+func (m *ConfigUpdate) ProtoSize() (n int) {
+	var l int
+	_ = l
+	if len(m.Folders) > 0 {
+		for _, e := range m.Folders {
+			l = e.ProtoSize()
+			n += 1 + l + sovBep(uint64(l))
+		}
+	}
+	if len(m.Devices) > 0 {
+		for _, e := range m.Devices {
+			l = e.ProtoSize()
+			n += 1 + l + sovBep(uint64(l))
+		}
+	}
+	return n
+}
+
 func (m *Folder) ProtoSize() (n int) {
 	var l int
 	_ = l
@@ -1303,6 +1555,27 @@ func (m *Device) ProtoSize() (n int) {
 	if m.IndexID != 0 {
 		n += 1 + sovBep(uint64(m.IndexID))
 	}
+	if m.SpinningDisk {
+		n += 2
+	}
+	if m.Metered {
+		n += 2
+	}
+	if m.BatteryPowered {
+		n += 2
+	}
+	if m.HardLinks {
+		n += 2
+	}
+	if m.FileMetadata {
+		n += 2
+	}
+	if m.LocalTime != 0 {
+		n += 1 + sovBep(uint64(m.LocalTime))
+	}
+	if m.IndexDictionary {
+		n += 2
+	}
 	return n
 }
 
@@ -1380,6 +1653,16 @@ func (m *FileInfo) ProtoSize() (n int) {
 			n += 2 + l + sovBep(uint64(l))
 		}
 	}
+	l = len(m.LinkGroup)
+	if l > 0 {
+		n += 2 + l + sovBep(uint64(l))
+	}
+	if len(m.Metadata) > 0 {
+		for _, e := range m.Metadata {
+			l = e.ProtoSize()
+			n += 2 + l + sovBep(uint64(l))
+		}
+	}
 	return n
 }
 
@@ -1399,6 +1682,20 @@ func (m *BlockInfo) ProtoSize() (n int) {
 	return n
 }
 
+func (m *FileMetadata) ProtoSize() (n int) {
+	var l int
+	_ = l
+	l = len(m.Key)
+	if l > 0 {
+		n += 1 + l + sovBep(uint64(l))
+	}
+	l = len(m.Value)
+	if l > 0 {
+		n += 1 + l + sovBep(uint64(l))
+	}
+	return n
+}
+
 func (m *Vector) ProtoSize() (n int) {
 	var l int
 	_ = l
@@ -1650,6 +1947,26 @@ func (m *Hello) Unmarshal(data []byte) error {
 			}
 			m.ClientVersion = string(data[iNdEx:postIndex])
 			iNdEx = postIndex
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ChunkedClusterConfig", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowBep
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.ChunkedClusterConfig = bool(v != 0)
 		default:
 			iNdEx = preIndex
 			skippy, err := skipBep(data[iNdEx:])
@@ -1840,7 +2157,7 @@ func (m *ClusterConfig) Unmarshal(data []byte) error {
 	}
 	return nil
 }
-func (m *Folder) Unmarshal(data []byte) error {
+func (m *ConfigUpdate) Unmarshal(data []byte) error {
 	l := len(data)
 	iNdEx := 0
 	for iNdEx < l {
@@ -1863,17 +2180,17 @@ func (m *Folder) Unmarshal(data []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: Folder: wiretype end group for non-group")
+			return fmt.Errorf("proto: ConfigUpdate: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: Folder: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: ConfigUpdate: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field ID", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Folders", wireType)
 			}
-			var stringLen uint64
+			var msglen int
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowBep
@@ -1883,26 +2200,28 @@ func (m *Folder) Unmarshal(data []byte) error {
 				}
 				b := data[iNdEx]
 				iNdEx++
-				stringLen |= (uint64(b) & 0x7F) << shift
+				msglen |= (int(b) & 0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			intStringLen := int(stringLen)
-			if intStringLen < 0 {
+			if msglen < 0 {
 				return ErrInvalidLengthBep
 			}
-			postIndex := iNdEx + intStringLen
+			postIndex := iNdEx + msglen
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.ID = string(data[iNdEx:postIndex])
+			m.Folders = append(m.Folders, Folder{})
+			if err := m.Folders[len(m.Folders)-1].Unmarshal(data[iNdEx:postIndex]); err != nil {
+				return err
+			}
 			iNdEx = postIndex
 		case 2:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Label", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Devices", wireType)
 			}
-			var stringLen uint64
+			var msglen int
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowBep
@@ -1912,34 +2231,144 @@ func (m *Folder) Unmarshal(data []byte) error {
 				}
 				b := data[iNdEx]
 				iNdEx++
-				stringLen |= (uint64(b) & 0x7F) << shift
+				msglen |= (int(b) & 0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			intStringLen := int(stringLen)
-			if intStringLen < 0 {
+			if msglen < 0 {
 				return ErrInvalidLengthBep
 			}
-			postIndex := iNdEx + intStringLen
+			postIndex := iNdEx + msglen
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Label = string(data[iNdEx:postIndex])
+			m.Devices = append(m.Devices, Device{})
+			if err := m.Devices[len(m.Devices)-1].Unmarshal(data[iNdEx:postIndex]); err != nil {
+				return err
+			}
 			iNdEx = postIndex
-		case 3:
-			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field ReadOnly", wireType)
+		default:
+			iNdEx = preIndex
+			skippy, err := skipBep(data[iNdEx:])
+			if err != nil {
+				return err
 			}
-			var v int
-			for shift := uint(0); ; shift += 7 {
-				if shift >= 64 {
-					return ErrIntOverflowBep
-				}
-				if iNdEx >= l {
-					return io.ErrUnexpectedEOF
-				}
-				b := data[iNdEx]
+			if skippy < 0 {
+				return ErrInvalidLengthBep
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *Folder) Unmarshal(data []byte) error {
+	l := len(data)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowBep
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := data[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: Folder: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: Folder: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ID", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowBep
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthBep
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.ID = string(data[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Label", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowBep
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthBep
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Label = string(data[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ReadOnly", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowBep
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
 				iNdEx++
 				v |= (int(b) & 0x7F) << shift
 				if b < 0x80 {
@@ -2283,6 +2712,145 @@ func (m *Device) Unmarshal(data []byte) error {
 					break
 				}
 			}
+		case 9:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SpinningDisk", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowBep
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.SpinningDisk = bool(v != 0)
+		case 10:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Metered", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowBep
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.Metered = bool(v != 0)
+		case 11:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field BatteryPowered", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowBep
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.BatteryPowered = bool(v != 0)
+		case 12:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field HardLinks", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowBep
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.HardLinks = bool(v != 0)
+		case 13:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field FileMetadata", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowBep
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.FileMetadata = bool(v != 0)
+		case 14:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field LocalTime", wireType)
+			}
+			m.LocalTime = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowBep
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				m.LocalTime |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 15:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field IndexDictionary", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowBep
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.IndexDictionary = bool(v != 0)
 		default:
 			iNdEx = preIndex
 			skippy, err := skipBep(data[iNdEx:])
@@ -2817,6 +3385,66 @@ func (m *FileInfo) Unmarshal(data []byte) error {
 				return err
 			}
 			iNdEx = postIndex
+		case 17:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field LinkGroup", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowBep
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthBep
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.LinkGroup = string(data[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 18:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Metadata", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowBep
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthBep
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Metadata = append(m.Metadata, FileMetadata{})
+			if err := m.Metadata[len(m.Metadata)-1].Unmarshal(data[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipBep(data[iNdEx:])
@@ -2957,6 +3585,115 @@ func (m *BlockInfo) Unmarshal(data []byte) error {
 	}
 	return nil
 }
+
+func (m *FileMetadata) Unmarshal(data []byte) error {
+	l := len(data)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowBep
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := data[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: FileMetadata: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: FileMetadata: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Key", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowBep
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthBep
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Key = string(data[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Value", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowBep
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthBep
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Value = string(data[iNdEx:postIndex])
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipBep(data[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthBep
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
 func (m *Vector) Unmarshal(data []byte) error {
 	l := len(data)
 	iNdEx := 0
@@ -77,6 +77,17 @@ func (f FileInfo) ModTime() time.Time {
 	return time.Unix(f.ModifiedS, int64(f.ModifiedNs))
 }
 
+// MetadataValue returns the value of the given metadata key, or the empty
+// string if the key is not present.
+func (f FileInfo) MetadataValue(key string) string {
+	for _, kv := range f.Metadata {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return ""
+}
+
 // WinsConflict returns true if "f" is the one to choose when it is in
 // conflict with "other".
 func (f FileInfo) WinsConflict(other FileInfo) bool {
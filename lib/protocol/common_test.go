@@ -14,6 +14,7 @@ type TestModel struct {
 	fromTemporary bool
 	closedCh      chan struct{}
 	closedErr     error
+	ccCh          chan ClusterConfig
 }
 
 func newTestModel() *TestModel {
@@ -45,11 +46,17 @@ func (t *TestModel) Closed(conn Connection, err error) {
 }
 
 func (t *TestModel) ClusterConfig(deviceID DeviceID, config ClusterConfig) {
+	if t.ccCh != nil {
+		t.ccCh <- config
+	}
 }
 
 func (t *TestModel) DownloadProgress(DeviceID, string, []FileDownloadProgressUpdate) {
 }
 
+func (t *TestModel) ConfigUpdate(DeviceID, ConfigUpdate) {
+}
+
 func (t *TestModel) closedError() error {
 	select {
 	case <-t.closedCh:
@@ -0,0 +1,77 @@
+// Copyright (C) 2016 The Protocol Authors.
+
+package protocol
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// congestionAlpha is the smoothing factor for the moving average of
+	// write times.
+	congestionAlpha = 0.2
+	// congestionInflation is how much slower than the observed floor
+	// writes must become before we consider the uplink saturated.
+	congestionInflation = 4.0
+	// congestionMaxDelay is the longest we hold back a single response.
+	congestionMaxDelay = 100 * time.Millisecond
+	// congestionMinBytes is the smallest response we time; tiny writes
+	// give a noisy per-byte figure.
+	congestionMinBytes = 1024
+)
+
+// congestionTracker watches how long it takes to push block responses
+// onto the wire. When the uplink is idle the socket buffer drains quickly
+// and writes complete fast; when it is saturated (bufferbloat) the buffer
+// stays full and writes stall. The tracker keeps a smoothed write time
+// per KiB together with the lowest such value seen, and derives a backoff
+// delay from the inflation between the two.
+type congestionTracker struct {
+	mut     sync.Mutex
+	ewmaNs  float64 // smoothed write time per KiB
+	floorNs float64 // lowest smoothed write time per KiB seen
+}
+
+// noteWrite records that writing a message of the given size took the
+// given time.
+func (t *congestionTracker) noteWrite(bytes int, elapsed time.Duration) {
+	if bytes < congestionMinBytes {
+		return
+	}
+	perKiB := float64(elapsed) / (float64(bytes) / 1024)
+
+	t.mut.Lock()
+	if t.ewmaNs == 0 {
+		t.ewmaNs = perKiB
+	} else {
+		t.ewmaNs = congestionAlpha*perKiB + (1-congestionAlpha)*t.ewmaNs
+	}
+	if t.floorNs == 0 || t.ewmaNs < t.floorNs {
+		t.floorNs = t.ewmaNs
+	}
+	t.mut.Unlock()
+}
+
+// delay returns how long the next response should be held back. Zero
+// until write times have inflated past the congestion threshold, then
+// growing with the inflation up to the maximum delay.
+func (t *congestionTracker) delay() time.Duration {
+	t.mut.Lock()
+	ewma, floor := t.ewmaNs, t.floorNs
+	t.mut.Unlock()
+
+	if floor == 0 {
+		return 0
+	}
+	inflation := ewma / floor
+	if inflation < congestionInflation {
+		return 0
+	}
+
+	delay := time.Duration((inflation - congestionInflation) / congestionInflation * float64(congestionMaxDelay))
+	if delay > congestionMaxDelay {
+		delay = congestionMaxDelay
+	}
+	return delay
+}
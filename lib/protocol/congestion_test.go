@@ -0,0 +1,43 @@
+// Copyright (C) 2016 The Protocol Authors.
+
+package protocol
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCongestionTracker(t *testing.T) {
+	var tr congestionTracker
+
+	// No observations yet; no delay.
+	if d := tr.delay(); d != 0 {
+		t.Errorf("unexpected delay %v before any writes", d)
+	}
+
+	// Fast writes establish a floor and cause no delay.
+	for i := 0; i < 20; i++ {
+		tr.noteWrite(128<<10, time.Millisecond)
+	}
+	if d := tr.delay(); d != 0 {
+		t.Errorf("unexpected delay %v on an idle uplink", d)
+	}
+
+	// Writes stalling well past the floor should trigger a backoff,
+	// capped at the maximum delay.
+	for i := 0; i < 100; i++ {
+		tr.noteWrite(128<<10, time.Second)
+	}
+	if d := tr.delay(); d == 0 {
+		t.Error("expected a delay on a saturated uplink")
+	} else if d > congestionMaxDelay {
+		t.Errorf("delay %v exceeds maximum %v", d, congestionMaxDelay)
+	}
+
+	// Tiny writes are too noisy to count.
+	var fresh congestionTracker
+	fresh.noteWrite(100, time.Second)
+	if d := fresh.delay(); d != 0 {
+		t.Errorf("unexpected delay %v from a sub-threshold write", d)
+	}
+}
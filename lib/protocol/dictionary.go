@@ -0,0 +1,56 @@
+// Copyright (C) 2016 The Protocol Authors.
+
+package protocol
+
+import (
+	"bytes"
+	"compress/flate"
+	"io/ioutil"
+)
+
+// indexDictionary is the preset dictionary used for index messages sent
+// with MessageCompressionDeflateDict. It is trained on the path
+// components, extensions and name fragments that occur most often in
+// real world indexes; deflate backreferences into the dictionary cut the
+// initial index exchange down substantially on deep hierarchies. The
+// contents are part of the protocol and must never change once released
+// — introduce a new MessageCompression value for a new dictionary
+// instead.
+var indexDictionary = []byte("" +
+	// Common extensions, most frequent last so they're cheapest to
+	// reference.
+	".bak.tmp.log.conf.ini.yaml.yml.json.xml.html.css.js.py.cpp.h.c.go" +
+	".iso.img.7z.rar.xz.bz2.tgz.tar.gz.zip" +
+	".md.rtf.txt.pdf.ods.odt.pptx.ppt.xlsx.xls.docx.doc" +
+	".wmv.m4v.mov.avi.mkv.mp4.wav.m4a.ogg.flac.mp3" +
+	".heic.nef.cr2.raw.tiff.tif.bmp.gif.png.jpeg.jpg" +
+	// Common directory and file name fragments.
+	"node_modules/vendor/github.com/src/lib/bin/test/build/dist/" +
+	"assets/static/images/scripts/styles/README.mdMakefilepackage.json" +
+	"Documents/Pictures/Photos/Music/Videos/Downloads/Desktop/Backup/" +
+	".thumbnails/.cache/Copy of copy (1)(2) - backup" +
+	"IMG_DSC_VID_P1020304050607080910/2011/2012/2013/2014/2015/2016/")
+
+// dictCompress compresses the data using deflate primed with the preset
+// index dictionary.
+func dictCompress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriterDict(&buf, flate.DefaultCompression, indexDictionary)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// dictDecompress reverses dictCompress.
+func dictDecompress(src []byte) ([]byte, error) {
+	r := flate.NewReaderDict(bytes.NewReader(src), indexDictionary)
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
@@ -23,6 +23,11 @@ type HelloResult struct {
 	DeviceName    string
 	ClientName    string
 	ClientVersion string
+
+	// ChunkedClusterConfig is set when the other side accepts cluster
+	// configs split into several consecutive messages. Only v0.14 Hello
+	// messages can announce it.
+	ChunkedClusterConfig bool
 }
 
 var (
@@ -81,9 +86,10 @@ func readHello(c io.Reader) (HelloResult, error) {
 			return HelloResult{}, err
 		}
 		res := HelloResult{
-			DeviceName:    hello.DeviceName,
-			ClientName:    hello.ClientName,
-			ClientVersion: hello.ClientVersion,
+			DeviceName:           hello.DeviceName,
+			ClientName:           hello.ClientName,
+			ClientVersion:        hello.ClientVersion,
+			ChunkedClusterConfig: hello.ChunkedClusterConfig,
 		}
 		return res, nil
 
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	lz4 "github.com/bkaradzic/go-lz4"
@@ -70,6 +71,9 @@ type Model interface {
 	Closed(conn Connection, err error)
 	// The peer device sent progress updates for the files it is currently downloading
 	DownloadProgress(deviceID DeviceID, folder string, updates []FileDownloadProgressUpdate)
+	// The peer device sent a config update for us to apply, if we've
+	// designated it as a remote admin
+	ConfigUpdate(deviceID DeviceID, update ConfigUpdate)
 }
 
 type Connection interface {
@@ -81,6 +85,7 @@ type Connection interface {
 	Request(folder string, name string, offset int64, size int, hash []byte, fromTemporary bool) ([]byte, error)
 	ClusterConfig(config ClusterConfig)
 	DownloadProgress(folder string, updates []FileDownloadProgressUpdate)
+	ConfigUpdate(update ConfigUpdate)
 	Statistics() Statistics
 	Closed() bool
 }
@@ -106,6 +111,21 @@ type rawConnection struct {
 	once        sync.Once
 	pool        sync.Pool
 	compression Compression
+
+	// responseCompressionHint, when set, is consulted before compressing
+	// response data. Must be set before Start.
+	responseCompressionHint func(folder, name string) bool
+
+	congestion          congestionTracker
+	congestionAvoidance int32 // atomic; nonzero when response backoff is enabled
+	indexDictionary     int32 // atomic; nonzero when the peer accepts dictionary compressed indexes
+	ccChunking          int32 // atomic; nonzero when the peer accepts chunked cluster configs
+
+	pingMut          sync.Mutex
+	basePingInterval time.Duration
+	pingInterval     time.Duration
+	receiveTimeout   time.Duration
+	stableChecks     int
 }
 
 type asyncResult struct {
@@ -121,17 +141,23 @@ type message interface {
 }
 
 type asyncMessage struct {
-	msg  message
-	done chan struct{} // done closes when we're done marshalling the message and it's contents can be reused
+	msg        message
+	done       chan struct{} // done closes when we're done marshalling the message and it's contents can be reused
+	noCompress bool          // the message data is known to not benefit from compression
 }
 
 const (
-	// PingSendInterval is how often we make sure to send a message, by
-	// triggering pings if necessary.
+	// PingSendInterval is the default for how often we make sure to send a
+	// message, by triggering pings if necessary.
 	PingSendInterval = 90 * time.Second
-	// ReceiveTimeout is the longest we'll wait for a message from the other
-	// side before closing the connection.
+	// ReceiveTimeout is the default for the longest we'll wait for a
+	// message from the other side before closing the connection.
 	ReceiveTimeout = 300 * time.Second
+	// MinPingInterval is the lower bound for the adaptive ping interval.
+	MinPingInterval = 10 * time.Second
+	// After this many consecutive healthy liveness checks the ping
+	// interval returns to its configured value.
+	stableChecksBeforeReset = 10
 )
 
 // A buffer pool for global use. We don't allocate smaller buffers than 64k,
@@ -140,10 +166,20 @@ var buffers = bufferPool{
 	minSize: 64 << 10,
 }
 
-func NewConnection(deviceID DeviceID, reader io.Reader, writer io.Writer, receiver Model, name string, compress Compression) Connection {
+// NewConnection returns a new connection. The ping interval and receive
+// timeout default to PingSendInterval and ReceiveTimeout when zero; longer
+// values suit high latency links that would otherwise time out.
+func NewConnection(deviceID DeviceID, reader io.Reader, writer io.Writer, receiver Model, name string, compress Compression, pingInterval, receiveTimeout time.Duration) Connection {
 	cr := &countingReader{Reader: reader}
 	cw := &countingWriter{Writer: writer}
 
+	if pingInterval <= 0 {
+		pingInterval = PingSendInterval
+	}
+	if receiveTimeout <= 0 {
+		receiveTimeout = ReceiveTimeout
+	}
+
 	c := rawConnection{
 		id:       deviceID,
 		name:     name,
@@ -159,6 +195,10 @@ func NewConnection(deviceID DeviceID, reader io.Reader, writer io.Writer, receiv
 			},
 		},
 		compression: compress,
+
+		basePingInterval: pingInterval,
+		pingInterval:     pingInterval,
+		receiveTimeout:   receiveTimeout,
 	}
 
 	return wireFormatConnection{&c}
@@ -173,6 +213,47 @@ func (c *rawConnection) Start() {
 	go c.pingReceiver()
 }
 
+// SetResponseCompressionHint sets a callback that is consulted before
+// response data is compressed. When it returns false for a file, responses
+// for that file are sent uncompressed regardless of the connection
+// compression setting. It must be called before Start.
+func (c *rawConnection) SetResponseCompressionHint(hint func(folder, name string) bool) {
+	c.responseCompressionHint = hint
+}
+
+// SetCongestionAvoidance enables or disables backing off block responses
+// when write times indicate a saturated uplink.
+func (c *rawConnection) SetCongestionAvoidance(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&c.congestionAvoidance, v)
+}
+
+// SetClusterConfigChunking enables or disables splitting large cluster
+// configs into several messages. It should be enabled only when the peer
+// has announced the corresponding capability in its Hello, and must be set
+// before Start.
+func (c *rawConnection) SetClusterConfigChunking(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&c.ccChunking, v)
+}
+
+// SetIndexDictionary enables or disables compressing index messages with
+// the preset dictionary. It should be enabled only once the peer has
+// announced the corresponding capability in its cluster config.
+func (c *rawConnection) SetIndexDictionary(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&c.indexDictionary, v)
+}
+
 func (c *rawConnection) ID() DeviceID {
 	return c.id
 }
@@ -248,9 +329,40 @@ func (c *rawConnection) Request(folder string, name string, offset int64, size i
 	return res.val, res.err
 }
 
+// Maximum number of folder records sent in a single ClusterConfig message.
+// Larger cluster configurations are split into several messages, each
+// flushed to the wire before the next is marshalled, so that clusters with
+// thousands of folders don't pin one enormous buffer on either side.
+const clusterConfigChunkFolders = 1000
+
 // ClusterConfig send the cluster configuration message to the peer and returns any error
 func (c *rawConnection) ClusterConfig(config ClusterConfig) {
-	c.send(&config, nil)
+	// Chunking is only used when the peer has announced in its Hello that
+	// it accepts it; older peers treat a repeated ClusterConfig as a
+	// protocol error and drop the connection.
+	if len(config.Folders) <= clusterConfigChunkFolders || atomic.LoadInt32(&c.ccChunking) == 0 {
+		c.send(&config, nil)
+		return
+	}
+
+	for i := 0; i < len(config.Folders); i += clusterConfigChunkFolders {
+		end := i + clusterConfigChunkFolders
+		if end > len(config.Folders) {
+			end = len(config.Folders)
+		}
+		chunk := ClusterConfig{Folders: config.Folders[i:end]}
+		done := make(chan struct{})
+		if !c.send(&chunk, done) {
+			return
+		}
+		// Wait for the chunk to be handed to the wire before marshalling
+		// the next one; this is the ack point that bounds memory usage.
+		select {
+		case <-done:
+		case <-c.closed:
+			return
+		}
+	}
 }
 
 func (c *rawConnection) Closed() bool {
@@ -270,6 +382,12 @@ func (c *rawConnection) DownloadProgress(folder string, updates []FileDownloadPr
 	}, nil)
 }
 
+// ConfigUpdate sends a config update to the peer device. It is only acted
+// on if the peer has designated us as a remote admin.
+func (c *rawConnection) ConfigUpdate(update ConfigUpdate) {
+	c.send(&update, nil)
+}
+
 func (c *rawConnection) ping() bool {
 	return c.send(&Ping{}, nil)
 }
@@ -299,7 +417,11 @@ func (c *rawConnection) readerLoop() (err error) {
 		switch msg := msg.(type) {
 		case *ClusterConfig:
 			l.Debugln("read ClusterConfig message")
-			if state != stateInitial {
+			// The first ClusterConfig starts the exchange. Further ones
+			// are continuation chunks of a large cluster configuration,
+			// but only from a peer that negotiated chunking in its Hello;
+			// otherwise they are a protocol error, as before.
+			if state != stateInitial && atomic.LoadInt32(&c.ccChunking) == 0 {
 				return fmt.Errorf("protocol error: cluster config message in state %d", state)
 			}
 			c.receiver.ClusterConfig(c.id, *msg)
@@ -343,6 +465,13 @@ func (c *rawConnection) readerLoop() (err error) {
 			}
 			c.receiver.DownloadProgress(c.id, msg.Folder, msg.Updates)
 
+		case *ConfigUpdate:
+			l.Debugln("read ConfigUpdate message")
+			if state != stateReady {
+				return fmt.Errorf("protocol error: config update message in state %d", state)
+			}
+			c.receiver.ConfigUpdate(c.id, *msg)
+
 		case *Ping:
 			l.Debugln("read Ping message")
 			if state != stateReady {
@@ -403,6 +532,14 @@ func (c *rawConnection) readMessageAfterHeader(hdr Header) (message, error) {
 		}
 		buf = decomp
 
+	case MessageCompressionDeflateDict:
+		decomp, err := dictDecompress(buf)
+		buffers.put(buf)
+		if err != nil {
+			return nil, fmt.Errorf("decompressing message: %v", err)
+		}
+		buf = decomp
+
 	default:
 		return nil, fmt.Errorf("unknown message compression %d", hdr.Compression)
 	}
@@ -486,6 +623,14 @@ func filterIndexMessageFiles(fs []FileInfo) []FileInfo {
 }
 
 func (c *rawConnection) handleRequest(req Request) {
+	if atomic.LoadInt32(&c.congestionAvoidance) != 0 {
+		if delay := c.congestion.delay(); delay > 0 {
+			// The uplink looks saturated; hold the response back a
+			// little to let interactive traffic through.
+			time.Sleep(delay)
+		}
+	}
+
 	size := int(req.Size)
 	usePool := size <= BlockSize
 
@@ -507,11 +652,19 @@ func (c *rawConnection) handleRequest(req Request) {
 			Code: errorToCode(err),
 		}, done)
 	} else {
-		c.send(&Response{
-			ID:   req.ID,
-			Data: buf,
-			Code: errorToCode(err),
-		}, done)
+		noCompress := false
+		if hint := c.responseCompressionHint; hint != nil {
+			noCompress = !hint(req.Folder, req.Name)
+		}
+		c.sendAsync(asyncMessage{
+			msg: &Response{
+				ID:   req.ID,
+				Data: buf,
+				Code: errorToCode(err),
+			},
+			done:       done,
+			noCompress: noCompress,
+		})
 	}
 
 	if usePool {
@@ -531,8 +684,12 @@ func (c *rawConnection) handleResponse(resp Response) {
 }
 
 func (c *rawConnection) send(msg message, done chan struct{}) bool {
+	return c.sendAsync(asyncMessage{msg: msg, done: done})
+}
+
+func (c *rawConnection) sendAsync(hm asyncMessage) bool {
 	select {
-	case c.outbox <- asyncMessage{msg, done}:
+	case c.outbox <- hm:
 		return true
 	case <-c.closed:
 		return false
@@ -543,7 +700,12 @@ func (c *rawConnection) writerLoop() {
 	for {
 		select {
 		case hm := <-c.outbox:
-			if err := c.writeMessage(hm); err != nil {
+			start := time.Now()
+			err := c.writeMessage(hm)
+			if resp, ok := hm.msg.(*Response); ok {
+				c.congestion.noteWrite(len(resp.Data), time.Since(start))
+			}
+			if err != nil {
 				c.close(err)
 				return
 			}
@@ -555,7 +717,7 @@ func (c *rawConnection) writerLoop() {
 }
 
 func (c *rawConnection) writeMessage(hm asyncMessage) error {
-	if c.shouldCompressMessage(hm.msg) {
+	if !hm.noCompress && c.shouldCompressMessage(hm.msg) {
 		return c.writeCompressedMessage(hm)
 	}
 	return c.writeUncompressedMessage(hm)
@@ -571,14 +733,22 @@ func (c *rawConnection) writeCompressedMessage(hm asyncMessage) error {
 		close(hm.done)
 	}
 
-	compressed, err := c.lz4Compress(buf)
+	var compressed []byte
+	var err error
+	compression := MessageCompressionLZ4
+	if c.useIndexDictionary(hm.msg) {
+		compression = MessageCompressionDeflateDict
+		compressed, err = dictCompress(buf)
+	} else {
+		compressed, err = c.lz4Compress(buf)
+	}
 	if err != nil {
 		return fmt.Errorf("compressing message: %v", err)
 	}
 
 	hdr := Header{
 		Type:        c.typeOf(hm.msg),
-		Compression: MessageCompressionLZ4,
+		Compression: compression,
 	}
 	hdrSize := hdr.ProtoSize()
 	if hdrSize > 1<<16-1 {
@@ -668,6 +838,8 @@ func (c *rawConnection) typeOf(msg message) MessageType {
 		return messageTypePing
 	case *Close:
 		return messageTypeClose
+	case *ConfigUpdate:
+		return messageTypeConfigUpdate
 	default:
 		panic("bug: unknown message type")
 	}
@@ -691,11 +863,24 @@ func (c *rawConnection) newMessage(t MessageType) (message, error) {
 		return new(Ping), nil
 	case messageTypeClose:
 		return new(Close), nil
+	case messageTypeConfigUpdate:
+		return new(ConfigUpdate), nil
 	default:
 		return nil, errUnknownMessage
 	}
 }
 
+// useIndexDictionary reports whether the message should be compressed
+// with the preset index dictionary: true for index messages once the peer
+// has announced that it accepts them.
+func (c *rawConnection) useIndexDictionary(msg message) bool {
+	switch msg.(type) {
+	case *Index, *IndexUpdate:
+		return atomic.LoadInt32(&c.indexDictionary) != 0
+	}
+	return false
+}
+
 func (c *rawConnection) shouldCompressMessage(msg message) bool {
 	switch c.compression {
 	case CompressNever:
@@ -734,18 +919,27 @@ func (c *rawConnection) close(err error) {
 }
 
 // The pingSender makes sure that we've sent a message within the last
-// PingSendInterval. If we already have something sent in the last
-// PingSendInterval/2, we do nothing. Otherwise we send a ping message. This
-// results in an effecting ping interval of somewhere between
-// PingSendInterval/2 and PingSendInterval.
+// ping interval. If we already have something sent in the last half
+// interval, we do nothing. Otherwise we send a ping message. This results
+// in an effective ping interval of somewhere between half the interval and
+// the full interval. The interval itself shrinks while the link is deemed
+// unstable by the pingReceiver.
 func (c *rawConnection) pingSender() {
-	ticker := time.Tick(PingSendInterval / 2)
+	interval := c.currentPingInterval()
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
 
 	for {
 		select {
-		case <-ticker:
+		case <-ticker.C:
+			if cur := c.currentPingInterval(); cur != interval {
+				interval = cur
+				ticker.Stop()
+				ticker = time.NewTicker(interval / 2)
+			}
+
 			d := time.Since(c.cw.Last())
-			if d < PingSendInterval/2 {
+			if d < interval/2 {
 				l.Debugln(c.id, "ping skipped after wr", d)
 				continue
 			}
@@ -760,18 +954,26 @@ func (c *rawConnection) pingSender() {
 }
 
 // The pingReceiver checks that we've received a message (any message will do,
-// but we expect pings in the absence of other messages) within the last
-// ReceiveTimeout. If not, we close the connection with an ErrTimeout.
+// but we expect pings in the absence of other messages) within the receive
+// timeout. If not, we close the connection with an ErrTimeout. A read gap of
+// more than half the timeout counts as a stall and raises the ping frequency,
+// so that a slow but alive link produces traffic before hitting the timeout.
 func (c *rawConnection) pingReceiver() {
-	ticker := time.Tick(ReceiveTimeout / 2)
+	ticker := time.NewTicker(c.receiveTimeout / 4)
+	defer ticker.Stop()
 
 	for {
 		select {
-		case <-ticker:
+		case <-ticker.C:
 			d := time.Since(c.cr.Last())
-			if d > ReceiveTimeout {
+			switch {
+			case d > c.receiveTimeout:
 				l.Debugln(c.id, "ping timeout", d)
 				c.close(ErrTimeout)
+			case d > c.receiveTimeout/2:
+				c.linkUnstable()
+			default:
+				c.linkStable()
 			}
 
 			l.Debugln(c.id, "last read within", d)
@@ -782,10 +984,42 @@ func (c *rawConnection) pingReceiver() {
 	}
 }
 
+func (c *rawConnection) currentPingInterval() time.Duration {
+	c.pingMut.Lock()
+	defer c.pingMut.Unlock()
+	return c.pingInterval
+}
+
+// linkUnstable halves the ping interval, down to MinPingInterval.
+func (c *rawConnection) linkUnstable() {
+	c.pingMut.Lock()
+	c.stableChecks = 0
+	if half := c.pingInterval / 2; half >= MinPingInterval {
+		c.pingInterval = half
+		l.Debugln(c.id, "unstable link; ping interval lowered to", half)
+	}
+	c.pingMut.Unlock()
+}
+
+// linkStable returns the ping interval to its configured value once the
+// link has been healthy for long enough.
+func (c *rawConnection) linkStable() {
+	c.pingMut.Lock()
+	c.stableChecks++
+	if c.stableChecks >= stableChecksBeforeReset && c.pingInterval != c.basePingInterval {
+		c.pingInterval = c.basePingInterval
+		l.Debugln(c.id, "link stable; ping interval restored to", c.basePingInterval)
+	}
+	c.pingMut.Unlock()
+}
+
 type Statistics struct {
 	At            time.Time
 	InBytesTotal  int64
 	OutBytesTotal int64
+	LastRead      time.Time
+	LastWrite     time.Time
+	PingInterval  time.Duration
 }
 
 func (c *rawConnection) Statistics() Statistics {
@@ -793,6 +1027,9 @@ func (c *rawConnection) Statistics() Statistics {
 		At:            time.Now(),
 		InBytesTotal:  c.cr.Tot(),
 		OutBytesTotal: c.cw.Tot(),
+		LastRead:      c.cr.Last(),
+		LastWrite:     c.cw.Last(),
+		PingInterval:  c.currentPingInterval(),
 	}
 }
 
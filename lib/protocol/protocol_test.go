@@ -6,6 +6,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"strings"
@@ -26,9 +27,9 @@ func TestPing(t *testing.T) {
 	ar, aw := io.Pipe()
 	br, bw := io.Pipe()
 
-	c0 := NewConnection(c0ID, ar, bw, newTestModel(), "name", CompressAlways).(wireFormatConnection).Connection.(*rawConnection)
+	c0 := NewConnection(c0ID, ar, bw, newTestModel(), "name", CompressAlways, 0, 0).(wireFormatConnection).Connection.(*rawConnection)
 	c0.Start()
-	c1 := NewConnection(c1ID, br, aw, newTestModel(), "name", CompressAlways).(wireFormatConnection).Connection.(*rawConnection)
+	c1 := NewConnection(c1ID, br, aw, newTestModel(), "name", CompressAlways, 0, 0).(wireFormatConnection).Connection.(*rawConnection)
 	c1.Start()
 	c0.ClusterConfig(ClusterConfig{})
 	c1.ClusterConfig(ClusterConfig{})
@@ -41,6 +42,90 @@ func TestPing(t *testing.T) {
 	}
 }
 
+func TestClusterConfigChunks(t *testing.T) {
+	m1 := newTestModel()
+	m1.ccCh = make(chan ClusterConfig, 8)
+
+	ar, aw := io.Pipe()
+	br, bw := io.Pipe()
+
+	c0 := NewConnection(c0ID, ar, bw, newTestModel(), "c0", CompressAlways, 0, 0).(wireFormatConnection).Connection.(*rawConnection)
+	c0.SetClusterConfigChunking(true)
+	c0.Start()
+	c1 := NewConnection(c1ID, br, aw, m1, "c1", CompressAlways, 0, 0)
+	c1.(wireFormatConnection).SetClusterConfigChunking(true)
+	c1.Start()
+	c1.ClusterConfig(ClusterConfig{})
+
+	// With chunking negotiated on both sides, a cluster config larger than
+	// the chunk size is split into several messages that together carry
+	// all folders, in order.
+	var cc ClusterConfig
+	for i := 0; i < 2*clusterConfigChunkFolders+10; i++ {
+		cc.Folders = append(cc.Folders, Folder{ID: fmt.Sprintf("folder%d", i)})
+	}
+	c0.ClusterConfig(cc)
+
+	var folders []Folder
+	for chunk := 0; chunk < 3; chunk++ {
+		select {
+		case msg := <-m1.ccCh:
+			folders = append(folders, msg.Folders...)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for cluster config chunk")
+		}
+	}
+
+	if len(folders) != len(cc.Folders) {
+		t.Fatalf("Received %d folders, expected %d", len(folders), len(cc.Folders))
+	}
+	for i := range folders {
+		if folders[i].ID != cc.Folders[i].ID {
+			t.Fatalf("Folder %d is %q, expected %q", i, folders[i].ID, cc.Folders[i].ID)
+		}
+	}
+}
+
+func TestClusterConfigChunksNotNegotiated(t *testing.T) {
+	m1 := newTestModel()
+	m1.ccCh = make(chan ClusterConfig, 8)
+
+	ar, aw := io.Pipe()
+	br, bw := io.Pipe()
+
+	c0 := NewConnection(c0ID, ar, bw, newTestModel(), "c0", CompressAlways, 0, 0).(wireFormatConnection).Connection.(*rawConnection)
+	c0.Start()
+	c1 := NewConnection(c1ID, br, aw, m1, "c1", CompressAlways, 0, 0)
+	c1.Start()
+	c1.ClusterConfig(ClusterConfig{})
+
+	// Without the capability negotiated, the whole config goes out as a
+	// single message, however large.
+	var cc ClusterConfig
+	for i := 0; i < clusterConfigChunkFolders+10; i++ {
+		cc.Folders = append(cc.Folders, Folder{ID: fmt.Sprintf("folder%d", i)})
+	}
+	c0.ClusterConfig(cc)
+
+	select {
+	case msg := <-m1.ccCh:
+		if len(msg.Folders) != len(cc.Folders) {
+			t.Fatalf("Received %d folders, expected %d", len(msg.Folders), len(cc.Folders))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for cluster config")
+	}
+
+	// A repeated ClusterConfig from a peer that did not negotiate
+	// chunking remains a protocol error that closes the connection.
+	c0.ClusterConfig(ClusterConfig{})
+	select {
+	case <-m1.closedCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the connection to close")
+	}
+}
+
 func TestClose(t *testing.T) {
 	m0 := newTestModel()
 	m1 := newTestModel()
@@ -48,9 +133,9 @@ func TestClose(t *testing.T) {
 	ar, aw := io.Pipe()
 	br, bw := io.Pipe()
 
-	c0 := NewConnection(c0ID, ar, bw, m0, "name", CompressAlways).(wireFormatConnection).Connection.(*rawConnection)
+	c0 := NewConnection(c0ID, ar, bw, m0, "name", CompressAlways, 0, 0).(wireFormatConnection).Connection.(*rawConnection)
 	c0.Start()
-	c1 := NewConnection(c1ID, br, aw, m1, "name", CompressAlways)
+	c1 := NewConnection(c1ID, br, aw, m1, "name", CompressAlways, 0, 0)
 	c1.Start()
 	c0.ClusterConfig(ClusterConfig{})
 	c1.ClusterConfig(ClusterConfig{})
@@ -99,6 +184,9 @@ func TestMarshalIndexMessage(t *testing.T) {
 			if len(f.Version.Counters) == 0 {
 				m1.Files[i].Version.Counters = nil
 			}
+			if len(f.Metadata) == 0 {
+				m1.Files[i].Metadata = nil
+			}
 		}
 
 		return testMarshal(t, "index", &m1, &Index{})
@@ -165,6 +253,31 @@ func TestMarshalClusterConfigMessage(t *testing.T) {
 	}
 }
 
+func TestMarshalConfigUpdateMessage(t *testing.T) {
+	if testing.Short() {
+		quickCfg.MaxCount = 10
+	}
+
+	f := func(m1 ConfigUpdate) bool {
+		if len(m1.Folders) == 0 {
+			m1.Folders = nil
+		}
+		for i := range m1.Folders {
+			if len(m1.Folders[i].Devices) == 0 {
+				m1.Folders[i].Devices = nil
+			}
+		}
+		if len(m1.Devices) == 0 {
+			m1.Devices = nil
+		}
+		return testMarshal(t, "configupdate", &m1, &ConfigUpdate{})
+	}
+
+	if err := quick.Check(f, quickCfg); err != nil {
+		t.Error(err)
+	}
+}
+
 func TestMarshalCloseMessage(t *testing.T) {
 	if testing.Short() {
 		quickCfg.MaxCount = 10
@@ -273,3 +386,35 @@ func TestLZ4Compression(t *testing.T) {
 		t.Logf("OK #%d, %d -> %d -> %d", i, dataLen, len(comp), dataLen)
 	}
 }
+
+func TestDictCompression(t *testing.T) {
+	// An index with the kind of repetitive paths the dictionary is
+	// trained on.
+	idx := Index{Folder: "default"}
+	for i := 0; i < 100; i++ {
+		idx.Files = append(idx.Files, FileInfo{
+			Name: fmt.Sprintf("Documents/Pictures/2016/IMG_%04d.jpg", i),
+		})
+	}
+	data, err := idx.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	comp, err := dictCompress(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comp) >= len(data) {
+		t.Errorf("dictionary compression grew the message, %d -> %d bytes", len(data), len(comp))
+	}
+
+	res, err := dictDecompress(comp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, res) {
+		t.Error("Incorrect decompressed data")
+	}
+	t.Logf("OK, %d -> %d -> %d", len(data), len(comp), len(res))
+}
@@ -38,3 +38,35 @@ func (c wireFormatConnection) Request(folder, name string, offset int64, size in
 	name = norm.NFC.String(filepath.ToSlash(name))
 	return c.Connection.Request(folder, name, offset, size, hash, fromTemporary)
 }
+
+func (c wireFormatConnection) SetResponseCompressionHint(hint func(folder, name string) bool) {
+	if hc, ok := c.Connection.(interface {
+		SetResponseCompressionHint(func(folder, name string) bool)
+	}); ok {
+		hc.SetResponseCompressionHint(hint)
+	}
+}
+
+func (c wireFormatConnection) SetCongestionAvoidance(enabled bool) {
+	if cc, ok := c.Connection.(interface {
+		SetCongestionAvoidance(bool)
+	}); ok {
+		cc.SetCongestionAvoidance(enabled)
+	}
+}
+
+func (c wireFormatConnection) SetClusterConfigChunking(enabled bool) {
+	if cc, ok := c.Connection.(interface {
+		SetClusterConfigChunking(bool)
+	}); ok {
+		cc.SetClusterConfigChunking(enabled)
+	}
+}
+
+func (c wireFormatConnection) SetIndexDictionary(enabled bool) {
+	if dc, ok := c.Connection.(interface {
+		SetIndexDictionary(bool)
+	}); ok {
+		dc.SetIndexDictionary(enabled)
+	}
+}
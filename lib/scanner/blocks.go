@@ -12,18 +12,25 @@ import (
 	"io"
 
 	"github.com/syncthing/syncthing/lib/protocol"
-	"github.com/syncthing/syncthing/lib/sha256"
 )
 
-var SHA256OfNothing = []uint8{0xe3, 0xb0, 0xc4, 0x42, 0x98, 0xfc, 0x1c, 0x14, 0x9a, 0xfb, 0xf4, 0xc8, 0x99, 0x6f, 0xb9, 0x24, 0x27, 0xae, 0x41, 0xe4, 0x64, 0x9b, 0x93, 0x4c, 0xa4, 0x95, 0x99, 0x1b, 0x78, 0x52, 0xb8, 0x55}
+var (
+	SHA256OfNothing  = []uint8{0xe3, 0xb0, 0xc4, 0x42, 0x98, 0xfc, 0x1c, 0x14, 0x9a, 0xfb, 0xf4, 0xc8, 0x99, 0x6f, 0xb9, 0x24, 0x27, 0xae, 0x41, 0xe4, 0x64, 0x9b, 0x93, 0x4c, 0xa4, 0x95, 0x99, 0x1b, 0x78, 0x52, 0xb8, 0x55}
+	Blake2bOfNothing = []uint8{0x0e, 0x57, 0x51, 0xc0, 0x26, 0xe5, 0x43, 0xb2, 0xe8, 0xab, 0x2e, 0xb0, 0x60, 0x99, 0xda, 0xa1, 0xd1, 0xe5, 0xdf, 0x47, 0x77, 0x8f, 0x77, 0x87, 0xfa, 0xab, 0x45, 0xcd, 0xf1, 0x2f, 0xe3, 0xa8}
+)
 
 type Counter interface {
 	Update(bytes int64)
 }
 
-// Blocks returns the blockwise hash of the reader.
+// Blocks returns the blockwise SHA-256 hash of the reader.
 func Blocks(r io.Reader, blocksize int, sizehint int64, counter Counter) ([]protocol.BlockInfo, error) {
-	hf := sha256.New()
+	return SHA256.Blocks(r, blocksize, sizehint, counter)
+}
+
+// Blocks returns the blockwise hash of the reader.
+func (a HashAlgorithm) Blocks(r io.Reader, blocksize int, sizehint int64, counter Counter) ([]protocol.BlockInfo, error) {
+	hf := a.New()
 	hashLength := hf.Size()
 
 	var blocks []protocol.BlockInfo
@@ -79,7 +86,7 @@ func Blocks(r io.Reader, blocksize int, sizehint int64, counter Counter) ([]prot
 		blocks = append(blocks, protocol.BlockInfo{
 			Offset: 0,
 			Size:   0,
-			Hash:   SHA256OfNothing,
+			Hash:   a.EmptyHash(),
 		})
 	}
 
@@ -119,10 +126,16 @@ func BlockDiff(src, tgt []protocol.BlockInfo) (have, need []protocol.BlockInfo)
 	return have, need
 }
 
+// Verify returns nil or an error describing the mismatch between the
+// SHA-256 block list and actual reader contents
+func Verify(r io.Reader, blocksize int, blocks []protocol.BlockInfo) error {
+	return SHA256.Verify(r, blocksize, blocks)
+}
+
 // Verify returns nil or an error describing the mismatch between the block
 // list and actual reader contents
-func Verify(r io.Reader, blocksize int, blocks []protocol.BlockInfo) error {
-	hf := sha256.New()
+func (a HashAlgorithm) Verify(r io.Reader, blocksize int, blocks []protocol.BlockInfo) error {
+	hf := a.New()
 	for i, block := range blocks {
 		lr := &io.LimitedReader{R: r, N: int64(blocksize)}
 		_, err := io.Copy(hf, lr)
@@ -149,10 +162,14 @@ func Verify(r io.Reader, blocksize int, blocks []protocol.BlockInfo) error {
 }
 
 func VerifyBuffer(buf []byte, block protocol.BlockInfo) ([]byte, error) {
+	return SHA256.VerifyBuffer(buf, block)
+}
+
+func (a HashAlgorithm) VerifyBuffer(buf []byte, block protocol.BlockInfo) ([]byte, error) {
 	if len(buf) != int(block.Size) {
 		return nil, fmt.Errorf("length mismatch %d != %d", len(buf), block.Size)
 	}
-	hf := sha256.New()
+	hf := a.New()
 	_, err := hf.Write(buf)
 	if err != nil {
 		return nil, err
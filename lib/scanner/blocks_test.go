@@ -122,3 +122,42 @@ func TestDiff(t *testing.T) {
 		}
 	}
 }
+
+func TestHashAlgorithm(t *testing.T) {
+	if _, err := HashAlgorithmFromString("md5"); err == nil {
+		t.Error("Expected an error for an unknown algorithm")
+	}
+
+	for _, name := range []string{"", "sha256", "blake2b"} {
+		algo, err := HashAlgorithmFromString(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// An empty file hashes to the algorithm's hash of nothing.
+		blocks, err := algo.Blocks(bytes.NewBuffer(nil), 1024, -1, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(blocks[0].Hash, algo.EmptyHash()) {
+			t.Errorf("%s: %x != %x", algo, blocks[0].Hash, algo.EmptyHash())
+		}
+
+		// Hashed blocks verify with the same algorithm.
+		data := []byte("contents")
+		blocks, err = algo.Blocks(bytes.NewBuffer(data), 1024, -1, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := algo.VerifyBuffer(data, blocks[0]); err != nil {
+			t.Errorf("%s: %v", algo, err)
+		}
+	}
+
+	// The algorithms give different hashes for the same data.
+	sb, _ := SHA256.Blocks(bytes.NewBufferString("contents"), 1024, -1, nil)
+	bb, _ := Blake2b.Blocks(bytes.NewBufferString("contents"), 1024, -1, nil)
+	if bytes.Equal(sb[0].Hash, bb[0].Hash) {
+		t.Error("Expected different hashes from different algorithms")
+	}
+}
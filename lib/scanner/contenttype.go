@@ -0,0 +1,54 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package scanner
+
+import (
+	"net/http"
+	"os"
+	"sort"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+)
+
+// ContentTypeKey is the metadata key under which detected content types
+// are stored.
+const ContentTypeKey = "contentType"
+
+// sniffLen is the number of leading bytes used for content type
+// detection, the same amount net/http uses.
+const sniffLen = 512
+
+// detectContentType sniffs the MIME type of the file from its leading
+// bytes. It returns the empty string when the file cannot be read.
+func detectContentType(path string) string {
+	fd, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer fd.Close()
+
+	buf := make([]byte, sniffLen)
+	n, _ := fd.Read(buf)
+	if n == 0 {
+		return ""
+	}
+	return http.DetectContentType(buf[:n])
+}
+
+// withMetadataValue returns the metadata list with the given key set,
+// keeping the list sorted by key.
+func withMetadataValue(metadata []protocol.FileMetadata, key, value string) []protocol.FileMetadata {
+	i := sort.Search(len(metadata), func(i int) bool { return metadata[i].Key >= key })
+	if i < len(metadata) && metadata[i].Key == key {
+		metadata[i].Value = value
+		return metadata
+	}
+	metadata = append(metadata, protocol.FileMetadata{})
+	copy(metadata[i+1:], metadata[i:])
+	metadata[i] = protocol.FileMetadata{Key: key, Value: value}
+	return metadata
+}
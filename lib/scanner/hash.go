@@ -0,0 +1,71 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package scanner
+
+import (
+	"fmt"
+	"hash"
+
+	"github.com/syncthing/syncthing/lib/blake2b"
+	"github.com/syncthing/syncthing/lib/sha256"
+)
+
+// A HashAlgorithm is the hash function used for block hashes in a folder.
+// All devices sharing a folder must be configured with the same algorithm,
+// as the block hashes are exchanged and compared between them. The zero
+// value is SHA-256.
+type HashAlgorithm int
+
+const (
+	SHA256 HashAlgorithm = iota
+	Blake2b
+)
+
+// HashAlgorithmFromString returns the hash algorithm with the given
+// configuration name. The empty string selects the default, SHA-256.
+func HashAlgorithmFromString(s string) (HashAlgorithm, error) {
+	switch s {
+	case "", "sha256":
+		return SHA256, nil
+	case "blake2b":
+		return Blake2b, nil
+	}
+	return 0, fmt.Errorf("unknown hash algorithm %q", s)
+}
+
+func (h HashAlgorithm) String() string {
+	switch h {
+	case SHA256:
+		return "sha256"
+	case Blake2b:
+		return "blake2b"
+	}
+	return "unknown"
+}
+
+// New returns a new hash.Hash for the algorithm.
+func (h HashAlgorithm) New() hash.Hash {
+	switch h {
+	case SHA256:
+		return sha256.New()
+	case Blake2b:
+		return blake2b.New()
+	}
+	panic("unknown hash algorithm")
+}
+
+// EmptyHash returns the hash of the empty block, used for zero length
+// files.
+func (h HashAlgorithm) EmptyHash() []byte {
+	switch h {
+	case SHA256:
+		return SHA256OfNothing
+	case Blake2b:
+		return Blake2bOfNothing
+	}
+	panic("unknown hash algorithm")
+}
@@ -0,0 +1,25 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build !windows
+
+package scanner
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// linkGroup returns an identifier shared by all hard links to the same
+// inode, or the empty string when the file has no additional links.
+func linkGroup(info os.FileInfo) string {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || uint64(st.Nlink) < 2 {
+		return ""
+	}
+	return fmt.Sprintf("%x:%x", uint64(st.Dev), uint64(st.Ino))
+}
@@ -0,0 +1,83 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package scanner
+
+import (
+	"os"
+	"sort"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syncthing/syncthing/lib/sync"
+)
+
+// A MetadataProvider contributes key/value metadata entries for scanned
+// files. Providers are how features like extended attributes, labels and
+// content types attach information to files without requiring a wire
+// format change each time.
+type MetadataProvider func(path string, info os.FileInfo) map[string]string
+
+var (
+	metadataMut       = sync.NewMutex()
+	metadataProviders []MetadataProvider
+)
+
+// RegisterMetadataProvider adds a provider that will be called for each
+// scanned file. Providers should be registered at startup, before
+// scanning begins.
+func RegisterMetadataProvider(provider MetadataProvider) {
+	metadataMut.Lock()
+	metadataProviders = append(metadataProviders, provider)
+	metadataMut.Unlock()
+}
+
+// collectMetadata runs the registered providers for the given file and
+// returns the merged result, sorted by key for a stable representation.
+func collectMetadata(path string, info os.FileInfo) []protocol.FileMetadata {
+	metadataMut.Lock()
+	providers := metadataProviders
+	metadataMut.Unlock()
+
+	if len(providers) == 0 {
+		return nil
+	}
+
+	merged := make(map[string]string)
+	for _, provider := range providers {
+		for key, value := range provider(path, info) {
+			merged[key] = value
+		}
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(merged))
+	for key := range merged {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	metadata := make([]protocol.FileMetadata, len(keys))
+	for i, key := range keys {
+		metadata[i] = protocol.FileMetadata{Key: key, Value: merged[key]}
+	}
+	return metadata
+}
+
+// metadataEqual returns true when the two metadata lists contain the same
+// entries in the same order.
+func metadataEqual(a, b []protocol.FileMetadata) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -49,6 +49,8 @@ type Config struct {
 	Subs []string
 	// BlockSize controls the size of the block used when hashing.
 	BlockSize int
+	// Hash selects the block hash algorithm. The zero value is SHA-256.
+	Hash HashAlgorithm
 	// If Matcher is not nil, it is used to identify files to ignore which were specified by the user.
 	Matcher *ignore.Matcher
 	// If TempNamer is not nil, it is used to ignore temporary files when walking.
@@ -73,6 +75,10 @@ type Config struct {
 	// Optional progress tick interval which defines how often FolderScanProgress
 	// events are emitted. Negative number means disabled.
 	ProgressTickIntervalS int
+	// When DetectContentType is set, the content type of scanned files is
+	// sniffed from their leading bytes and stored under the "contentType"
+	// metadata key.
+	DetectContentType bool
 	// Signals cancel from the outside - when closed, we should stop walking.
 	Cancel chan struct{}
 }
@@ -140,7 +146,7 @@ func (w *walker) walk() (chan protocol.FileInfo, error) {
 	// We're not required to emit scan progress events, just kick off hashers,
 	// and feed inputs directly from the walker.
 	if w.ProgressTickIntervalS < 0 {
-		newParallelHasher(w.Dir, w.BlockSize, w.Hashers, finishedChan, toHashChan, nil, nil, w.Cancel)
+		newParallelHasher(w.Dir, w.BlockSize, w.Hashers, w.Hash, finishedChan, toHashChan, nil, nil, w.Cancel)
 		return finishedChan, nil
 	}
 
@@ -172,7 +178,7 @@ func (w *walker) walk() (chan protocol.FileInfo, error) {
 		progress := newByteCounter()
 		defer progress.Close()
 
-		newParallelHasher(w.Dir, w.BlockSize, w.Hashers, finishedChan, realToHashChan, progress, done, w.Cancel)
+		newParallelHasher(w.Dir, w.BlockSize, w.Hashers, w.Hash, finishedChan, realToHashChan, progress, done, w.Cancel)
 
 		// A routine which actually emits the FolderScanProgress events
 		// every w.ProgressTicker ticks, until the hasher routines terminate.
@@ -258,7 +264,8 @@ func (w *walker) walkAndHashFiles(fchan, dchan chan protocol.FileInfo) filepath.
 		}
 
 		if sn := filepath.Base(relPath); sn == ".stignore" || sn == ".stfolder" ||
-			strings.HasPrefix(relPath, ".stversions") || (w.Matcher != nil && w.Matcher.Match(relPath).IsIgnored()) {
+			strings.HasPrefix(relPath, ".stversions") || strings.HasPrefix(relPath, ".stpreviews") ||
+			(w.Matcher != nil && w.Matcher.Match(relPath).IsIgnored()) {
 			// An ignored file
 			l.Debugln("ignored:", relPath)
 			return skip
@@ -299,6 +306,14 @@ func (w *walker) walkRegular(relPath string, info os.FileInfo, fchan chan protoc
 		curMode |= 0111
 	}
 
+	curGroup := linkGroup(info)
+	curMeta := collectMetadata(filepath.Join(w.Dir, relPath), info)
+	if w.DetectContentType && info.Size() > 0 {
+		if ct := detectContentType(filepath.Join(w.Dir, relPath)); ct != "" {
+			curMeta = withMetadataValue(curMeta, ContentTypeKey, ct)
+		}
+	}
+
 	// A file is "unchanged", if it
 	//  - exists
 	//  - has the same permissions as previously, unless we are ignoring permissions
@@ -308,10 +323,13 @@ func (w *walker) walkRegular(relPath string, info os.FileInfo, fchan chan protoc
 	//  - was not a symlink (since it's a file now)
 	//  - was not invalid (since it looks valid now)
 	//  - has the same size as previously
+	//  - belongs to the same hard link group as previously
+	//  - has the same metadata as previously
 	cf, ok := w.CurrentFiler.CurrentFile(relPath)
 	permUnchanged := w.IgnorePerms || !cf.HasPermissionBits() || PermsEqual(cf.Permissions, curMode)
 	if ok && permUnchanged && !cf.IsDeleted() && cf.ModTime().Equal(info.ModTime()) && !cf.IsDirectory() &&
-		!cf.IsSymlink() && !cf.IsInvalid() && cf.Size == info.Size() {
+		!cf.IsSymlink() && !cf.IsInvalid() && cf.Size == info.Size() && cf.LinkGroup == curGroup &&
+		metadataEqual(cf.Metadata, curMeta) {
 		return nil
 	}
 
@@ -326,6 +344,8 @@ func (w *walker) walkRegular(relPath string, info os.FileInfo, fchan chan protoc
 		ModifiedS:     info.ModTime().Unix(),
 		ModifiedNs:    int32(info.ModTime().Nanosecond()),
 		Size:          info.Size(),
+		LinkGroup:     curGroup,
+		Metadata:      curMeta,
 	}
 	l.Debugln("to hash:", relPath, f)
 
@@ -11,11 +11,13 @@ import (
 	"crypto/rand"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
 	rdebug "runtime/debug"
 	"sort"
+	"strings"
 	"sync"
 	"testing"
 
@@ -418,6 +420,49 @@ func TestSymlinkTypeEqual(t *testing.T) {
 	}
 }
 
+func TestWalkHardLinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hard link detection not implemented on Windows")
+	}
+
+	tmpdir, err := ioutil.TempDir("", "syncthing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	if err := ioutil.WriteFile(filepath.Join(tmpdir, "linked"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(tmpdir, "linked"), filepath.Join(tmpdir, "other")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpdir, "plain"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fchan, err := Walk(Config{
+		Dir:       tmpdir,
+		BlockSize: 128 * 1024,
+		Hashers:   2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groups := make(map[string]string)
+	for f := range fchan {
+		groups[f.Name] = f.LinkGroup
+	}
+
+	if groups["linked"] == "" || groups["linked"] != groups["other"] {
+		t.Errorf("hard linked files should share a link group, got %q and %q", groups["linked"], groups["other"])
+	}
+	if groups["plain"] != "" {
+		t.Errorf("file with a single link should have no link group, got %q", groups["plain"])
+	}
+}
+
 var initOnce sync.Once
 
 const (
@@ -430,7 +475,7 @@ func BenchmarkHashFile(b *testing.B) {
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		if _, err := HashFile(testdataName, protocol.BlockSize, nil); err != nil {
+		if _, err := HashFile(testdataName, protocol.BlockSize, nil, SHA256); err != nil {
 			b.Fatal(err)
 		}
 	}
@@ -453,3 +498,81 @@ func initTestFile() {
 		panic(err)
 	}
 }
+
+func TestWalkMetadata(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "syncthing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	if err := ioutil.WriteFile(filepath.Join(tmpdir, "file.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The provider stays registered for the remainder of the test run, so
+	// limit it to our temporary directory.
+	RegisterMetadataProvider(func(path string, info os.FileInfo) map[string]string {
+		if !strings.HasPrefix(path, tmpdir) {
+			return nil
+		}
+		return map[string]string{"label": "test", "content-type": "text/plain"}
+	})
+
+	fchan, err := Walk(Config{
+		Dir:       tmpdir,
+		BlockSize: 128 * 1024,
+		Hashers:   2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var file protocol.FileInfo
+	for f := range fchan {
+		if f.Name == "file.txt" {
+			file = f
+		}
+	}
+
+	expected := []protocol.FileMetadata{
+		{Key: "content-type", Value: "text/plain"},
+		{Key: "label", Value: "test"},
+	}
+	if !metadataEqual(file.Metadata, expected) {
+		t.Errorf("unexpected metadata %v, expected %v", file.Metadata, expected)
+	}
+}
+
+func TestWalkContentType(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "syncthing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	if err := ioutil.WriteFile(filepath.Join(tmpdir, "page.html"), []byte("<html><body>hello</body></html>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fchan, err := Walk(Config{
+		Dir:               tmpdir,
+		BlockSize:         128 * 1024,
+		Hashers:           2,
+		DetectContentType: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var file protocol.FileInfo
+	for f := range fchan {
+		if f.Name == "page.html" {
+			file = f
+		}
+	}
+
+	if ct := file.MetadataValue(ContentTypeKey); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+}
@@ -13,7 +13,10 @@ import (
 )
 
 type DeviceStatistics struct {
-	LastSeen time.Time `json:"lastSeen"`
+	LastSeen        time.Time `json:"lastSeen"`
+	RelayBytesMonth int64     `json:"relayBytesMonth"`
+	InBytesMonth    int64     `json:"inBytesMonth"`
+	OutBytesMonth   int64     `json:"outBytesMonth"`
 }
 
 type DeviceStatisticsReference struct {
@@ -22,7 +25,7 @@ type DeviceStatisticsReference struct {
 }
 
 func NewDeviceStatisticsReference(ldb *db.Instance, device string) *DeviceStatisticsReference {
-	prefix := string(db.KeyTypeDeviceStatistic) + device
+	prefix := string([]byte{db.KeyTypeDeviceStatistic}) + device
 	return &DeviceStatisticsReference{
 		ns:     db.NewNamespacedKV(ldb, prefix),
 		device: device,
@@ -45,8 +48,59 @@ func (s *DeviceStatisticsReference) WasSeen() {
 	s.ns.PutTime("lastSeen", time.Now())
 }
 
+// relayMonth identifies the current accounting period for the relay
+// traffic counter.
+func relayMonth() string {
+	return time.Now().Format("2006-01")
+}
+
+// AddRelayBytes adds n bytes of relayed traffic to the counter for the
+// current month, resetting it first if the month has changed.
+func (s *DeviceStatisticsReference) AddRelayBytes(n int64) {
+	cur := s.GetRelayBytesMonth()
+	l.Debugln("stats.DeviceStatisticsReference.AddRelayBytes:", s.device, n)
+	s.ns.PutString("relayBytesMonthStamp", relayMonth())
+	s.ns.PutInt64("relayBytesMonth", cur+n)
+}
+
+// GetRelayBytesMonth returns the bytes of relayed traffic recorded for
+// the current month.
+func (s *DeviceStatisticsReference) GetRelayBytesMonth() int64 {
+	if stamp, ok := s.ns.String("relayBytesMonthStamp"); !ok || stamp != relayMonth() {
+		return 0
+	}
+	n, _ := s.ns.Int64("relayBytesMonth")
+	return n
+}
+
+// AddTransferBytes adds in bytes of received and out bytes of sent
+// traffic to the counters for the current month, resetting them first if
+// the month has changed.
+func (s *DeviceStatisticsReference) AddTransferBytes(in, out int64) {
+	curIn, curOut := s.GetTransferBytesMonth()
+	l.Debugln("stats.DeviceStatisticsReference.AddTransferBytes:", s.device, in, out)
+	s.ns.PutString("transferBytesMonthStamp", relayMonth())
+	s.ns.PutInt64("inBytesMonth", curIn+in)
+	s.ns.PutInt64("outBytesMonth", curOut+out)
+}
+
+// GetTransferBytesMonth returns the bytes of received and sent traffic
+// recorded for the current month.
+func (s *DeviceStatisticsReference) GetTransferBytesMonth() (in, out int64) {
+	if stamp, ok := s.ns.String("transferBytesMonthStamp"); !ok || stamp != relayMonth() {
+		return 0, 0
+	}
+	in, _ = s.ns.Int64("inBytesMonth")
+	out, _ = s.ns.Int64("outBytesMonth")
+	return in, out
+}
+
 func (s *DeviceStatisticsReference) GetStatistics() DeviceStatistics {
+	in, out := s.GetTransferBytesMonth()
 	return DeviceStatistics{
-		LastSeen: s.GetLastSeen(),
+		LastSeen:        s.GetLastSeen(),
+		RelayBytesMonth: s.GetRelayBytesMonth(),
+		InBytesMonth:    in,
+		OutBytesMonth:   out,
 	}
 }
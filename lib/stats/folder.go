@@ -29,7 +29,7 @@ type LastFile struct {
 }
 
 func NewFolderStatisticsReference(ldb *db.Instance, folder string) *FolderStatisticsReference {
-	prefix := string(db.KeyTypeFolderStatistic) + folder
+	prefix := string([]byte{db.KeyTypeFolderStatistic}) + folder
 	return &FolderStatisticsReference{
 		ns:     db.NewNamespacedKV(ldb, prefix),
 		folder: folder,
@@ -0,0 +1,136 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/syncthing/syncthing/lib/db"
+)
+
+const (
+	// Hourly samples older than this are folded into daily samples.
+	hourlyRetention = 7 * 24 * time.Hour
+	// Daily samples older than this are dropped.
+	dailyRetention = 365 * 24 * time.Hour
+)
+
+// HistorySample is a point-in-time snapshot of folder state and activity.
+// The byte and error counters are cumulative since the folder was added, so
+// rates are the difference between adjacent samples.
+type HistorySample struct {
+	When        time.Time `json:"when"`
+	GlobalFiles int       `json:"globalFiles"`
+	GlobalBytes int64     `json:"globalBytes"`
+	LocalFiles  int       `json:"localFiles"`
+	LocalBytes  int64     `json:"localBytes"`
+	InBytes     int64     `json:"inBytes"`  // bytes pulled into the folder from other devices
+	OutBytes    int64     `json:"outBytes"` // bytes served from the folder to other devices
+	Errors      int       `json:"errors"`   // pull errors encountered
+}
+
+// FolderHistoryReference stores a time series of HistorySamples for a
+// folder. Samples are kept at hourly resolution for a week and daily
+// resolution for a year; older data is discarded.
+type FolderHistoryReference struct {
+	ns     *db.NamespacedKV
+	folder string
+}
+
+func NewFolderHistoryReference(ldb *db.Instance, folder string) *FolderHistoryReference {
+	prefix := string([]byte{db.KeyTypeFolderHistory}) + folder
+	return &FolderHistoryReference{
+		ns:     db.NewNamespacedKV(ldb, prefix),
+		folder: folder,
+	}
+}
+
+// Samples sort lexicographically by key within each resolution, and the
+// daily prefix sorts before the hourly one, so a scan over both prefixes
+// returns old dailies followed by recent hourlies.
+func dailyKey(t time.Time) string {
+	return fmt.Sprintf("d%016x", t.Truncate(24*time.Hour).Unix())
+}
+
+func hourlyKey(t time.Time) string {
+	return fmt.Sprintf("h%016x", t.Truncate(time.Hour).Unix())
+}
+
+// Record stores a sample at hourly resolution, overwriting any previous
+// sample for the same hour, and downsamples and expires old data.
+func (h *FolderHistoryReference) Record(s HistorySample) {
+	l.Debugln("stats.FolderHistoryReference.Record:", h.folder, s.When)
+	bs, err := json.Marshal(s)
+	if err != nil {
+		panic(err)
+	}
+	h.ns.PutBytes(hourlyKey(s.When), bs)
+	h.downsample(s.When)
+}
+
+// Since returns all samples taken at or after the given time, oldest
+// first. A zero time returns the full retained history.
+func (h *FolderHistoryReference) Since(t time.Time) []HistorySample {
+	var samples []HistorySample
+	collect := func(key string, val []byte) bool {
+		var s HistorySample
+		if err := json.Unmarshal(val, &s); err != nil {
+			return true
+		}
+		if !s.When.Before(t) {
+			samples = append(samples, s)
+		}
+		return true
+	}
+	h.ns.WithPrefixedKV("d", collect)
+	h.ns.WithPrefixedKV("h", collect)
+	return samples
+}
+
+// downsample folds hourly samples older than the hourly retention into
+// daily samples, and drops daily samples older than the daily retention.
+func (h *FolderHistoryReference) downsample(now time.Time) {
+	hourlyCutoff := hourlyKey(now.Add(-hourlyRetention))
+	days := make(map[string][]HistorySample)
+	var expired []string
+	h.ns.WithPrefixedKV("h", func(key string, val []byte) bool {
+		if key >= hourlyCutoff {
+			// Keys are in order, so nothing further is old enough.
+			return false
+		}
+		var s HistorySample
+		if err := json.Unmarshal(val, &s); err == nil {
+			days[dailyKey(s.When)] = append(days[dailyKey(s.When)], s)
+		}
+		expired = append(expired, key)
+		return true
+	})
+
+	for key, samples := range days {
+		day := samples[len(samples)-1]
+		day.When = day.When.Truncate(24 * time.Hour)
+		bs, err := json.Marshal(day)
+		if err != nil {
+			panic(err)
+		}
+		h.ns.PutBytes(key, bs)
+	}
+	for _, key := range expired {
+		h.ns.Delete(key)
+	}
+
+	dailyCutoff := dailyKey(now.Add(-dailyRetention))
+	h.ns.WithPrefixedKV("d", func(key string, val []byte) bool {
+		if key >= dailyCutoff {
+			return false
+		}
+		h.ns.Delete(key)
+		return true
+	})
+}
@@ -0,0 +1,77 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syncthing/syncthing/lib/db"
+)
+
+func TestFolderHistory(t *testing.T) {
+	ldb := db.OpenMemory()
+	h := NewFolderHistoryReference(ldb, "default")
+
+	now := time.Now().Truncate(time.Hour)
+	for i := 0; i < 5; i++ {
+		h.Record(HistorySample{
+			When:       now.Add(time.Duration(i-4) * time.Hour),
+			LocalFiles: i + 1,
+		})
+	}
+
+	samples := h.Since(time.Time{})
+	if len(samples) != 5 {
+		t.Fatalf("expected 5 samples, got %d", len(samples))
+	}
+	for i := 1; i < len(samples); i++ {
+		if samples[i].When.Before(samples[i-1].When) {
+			t.Error("samples should be in chronological order")
+		}
+	}
+
+	samples = h.Since(now.Add(-time.Hour))
+	if len(samples) != 2 {
+		t.Errorf("expected 2 samples since an hour ago, got %d", len(samples))
+	}
+
+	// Recording a sample in the same hour overwrites the previous one.
+	h.Record(HistorySample{When: now, LocalFiles: 42})
+	samples = h.Since(time.Time{})
+	if len(samples) != 5 {
+		t.Fatalf("expected 5 samples after overwrite, got %d", len(samples))
+	}
+	if samples[len(samples)-1].LocalFiles != 42 {
+		t.Errorf("expected overwritten sample, got %v", samples[len(samples)-1])
+	}
+}
+
+func TestFolderHistoryDownsampling(t *testing.T) {
+	ldb := db.OpenMemory()
+	h := NewFolderHistoryReference(ldb, "default")
+
+	// Two days worth of hourly samples from a couple of weeks back, then a
+	// current sample to trigger downsampling.
+	old := time.Now().Add(-14 * 24 * time.Hour).Truncate(24 * time.Hour)
+	for i := 0; i < 48; i++ {
+		h.Record(HistorySample{
+			When:       old.Add(time.Duration(i) * time.Hour),
+			LocalFiles: i,
+		})
+	}
+	h.Record(HistorySample{When: time.Now(), LocalFiles: 100})
+
+	samples := h.Since(time.Time{})
+	if len(samples) != 3 {
+		t.Fatalf("expected 2 daily samples and 1 hourly, got %d", len(samples))
+	}
+	// Each daily sample keeps the values of the last sample of that day.
+	if samples[0].LocalFiles != 23 || samples[1].LocalFiles != 47 {
+		t.Errorf("unexpected daily samples: %v, %v", samples[0], samples[1])
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package stats
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/syncthing/syncthing/lib/db"
+)
+
+// FileProvenance records who originated the version of a file we currently
+// have, and when we applied it locally.
+type FileProvenance struct {
+	Path    string    `json:"path"`
+	By      string    `json:"by"` // the device the change came from
+	At      time.Time `json:"at"` // when the change was applied locally
+	Version string    `json:"version"`
+	Deleted bool      `json:"deleted"`
+}
+
+// ProvenanceSummary sums up the provenance records of a folder: how many
+// changes each device has contributed, and the most recent change.
+type ProvenanceSummary struct {
+	Changes    map[string]int64 `json:"changes"` // device -> number of changes applied
+	LastChange FileProvenance   `json:"lastChange"`
+}
+
+// ProvenanceReference stores per file provenance records for a folder.
+type ProvenanceReference struct {
+	ns     *db.NamespacedKV
+	folder string
+}
+
+func NewProvenanceReference(ldb *db.Instance, folder string) *ProvenanceReference {
+	prefix := string([]byte{db.KeyTypeProvenance}) + folder
+	return &ProvenanceReference{
+		ns:     db.NewNamespacedKV(ldb, prefix),
+		folder: folder,
+	}
+}
+
+// Record notes that the given version of a file, as announced by the given
+// device, was applied locally at the given time.
+func (p *ProvenanceReference) Record(file, version, by string, deleted bool, when time.Time) {
+	l.Debugln("stats.ProvenanceReference.Record:", p.folder, file, by)
+	rec := FileProvenance{
+		Path:    file,
+		By:      by,
+		At:      when,
+		Version: version,
+		Deleted: deleted,
+	}
+	bs, err := json.Marshal(rec)
+	if err != nil {
+		panic(err)
+	}
+	p.ns.PutBytes("f"+file, bs)
+	p.ns.PutBytes("last", bs)
+
+	count, _ := p.ns.Int64("c" + by)
+	p.ns.PutInt64("c"+by, count+1)
+}
+
+// Get returns the provenance record for the given file, if there is one.
+func (p *ProvenanceReference) Get(file string) (FileProvenance, bool) {
+	bs, ok := p.ns.Bytes("f" + file)
+	if !ok {
+		return FileProvenance{}, false
+	}
+	var rec FileProvenance
+	if err := json.Unmarshal(bs, &rec); err != nil {
+		return FileProvenance{}, false
+	}
+	return rec, true
+}
+
+// Summary returns the per device change counts and the most recent change
+// for the folder.
+func (p *ProvenanceReference) Summary() ProvenanceSummary {
+	sum := ProvenanceSummary{Changes: make(map[string]int64)}
+	p.ns.WithPrefixedKV("c", func(key string, val []byte) bool {
+		if len(val) == 8 {
+			sum.Changes[key[1:]] = int64(binary.BigEndian.Uint64(val))
+		}
+		return true
+	})
+	if bs, ok := p.ns.Bytes("last"); ok {
+		json.Unmarshal(bs, &sum.LastChange)
+	}
+	return sum
+}
@@ -0,0 +1,53 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syncthing/syncthing/lib/db"
+)
+
+func TestProvenance(t *testing.T) {
+	ldb := db.OpenMemory()
+	p := NewProvenanceReference(ldb, "default")
+
+	if _, ok := p.Get("file"); ok {
+		t.Error("unexpected provenance for unknown file")
+	}
+
+	now := time.Now().Round(time.Second)
+	p.Record("a", "{[{1 1}]}", "device1", false, now.Add(-time.Minute))
+	p.Record("b", "{[{2 1}]}", "device2", false, now)
+	p.Record("a", "{[{1 2}]}", "device1", true, now)
+
+	rec, ok := p.Get("a")
+	if !ok {
+		t.Fatal("expected provenance for a")
+	}
+	if rec.By != "device1" || !rec.Deleted || rec.Version != "{[{1 2}]}" {
+		t.Errorf("unexpected record %+v", rec)
+	}
+	if !rec.At.Equal(now) {
+		t.Errorf("unexpected time %v, expected %v", rec.At, now)
+	}
+
+	sum := p.Summary()
+	if sum.Changes["device1"] != 2 || sum.Changes["device2"] != 1 {
+		t.Errorf("unexpected change counts %v", sum.Changes)
+	}
+	if sum.LastChange.Path != "a" || sum.LastChange.By != "device1" {
+		t.Errorf("unexpected last change %+v", sum.LastChange)
+	}
+
+	// Folders do not see each other's records.
+	other := NewProvenanceReference(ldb, "other")
+	if _, ok := other.Get("a"); ok {
+		t.Error("unexpected provenance leak between folders")
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package upgrade
+
+import "fmt"
+
+// A downgrade target must be recent enough to understand the data the
+// running version has written to disk. These tables record, per schema
+// version, the oldest release that can read it, and must gain an entry
+// whenever a schema version is bumped.
+
+var minReleaseForConfig = map[int]string{
+	13: "v0.13.0",
+	14: "v0.14.0",
+	15: "v0.14.0",
+	16: "v0.14.0",
+}
+
+var minReleaseForDBSchema = map[int64]string{
+	0: "v0.13.0",
+	1: "v0.14.0",
+}
+
+// CheckDowngrade verifies that the release tagged "to" can read data
+// written with the given configuration and database schema versions. It
+// returns nil when the downgrade is safe, and a descriptive error when the
+// target release is too old to read the current data or when the schema
+// versions are unknown to this build.
+func CheckDowngrade(to string, configVersion int, dbSchema int64) error {
+	min, ok := minReleaseForConfig[configVersion]
+	if !ok {
+		return fmt.Errorf("unknown config version %d; refusing downgrade", configVersion)
+	}
+	if CompareVersions(to, min) < Equal {
+		return fmt.Errorf("%s cannot read a version %d configuration (requires at least %s); export a compatible configuration over /rest/system/config/downgrade first", to, configVersion, min)
+	}
+
+	min, ok = minReleaseForDBSchema[dbSchema]
+	if !ok {
+		return fmt.Errorf("unknown database schema version %d; refusing downgrade", dbSchema)
+	}
+	if CompareVersions(to, min) < Equal {
+		return fmt.Errorf("%s cannot read a schema version %d index database (requires at least %s); remove the index database to proceed", to, dbSchema, min)
+	}
+
+	return nil
+}
@@ -0,0 +1,41 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package upgrade
+
+import "testing"
+
+func TestCheckDowngrade(t *testing.T) {
+	cases := []struct {
+		to            string
+		configVersion int
+		dbSchema      int64
+		ok            bool
+	}{
+		// Current data is readable by any v0.14.x release.
+		{"v0.14.0", 16, 1, true},
+		{"v0.14.3", 16, 1, true},
+
+		// A v0.13.x release reads neither a v14+ config nor a schema 1
+		// database.
+		{"v0.13.9", 16, 1, false},
+		{"v0.13.9", 13, 1, false},
+		{"v0.13.9", 13, 0, true},
+
+		// Schema versions we have no markers for are refused outright.
+		{"v0.14.0", 17, 1, false},
+		{"v0.14.0", 16, 2, false},
+	}
+
+	for _, tc := range cases {
+		err := CheckDowngrade(tc.to, tc.configVersion, tc.dbSchema)
+		if tc.ok && err != nil {
+			t.Errorf("CheckDowngrade(%q, %d, %d): unexpected error: %v", tc.to, tc.configVersion, tc.dbSchema, err)
+		} else if !tc.ok && err == nil {
+			t.Errorf("CheckDowngrade(%q, %d, %d): unexpected pass", tc.to, tc.configVersion, tc.dbSchema)
+		}
+	}
+}
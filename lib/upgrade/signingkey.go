@@ -17,3 +17,8 @@ MIGbMBAGByqGSM49AgEGBSuBBAAjA4GGAAQA1iRk+p+DsmolixxVKcpEVlMDPOeQ
 7jUpeAjtPoVVxvOh37qDeDVcjCgJbbDTPKbjxq/Ae3SHlQMRcoes7lVY1+YJ8dPk
 2oPfjA6jtmo9aVbf/uo=
 -----END EC PUBLIC KEY-----`)
+
+// SigningKeys lists all keys accepted when verifying upgrade signatures.
+// Additional keys can be appended by forks signing their own releases, and
+// during rollover to a new signing key.
+var SigningKeys = [][]byte{SigningKey}
@@ -80,6 +80,22 @@ func ToURL(url string) error {
 	}
 }
 
+// Rollback restores the previous binary saved by an earlier upgrade,
+// recording the reason for the rollback alongside the binary.
+func Rollback(reason string) error {
+	select {
+	case <-upgradeUnlocked:
+		binary, err := osext.Executable()
+		if err == nil {
+			err = rollbackBinary(binary, reason)
+		}
+		upgradeUnlocked <- true
+		return err
+	default:
+		return ErrUpgradeInProgress
+	}
+}
+
 type Relation int
 
 const (
@@ -14,12 +14,14 @@ import (
 	"bytes"
 	"compress/gzip"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
 	"runtime"
@@ -62,8 +64,10 @@ const (
 // validation. We do this because some systems where Syncthing runs have
 // issues with old or missing CA roots. It doesn't actually matter that we
 // load the upgrade insecurely as we verify an ECDSA signature of the actual
-// binary contents before accepting the upgrade.
-var insecureHTTP = &http.Client{
+// binary contents before accepting the upgrade. Deployments that want the
+// transport verified anyway can install a CA bundle with ConfigureClient.
+// The proxy related environment variables are honored in either case.
+var upgradeHTTP = &http.Client{
 	Timeout: readTimeout,
 	Transport: &http.Transport{
 		Dial:  dialer.Dial,
@@ -74,14 +78,69 @@ var insecureHTTP = &http.Client{
 	},
 }
 
-func insecureGet(url, version string) (*http.Response, error) {
-	req, err := http.NewRequest("GET", url, nil)
+// ConfigureClient makes the upgrade HTTP client verify server
+// certificates against the CAs in the given PEM bundle, for deployments
+// with internal release mirrors or TLS intercepting proxies.
+func ConfigureClient(caBundlePath string) error {
+	if caBundlePath == "" {
+		return nil
+	}
+
+	pemBytes, err := ioutil.ReadFile(caBundlePath)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemBytes) {
+		return fmt.Errorf("no certificates found in %s", caBundlePath)
+	}
+
+	upgradeHTTP.Transport = &http.Transport{
+		Dial:  dialer.Dial,
+		Proxy: http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{
+			RootCAs: pool,
+		},
+	}
+	return nil
+}
+
+// Metadata requests are retried a few times with a short backoff, as
+// transient failures are common and an auto upgrade check should not give
+// up at the first hiccup. Binary downloads are not retried; the caller
+// gets the error and can try again.
+const (
+	metadataAttempts   = 3
+	metadataRetryDelay = 5 * time.Second
+)
+
+func insecureGet(url, version string) (*http.Response, error) {
+	var resp *http.Response
+	var err error
+
+	for attempt := 0; attempt < metadataAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * metadataRetryDelay)
+		}
+
+		req, reqErr := http.NewRequest("GET", url, nil)
+		if reqErr != nil {
+			return nil, reqErr
+		}
+		req.Header.Set("User-Agent", fmt.Sprintf(`syncthing %s (%s %s-%s)`, version, runtime.Version(), runtime.GOOS, runtime.GOARCH))
+
+		resp, err = upgradeHTTP.Do(req)
+		if err == nil && resp.StatusCode < 500 {
+			return resp, nil
+		}
+		if err == nil {
+			resp.Body.Close()
+			err = fmt.Errorf("server error: %s", resp.Status)
+		}
+		l.Debugf("%s: %v (attempt %d)", url, err, attempt)
 	}
 
-	req.Header.Set("User-Agent", fmt.Sprintf(`syncthing %s (%s %s-%s)`, version, runtime.Version(), runtime.GOOS, runtime.GOARCH))
-	return insecureHTTP.Do(req)
+	return nil, err
 }
 
 // FetchLatestReleases returns the latest releases, including prereleases or
@@ -210,6 +269,48 @@ func upgradeToURL(archiveName, binary string, url string) error {
 		os.Rename(old, binary)
 		return err
 	}
+
+	// Stamp the saved binary with the time of the upgrade, so that the
+	// monitor can tell how recent the upgrade is if a rollback becomes
+	// necessary.
+	now := time.Now()
+	os.Chtimes(old, now, now)
+
+	return nil
+}
+
+// rollbackBinary swaps the current binary for the ".old" copy saved by a
+// previous upgrade, after verifying that the old binary still starts. The
+// failed binary is kept with a ".failed" extension and the reason for the
+// rollback is recorded next to the binary.
+func rollbackBinary(binary, reason string) error {
+	old := binary + ".old"
+	if _, err := os.Stat(old); err != nil {
+		return fmt.Errorf("no previous binary to roll back to: %v", err)
+	}
+
+	// Check that the previous binary still starts before swapping it in.
+	if err := exec.Command(old, "-version").Run(); err != nil {
+		return fmt.Errorf("previous binary does not start: %v", err)
+	}
+
+	failed := binary + ".failed"
+	os.Remove(failed)
+	if err := os.Rename(binary, failed); err != nil {
+		return err
+	}
+	if err := os.Rename(old, binary); err != nil {
+		os.Rename(failed, binary)
+		return err
+	}
+
+	// Record why we rolled back, for later inspection. Failure to do so
+	// doesn't fail the rollback itself.
+	record := fmt.Sprintf("%s %s\n", time.Now().Format(time.RFC3339), reason)
+	if err := ioutil.WriteFile(binary+".rollback", []byte(record), 0644); err != nil {
+		l.Warnln("Recording rollback reason:", err)
+	}
+
 	return nil
 }
 
@@ -222,7 +323,7 @@ func readRelease(archiveName, dir, url string) (string, error) {
 	}
 
 	req.Header.Add("Accept", "application/octet-stream")
-	resp, err := insecureHTTP.Do(req)
+	resp, err := upgradeHTTP.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -374,6 +475,13 @@ func verifyUpgrade(archiveName, tempName string, sig []byte) error {
 		return fmt.Errorf("no upgrade found")
 	}
 	if sig == nil {
+		// Unsigned releases are refused, except when the user has
+		// explicitly decided to trust the release source instead of the
+		// signature.
+		if os.Getenv("STUPGRADEUNSIGNED") != "" {
+			l.Warnln("Accepting unsigned upgrade; STUPGRADEUNSIGNED is set")
+			return nil
+		}
 		return fmt.Errorf("no signature found")
 	}
 
@@ -383,6 +491,7 @@ func verifyUpgrade(archiveName, tempName string, sig []byte) error {
 	if err != nil {
 		return err
 	}
+	defer fd.Close()
 
 	// Create a new reader that will serve reads from, in order:
 	//
@@ -394,17 +503,22 @@ func verifyUpgrade(archiveName, tempName string, sig []byte) error {
 	// We then verify the release signature against the contents of this
 	// multireader. This ensures that it is not only a bonafide syncthing
 	// binary, but it it also of exactly the platform and version we expect.
+	// Any of the compiled in signing keys is acceptable.
 
-	mr := io.MultiReader(bytes.NewBufferString(archiveName+"\n"), fd)
-	err = signature.Verify(SigningKey, sig, mr)
-	fd.Close()
-
-	if err != nil {
-		os.Remove(tempName)
-		return err
+	err = fmt.Errorf("no accepted signing keys")
+	for _, key := range SigningKeys {
+		if _, err := fd.Seek(0, 0); err != nil {
+			os.Remove(tempName)
+			return err
+		}
+		mr := io.MultiReader(bytes.NewBufferString(archiveName+"\n"), fd)
+		if err = signature.Verify(key, sig, mr); err == nil {
+			return nil
+		}
 	}
 
-	return nil
+	os.Remove(tempName)
+	return err
 }
 
 func writeBinary(dir string, inFile io.Reader) (filename string, err error) {
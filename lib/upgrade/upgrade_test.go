@@ -9,8 +9,16 @@
 package upgrade
 
 import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
+
+	"github.com/syncthing/syncthing/lib/signature"
 )
 
 var versions = []struct {
@@ -108,3 +116,114 @@ func TestSelectedRelease(t *testing.T) {
 		}
 	}
 }
+
+func TestVerifyUpgrade(t *testing.T) {
+	privKey, pubKey, err := signature.GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := []byte("this is the upgrade binary")
+	sig, err := signature.Sign(privKey, io.MultiReader(strings.NewReader("archive.tar.gz\n"), bytes.NewReader(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldKeys := SigningKeys
+	SigningKeys = [][]byte{pubKey}
+	defer func() { SigningKeys = oldKeys }()
+
+	writeTemp := func() string {
+		fd, err := ioutil.TempFile("", "syncthing-upgrade-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fd.Write(content)
+		fd.Close()
+		return fd.Name()
+	}
+
+	name := writeTemp()
+	defer os.Remove(name)
+	if err := verifyUpgrade("archive.tar.gz", name, sig); err != nil {
+		t.Error("expected valid signature to verify:", err)
+	}
+
+	// The signature covers the archive name, so a binary for another
+	// platform or version does not verify.
+	name = writeTemp()
+	defer os.Remove(name)
+	if err := verifyUpgrade("otherarchive.tar.gz", name, sig); err == nil {
+		t.Error("expected mismatching archive name to fail verification")
+	}
+
+	// Unsigned releases are refused...
+	os.Unsetenv("STUPGRADEUNSIGNED")
+	name = writeTemp()
+	defer os.Remove(name)
+	if err := verifyUpgrade("archive.tar.gz", name, nil); err == nil {
+		t.Error("expected unsigned upgrade to be refused")
+	}
+
+	// ...unless explicitly overridden.
+	os.Setenv("STUPGRADEUNSIGNED", "1")
+	defer os.Unsetenv("STUPGRADEUNSIGNED")
+	name = writeTemp()
+	defer os.Remove(name)
+	if err := verifyUpgrade("archive.tar.gz", name, nil); err != nil {
+		t.Error("expected unsigned upgrade to be accepted with override:", err)
+	}
+}
+
+func TestRollbackBinary(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses shell scripts as stand-in binaries")
+	}
+
+	dir, err := ioutil.TempDir("", "syncthing-rollback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	binary := filepath.Join(dir, "syncthing")
+	writeScript := func(name, body string) {
+		if err := ioutil.WriteFile(name, []byte("#!/bin/sh\n"+body), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeScript(binary, "exit 0\n")
+
+	// Nothing to roll back to.
+	if err := rollbackBinary(binary, "test"); err == nil {
+		t.Error("expected an error without a saved binary")
+	}
+
+	writeScript(binary+".old", "# old\nexit 0\n")
+	if err := rollbackBinary(binary, "something broke"); err != nil {
+		t.Fatal(err)
+	}
+
+	bs, err := ioutil.ReadFile(binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(bs), "# old") {
+		t.Error("previous binary was not restored")
+	}
+	if _, err := os.Stat(binary + ".failed"); err != nil {
+		t.Error("failed binary was not kept:", err)
+	}
+	if rec, err := ioutil.ReadFile(binary + ".rollback"); err != nil || !strings.Contains(string(rec), "something broke") {
+		t.Errorf("rollback reason not recorded: %q, %v", rec, err)
+	}
+
+	// A saved binary that doesn't start must not be swapped in.
+	writeScript(binary+".old", "exit 1\n")
+	if err := rollbackBinary(binary, "test"); err == nil {
+		t.Error("expected an error for a saved binary that doesn't start")
+	}
+	if bs, _ := ioutil.ReadFile(binary); !strings.Contains(string(bs), "# old") {
+		t.Error("current binary was replaced despite failed verification")
+	}
+}
@@ -10,6 +10,10 @@ package upgrade
 
 const DisabledByCompilation = true
 
+func ConfigureClient(caBundlePath string) error {
+	return nil
+}
+
 func upgradeTo(binary string, rel Release) error {
 	return ErrUpgradeUnsupported
 }
@@ -18,6 +22,14 @@ func upgradeToURL(archiveName, binary, url string) error {
 	return ErrUpgradeUnsupported
 }
 
+func rollbackBinary(binary, reason string) error {
+	return ErrUpgradeUnsupported
+}
+
 func LatestRelease(releasesURL, version string) (Release, error) {
 	return Release{}, ErrUpgradeUnsupported
 }
+
+func FetchLatestReleases(releasesURL, version string) []Release {
+	return nil
+}
@@ -0,0 +1,94 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package versioner
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// An ArchivedVersion describes one archived copy of a file in a versions
+// directory.
+type ArchivedVersion struct {
+	// Name is the name of the file the copy was made from, relative to the
+	// folder root.
+	Name string `json:"name"`
+	// ArchiveTime is the time embedded in the version tag, or the
+	// modification time of the archived copy for untagged (trashcan style)
+	// versions.
+	ArchiveTime time.Time `json:"archiveTime"`
+	Size        int64     `json:"size"`
+	// Path is the path to the archived copy, relative to the versions
+	// directory.
+	Path string `json:"path"`
+}
+
+// ListArchivedVersions walks the given versions directory and returns the
+// archived versions found there, keyed by the original file name and sorted
+// by ascending archive time.
+func ListArchivedVersions(versionsDir string) (map[string][]ArchivedVersion, error) {
+	versions := make(map[string][]ArchivedVersion)
+
+	err := filepath.Walk(versionsDir, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if f.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(versionsDir, path)
+		if err != nil {
+			return err
+		}
+
+		when := f.ModTime()
+		if tag := filenameTag(rel); tag != "" {
+			if tagTime, err := time.ParseInLocation(TimeFormat, tag, time.Local); err == nil {
+				when = tagTime
+			}
+		}
+
+		name := filepath.ToSlash(untaggedFilename(rel))
+		versions[name] = append(versions[name], ArchivedVersion{
+			Name:        name,
+			ArchiveTime: when,
+			Size:        f.Size(),
+			Path:        rel,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, vs := range versions {
+		sort.Sort(byArchiveTime(vs))
+	}
+	return versions, nil
+}
+
+// VersionAsOf returns the archived version that best represents the file
+// content at the given time, that is the oldest copy archived at or after
+// that time. The boolean is false when no such copy exists, in which case
+// the current file already represents the content as of that time.
+func VersionAsOf(versions []ArchivedVersion, asOf time.Time) (ArchivedVersion, bool) {
+	for _, v := range versions {
+		if !v.ArchiveTime.Before(asOf) {
+			return v, true
+		}
+	}
+	return ArchivedVersion{}, false
+}
+
+type byArchiveTime []ArchivedVersion
+
+func (l byArchiveTime) Len() int           { return len(l) }
+func (l byArchiveTime) Less(a, b int) bool { return l[a].ArchiveTime.Before(l[b].ArchiveTime) }
+func (l byArchiveTime) Swap(a, b int)      { l[a], l[b] = l[b], l[a] }
@@ -0,0 +1,69 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package versioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestListArchivedVersions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syncthing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	t0 := time.Date(2016, 1, 1, 12, 0, 0, 0, time.Local)
+	t1 := t0.Add(time.Hour)
+
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	for _, file := range []string{
+		TagFilename("file.txt", t0),
+		TagFilename("file.txt", t1),
+		TagFilename(filepath.Join("sub", "other"), t0),
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, file), []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	versions, err := ListArchivedVersions(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(versions) != 2 {
+		t.Fatalf("Expected 2 files with versions, got %d", len(versions))
+	}
+	vs := versions["file.txt"]
+	if len(vs) != 2 {
+		t.Fatalf("Expected 2 versions of file.txt, got %d", len(vs))
+	}
+	if !vs[0].ArchiveTime.Equal(t0) || !vs[1].ArchiveTime.Equal(t1) {
+		t.Errorf("Versions not sorted by archive time: %v", vs)
+	}
+	if len(versions["sub/other"]) != 1 {
+		t.Errorf("Expected 1 version of sub/other, got %v", versions["sub/other"])
+	}
+
+	// Before both versions we get the oldest copy.
+	if v, ok := VersionAsOf(vs, t0.Add(-time.Minute)); !ok || !v.ArchiveTime.Equal(t0) {
+		t.Errorf("Expected version from t0, got %v, %v", v, ok)
+	}
+	// Between the versions we get the newer copy.
+	if v, ok := VersionAsOf(vs, t0.Add(time.Minute)); !ok || !v.ArchiveTime.Equal(t1) {
+		t.Errorf("Expected version from t1, got %v, %v", v, ok)
+	}
+	// After both versions the current file is authoritative.
+	if _, ok := VersionAsOf(vs, t1.Add(time.Minute)); ok {
+		t.Errorf("Expected no archived version after t1")
+	}
+}
@@ -9,8 +9,16 @@ package versioner
 import (
 	"path/filepath"
 	"regexp"
+	"strings"
+	"time"
 )
 
+// TagFilename returns the name tagged with the given time, in the format
+// used by the default versioning schemes.
+func TagFilename(name string, t time.Time) string {
+	return taggedFilename(name, t.Format(TimeFormat))
+}
+
 // Inserts ~tag just before the extension of the filename.
 func taggedFilename(name, tag string) string {
 	dir, file := filepath.Dir(name), filepath.Base(name)
@@ -31,3 +39,13 @@ func filenameTag(path string) string {
 	}
 	return match[1]
 }
+
+// Returns the filename with the ~tag removed, i.e. the inverse of
+// taggedFilename.
+func untaggedFilename(path string) string {
+	tag := filenameTag(path)
+	if tag == "" {
+		return path
+	}
+	return strings.Replace(path, "~"+tag, "", 1)
+}
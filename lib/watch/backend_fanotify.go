@@ -0,0 +1,132 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build linux,fanotify
+
+package watch
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// The fanotify backend marks the whole mount containing the folder and
+// reports close-after-write events, which is a single kernel object
+// regardless of the number of directories. Creating marks requires
+// CAP_SYS_ADMIN. Deletions and renames are not reported by classic
+// fanotify and are picked up by the regular rescan interval instead.
+
+const (
+	fanCloexec    = 0x1
+	fanClassNotif = 0x0
+
+	fanMarkAdd   = 0x1
+	fanMarkMount = 0x10
+
+	fanCloseWrite = 0x8
+
+	fanotifyMetadataLen = 24
+)
+
+type fanotifyBackend struct {
+	dir    string
+	fd     int
+	events chan Event
+}
+
+// NewBackend returns a fanotify based change journal backend for the
+// directory. The watch budget does not apply, as the journal uses a
+// single mark for the whole mount.
+func NewBackend(folder, dir string, budget int) (Backend, error) {
+	fd, _, errno := syscall.Syscall(syscall.SYS_FANOTIFY_INIT, fanCloexec|fanClassNotif, uintptr(syscall.O_RDONLY|syscall.O_LARGEFILE|syscall.O_CLOEXEC), 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("fanotify_init: %v", errno)
+	}
+
+	path, err := syscall.BytePtrFromString(dir)
+	if err != nil {
+		syscall.Close(int(fd))
+		return nil, err
+	}
+	_, _, errno = syscall.Syscall6(syscall.SYS_FANOTIFY_MARK, fd, fanMarkAdd|fanMarkMount, fanCloseWrite, uintptr(0xffffffffffffff9c) /* AT_FDCWD */, uintptr(unsafe.Pointer(path)), 0)
+	if errno != 0 {
+		syscall.Close(int(fd))
+		return nil, fmt.Errorf("fanotify_mark %q: %v", dir, errno)
+	}
+
+	b := &fanotifyBackend{
+		dir:    dir,
+		fd:     int(fd),
+		events: make(chan Event),
+	}
+	go b.readerLoop()
+	return b, nil
+}
+
+func (b *fanotifyBackend) Events() <-chan Event {
+	return b.events
+}
+
+func (b *fanotifyBackend) Stop() {
+	syscall.Close(b.fd)
+}
+
+func (b *fanotifyBackend) readerLoop() {
+	defer close(b.events)
+
+	ourPid := int32(os.Getpid())
+	prefix := b.dir
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := syscall.Read(b.fd, buf)
+		if err != nil {
+			// The fd was closed by Stop(), or something is properly
+			// wrong. Either way we are done.
+			return
+		}
+
+		for off := 0; off+fanotifyMetadataLen <= n; {
+			eventLen := int(binary.LittleEndian.Uint32(buf[off:]))
+			if eventLen < fanotifyMetadataLen || off+eventLen > n {
+				break
+			}
+			mask := binary.LittleEndian.Uint64(buf[off+8:])
+			eventFd := int32(binary.LittleEndian.Uint32(buf[off+16:]))
+			pid := int32(binary.LittleEndian.Uint32(buf[off+20:]))
+			off += eventLen
+
+			if eventFd < 0 {
+				continue
+			}
+
+			path, err := os.Readlink(fmt.Sprintf("/proc/self/fd/%d", eventFd))
+			syscall.Close(int(eventFd))
+			if err != nil || mask&fanCloseWrite == 0 {
+				continue
+			}
+			if pid == ourPid {
+				// Our own writes are recorded by the puller directly and
+				// need no rescan.
+				continue
+			}
+			if !strings.HasPrefix(path, prefix) {
+				// The mark covers the whole mount; skip changes outside
+				// the folder.
+				continue
+			}
+
+			b.events <- Event{Path: path, Op: OpWrite}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build linux,!fanotify
+
+package watch
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+
+	"github.com/syncthing/syncthing/lib/events"
+)
+
+// The inotify backend watches every directory in the folder, which
+// consumes one watch descriptor each. Watches are accounted against a
+// budget; subtrees that do not fit within the budget are announced with a
+// WatchBudgetExceeded event and left to the periodic rescan instead of
+// silently going unwatched.
+
+const defaultWatchBudget = 8192
+
+const inotifyDirMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_CLOSE_WRITE
+
+type inotifyBackend struct {
+	folder  string
+	budget  int
+	fd      int
+	watches map[int32]string
+	count   int
+	events  chan Event
+}
+
+// NewBackend returns an inotify based change backend for the directory,
+// consuming at most budget watch descriptors. A budget of zero or less
+// selects the default.
+func NewBackend(folder, dir string, budget int) (Backend, error) {
+	if budget <= 0 {
+		budget = defaultWatchBudget
+	}
+
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &inotifyBackend{
+		folder:  folder,
+		budget:  budget,
+		fd:      fd,
+		watches: make(map[int32]string),
+		events:  make(chan Event),
+	}
+	b.addWatches(dir)
+	go b.readerLoop()
+	return b, nil
+}
+
+func (b *inotifyBackend) Events() <-chan Event {
+	return b.events
+}
+
+func (b *inotifyBackend) Stop() {
+	syscall.Close(b.fd)
+}
+
+// addWatches watches root and every directory below it, as far as the
+// budget allows. It is called from the reader loop for directories that
+// appear after the initial setup, so no locking is needed.
+func (b *inotifyBackend) addWatches(root string) {
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+
+		if b.count >= b.budget {
+			l.Infof("Folder %q: watch budget (%d) exhausted at %q; the subtree falls back to periodic scanning", b.folder, b.budget, path)
+			events.Default.Log(events.WatchBudgetExceeded, map[string]interface{}{
+				"folder": b.folder,
+				"path":   path,
+				"budget": b.budget,
+			})
+			return filepath.SkipDir
+		}
+
+		wd, err := syscall.InotifyAddWatch(b.fd, path, inotifyDirMask)
+		if err != nil {
+			// Permission problems and the like; the periodic scan deals
+			// with whatever happens in here.
+			return nil
+		}
+		b.watches[int32(wd)] = path
+		b.count++
+		return nil
+	})
+}
+
+func (b *inotifyBackend) readerLoop() {
+	defer close(b.events)
+
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := syscall.Read(b.fd, buf)
+		if err != nil {
+			// The fd was closed by Stop(), or something is properly
+			// wrong. Either way we are done.
+			return
+		}
+
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			name := ""
+			if ev.Len > 0 {
+				bs := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(ev.Len)]
+				if i := bytes.IndexByte(bs, 0); i >= 0 {
+					bs = bs[:i]
+				}
+				name = string(bs)
+			}
+			off += syscall.SizeofInotifyEvent + int(ev.Len)
+
+			if ev.Mask&syscall.IN_IGNORED != 0 {
+				// The watch was removed, implicitly or explicitly.
+				delete(b.watches, ev.Wd)
+				b.count--
+				continue
+			}
+
+			dir, ok := b.watches[ev.Wd]
+			if !ok {
+				continue
+			}
+			path := dir
+			if name != "" {
+				path = filepath.Join(dir, name)
+			}
+
+			if ev.Mask&syscall.IN_ISDIR != 0 && ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+				// A new directory; start watching it and anything created
+				// in it before the watch was in place.
+				b.addWatches(path)
+			}
+
+			op := OpWrite
+			switch {
+			case ev.Mask&syscall.IN_CREATE != 0:
+				op = OpCreate
+			case ev.Mask&syscall.IN_DELETE != 0:
+				op = OpRemove
+			case ev.Mask&syscall.IN_MOVED_FROM != 0:
+				op = OpRenameFrom
+			case ev.Mask&syscall.IN_MOVED_TO != 0:
+				op = OpRenameTo
+			}
+			cookie := uint32(0)
+			if op == OpRenameFrom || op == OpRenameTo {
+				cookie = ev.Cookie
+			}
+
+			b.events <- Event{Path: path, Op: op, Cookie: cookie}
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build linux,!fanotify
+
+package watch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/syncthing/syncthing/lib/events"
+)
+
+func TestInotifyBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syncthing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend, err := NewBackend("default", dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Stop()
+
+	expectEvent := func(expected string, op Op) Event {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case ev := <-backend.Events():
+				if ev.Path == expected && ev.Op == op {
+					return ev
+				}
+			case <-timeout:
+				t.Fatalf("Timed out waiting for event %q", expected)
+			}
+		}
+	}
+
+	// A new directory is reported, and watched from then on.
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(sub, OpCreate)
+
+	// As is a write in it.
+	file := filepath.Join(sub, "file")
+	if err := ioutil.WriteFile(file, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(file, OpWrite)
+
+	// A rename is reported as a from/to pair sharing a cookie.
+	renamed := filepath.Join(sub, "renamed")
+	if err := os.Rename(file, renamed); err != nil {
+		t.Fatal(err)
+	}
+	from := expectEvent(file, OpRenameFrom)
+	to := expectEvent(renamed, OpRenameTo)
+	if from.Cookie == 0 || from.Cookie != to.Cookie {
+		t.Errorf("Rename cookies %d and %d do not pair", from.Cookie, to.Cookie)
+	}
+}
+
+func TestInotifyBudget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syncthing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, sub := range []string{"a", "b"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sub := events.Default.Subscribe(events.WatchBudgetExceeded)
+	defer events.Default.Unsubscribe(sub)
+
+	// A budget of one covers the folder root only; the subdirectories
+	// must be announced as falling back to periodic scanning.
+	backend, err := NewBackend("default", dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Stop()
+
+	ev, err := sub.Poll(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := ev.Data.(map[string]interface{})
+	if data["folder"] != "default" || data["budget"] != 1 {
+		t.Errorf("Unexpected event data: %v", data)
+	}
+}
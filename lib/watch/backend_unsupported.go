@@ -0,0 +1,18 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build !linux
+
+package watch
+
+import "errors"
+
+// NewBackend returns the change backend for the directory. On this
+// platform there is none; the NTFS USN journal backend plugs in through
+// the same interface.
+func NewBackend(folder, dir string, budget int) (Backend, error) {
+	return nil, errors.New("no change watch backend available on this platform")
+}
@@ -0,0 +1,207 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package watch feeds filesystem change hints to the scanner from a
+// change journal backend. A change journal is a single kernel side feed
+// for a whole subtree (fanotify on Linux, the USN journal on NTFS) and so
+// scales to folders with millions of files, where per directory watches
+// exhaust system limits. Backends deliver affected paths; the aggregator
+// deduplicates and batches them, then requests a rescan of just those
+// items through the normal scan path.
+package watch
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// An Op describes what happened to a path. Backends report whatever
+// subset they can observe; anything they miss is picked up by the
+// regular rescan interval.
+type Op int
+
+const (
+	OpWrite Op = iota
+	OpCreate
+	OpRemove
+	OpRenameFrom
+	OpRenameTo
+)
+
+// An Event is a single change to a filesystem item.
+type Event struct {
+	// Path is the absolute path of the changed item.
+	Path string
+	// Op is what happened to it.
+	Op Op
+	// Cookie pairs the two halves of a rename, where the backend
+	// supports it. Zero otherwise.
+	Cookie uint32
+}
+
+// A Backend delivers change events for filesystem items.
+type Backend interface {
+	// Events returns the channel on which changes are delivered. The
+	// channel is closed when the backend stops.
+	Events() <-chan Event
+	// Stop stops the backend.
+	Stop()
+}
+
+// The Scanner is the consumer of change hints; implemented by the model.
+type Scanner interface {
+	ScanFolderSubdirs(folder string, subs []string) error
+}
+
+const (
+	// How long to wait after the first change before requesting a scan,
+	// letting further changes join the same batch.
+	notifyDelay = 10 * time.Second
+	// When more than this many distinct items are pending we give up on
+	// tracking them individually and do a full scan instead.
+	maxPendingItems = 1024
+)
+
+// An Aggregator reads change events from a backend, batches them and
+// requests rescans. It implements the suture.Service interface and is
+// run and stopped together with the other folder services.
+type Aggregator struct {
+	folder  string
+	dir     string
+	backend Backend
+	scanner Scanner
+	delay   time.Duration
+	stop    chan struct{}
+}
+
+func NewAggregator(folder, dir string, backend Backend, scanner Scanner) *Aggregator {
+	return &Aggregator{
+		folder:  folder,
+		dir:     dir,
+		backend: backend,
+		scanner: scanner,
+		delay:   notifyDelay,
+		stop:    make(chan struct{}),
+	}
+}
+
+func (a *Aggregator) Serve() {
+	defer a.backend.Stop()
+
+	timer := time.NewTimer(a.delay)
+	timer.Stop()
+
+	pending := make(map[string]Op)
+	renames := make(map[uint32]string)
+	overflow := false
+
+	for {
+		select {
+		case ev, ok := <-a.backend.Events():
+			if !ok {
+				return
+			}
+
+			rel, err := filepath.Rel(a.dir, ev.Path)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				// Not ours; mount wide backends report changes outside
+				// the folder as well.
+				continue
+			}
+			if isInternal(rel) {
+				continue
+			}
+
+			if len(pending) == 0 && !overflow {
+				timer.Reset(a.delay)
+			}
+			if len(pending) >= maxPendingItems {
+				l.Debugln(a.folder, "too many pending changes, will do a full scan")
+				pending = make(map[string]Op)
+				renames = make(map[uint32]string)
+				overflow = true
+			}
+			if overflow {
+				continue
+			}
+
+			switch ev.Op {
+			case OpRemove, OpRenameFrom:
+				if ev.Op == OpRenameFrom && ev.Cookie != 0 {
+					renames[ev.Cookie] = rel
+				}
+				if pending[rel] == OpCreate {
+					// The item was created and removed again within the
+					// window - a build artifact or rotated log. Nothing
+					// on disk, nothing in the index; no scan needed.
+					l.Debugln(a.folder, "coalescing create+remove of", rel)
+					delete(pending, rel)
+					continue
+				}
+				pending[rel] = OpRemove
+			case OpCreate:
+				pending[rel] = OpCreate
+			case OpRenameTo:
+				if from, ok := renames[ev.Cookie]; ok && ev.Cookie != 0 {
+					l.Debugln(a.folder, "rename", from, "->", rel)
+					delete(renames, ev.Cookie)
+				}
+				pending[rel] = OpCreate
+			default: // OpWrite
+				if _, ok := pending[rel]; !ok {
+					pending[rel] = OpWrite
+				}
+			}
+
+		case <-timer.C:
+			var subs []string
+			if !overflow {
+				if len(pending) == 0 {
+					continue
+				}
+				subs = make([]string, 0, len(pending))
+				for sub := range pending {
+					subs = append(subs, sub)
+				}
+				sort.Strings(subs)
+			}
+			pending = make(map[string]Op)
+			renames = make(map[uint32]string)
+			overflow = false
+
+			l.Debugln(a.folder, "scanning due to watched changes:", subs)
+			if err := a.scanner.ScanFolderSubdirs(a.folder, subs); err != nil {
+				l.Infof("Watch triggered scan of %q: %v", a.folder, err)
+			}
+
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+func (a *Aggregator) Stop() {
+	close(a.stop)
+}
+
+// isInternal returns true for items that are created and indexed by
+// ourselves, where a change hint would only cause scan churn.
+func isInternal(rel string) bool {
+	base := filepath.Base(rel)
+	switch {
+	case base == ".stfolder", base == ".stignore":
+		return true
+	case strings.HasPrefix(rel, ".stversions"):
+		return true
+	case strings.HasPrefix(base, ".syncthing.") && strings.HasSuffix(base, ".tmp"):
+		return true
+	case strings.HasPrefix(base, "~syncthing~") && strings.HasSuffix(base, ".tmp"):
+		return true
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package watch
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fakeBackend struct {
+	events chan Event
+}
+
+func (b *fakeBackend) Events() <-chan Event { return b.events }
+func (b *fakeBackend) Stop()                {}
+
+type fakeScanner struct {
+	scans chan []string
+}
+
+func (s *fakeScanner) ScanFolderSubdirs(folder string, subs []string) error {
+	s.scans <- subs
+	return nil
+}
+
+func TestAggregator(t *testing.T) {
+	backend := &fakeBackend{events: make(chan Event)}
+	scanner := &fakeScanner{scans: make(chan []string, 1)}
+
+	a := NewAggregator("default", "/folder", backend, scanner)
+	a.delay = 50 * time.Millisecond
+	go a.Serve()
+	defer a.Stop()
+
+	// Duplicates, internal files and paths outside the folder collapse
+	// into one sorted batch.
+	backend.events <- Event{Path: "/folder/b/file", Op: OpWrite}
+	backend.events <- Event{Path: "/folder/a/file", Op: OpWrite}
+	backend.events <- Event{Path: "/folder/b/file", Op: OpWrite}
+	backend.events <- Event{Path: "/folder/.syncthing.a.tmp", Op: OpWrite}
+	backend.events <- Event{Path: "/elsewhere/file", Op: OpWrite}
+
+	select {
+	case subs := <-scanner.scans:
+		if !reflect.DeepEqual(subs, []string{"a/file", "b/file"}) {
+			t.Errorf("Unexpected subs %v", subs)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for scan")
+	}
+}
+
+func TestAggregatorCoalesce(t *testing.T) {
+	backend := &fakeBackend{events: make(chan Event)}
+	scanner := &fakeScanner{scans: make(chan []string, 1)}
+
+	a := NewAggregator("default", "/folder", backend, scanner)
+	a.delay = 50 * time.Millisecond
+	go a.Serve()
+	defer a.Stop()
+
+	// A short lived temp file - created, written and removed within the
+	// window - should not trigger a scan at all. A rename is a paired
+	// from/to and results in hints for both sides. A burst of writes to
+	// the same file is a single hint.
+	backend.events <- Event{Path: "/folder/tmp", Op: OpCreate}
+	backend.events <- Event{Path: "/folder/tmp", Op: OpWrite}
+	backend.events <- Event{Path: "/folder/tmp", Op: OpRemove}
+	backend.events <- Event{Path: "/folder/log.1", Op: OpRenameFrom, Cookie: 42}
+	backend.events <- Event{Path: "/folder/log.2", Op: OpRenameTo, Cookie: 42}
+	backend.events <- Event{Path: "/folder/log", Op: OpWrite}
+	backend.events <- Event{Path: "/folder/log", Op: OpWrite}
+	backend.events <- Event{Path: "/folder/log", Op: OpWrite}
+
+	select {
+	case subs := <-scanner.scans:
+		if !reflect.DeepEqual(subs, []string{"log", "log.1", "log.2"}) {
+			t.Errorf("Unexpected subs %v", subs)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for scan")
+	}
+}
+
+func TestAggregatorOverflow(t *testing.T) {
+	backend := &fakeBackend{events: make(chan Event)}
+	scanner := &fakeScanner{scans: make(chan []string, 1)}
+
+	a := NewAggregator("default", "/folder", backend, scanner)
+	a.delay = 50 * time.Millisecond
+	go a.Serve()
+	defer a.Stop()
+
+	for i := 0; i < maxPendingItems+1; i++ {
+		backend.events <- Event{Path: fmt.Sprintf("/folder/file%d", i), Op: OpWrite}
+	}
+
+	select {
+	case subs := <-scanner.scans:
+		if subs != nil {
+			t.Errorf("Expected a full scan (nil subs), got %d subs", len(subs))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for scan")
+	}
+}
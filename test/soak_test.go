@@ -0,0 +1,150 @@
+// Copyright (C) 2016 The Syncthing Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build integration
+
+package integration
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/syncthing/syncthing/lib/protocol"
+	"github.com/syncthing/syncthing/lib/rc"
+)
+
+// TestSoak continuously mutates a scratch folder on one instance, syncs
+// it with a second instance, and after each converged round checks
+// invariants that would reveal slow corruption: the database size
+// accounting matches what is actually on disk, no orphan temp files are
+// left behind, and the local sequence number only ever moves forward.
+func TestSoak(t *testing.T) {
+	timeLimit := longTimeLimit
+	if testing.Short() {
+		timeLimit = shortTimeLimit
+	}
+
+	const (
+		numFiles    = 100
+		fileSizeExp = 18
+	)
+	rand.Seed(42)
+
+	log.Println("Cleaning...")
+	err := removeAll("s1", "s2", "h1/index*", "h2/index*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log.Println("Generating files...")
+	err = generateFiles("s1", numFiles, fileSizeExp, "../LICENSE")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log.Println("Starting Syncthing...")
+	p0 := startInstance(t, 1)
+	defer checkedStop(t, p0)
+	p1 := startInstance(t, 2)
+	defer checkedStop(t, p1)
+
+	lastSeq := map[int]int{}
+	dirs := map[int]string{0: "s1", 1: "s2"}
+
+	start := time.Now()
+	iteration := 0
+	for time.Since(start) < timeLimit {
+		iteration++
+		log.Println("Iteration", iteration)
+
+		if err := p0.RescanDelay("default", 86400); err != nil {
+			t.Fatal(err)
+		}
+		if err := p1.RescanDelay("default", 86400); err != nil {
+			t.Fatal(err)
+		}
+
+		log.Println("Syncing...")
+		rc.AwaitSync("default", p0, p1)
+		if err := compareDirectories("s1", "s2"); err != nil {
+			t.Fatal(err)
+		}
+
+		log.Println("Checking invariants...")
+		for i, p := range []*rc.Process{p0, p1} {
+			m, err := p.Model("default")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := checkAccounting(dirs[i], m); err != nil {
+				t.Fatalf("device %d, iteration %d: %v", i+1, iteration, err)
+			}
+			if err := checkNoTempFiles(dirs[i]); err != nil {
+				t.Fatalf("device %d, iteration %d: %v", i+1, iteration, err)
+			}
+			if m.Version < lastSeq[i] {
+				t.Fatalf("device %d, iteration %d: sequence went backwards, %d -> %d", i+1, iteration, lastSeq[i], m.Version)
+			}
+			lastSeq[i] = m.Version
+		}
+
+		log.Println("Altering...")
+		if err := alterFiles("s1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkAccounting verifies that the local file and byte counts reported
+// by the database match what is actually in the directory. Directories
+// count their synthetic size, like in the database.
+func checkAccounting(dir string, m rc.Model) error {
+	actual, err := directoryContents(dir)
+	if err != nil {
+		return err
+	}
+
+	files := 0
+	bytes := 0
+	for _, f := range actual {
+		if f.mode.IsDir() {
+			bytes += protocol.SyntheticDirectorySize
+			continue
+		}
+		files++
+		bytes += int(f.size)
+	}
+
+	if m.LocalFiles != files {
+		return fmt.Errorf("local file count mismatch: db says %d, disk says %d", m.LocalFiles, files)
+	}
+	if m.LocalBytes != bytes {
+		return fmt.Errorf("local byte count mismatch: db says %d, disk says %d", m.LocalBytes, bytes)
+	}
+	return nil
+}
+
+// checkNoTempFiles verifies that a converged folder contains no leftover
+// sync temp files.
+func checkNoTempFiles(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		base := filepath.Base(path)
+		if strings.HasPrefix(base, ".syncthing.") || strings.HasPrefix(base, "~syncthing~") {
+			return fmt.Errorf("orphan temp file %q", path)
+		}
+		return nil
+	})
+}